@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 var Cfg *Config
 
 type Config struct {
+	//pod provider of pods that don't request one, pods are refused if it isn't loaded.
+	//The first loaded provider by name is used if empty.
 	PreferredRuntime  string `json:"preferredRuntime"`
 	DeviceName        string `json:"deviceName"`
 	ShortDeviceName   string
@@ -23,6 +26,97 @@ type Config struct {
 	ExternalInterface string `json:"interface"`
 	HeartbeatTime     int    `json:"heartbeatTime"`
 	UseCLVers         bool   `json:"useCLVers"`
+	//pod providers to load, all registered providers if empty
+	PodProviders []string `json:"podProviders"`
+	//maps RuntimeClass names to pod provider names, the handler of a RuntimeClass isn't used
+	RuntimeClasses map[string]string `json:"runtimeClasses"`
+	//pods a pod provider can run when it doesn't report its own limit, 110 if not set
+	MaxPods    int              `json:"maxPods"`
+	OSv        OSvConfig        `json:"osv"`
+	Wasm       WasmConfig       `json:"wasm"`
+	Native     NativeConfig     `json:"native"`
+	CRI        CRIConfig        `json:"cri"`
+	Docker     DockerConfig     `json:"docker"`
+	Containerd ContainerdConfig `json:"containerd"`
+	//directory with the unix sockets of pod provider plugins
+	PluginDir string `json:"pluginDir"`
+}
+
+type OSvConfig struct {
+	//command used to boot an image, e.g. scripts/run.py from the OSv repo
+	Launcher     string   `json:"launcher"`
+	LauncherArgs []string `json:"launcherArgs"`
+	LogDir       string   `json:"logDir"`
+}
+
+type WasmConfig struct {
+	//pulled modules and compiled code are cached here
+	CacheDir string `json:"cacheDir"`
+	LogDir   string `json:"logDir"`
+}
+
+type NativeConfig struct {
+	//downloaded binaries and unpacked tarballs are kept here
+	CacheDir string `json:"cacheDir"`
+	LogDir   string `json:"logDir"`
+}
+
+type CRIConfig struct {
+	//CRI runtime socket, e.g. unix:///var/run/crio/crio.sock
+	Endpoint string `json:"endpoint"`
+	//timeout of CRI calls in seconds, image pulls aren't limited
+	Timeout int `json:"timeout"`
+	//pod log directories handed to the runtime are created here
+	PodLogDir string `json:"podLogDir"`
+}
+
+type DockerConfig struct {
+	//docker daemon socket, e.g. unix:///var/run/docker.sock
+	Endpoint string `json:"endpoint"`
+	//engine API version to request, e.g. 1.40, the daemon's own version if empty
+	APIVersion string `json:"apiVersion"`
+	//timeout of docker calls in seconds, image pulls and stops aren't limited by it
+	Timeout int `json:"timeout"`
+	//image of the pause container that holds the namespaces of a pod, e.g. k8s.gcr.io/pause:3.6
+	SandboxImage string `json:"sandboxImage"`
+}
+
+type ContainerdConfig struct {
+	//container logs are written here in the CRI logging format
+	LogDir string `json:"logDir"`
+	//a log file is rotated when it reaches this size, e.g. 10Mi
+	LogMaxSize string `json:"logMaxSize"`
+	//log files kept per container run, including the current one
+	LogMaxFiles int `json:"logMaxFiles"`
+	//gzip rotated log files
+	LogCompress bool `json:"logCompress"`
+	//the oldest logs are removed when all container logs together grow larger, e.g. 100Mi
+	LogBudget string `json:"logBudget"`
+	//image of the pause container that holds the namespaces of a pod, e.g. k8s.gcr.io/pause:3.6
+	SandboxImage string `json:"sandboxImage"`
+	//the pods running on containerd are kept here, so they can be adopted again after a restart
+	StateDir string `json:"stateDir"`
+	//images pulled at the same time, pulls of the same image are done once
+	MaxParallelPulls int `json:"maxParallelPulls"`
+	//credentials of registries by their host, e.g. ghcr.io or *.example.com, the pull secrets of a pod go first
+	RegistryCredentials map[string]RegistryCredentials `json:"registryCredentials"`
+	Registry            RegistryConfig                 `json:"registry"`
+}
+
+type RegistryConfig struct {
+	//registry of images that don't name one, docker.io if empty
+	DefaultRegistry string `json:"defaultRegistry"`
+	//mirrors of registries by their host, tried in order before the registry itself, e.g. {"docker.io":["http://cache.local:5000"]}
+	Mirrors map[string][]string `json:"mirrors"`
+	//registries and mirrors that are reached over plain http, or over https without verifying their certificate
+	//when their mirror url says https, e.g. cache.local:5000
+	Insecure []string `json:"insecure"`
+}
+
+type RegistryCredentials struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identityToken"`
 }
 
 func LoadConfig(filename string) error {
@@ -51,6 +145,14 @@ func LoadConfig(filename string) error {
 		Cfg.IgnoreKubeProxy = os.Getenv("FLEDGE_IGNORE_KPROXY")
 		Cfg.ExternalInterface = os.Getenv("FLEDGE_INET_INTERFACE")
 		Cfg.HeartbeatTime, _ = strconv.Atoi(os.Getenv("HEARTBEAT_TIME"))
+		Cfg.PreferredRuntime = os.Getenv("FLEDGE_PREFERRED_RUNTIME")
+		if podProviders := os.Getenv("FLEDGE_POD_PROVIDERS"); podProviders != "" {
+			Cfg.PodProviders = strings.Split(podProviders, ",")
+		}
+		Cfg.OSv.Launcher = os.Getenv("FLEDGE_OSV_LAUNCHER")
+		Cfg.CRI.Endpoint = os.Getenv("FLEDGE_CRI_ENDPOINT")
+		Cfg.Docker.Endpoint = os.Getenv("FLEDGE_DOCKER_ENDPOINT")
+		Cfg.PluginDir = os.Getenv("FLEDGE_PLUGIN_DIR")
 	}
 
 	return err
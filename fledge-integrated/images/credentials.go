@@ -0,0 +1,198 @@
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"fledge/fledge-integrated/manager"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Credentials log in to a registry, with a username and password or an identity token
+type Credentials struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// Keyring holds the credentials of registries by their host, e.g. ghcr.io or *.example.com
+type Keyring map[string]Credentials
+
+// dockerConfigEntry is a registry in a .dockercfg or .dockerconfigjson, auth is the base64 encoded username:password
+type dockerConfigEntry struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// KeyringFromSecret reads the registries of an image pull secret of type kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg
+func KeyringFromSecret(secret *v1.Secret) (Keyring, error) {
+	entries := map[string]dockerConfigEntry{}
+	switch secret.Type {
+	case v1.SecretTypeDockerConfigJson:
+		config := struct {
+			Auths map[string]dockerConfigEntry `json:"auths"`
+		}{}
+		if err := json.Unmarshal(secret.Data[v1.DockerConfigJsonKey], &config); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode secret %s", secret.Name)
+		}
+		entries = config.Auths
+	case v1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[v1.DockerConfigKey], &entries); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode secret %s", secret.Name)
+		}
+	default:
+		return nil, errors.Errorf("secret %s of type %s isn't an image pull secret", secret.Name, secret.Type)
+	}
+
+	keyring := Keyring{}
+	for registry, entry := range entries {
+		creds := Credentials{Username: entry.Username, Password: entry.Password, IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid auth of registry %s in secret %s", registry, secret.Name)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, errors.Errorf("invalid auth of registry %s in secret %s", registry, secret.Name)
+			}
+			creds.Username, creds.Password = parts[0], parts[1]
+		}
+		keyring.Add(registry, creds)
+	}
+	return keyring, nil
+}
+
+// PodKeyring reads the image pull secrets of the pod and then those of its service account into a keyring.
+// Secrets that couldn't be read are returned as namespace/name, so the caller can tell the pod about them.
+func PodKeyring(ctx context.Context, rm *manager.ResourceManager, pod *v1.Pod) (Keyring, []string) {
+	keyring := Keyring{}
+	namespace := pod.ObjectMeta.Namespace
+	secrets := append([]v1.LocalObjectReference{}, pod.Spec.ImagePullSecrets...)
+	account := pod.Spec.ServiceAccountName
+	if account == "" {
+		account = "default"
+	}
+	if sa, err := rm.GetServiceAccount(ctx, account, namespace); err == nil {
+		secrets = append(secrets, sa.ImagePullSecrets...)
+	} else if !apierrors.IsNotFound(err) {
+		fmt.Printf("Failed to get service account %s of pod %s: %s\n", account, pod.ObjectMeta.Name, err.Error())
+	}
+
+	missing := []string{}
+	seen := make(map[string]bool)
+	for _, ref := range secrets {
+		if seen[ref.Name] {
+			continue
+		}
+		seen[ref.Name] = true
+		secret, err := rm.GetSecret(ctx, ref.Name, namespace)
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("%s/%s", namespace, ref.Name))
+			continue
+		}
+		secretKeyring, err := KeyringFromSecret(secret)
+		if err != nil {
+			fmt.Printf("Skipping image pull secret of pod %s: %s\n", pod.ObjectMeta.Name, err.Error())
+			continue
+		}
+		keyring = keyring.Merge(secretKeyring)
+	}
+	return keyring, missing
+}
+
+// Add sets the credentials of a registry, written as a host or as in a docker config, e.g. https://index.docker.io/v1/
+func (k Keyring) Add(registry string, creds Credentials) {
+	k[registryHost(registry)] = creds
+}
+
+// Merge adds the registries of other that aren't in the keyring yet
+func (k Keyring) Merge(other Keyring) Keyring {
+	merged := Keyring{}
+	for _, keyring := range []Keyring{other, k} {
+		for host, creds := range keyring {
+			merged[host] = creds
+		}
+	}
+	return merged
+}
+
+// Lookup returns the credentials of a registry host, exact matches go before wildcards
+func (k Keyring) Lookup(host string) (Credentials, bool) {
+	host = registryHost(host)
+	if creds, found := k[host]; found {
+		return creds, true
+	}
+	for pattern, creds := range k {
+		if strings.HasPrefix(pattern, "*.") {
+			if matched, _ := path.Match(pattern, host); matched {
+				return creds, true
+			}
+		}
+	}
+	return Credentials{}, false
+}
+
+// LookupImage returns the credentials of the registry an image is pulled from, the image is written as in a pod
+func (k Keyring) LookupImage(image string) (Credentials, bool) {
+	ref, err := NormalizeRef(image, "")
+	if err != nil {
+		return Credentials{}, false
+	}
+	return k.Lookup(refHost(ref))
+}
+
+// Resolve returns the username and secret the containerd resolver logs in to host with
+func (k Keyring) Resolve(host string) (string, string, error) {
+	creds, found := k.Lookup(host)
+	if !found {
+		return "", "", nil
+	}
+	if creds.IdentityToken != "" {
+		return "", creds.IdentityToken, nil
+	}
+	return creds.Username, creds.Password, nil
+}
+
+// pullKey tells pulls of the same image with different credentials apart, so a pull that is denied
+// doesn't hold back or fail one that is allowed
+func (k Keyring) pullKey(ref string) string {
+	creds, found := k.Lookup(refHost(ref))
+	if !found {
+		return ref
+	}
+	sum := sha256.Sum256([]byte(creds.Username + "\x00" + creds.Password + "\x00" + creds.IdentityToken))
+	return fmt.Sprintf("%s#%x", ref, sum[:8])
+}
+
+// registryHost returns the host of a registry as it is written in docker configs, e.g. https://index.docker.io/v1/
+func registryHost(registry string) string {
+	if strings.Contains(registry, "://") {
+		if u, err := url.Parse(registry); err == nil {
+			registry = u.Host
+		}
+	}
+	registry = strings.SplitN(registry, "/", 2)[0]
+	//docker hub goes by a few names, containerd talks to registry-1.docker.io
+	switch registry {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return "registry-1.docker.io"
+	}
+	return registry
+}
+
+// refHost returns the registry host of a full image reference
+func refHost(ref string) string {
+	return registryHost(strings.SplitN(ref, "/", 2)[0])
+}
@@ -0,0 +1,128 @@
+package images
+
+import (
+	"encoding/base64"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func auth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+func TestKeyringFromSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  v1.Secret
+		want    Keyring
+		invalid bool
+	}{
+		{
+			name: "dockerconfigjson",
+			secret: v1.Secret{Type: v1.SecretTypeDockerConfigJson, Data: map[string][]byte{v1.DockerConfigJsonKey: []byte(
+				`{"auths":{"ghcr.io":{"auth":"` + auth("user", "pass:word") + `"},"https://index.docker.io/v1/":{"username":"hub","password":"secret"}}}`)}},
+			want: Keyring{
+				"ghcr.io":              {Username: "user", Password: "pass:word"},
+				"registry-1.docker.io": {Username: "hub", Password: "secret"},
+			},
+		},
+		{
+			name: "dockercfg",
+			secret: v1.Secret{Type: v1.SecretTypeDockercfg, Data: map[string][]byte{v1.DockerConfigKey: []byte(
+				`{"cache.local:5000":{"identitytoken":"token"},"*.example.com":{"auth":"` + auth("user", "pass") + `"}}`)}},
+			want: Keyring{
+				"cache.local:5000": {IdentityToken: "token"},
+				"*.example.com":    {Username: "user", Password: "pass"},
+			},
+		},
+		{
+			name:    "auth that isn't base64",
+			secret:  v1.Secret{Type: v1.SecretTypeDockercfg, Data: map[string][]byte{v1.DockerConfigKey: []byte(`{"ghcr.io":{"auth":"%%%"}}`)}},
+			invalid: true,
+		},
+		{
+			name: "auth without a password",
+			secret: v1.Secret{Type: v1.SecretTypeDockercfg, Data: map[string][]byte{v1.DockerConfigKey: []byte(
+				`{"ghcr.io":{"auth":"` + base64.StdEncoding.EncodeToString([]byte("user")) + `"}}`)}},
+			invalid: true,
+		},
+		{
+			name:    "invalid json",
+			secret:  v1.Secret{Type: v1.SecretTypeDockerConfigJson, Data: map[string][]byte{v1.DockerConfigJsonKey: []byte(`{"auths":`)}},
+			invalid: true,
+		},
+		{
+			name:    "opaque secret",
+			secret:  v1.Secret{Type: v1.SecretTypeOpaque},
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.secret.ObjectMeta = metav1.ObjectMeta{Name: "pull"}
+			keyring, err := KeyringFromSecret(&test.secret)
+			if test.invalid {
+				if err == nil {
+					t.Fatal("the secret should not be accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keyring) != len(test.want) {
+				t.Fatalf("expected %v, got %v", test.want, keyring)
+			}
+			for host, creds := range test.want {
+				if keyring[host] != creds {
+					t.Fatalf("expected %v for %s, got %v", creds, host, keyring[host])
+				}
+			}
+		})
+	}
+}
+
+func TestKeyringLookup(t *testing.T) {
+	keyring := Keyring{}
+	keyring.Add("https://index.docker.io/v1/", Credentials{Username: "hub"})
+	keyring.Add("registry.example.com", Credentials{Username: "exact"})
+	keyring.Add("*.example.com", Credentials{Username: "wildcard"})
+	keyring.Add("localhost:5000", Credentials{Username: "local"})
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "nginx", want: "hub"},
+		{image: "docker.io/user/app", want: "hub"},
+		{image: "index.docker.io/user/app", want: "hub"},
+		{image: "registry.example.com/app", want: "exact"},
+		{image: "cache.example.com/app", want: "wildcard"},
+		{image: "example.com/app", want: ""},
+		{image: "localhost:5000/app", want: "local"},
+		{image: "localhost/app", want: ""},
+		{image: "ghcr.io/org/app", want: ""},
+	}
+
+	for _, test := range tests {
+		creds, found := keyring.LookupImage(test.image)
+		if found != (test.want != "") || creds.Username != test.want {
+			t.Fatalf("expected credentials %q for %s, got %q", test.want, test.image, creds.Username)
+		}
+	}
+}
+
+func TestKeyringMerge(t *testing.T) {
+	first := Keyring{"ghcr.io": {Username: "first"}}
+	second := Keyring{"ghcr.io": {Username: "second"}, "quay.io": {Username: "second"}}
+	merged := first.Merge(second)
+	if merged["ghcr.io"].Username != "first" || merged["quay.io"].Username != "second" {
+		t.Fatalf("registries already in the keyring should be kept, got %v", merged)
+	}
+	if len(first) != 1 {
+		t.Fatal("merging should not change the keyring")
+	}
+}
@@ -0,0 +1,189 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"fledge/fledge-integrated/providers"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	DefaultMaxParallelPulls = 2
+	//pull delays double from pullInitialBackoff up to pullMaxBackoff, like the kubelet's ImagePullBackOff
+	pullInitialBackoff = 10 * time.Second
+	pullMaxBackoff     = 5 * time.Minute
+)
+
+// Puller is what the image manager needs from a container runtime, refs are full image references
+type Puller interface {
+	//Present returns the digest of the image when it is stored locally, found is false when it isn't
+	Present(ctx context.Context, ref string) (digest string, found bool, err error)
+	//Resolve returns the digest the registry serves for ref, without downloading the image
+	Resolve(ctx context.Context, ref string, auth Keyring) (digest string, err error)
+	//Pull downloads the image and returns its digest
+	Pull(ctx context.Context, ref string, auth Keyring) (digest string, err error)
+}
+
+// EventFunc sends an event about the pod, it is called without a pod for images that aren't pulled for a container
+type EventFunc func(pod *v1.Pod, eventType, reason, message string)
+
+// Manager pulls the images of containers. Concurrent pulls of the same image are done once, only a limited number
+// of images is pulled at the same time and an image that failed to pull isn't tried again until its backoff passed.
+type Manager struct {
+	//pulls run in this context, they aren't canceled with the caller that started them
+	ctx    context.Context
+	puller Puller
+	event  EventFunc
+	//a slot is taken for every running pull
+	slots chan struct{}
+
+	lock    sync.Mutex
+	pulls   map[string]*pull
+	backoff map[string]*pullBackoff
+}
+
+// pull is a running pull of an image, done is closed once it finished
+type pull struct {
+	done   chan struct{}
+	digest string
+	err    error
+}
+
+type pullBackoff struct {
+	delay time.Duration
+	until time.Time
+}
+
+// NewManager uses DefaultMaxParallelPulls when maxParallelPulls isn't positive, event may be nil
+func NewManager(ctx context.Context, puller Puller, maxParallelPulls int, event EventFunc) *Manager {
+	if maxParallelPulls <= 0 {
+		maxParallelPulls = DefaultMaxParallelPulls
+	}
+	return &Manager{
+		ctx:     ctx,
+		puller:  puller,
+		event:   event,
+		slots:   make(chan struct{}, maxParallelPulls),
+		pulls:   make(map[string]*pull),
+		backoff: make(map[string]*pullBackoff),
+	}
+}
+
+// EnsureImage makes sure the image of the container is present as its pull policy asks, and returns its digest.
+// An image that is present isn't downloaded again for the Always policy unless the registry serves another digest.
+// The registry is logged in to with the credentials in auth, which may be nil. Errors are providers.ImagePullErrors.
+func (m *Manager) EnsureImage(ctx context.Context, pod *v1.Pod, container string, ref string, policy v1.PullPolicy, auth Keyring) (string, error) {
+	digest, present, err := m.puller.Present(ctx, ref)
+	if err != nil {
+		return "", &providers.ImagePullError{Container: container, Image: ref, Err: errors.Wrap(err, "failed to look up image")}
+	}
+
+	switch {
+	case policy == v1.PullNever && !present:
+		message := fmt.Sprintf("Container image %q is not present with pull policy of Never", ref)
+		m.sendEvent(pod, v1.EventTypeWarning, "ErrImageNeverPull", message)
+		return "", &providers.ImagePullError{Container: container, Image: ref, Reason: providers.ReasonErrImageNeverPull, Err: errors.New(message)}
+	case policy == v1.PullAlways && present:
+		remote, err := m.puller.Resolve(ctx, ref, auth)
+		if err != nil {
+			//edge nodes are often offline, the image they have is better than none
+			fmt.Printf("Failed to resolve image %s, using the local one: %s\n", ref, err.Error())
+			m.sendEvent(pod, v1.EventTypeNormal, "Pulled", fmt.Sprintf("Container image %q already present on machine", ref))
+			return digest, nil
+		}
+		if remote == digest {
+			m.sendEvent(pod, v1.EventTypeNormal, "Pulled", fmt.Sprintf("Container image %q already present on machine and up to date", ref))
+			return digest, nil
+		}
+	case present:
+		m.sendEvent(pod, v1.EventTypeNormal, "Pulled", fmt.Sprintf("Container image %q already present on machine", ref))
+		return digest, nil
+	}
+
+	key := auth.pullKey(ref)
+	if remaining := m.backoffRemaining(key); remaining > 0 {
+		message := fmt.Sprintf("Back-off pulling image %q", ref)
+		m.sendEvent(pod, v1.EventTypeNormal, "BackOff", message)
+		return "", &providers.ImagePullError{Container: container, Image: ref, Reason: providers.ReasonImagePullBackOff, Err: errors.Errorf("%s, next attempt in %s", message, remaining.Round(time.Second))}
+	}
+
+	m.sendEvent(pod, v1.EventTypeNormal, "Pulling", fmt.Sprintf("Pulling image %q", ref))
+	start := time.Now()
+	digest, err = m.pull(ctx, key, ref, auth)
+	if err != nil {
+		m.sendEvent(pod, v1.EventTypeWarning, "Failed", fmt.Sprintf("Failed to pull image %q: %s", ref, err.Error()))
+		return "", &providers.ImagePullError{Container: container, Image: ref, Err: err}
+	}
+	m.sendEvent(pod, v1.EventTypeNormal, "Pulled", fmt.Sprintf("Successfully pulled image %q in %s", ref, time.Since(start).Round(time.Millisecond)))
+	return digest, nil
+}
+
+// pull downloads the image, or waits for the pull of it with the same key that is already running
+func (m *Manager) pull(ctx context.Context, key string, ref string, auth Keyring) (string, error) {
+	m.lock.Lock()
+	p, running := m.pulls[key]
+	if !running {
+		p = &pull{done: make(chan struct{})}
+		m.pulls[key] = p
+	}
+	m.lock.Unlock()
+
+	if !running {
+		//the pull isn't tied to the first caller, others may still be waiting for it
+		go m.runPull(key, ref, auth, p)
+	}
+	select {
+	case <-p.done:
+		return p.digest, p.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (m *Manager) runPull(key string, ref string, auth Keyring, p *pull) {
+	m.slots <- struct{}{}
+	fmt.Printf("Pulling image %s\n", ref)
+	p.digest, p.err = m.puller.Pull(m.ctx, ref, auth)
+	<-m.slots
+
+	m.lock.Lock()
+	delete(m.pulls, key)
+	if p.err != nil {
+		b, found := m.backoff[key]
+		if !found {
+			b = &pullBackoff{delay: pullInitialBackoff}
+			m.backoff[key] = b
+		} else {
+			b.delay *= 2
+			if b.delay > pullMaxBackoff {
+				b.delay = pullMaxBackoff
+			}
+		}
+		b.until = time.Now().Add(b.delay)
+	} else {
+		delete(m.backoff, key)
+	}
+	m.lock.Unlock()
+	close(p.done)
+}
+
+// backoffRemaining returns how long the pull with the key has to wait after it failed
+func (m *Manager) backoffRemaining(key string) time.Duration {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if b, found := m.backoff[key]; found {
+		return time.Until(b.until)
+	}
+	return 0
+}
+
+func (m *Manager) sendEvent(pod *v1.Pod, eventType, reason, message string) {
+	if pod != nil && m.event != nil {
+		m.event(pod, eventType, reason, message)
+	}
+}
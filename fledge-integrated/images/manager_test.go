@@ -0,0 +1,344 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/providers"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// fakePuller keeps the digests of local and remote images in memory, pulls wait until release is closed
+type fakePuller struct {
+	lock    sync.Mutex
+	local   map[string]string
+	remote  map[string]string
+	failing map[string]bool
+	release chan struct{}
+	//pulls counts the pulls of every ref, active and maxActive the pulls running at the same time
+	pulls     map[string]int
+	active    int
+	maxActive int
+	resolved  int
+}
+
+func newFakePuller() *fakePuller {
+	release := make(chan struct{})
+	close(release)
+	return &fakePuller{
+		local:   make(map[string]string),
+		remote:  make(map[string]string),
+		failing: make(map[string]bool),
+		pulls:   make(map[string]int),
+		release: release,
+	}
+}
+
+func (f *fakePuller) Present(ctx context.Context, ref string) (string, bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	digest, found := f.local[ref]
+	return digest, found, nil
+}
+
+func (f *fakePuller) Resolve(ctx context.Context, ref string, auth Keyring) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.resolved++
+	digest, found := f.remote[ref]
+	if !found {
+		return "", errors.New("registry unreachable")
+	}
+	return digest, nil
+}
+
+func (f *fakePuller) Pull(ctx context.Context, ref string, auth Keyring) (string, error) {
+	f.lock.Lock()
+	f.pulls[ref]++
+	f.active++
+	if f.active > f.maxActive {
+		f.maxActive = f.active
+	}
+	release := f.release
+	f.lock.Unlock()
+
+	<-release
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.active--
+	if f.failing[ref] {
+		return "", errors.Errorf("pull access denied for %s", ref)
+	}
+	f.local[ref] = f.remote[ref]
+	return f.remote[ref], nil
+}
+
+func (f *fakePuller) pullCount(ref string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.pulls[ref]
+}
+
+// eventRecorder collects the reasons of the events the manager sends
+type eventRecorder struct {
+	lock    sync.Mutex
+	reasons []string
+}
+
+func (r *eventRecorder) event(pod *v1.Pod, eventType, reason, message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.reasons = append(r.reasons, reason)
+}
+
+func (r *eventRecorder) get() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return strings.Join(r.reasons, ",")
+}
+
+func TestEnsureImagePolicies(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  v1.PullPolicy
+		local   string
+		remote  string
+		digest  string
+		pulls   int
+		reason  string
+		events  string
+		resolve int
+	}{
+		{name: "present", policy: v1.PullIfNotPresent, local: "sha256:a", remote: "sha256:b", digest: "sha256:a", events: "Pulled"},
+		{name: "missing", policy: v1.PullIfNotPresent, remote: "sha256:b", digest: "sha256:b", pulls: 1, events: "Pulling,Pulled"},
+		{name: "never present", policy: v1.PullNever, local: "sha256:a", digest: "sha256:a", events: "Pulled"},
+		{name: "never missing", policy: v1.PullNever, remote: "sha256:b", reason: providers.ReasonErrImageNeverPull, events: "ErrImageNeverPull"},
+		{name: "always up to date", policy: v1.PullAlways, local: "sha256:a", remote: "sha256:a", digest: "sha256:a", events: "Pulled", resolve: 1},
+		{name: "always outdated", policy: v1.PullAlways, local: "sha256:a", remote: "sha256:b", digest: "sha256:b", pulls: 1, events: "Pulling,Pulled", resolve: 1},
+		//an edge node keeps running what it has while the registry can't be reached
+		{name: "always offline", policy: v1.PullAlways, local: "sha256:a", digest: "sha256:a", events: "Pulled", resolve: 1},
+		{name: "always missing", policy: v1.PullAlways, remote: "sha256:b", digest: "sha256:b", pulls: 1, events: "Pulling,Pulled"},
+	}
+	for _, c := range cases {
+		ref := "docker.io/library/nginx:latest"
+		puller := newFakePuller()
+		if c.local != "" {
+			puller.local[ref] = c.local
+		}
+		if c.remote != "" {
+			puller.remote[ref] = c.remote
+		}
+		recorder := &eventRecorder{}
+		m := NewManager(context.Background(), puller, 0, recorder.event)
+
+		digest, err := m.EnsureImage(context.Background(), &v1.Pod{}, "app", ref, c.policy, nil)
+		if c.reason != "" {
+			if _, reason, _ := providers.ContainerWaitingReason(err); reason != c.reason {
+				t.Errorf("%s: failed with %v, want %s", c.name, err, c.reason)
+			}
+		} else if err != nil || digest != c.digest {
+			t.Errorf("%s: got %q, %v, want %q", c.name, digest, err, c.digest)
+		}
+		if pulls := puller.pullCount(ref); pulls != c.pulls {
+			t.Errorf("%s: pulled %d times, want %d", c.name, pulls, c.pulls)
+		}
+		if puller.resolved != c.resolve {
+			t.Errorf("%s: resolved %d times, want %d", c.name, puller.resolved, c.resolve)
+		}
+		if events := recorder.get(); events != c.events {
+			t.Errorf("%s: events %s, want %s", c.name, events, c.events)
+		}
+	}
+}
+
+func TestEnsureImageDeduplicatesPulls(t *testing.T) {
+	ref := "docker.io/library/nginx:latest"
+	puller := newFakePuller()
+	puller.remote[ref] = "sha256:b"
+	puller.release = make(chan struct{})
+	m := NewManager(context.Background(), puller, 0, nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			digest, err := m.EnsureImage(context.Background(), nil, "app", ref, v1.PullIfNotPresent, nil)
+			if err == nil && digest != "sha256:b" {
+				err = errors.Errorf("got digest %s", digest)
+			}
+			errs <- err
+		}()
+	}
+	//all of them wait for the one pull
+	waitFor(t, func() bool {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		return len(m.pulls) == 1 && puller.pullCount(ref) == 1
+	})
+	time.Sleep(50 * time.Millisecond)
+	close(puller.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pulls := puller.pullCount(ref); pulls != 1 {
+		t.Fatalf("pulled %d times, want once", pulls)
+	}
+}
+
+func TestEnsureImageDifferentCredentialsPullSeparately(t *testing.T) {
+	ref := "ghcr.io/org/app:latest"
+	puller := newFakePuller()
+	puller.remote[ref] = "sha256:b"
+	puller.release = make(chan struct{})
+	m := NewManager(context.Background(), puller, 0, nil)
+
+	var wg sync.WaitGroup
+	for _, keyring := range []Keyring{nil, {"ghcr.io": {Username: "user", Password: "secret"}}} {
+		wg.Add(1)
+		go func(keyring Keyring) {
+			defer wg.Done()
+			m.EnsureImage(context.Background(), nil, "app", ref, v1.PullIfNotPresent, keyring)
+		}(keyring)
+	}
+	waitFor(t, func() bool { return puller.pullCount(ref) == 2 })
+	close(puller.release)
+	wg.Wait()
+}
+
+func TestEnsureImageParallelPullCap(t *testing.T) {
+	puller := newFakePuller()
+	puller.release = make(chan struct{})
+	m := NewManager(context.Background(), puller, 2, nil)
+
+	refs := []string{}
+	for i := 0; i < 5; i++ {
+		ref := fmt.Sprintf("docker.io/library/app%d:latest", i)
+		puller.remote[ref] = fmt.Sprintf("sha256:%d", i)
+		refs = append(refs, ref)
+	}
+	var wg sync.WaitGroup
+	for _, ref := range refs {
+		ref := ref
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.EnsureImage(context.Background(), nil, "app", ref, v1.PullIfNotPresent, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	waitFor(t, func() bool {
+		puller.lock.Lock()
+		defer puller.lock.Unlock()
+		return puller.active == 2
+	})
+	//the other pulls wait for a slot
+	time.Sleep(50 * time.Millisecond)
+	close(puller.release)
+	wg.Wait()
+
+	puller.lock.Lock()
+	defer puller.lock.Unlock()
+	if puller.maxActive != 2 || len(puller.pulls) != 5 {
+		t.Fatalf("%d images pulled with up to %d at the same time, want 5 with up to 2", len(puller.pulls), puller.maxActive)
+	}
+}
+
+func TestEnsureImageBackoff(t *testing.T) {
+	ref := "docker.io/library/private:latest"
+	puller := newFakePuller()
+	puller.remote[ref] = "sha256:b"
+	puller.failing[ref] = true
+	recorder := &eventRecorder{}
+	m := NewManager(context.Background(), puller, 0, recorder.event)
+	pod := &v1.Pod{}
+
+	_, err := m.EnsureImage(context.Background(), pod, "app", ref, v1.PullIfNotPresent, nil)
+	if container, reason, _ := providers.ContainerWaitingReason(err); container != "app" || reason != providers.ReasonErrImagePull {
+		t.Fatalf("failed pull returned %v", err)
+	}
+	//the image isn't pulled again until the backoff passed
+	_, err = m.EnsureImage(context.Background(), pod, "app", ref, v1.PullIfNotPresent, nil)
+	if _, reason, _ := providers.ContainerWaitingReason(err); reason != providers.ReasonImagePullBackOff {
+		t.Fatalf("pull during the backoff returned %v", err)
+	}
+	if pulls := puller.pullCount(ref); pulls != 1 {
+		t.Fatalf("pulled %d times during the backoff", pulls)
+	}
+	if events := recorder.get(); events != "Pulling,Failed,BackOff" {
+		t.Fatalf("events %s", events)
+	}
+
+	//the backoff doubles with every failure up to its maximum
+	delays := []time.Duration{}
+	for i := 0; i < 6; i++ {
+		m.lock.Lock()
+		m.backoff[ref].until = time.Now()
+		m.lock.Unlock()
+		m.EnsureImage(context.Background(), pod, "app", ref, v1.PullIfNotPresent, nil)
+		m.lock.Lock()
+		delays = append(delays, m.backoff[ref].delay)
+		m.lock.Unlock()
+	}
+	if fmt.Sprint(delays) != "[20s 40s 1m20s 2m40s 5m0s 5m0s]" {
+		t.Fatalf("backoff delays %v", delays)
+	}
+
+	//a successful pull resets the backoff
+	puller.lock.Lock()
+	puller.failing[ref] = false
+	puller.lock.Unlock()
+	m.lock.Lock()
+	m.backoff[ref].until = time.Now()
+	m.lock.Unlock()
+	if _, err := m.EnsureImage(context.Background(), pod, "app", ref, v1.PullIfNotPresent, nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.backoffRemaining(ref) != 0 {
+		t.Fatal("backoff is kept after the image was pulled")
+	}
+}
+
+func TestEnsureImageCallerCanceled(t *testing.T) {
+	ref := "docker.io/library/nginx:latest"
+	puller := newFakePuller()
+	puller.remote[ref] = "sha256:b"
+	puller.release = make(chan struct{})
+	m := NewManager(context.Background(), puller, 0, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.EnsureImage(ctx, nil, "app", ref, v1.PullIfNotPresent, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled caller got %v", err)
+	}
+	//the pull goes on for the callers after it
+	close(puller.release)
+	waitFor(t, func() bool {
+		digest, found, _ := puller.Present(context.Background(), ref)
+		return found && digest == "sha256:b"
+	})
+}
+
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
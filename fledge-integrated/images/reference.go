@@ -0,0 +1,41 @@
+package images
+
+import (
+	"strings"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+)
+
+// NormalizeRef turns an image as it is written in a pod into the full reference it is stored by, following the
+// distribution reference grammar, e.g. nginx becomes docker.io/library/nginx:latest. Images without a registry are
+// taken from defaultRegistry, docker.io if it is empty. References with a digest don't get a tag.
+func NormalizeRef(ref string, defaultRegistry string) (string, error) {
+	if defaultRegistry != "" && defaultRegistry != "docker.io" && !hasRegistry(ref) {
+		ref = defaultRegistry + "/" + ref
+	}
+	named, err := docker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image reference %q", ref)
+	}
+	return docker.TagNameOnly(named).String(), nil
+}
+
+// ValidateDefaultRegistry makes sure a default registry is read as a registry when it is put in front of an image,
+// e.g. ghcr.io or ghcr.io/team. A name like myreg would be taken for a docker hub user instead.
+func ValidateDefaultRegistry(registry string) error {
+	if registry == "" || registry == "docker.io" {
+		return nil
+	}
+	if !hasRegistry(registry + "/image") {
+		return errors.Errorf("default registry %q has to start with a host with a dot or a port, or localhost", registry)
+	}
+	return nil
+}
+
+// hasRegistry tells whether the first part of a reference is a registry, as the reference grammar decides it:
+// it has to contain a dot or a port, or be localhost
+func hasRegistry(ref string) bool {
+	i := strings.IndexRune(ref, '/')
+	return i >= 0 && (strings.ContainsAny(ref[:i], ".:") || ref[:i] == "localhost")
+}
@@ -0,0 +1,70 @@
+package images
+
+import "testing"
+
+const digest = "6fd0b1ee4c2f3a7e44f9e0e2b1c1a5a3a0a6c1f1d3f7c8b0b8e3c7d2e1f0a9b8"
+
+func TestNormalizeRef(t *testing.T) {
+	tests := []struct {
+		ref             string
+		defaultRegistry string
+		want            string
+	}{
+		{ref: "nginx", want: "docker.io/library/nginx:latest"},
+		{ref: "nginx:1.21", want: "docker.io/library/nginx:1.21"},
+		{ref: "user/app", want: "docker.io/user/app:latest"},
+		{ref: "docker.io/library/nginx:latest", want: "docker.io/library/nginx:latest"},
+		{ref: "ghcr.io/org/app", want: "ghcr.io/org/app:latest"},
+		{ref: "localhost/app", want: "localhost/app:latest"},
+		{ref: "cache:5000/app:v1", want: "cache:5000/app:v1"},
+		{ref: "nginx@sha256:" + digest, want: "docker.io/library/nginx@sha256:" + digest},
+		{ref: "nginx", defaultRegistry: "docker.io", want: "docker.io/library/nginx:latest"},
+		{ref: "nginx", defaultRegistry: "ghcr.io", want: "ghcr.io/nginx:latest"},
+		{ref: "nginx", defaultRegistry: "ghcr.io/team", want: "ghcr.io/team/nginx:latest"},
+		{ref: "user/app", defaultRegistry: "cache:5000", want: "cache:5000/user/app:latest"},
+		{ref: "quay.io/org/app", defaultRegistry: "ghcr.io", want: "quay.io/org/app:latest"},
+		{ref: "localhost/app", defaultRegistry: "ghcr.io", want: "localhost/app:latest"},
+	}
+
+	for _, test := range tests {
+		got, err := NormalizeRef(test.ref, test.defaultRegistry)
+		if err != nil {
+			t.Fatalf("%s with default registry %q: %s", test.ref, test.defaultRegistry, err)
+		}
+		if got != test.want {
+			t.Fatalf("%s with default registry %q: expected %s, got %s", test.ref, test.defaultRegistry, test.want, got)
+		}
+	}
+
+	for _, ref := range []string{"", "Nginx", "nginx:", "nginx@sha256:abc"} {
+		if _, err := NormalizeRef(ref, ""); err == nil {
+			t.Fatalf("%q should not be a valid reference", ref)
+		}
+	}
+}
+
+func TestValidateDefaultRegistry(t *testing.T) {
+	tests := []struct {
+		registry string
+		valid    bool
+	}{
+		{registry: "", valid: true},
+		{registry: "docker.io", valid: true},
+		{registry: "ghcr.io", valid: true},
+		{registry: "ghcr.io/team", valid: true},
+		{registry: "cache:5000", valid: true},
+		{registry: "localhost", valid: true},
+		{registry: "myreg", valid: false},
+		{registry: "myreg/team", valid: false},
+	}
+
+	for _, test := range tests {
+		err := ValidateDefaultRegistry(test.registry)
+		if test.valid && err != nil {
+			t.Fatalf("%q should be a valid default registry: %s", test.registry, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%q should not be a valid default registry", test.registry)
+		}
+	}
+}
@@ -47,7 +47,7 @@ func main() {
 		//fmt.Println("Created containerd runtime interface")
 		vkube.Cri = (&vkube.ContainerdRuntimeInterface{}).Init()
 	} else {
-		vkube.Cri = nil //(&DockerRuntimeInterface{}).Init()
+		vkube.Cri, _ = vkube.NewDockerRuntimeInterface(config.Cfg.Docker)
 	}*/
 
 	vkube.StartTime = time.Now()
@@ -227,6 +227,8 @@ func initVkubeletConfig() vkubelet.Config {
 		InternalIP:            config.Cfg.DeviceIP,
 		BridgeDevice:          brdInterface,
 		AvailablePodProviders: availableProviders,
+		DefaultRuntime:        config.Cfg.PreferredRuntime,
+		RuntimeClasses:        config.Cfg.RuntimeClasses,
 	}
 
 	jsonStr, _ := json.Marshal(nodeConfig)
@@ -252,7 +254,13 @@ func initVkubeletConfig() vkubelet.Config {
 }
 
 func checkAvailableProviders() (map[string]*providers.PodProvider, error) {
-	podProviderNames := []string{"containerd", "osv"}
+	pluginNames := register.RegisterPlugins(config.Cfg.PluginDir)
+	fmt.Printf("Plugins found %v\n", pluginNames)
+
+	podProviderNames := config.Cfg.PodProviders
+	if len(podProviderNames) == 0 {
+		podProviderNames = register.GetPodProviderNames()
+	}
 
 	//check which ones are actually present
 	availableProviders := make(map[string]*providers.PodProvider)
@@ -51,3 +51,8 @@ func (rm *ResourceManager) GetConfigMap(ctx context.Context, name, namespace str
 func (rm *ResourceManager) GetSecret(ctx context.Context, name, namespace string) (*v1.Secret, error) {
 	return rm.k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{}) //.secretLister.Secrets(namespace).Get(name)
 }
+
+// GetServiceAccount retrieves the specified service account from Kubernetes.
+func (rm *ResourceManager) GetServiceAccount(ctx context.Context, name, namespace string) (*v1.ServiceAccount, error) {
+	return rm.k8sClient.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+}
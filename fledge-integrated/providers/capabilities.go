@@ -0,0 +1,132 @@
+package providers
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Pod providers can implement the optional interfaces below to tell the node provider what they support.
+// A provider that doesn't implement VolumeCapabilities or StreamingCapabilities is assumed to support all of it,
+// one that doesn't implement GPUCapabilities doesn't get any GPUs.
+
+const (
+	VolumeTypeHostPath    = "hostPath"
+	VolumeTypeEmptyDir    = "emptyDir"
+	VolumeTypeSecret      = "secret"
+	VolumeTypeConfigMap   = "configMap"
+	VolumeTypeProjected   = "projected"
+	VolumeTypeDownwardAPI = "downwardAPI"
+)
+
+// VolumeTypesMountable are the volume types vkube.GetHostMountPath can provide a host directory for
+var VolumeTypesMountable = []string{VolumeTypeHostPath, VolumeTypeEmptyDir, VolumeTypeSecret, VolumeTypeConfigMap, VolumeTypeProjected, VolumeTypeDownwardAPI}
+
+const (
+	ResourceCudaGPU   v1.ResourceName = "device/cudagpu"
+	ResourceOpenCLGPU v1.ResourceName = "device/openclgpu"
+)
+
+// VolumeCapabilities is implemented by pod providers that can only mount some volume types
+type VolumeCapabilities interface {
+	SupportedVolumeTypes() []string
+}
+
+// StreamingCapabilities is implemented by pod providers that can't exec into or fetch logs of their containers
+type StreamingCapabilities interface {
+	SupportsExec() bool
+	SupportsLogs() bool
+}
+
+// GPUCapabilities is implemented by pod providers that can hand GPUs to containers, it returns the device resources they handle
+type GPUCapabilities interface {
+	SupportedGPUs() []v1.ResourceName
+}
+
+// ResourceReporter is implemented by pod providers that track what their pods use.
+// The node provider sums the requests and limits of GetPods for providers that don't implement it.
+type ResourceReporter interface {
+	// AllocatedResources returns the requests and limits of the provider's pods, keyed as requests.cpu, limits.memory, ...
+	AllocatedResources(context.Context) v1.ResourceList
+}
+
+// PodCapacityReporter is implemented by pod providers that can only run a limited number of pods.
+// The node provider uses the maxPods of the config for providers that don't implement it.
+type PodCapacityReporter interface {
+	// PodCapacity returns how many pods the provider can run at the same time, 0 if it doesn't know
+	PodCapacity() int64
+}
+
+// GetVolumeType returns the name of the volume's source, as used in the pod spec
+func GetVolumeType(vol v1.Volume) string {
+	switch {
+	case vol.HostPath != nil:
+		return VolumeTypeHostPath
+	case vol.EmptyDir != nil:
+		return VolumeTypeEmptyDir
+	case vol.Secret != nil:
+		return VolumeTypeSecret
+	case vol.ConfigMap != nil:
+		return VolumeTypeConfigMap
+	case vol.PersistentVolumeClaim != nil:
+		return "persistentVolumeClaim"
+	case vol.Projected != nil:
+		return VolumeTypeProjected
+	case vol.DownwardAPI != nil:
+		return VolumeTypeDownwardAPI
+	case vol.NFS != nil:
+		return "nfs"
+	}
+	return "unknown"
+}
+
+// SumPodResources adds up the requests and limits of the containers of pods
+func SumPodResources(pods []*v1.Pod) v1.ResourceList {
+	sums := map[v1.ResourceName]*resource.Quantity{
+		v1.ResourceRequestsCPU:     resource.NewQuantity(0, resource.DecimalSI),
+		v1.ResourceRequestsMemory:  resource.NewQuantity(0, resource.BinarySI),
+		v1.ResourceRequestsStorage: resource.NewQuantity(0, resource.BinarySI),
+		v1.ResourceLimitsCPU:       resource.NewQuantity(0, resource.DecimalSI),
+		v1.ResourceLimitsMemory:    resource.NewQuantity(0, resource.BinarySI),
+	}
+	add := func(name v1.ResourceName, list v1.ResourceList, key v1.ResourceName) {
+		if val, found := list[key]; found {
+			sums[name].Add(val)
+		}
+	}
+	for _, pod := range pods {
+		if pod == nil {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			add(v1.ResourceRequestsCPU, container.Resources.Requests, v1.ResourceCPU)
+			add(v1.ResourceRequestsMemory, container.Resources.Requests, v1.ResourceMemory)
+			add(v1.ResourceRequestsStorage, container.Resources.Requests, v1.ResourceStorage)
+			add(v1.ResourceLimitsCPU, container.Resources.Limits, v1.ResourceCPU)
+			add(v1.ResourceLimitsMemory, container.Resources.Limits, v1.ResourceMemory)
+		}
+	}
+
+	resources := v1.ResourceList{}
+	for name, sum := range sums {
+		resources[name] = *sum
+	}
+	return resources
+}
+
+// MergeResources adds the quantities of every list together
+func MergeResources(lists ...v1.ResourceList) v1.ResourceList {
+	merged := v1.ResourceList{}
+	for _, list := range lists {
+		for name, val := range list {
+			if cur, found := merged[name]; found {
+				cur.Add(val)
+				merged[name] = cur
+			} else {
+				merged[name] = val.DeepCopy()
+			}
+		}
+	}
+	return merged
+}
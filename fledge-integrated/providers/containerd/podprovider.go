@@ -1,24 +1,25 @@
 package containerd
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"regexp"
-	"strconv"
 	"time"
 
+	"github.com/cpuguy83/strongerrors"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/remotecommand"
 
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/images"
+	"fledge/fledge-integrated/manager"
+	"fledge/fledge-integrated/providers"
 	"fledge/fledge-integrated/vkube"
-
-	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 var reInsideWhtsp = regexp.MustCompile(`\s+`)
@@ -30,6 +31,12 @@ func NewContainerdProvider() (*ContainerdProvider, error) {
 	if CheckProviderRequirements() {
 		var provider ContainerdProvider
 
+		if err := images.ValidateDefaultRegistry(config.Cfg.Containerd.Registry.DefaultRegistry); err != nil {
+			return nil, err
+		}
+		if vkube.Cri == nil {
+			vkube.Cri = vkube.NewContainerdRuntimeInterface()
+		}
 		return &provider, nil
 	} else {
 		return nil, errors.New("Containerd not found")
@@ -51,6 +58,24 @@ func (p *ContainerdProvider) ResetChanges() {
 	vkube.Cri.ResetFlags()
 }
 
+func (p *ContainerdProvider) NotifyPods(ctx context.Context, notify func()) {
+	if notifier, ok := vkube.Cri.(providers.PodNotifier); ok {
+		notifier.NotifyPods(ctx, notify)
+	}
+}
+
+func (p *ContainerdProvider) RecordPodEvents(recorder record.EventRecorder) {
+	if eventRecorder, ok := vkube.Cri.(providers.PodEventRecorder); ok {
+		eventRecorder.RecordPodEvents(recorder)
+	}
+}
+
+func (p *ContainerdProvider) UseResourceManager(rm *manager.ResourceManager) {
+	if user, ok := vkube.Cri.(providers.PodResourceUser); ok {
+		user.UseResourceManager(rm)
+	}
+}
+
 func (p *ContainerdProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 	fmt.Println("CreatePod")
 
@@ -68,8 +93,7 @@ func (p *ContainerdProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 
 	fmt.Printf("Creating pod num containers %d restart policy %s use host network %t\n", len(containers), restartPolicy, useHostnetwork)
 
-	vkube.Cri.DeployPod(pod)
-	return nil
+	return vkube.Cri.DeployPod(pod)
 }
 
 func (p *ContainerdProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
@@ -78,8 +102,7 @@ func (p *ContainerdProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
 
 	fmt.Printf("Updating pod namespace %s name %s\n", namespace, name)
 
-	vkube.Cri.UpdatePod(pod)
-	return nil
+	return vkube.Cri.UpdatePod(pod)
 }
 
 func (p *ContainerdProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
@@ -88,8 +111,7 @@ func (p *ContainerdProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
 
 	fmt.Printf("Deleting pod namespace %s name %s\n", namespace, name)
 
-	vkube.Cri.DeletePod(pod)
-	return nil
+	return vkube.Cri.DeletePod(pod)
 }
 
 func (p *ContainerdProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
@@ -104,17 +126,8 @@ func (p *ContainerdProvider) GetPod(ctx context.Context, namespace, name string)
 }
 
 // GetContainerLogs returns the logs of a container running in a pod by name.
-func (p *ContainerdProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error) {
-	reader := vkube.Cri.FetchContainerLogs(namespace, podName, containerName, strconv.Itoa(tail), true)
-	if reader != nil {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(*reader)
-		logs := buf.String()
-		//fmt.Fprintf(w, "%s", logs)
-		return logs, nil
-	} else {
-		return "", nil
-	}
+func (p *ContainerdProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	return vkube.Cri.FetchContainerLogs(ctx, namespace, podName, containerName, opts)
 }
 
 // Get full pod name as defined in the provider context
@@ -126,10 +139,22 @@ func (p *ContainerdProvider) GetContainerLogs(ctx context.Context, namespace, po
 
 // ExecInContainer executes a command in a container in the pod, copying data
 // between in/out/err and the container's stdin/stdout/stderr.
-// TODO: Implementation
 func (p *ContainerdProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
-	log.Printf("receive ExecInContainer %q\n", container)
-	return nil
+	return vkube.Cri.ExecInContainer(name, uid, container, cmd, in, out, err, tty, resize, timeout)
+}
+
+// AttachToContainer connects to the stdio of a running container.
+func (p *ContainerdProvider) AttachToContainer(name string, uid types.UID, container string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	return vkube.Cri.AttachToContainer(name, uid, container, in, out, err, tty, resize)
+}
+
+// PortForward connects stream to a port inside the network namespace of the pod.
+func (p *ContainerdProvider) PortForward(ctx context.Context, namespace, podName string, port int32, stream io.ReadWriteCloser) error {
+	pod, found := vkube.Cri.GetPod(namespace, podName)
+	if !found {
+		return strongerrors.NotFound(fmt.Errorf("pod %s/%s not found", namespace, podName))
+	}
+	return vkube.ForwardPodPort(ctx, pod, port, stream)
 }
 
 // GetPodStatus retrieves the status of a given pod by name.
@@ -155,56 +180,29 @@ func (p *ContainerdProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 	return pods, nil
 }
 
-//don't know enough go to write this in a decent way yet
-//then again, there's probably no decent way to write this in go
-//table flip
-func GetContainerResources() map[v1.ResourceName]*resource.Quantity {
-	cpuRequest, _ := resource.ParseQuantity("0")
-	memRequest, _ := resource.ParseQuantity("0")
-	storRequest, _ := resource.ParseQuantity("0")
-	cpuLimit, _ := resource.ParseQuantity("0")
-	memLimit, _ := resource.ParseQuantity("0")
+// AllocatedResources sums the requests and limits of the pods deployed through containerd
+func (p *ContainerdProvider) AllocatedResources(ctx context.Context) v1.ResourceList {
+	return providers.SumPodResources(vkube.Cri.GetPods())
+}
 
-	podSpecs := vkube.Cri.GetPods()
-	fmt.Printf("Podspecs count %d\n", len(podSpecs))
-	//iterate pods => podspec => iterate containers => resourcerequirements
-	for _, pod := range podSpecs {
-		fmt.Printf("Tallying podspec %s\n", pod.ObjectMeta.Name)
-		for _, container := range pod.Spec.Containers {
-			fmt.Printf("Tallying container %s\n", container.Name)
-			if container.Resources.Requests != nil {
-				val := container.Resources.Requests.Cpu()
-				if val != nil {
-					cpuRequest.Add(*val)
-				}
-				val = container.Resources.Requests.Memory()
-				if val != nil {
-					memRequest.Add(*val)
-				}
-				sval, err := container.Resources.Requests[v1.ResourceStorage]
-				if !err {
-					storRequest.Add(sval)
-				}
-				val = container.Resources.Limits.Cpu()
-				if val != nil {
-					cpuLimit.Add(*val)
-				}
-				val = container.Resources.Limits.Memory()
-				if val != nil {
-					memLimit.Add(*val)
-				}
-			}
-		}
-	}
+// PodCapacity is limited by the addresses pods get in the node subnet
+func (p *ContainerdProvider) PodCapacity() int64 {
+	return int64(vkube.PodAddressCapacity())
+}
+
+func (p *ContainerdProvider) SupportedVolumeTypes() []string {
+	return providers.VolumeTypesMountable
+}
+
+func (p *ContainerdProvider) SupportsExec() bool {
+	return true
+}
 
-	resources := make(map[v1.ResourceName]*resource.Quantity)
-	resources[v1.ResourceRequestsCPU] = &cpuRequest
-	resources[v1.ResourceRequestsMemory] = &memRequest
-	resources[v1.ResourceRequestsStorage] = &storRequest
-	resources[v1.ResourceLimitsCPU] = &cpuLimit
-	resources[v1.ResourceLimitsMemory] = &memLimit
-	fmt.Println("Resources used")
-	fmt.Println(resources)
+func (p *ContainerdProvider) SupportsLogs() bool {
+	return true
+}
 
-	return resources
+// SupportedGPUs returns the GPUs that can be passed through the nvidia container hook
+func (p *ContainerdProvider) SupportedGPUs() []v1.ResourceName {
+	return []v1.ResourceName{providers.ResourceCudaGPU, providers.ResourceOpenCLGPU}
 }
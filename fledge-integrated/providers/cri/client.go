@@ -0,0 +1,64 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	defaultEndpoint = "unix:///var/run/crio/crio.sock"
+	defaultTimeout  = 10 * time.Second
+	connectTimeout  = 5 * time.Second
+	//same limit the kubelet uses, ListContainers responses can get big
+	maxMsgSize = 16 * 1024 * 1024
+)
+
+// ParseEndpoint returns the socket path of a unix:// endpoint, a plain path is accepted as well
+func ParseEndpoint(endpoint string) (string, error) {
+	if strings.HasPrefix(endpoint, "unix://") {
+		return strings.TrimPrefix(endpoint, "unix://"), nil
+	}
+	if strings.Contains(endpoint, "://") {
+		return "", errors.Errorf("only unix endpoints are supported, got %s", endpoint)
+	}
+	return endpoint, nil
+}
+
+// Connect dials the runtime and checks that it speaks the v1 CRI API.
+// The runtime name is returned as well, it's used as the scheme of container IDs.
+func Connect(endpoint string) (*grpc.ClientConn, string, error) {
+	socket, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+	}
+	conn, err := grpc.DialContext(ctx, socket,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(dialer),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize)))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to connect to CRI runtime at %s", endpoint)
+	}
+
+	version, err := runtimeapi.NewRuntimeServiceClient(conn).Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		conn.Close()
+		return nil, "", errors.Wrapf(err, "CRI runtime at %s doesn't support the v1 API", endpoint)
+	}
+	fmt.Printf("Connected to CRI runtime %s %s (CRI %s)\n", version.RuntimeName, version.RuntimeVersion, version.RuntimeApiVersion)
+	return conn, version.RuntimeName, nil
+}
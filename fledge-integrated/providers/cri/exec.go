@@ -0,0 +1,128 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	utilexec "k8s.io/utils/exec"
+)
+
+// sizeQueue hands terminal resizes to the remotecommand client
+type sizeQueue struct {
+	resize <-chan remotecommand.TerminalSize
+}
+
+func (q *sizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q.resize
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// findContainer returns the runtime ID of a container, the pod is looked up by uid and otherwise by its pod key
+func (p *CRIProvider) findContainer(name string, uid types.UID, container string) (string, bool) {
+	cpod, found := p.Store.Find(name, uid).(*criPod)
+	if !found {
+		return "", false
+	}
+	p.Store.Lock()
+	defer p.Store.Unlock()
+	cont, found := cpod.containers[container]
+	if !found {
+		return "", false
+	}
+	return cont.id, true
+}
+
+// ExecInContainer runs a command in a container.
+// Commands without stdin or tty use ExecSync, interactive ones are streamed through the runtime's streaming server.
+func (p *CRIProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	containerID, found := p.findContainer(name, uid, container)
+	if !found {
+		return strongerrors.NotFound(errors.Errorf("container %s not found in pod %s", container, name))
+	}
+
+	if in == nil && !tty {
+		return p.execSync(containerID, cmd, out, errOut, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	resp, err := p.runtime.Exec(ctx, &runtimeapi.ExecRequest{
+		ContainerId: containerID,
+		Cmd:         cmd,
+		Tty:         tty,
+		Stdin:       in != nil,
+		Stdout:      out != nil,
+		Stderr:      errOut != nil && !tty,
+	})
+	cancel()
+	if err != nil {
+		return errors.Wrap(err, "failed to prepare exec")
+	}
+
+	execURL, err := url.Parse(resp.Url)
+	if err != nil {
+		return errors.Wrapf(err, "invalid exec url %s", resp.Url)
+	}
+	executor, err := remotecommand.NewSPDYExecutor(&rest.Config{}, "POST", execURL)
+	if err != nil {
+		return err
+	}
+
+	options := remotecommand.StreamOptions{
+		Stdin: in,
+		Tty:   tty,
+	}
+	if out != nil {
+		options.Stdout = out
+	}
+	if errOut != nil && !tty {
+		options.Stderr = errOut
+	}
+	if resize != nil {
+		options.TerminalSizeQueue = &sizeQueue{resize: resize}
+	}
+	return executor.Stream(options)
+}
+
+func (p *CRIProvider) execSync(containerID string, cmd []string, out, errOut io.WriteCloser, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		//the runtime enforces the timeout, give the call itself some slack
+		ctx, cancel = context.WithTimeout(ctx, timeout+p.timeout)
+		defer cancel()
+	}
+
+	resp, err := p.runtime.ExecSync(ctx, &runtimeapi.ExecSyncRequest{
+		ContainerId: containerID,
+		Cmd:         cmd,
+		Timeout:     int64(timeout.Seconds()),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to exec in container")
+	}
+	if out != nil && len(resp.Stdout) > 0 {
+		out.Write(resp.Stdout)
+	}
+	if errOut != nil && len(resp.Stderr) > 0 {
+		errOut.Write(resp.Stderr)
+	}
+	if resp.ExitCode != 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with exit code %d", resp.ExitCode),
+			Code: int(resp.ExitCode),
+		}
+	}
+	return nil
+}
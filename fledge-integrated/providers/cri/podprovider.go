@@ -0,0 +1,542 @@
+package cri
+
+import (
+	"context"
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/images"
+	"fledge/fledge-integrated/manager"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/podstore"
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	defaultPodLogDir = "/var/log/pods"
+	pollInterval     = 3 * time.Second
+)
+
+// used for restarts, replaced in tests
+var restartBackoff = vkube.RestartBackoff
+
+// criContainer is the runtime container currently running a container of the pod.
+// Every restart creates a new runtime container, the one of the previous run is kept for its logs.
+type criContainer struct {
+	id         string
+	previousID string
+	attempt    int
+	status     v1.ContainerStatus
+	hasStatus  bool
+
+	lastTermination *v1.ContainerStateTerminated
+	backoff         time.Duration
+	waitingUntil    time.Time
+}
+
+// criPod keeps the pod spec together with the sandbox and containers the runtime created for it
+type criPod struct {
+	pod           *v1.Pod
+	sandboxID     string
+	sandboxConfig *runtimeapi.PodSandboxConfig
+	containers    map[string]*criContainer
+	deleted       bool
+}
+
+func (cpod *criPod) Pod() *v1.Pod {
+	return cpod.pod
+}
+
+// ContainerStatus returns the status of the last poll, along with the restarts fledge did
+func (cpod *criPod) ContainerStatus(name string) (v1.ContainerStatus, bool) {
+	cont, found := cpod.containers[name]
+	if !found || !cont.hasStatus {
+		return v1.ContainerStatus{}, false
+	}
+	status := cont.status
+	status.RestartCount = int32(cont.attempt)
+	if cont.lastTermination != nil {
+		status.LastTerminationState.Terminated = cont.lastTermination
+	}
+	if !cont.waitingUntil.IsZero() {
+		status.Ready = false
+		status.State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{
+			Reason:  "CrashLoopBackOff",
+			Message: fmt.Sprintf("back-off %s restarting failed container %s", cont.backoff, name),
+		}}
+	}
+	return status, true
+}
+
+// CRIProvider runs pods on any runtime implementing the kubernetes CRI API, like CRI-O or containerd's CRI plugin.
+// The runtime doesn't restart containers, exited containers are replaced by new ones according to the restart policy.
+type CRIProvider struct {
+	*podstore.Store
+
+	conn        *grpc.ClientConn
+	runtime     runtimeapi.RuntimeServiceClient
+	images      runtimeapi.ImageServiceClient
+	runtimeName string
+	timeout     time.Duration
+	podLogDir   string
+	resources   *manager.ResourceManager
+}
+
+// NewCRIProvider connects to the runtime at the configured endpoint and starts polling container states.
+func NewCRIProvider(cfg config.CRIConfig) (*CRIProvider, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	conn, runtimeName, err := Connect(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := CRIProvider{
+		Store:       podstore.NewStore(),
+		conn:        conn,
+		runtime:     runtimeapi.NewRuntimeServiceClient(conn),
+		images:      runtimeapi.NewImageServiceClient(conn),
+		runtimeName: runtimeName,
+		timeout:     time.Duration(cfg.Timeout) * time.Second,
+		podLogDir:   cfg.PodLogDir,
+	}
+	if provider.timeout <= 0 {
+		provider.timeout = defaultTimeout
+	}
+	if provider.podLogDir == "" {
+		provider.podLogDir = defaultPodLogDir
+	}
+
+	go provider.PollLoop()
+	return &provider, nil
+}
+
+// UseResourceManager registers the resource manager the image pull secrets of pods are read from
+func (p *CRIProvider) UseResourceManager(rm *manager.ResourceManager) {
+	p.Store.Lock()
+	defer p.Store.Unlock()
+	p.resources = rm
+}
+
+// imagePullKeyring collects the image pull secrets of the pod and of its service account,
+// the credentials of the node are up to the runtime's own configuration
+func (p *CRIProvider) imagePullKeyring(ctx context.Context, pod *v1.Pod) images.Keyring {
+	p.Store.Lock()
+	rm := p.resources
+	p.Store.Unlock()
+	if rm == nil {
+		return images.Keyring{}
+	}
+
+	keyring, missing := images.PodKeyring(ctx, rm, pod)
+	if len(missing) > 0 {
+		fmt.Printf("Unable to retrieve image pull secrets %s of pod %s\n", strings.Join(missing, ", "), pod.Name)
+	}
+	return keyring
+}
+
+// ensureImage pulls the container's image according to its pull policy
+func (p *CRIProvider) ensureImage(ctx context.Context, dc *v1.Container, sandboxConfig *runtimeapi.PodSandboxConfig, keyring images.Keyring) error {
+	spec := &runtimeapi.ImageSpec{Image: dc.Image}
+
+	if dc.ImagePullPolicy != v1.PullAlways {
+		statusCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		resp, err := p.images.ImageStatus(statusCtx, &runtimeapi.ImageStatusRequest{Image: spec})
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, "failed to get status of image %s", dc.Image)
+		}
+		if resp.Image != nil {
+			return nil
+		}
+		if dc.ImagePullPolicy == v1.PullNever {
+			return errors.Errorf("image %s not present and pull policy is Never", dc.Image)
+		}
+	}
+
+	request := &runtimeapi.PullImageRequest{
+		Image:         spec,
+		SandboxConfig: sandboxConfig,
+	}
+	if creds, found := keyring.LookupImage(dc.Image); found {
+		request.Auth = &runtimeapi.AuthConfig{
+			Username:      creds.Username,
+			Password:      creds.Password,
+			IdentityToken: creds.IdentityToken,
+		}
+	}
+
+	fmt.Printf("Pulling image %s\n", dc.Image)
+	if _, err := p.images.PullImage(ctx, request); err != nil {
+		return errors.Wrapf(err, "failed to pull image %s", dc.Image)
+	}
+	return nil
+}
+
+// CreatePod runs a sandbox for the pod and creates and starts its containers in it
+func (p *CRIProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	if len(pod.Spec.InitContainers) > 0 {
+		return &providers.RuntimeUnavailableError{Runtime: "cri", Reason: "init containers are not supported"}
+	}
+
+	fmt.Printf("Creating CRI pod %s\n", podstore.PodKey(pod.Namespace, pod.Name))
+
+	pod = podstore.NewPod(pod)
+	cpod := &criPod{
+		pod:        pod,
+		containers: make(map[string]*criContainer),
+	}
+	if err := p.Store.Add(cpod); err != nil {
+		return err
+	}
+
+	vkube.CreateVolumes(ctx, pod)
+
+	if err := p.startPod(ctx, cpod); err != nil {
+		p.DeletePod(ctx, pod)
+		return err
+	}
+
+	p.refreshPod(ctx, cpod)
+	return nil
+}
+
+func (p *CRIProvider) startPod(ctx context.Context, cpod *criPod) error {
+	pod := cpod.pod
+	sandboxConfig := BuildSandboxConfig(p.podLogDir, pod)
+	if err := os.MkdirAll(sandboxConfig.LogDirectory, 0755); err != nil {
+		return errors.Wrap(err, "failed to create pod log directory")
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	sandbox, err := p.runtime.RunPodSandbox(runCtx, &runtimeapi.RunPodSandboxRequest{Config: sandboxConfig})
+	cancel()
+	if err != nil {
+		return errors.Wrap(err, "failed to run pod sandbox")
+	}
+	p.Store.Lock()
+	cpod.sandboxID = sandbox.PodSandboxId
+	cpod.sandboxConfig = sandboxConfig
+	p.Store.Unlock()
+
+	keyring := p.imagePullKeyring(ctx, pod)
+	for i := range pod.Spec.Containers {
+		dc := &pod.Spec.Containers[i]
+		if err := p.ensureImage(ctx, dc, sandboxConfig, keyring); err != nil {
+			return err
+		}
+
+		id, err := p.runContainer(ctx, sandbox.PodSandboxId, sandboxConfig, pod, dc, 0)
+		if id != "" {
+			p.Store.Lock()
+			cpod.containers[dc.Name] = &criContainer{id: id}
+			p.Store.Unlock()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runContainer creates and starts a container in the sandbox, the id is returned as well if only the start failed
+func (p *CRIProvider) runContainer(ctx context.Context, sandboxID string, sandboxConfig *runtimeapi.PodSandboxConfig, pod *v1.Pod, dc *v1.Container, attempt int) (string, error) {
+	createCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	created, err := p.runtime.CreateContainer(createCtx, &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxID,
+		Config:        BuildContainerConfig(pod, dc, attempt),
+		SandboxConfig: sandboxConfig,
+	})
+	cancel()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create container %s", dc.Name)
+	}
+
+	startCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	_, err = p.runtime.StartContainer(startCtx, &runtimeapi.StartContainerRequest{ContainerId: created.ContainerId})
+	cancel()
+	if err != nil {
+		return created.ContainerId, errors.Wrapf(err, "failed to start container %s", dc.Name)
+	}
+	return created.ContainerId, nil
+}
+
+// scheduleRestart restarts an exited container after its backoff, the lock must be held
+func (p *CRIProvider) scheduleRestart(cpod *criPod, name string, cont *criContainer, terminated *v1.ContainerStateTerminated) {
+	cont.lastTermination = terminated
+	cont.backoff = restartBackoff(cont.backoff, terminated.FinishedAt.Sub(terminated.StartedAt.Time))
+	cont.waitingUntil = time.Now().Add(cont.backoff)
+	p.Store.MarkChanged()
+
+	fmt.Printf("Restarting container %s of pod %s in %s\n", name, cpod.pod.Name, cont.backoff)
+	time.AfterFunc(cont.backoff, func() {
+		p.restartContainer(cpod, name, cont)
+	})
+}
+
+// restartContainer replaces the exited runtime container by a new one with the next attempt, which logs to a new file.
+// Only the container of the previous run is kept, older ones are removed with their logs.
+func (p *CRIProvider) restartContainer(cpod *criPod, name string, cont *criContainer) {
+	ctx := context.Background()
+
+	p.Store.Lock()
+	if cpod.deleted {
+		p.Store.Unlock()
+		return
+	}
+	pod := cpod.pod
+	sandboxID, sandboxConfig := cpod.sandboxID, cpod.sandboxConfig
+	attempt := cont.attempt + 1
+	p.Store.Unlock()
+
+	var dc *v1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == name {
+			dc = &pod.Spec.Containers[i]
+		}
+	}
+
+	err := p.ensureImage(ctx, dc, sandboxConfig, p.imagePullKeyring(ctx, pod))
+	id := ""
+	if err == nil {
+		id, err = p.runContainer(ctx, sandboxID, sandboxConfig, pod, dc, attempt)
+	}
+	if err != nil && id != "" {
+		p.removeContainer(ctx, id)
+	}
+
+	p.Store.Lock()
+	if cpod.deleted {
+		p.Store.Unlock()
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to restart container %s of pod %s: %s\n", name, pod.Name, err.Error())
+		now := metav1.Now()
+		p.scheduleRestart(cpod, name, cont, &v1.ContainerStateTerminated{
+			ExitCode:   128,
+			Reason:     "StartError",
+			Message:    err.Error(),
+			StartedAt:  now,
+			FinishedAt: now,
+		})
+		p.Store.Unlock()
+		return
+	}
+	outdatedID := cont.previousID
+	cont.previousID, cont.id = cont.id, id
+	cont.attempt = attempt
+	cont.waitingUntil = time.Time{}
+	cont.status.State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+	cont.status.Ready = false
+	p.Store.MarkChanged()
+	p.Store.Unlock()
+
+	if outdatedID != "" {
+		p.removeContainer(ctx, outdatedID)
+		os.Remove(filepath.Join(sandboxConfig.LogDirectory, ContainerLogPath(name, attempt-2)))
+	}
+	p.refreshPod(ctx, cpod)
+}
+
+func (p *CRIProvider) removeContainer(ctx context.Context, id string) {
+	removeCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if _, err := p.runtime.RemoveContainer(removeCtx, &runtimeapi.RemoveContainerRequest{ContainerId: id}); err != nil {
+		fmt.Printf("Failed to remove container %s: %s\n", id, err.Error())
+	}
+}
+
+// UpdatePod recreates the pod's sandbox and containers with the new spec
+func (p *CRIProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Updating pod namespace %s name %s\n", pod.Namespace, pod.Name)
+
+	if err := p.DeletePod(ctx, pod); err != nil && !strongerrors.IsNotFound(err) {
+		return err
+	}
+	return p.CreatePod(ctx, pod)
+}
+
+// DeletePod stops the pod's containers within the grace period and removes the sandbox along with them.
+// A pending restart of a container is dropped. The pod stays stored, marked as deleted, until its sandbox
+// is removed, so deleting it again cleans up what a failed call left.
+func (p *CRIProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Deleting pod namespace %s name %s\n", pod.Namespace, pod.Name)
+
+	state := p.Store.Get(pod.Namespace, pod.Name)
+	if state == nil {
+		return strongerrors.NotFound(errors.Errorf("pod %s not found", podstore.PodKey(pod.Namespace, pod.Name)))
+	}
+	cpod := state.(*criPod)
+	p.Store.Lock()
+	cpod.deleted = true
+	sandboxID := cpod.sandboxID
+	containerIDs := []string{}
+	for _, cont := range cpod.containers {
+		containerIDs = append(containerIDs, cont.id)
+	}
+	p.Store.Unlock()
+
+	gracePeriod := int64(vkube.TerminationGracePeriod(pod, nil) / time.Second)
+	for _, id := range containerIDs {
+		stopCtx, cancel := context.WithTimeout(ctx, p.timeout+time.Duration(gracePeriod)*time.Second)
+		_, err := p.runtime.StopContainer(stopCtx, &runtimeapi.StopContainerRequest{ContainerId: id, Timeout: gracePeriod})
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, "failed to stop container %s", id)
+		}
+	}
+
+	//removing the sandbox removes all of its containers, including those of previous runs
+	if sandboxID != "" {
+		stopCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		_, err := p.runtime.StopPodSandbox(stopCtx, &runtimeapi.StopPodSandboxRequest{PodSandboxId: sandboxID})
+		cancel()
+		if err != nil {
+			return errors.Wrap(err, "failed to stop pod sandbox")
+		}
+		removeCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		_, err = p.runtime.RemovePodSandbox(removeCtx, &runtimeapi.RemovePodSandboxRequest{PodSandboxId: sandboxID})
+		cancel()
+		if err != nil {
+			return errors.Wrap(err, "failed to remove pod sandbox")
+		}
+	}
+	os.RemoveAll(PodLogDirectory(p.podLogDir, pod))
+	p.Store.Forget(cpod)
+	return nil
+}
+
+// GetContainerLogs reads the container's log file, which the runtime writes in the CRI logging format.
+// Every run logs to its own file, the one of the previous run is kept.
+func (p *CRIProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	var pod *v1.Pod
+	var cont *criContainer
+	attempt := 0
+	if cpod, found := p.Store.Get(namespace, podName).(*criPod); found {
+		p.Store.Lock()
+		if cont, found = cpod.containers[containerName]; found {
+			pod = cpod.pod
+			attempt = cont.attempt
+		}
+		p.Store.Unlock()
+	}
+
+	if pod == nil {
+		return nil, strongerrors.NotFound(errors.Errorf("container %s not found in pod %s/%s", containerName, namespace, podName))
+	}
+
+	//following stops once the poll loop saw the container stop, or at the end of a previous run
+	running := func() bool {
+		p.Store.Lock()
+		defer p.Store.Unlock()
+		return cont.attempt == attempt && (!cont.hasStatus || cont.status.State.Terminated == nil)
+	}
+	if opts.Previous {
+		if attempt == 0 {
+			return nil, strongerrors.InvalidArgument(errors.Errorf("previous terminated container %s in pod %s/%s not found", containerName, namespace, podName))
+		}
+		attempt--
+		running = func() bool { return false }
+	}
+	logPath := filepath.Join(PodLogDirectory(p.podLogDir, pod), ContainerLogPath(containerName, attempt))
+	return vkube.ReadCRILog(ctx, logPath, opts, running)
+}
+
+// PollLoop asks the runtime for the state of every pod, the CRI API has no events for container exits
+func (p *CRIProvider) PollLoop() {
+	for {
+		time.Sleep(pollInterval)
+		for _, state := range p.Store.States() {
+			p.refreshPod(context.Background(), state.(*criPod))
+		}
+	}
+}
+
+// refreshPod queries the sandbox and container states of a pod and restarts exited containers,
+// the lock isn't held during the calls
+func (p *CRIProvider) refreshPod(ctx context.Context, cpod *criPod) {
+	p.Store.Lock()
+	if cpod.deleted {
+		p.Store.Unlock()
+		return
+	}
+	sandboxID := cpod.sandboxID
+	containerIDs := map[string]string{}
+	for name, cont := range cpod.containers {
+		containerIDs[name] = cont.id
+	}
+	images := map[string]string{}
+	for _, cont := range cpod.pod.Spec.Containers {
+		images[cont.Name] = cont.Image
+	}
+	hostNetwork := cpod.pod.Spec.HostNetwork
+	p.Store.Unlock()
+
+	podIP := ""
+	if hostNetwork {
+		podIP = config.Cfg.DeviceIP
+	} else if sandboxID != "" {
+		statusCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		resp, err := p.runtime.PodSandboxStatus(statusCtx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: sandboxID})
+		cancel()
+		if err != nil {
+			fmt.Printf("Failed to get status of sandbox %s: %s\n", sandboxID, err.Error())
+		} else if resp.Status != nil && resp.Status.Network != nil {
+			podIP = resp.Status.Network.Ip
+		}
+	}
+
+	statuses := map[string]v1.ContainerStatus{}
+	for name, id := range containerIDs {
+		statusCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		resp, err := p.runtime.ContainerStatus(statusCtx, &runtimeapi.ContainerStatusRequest{ContainerId: id})
+		cancel()
+		if err != nil {
+			fmt.Printf("Failed to get status of container %s: %s\n", id, err.Error())
+			continue
+		}
+		statuses[name] = ConvertContainerStatus(p.runtimeName, images[name], resp.Status)
+	}
+
+	p.Store.Lock()
+	if cpod.deleted {
+		p.Store.Unlock()
+		return
+	}
+	if podIP != "" {
+		cpod.pod.Status.PodIP = podIP
+	}
+	for name, status := range statuses {
+		cont := cpod.containers[name]
+		//the container was restarted while its status was queried
+		if cont.id != containerIDs[name] {
+			continue
+		}
+		cont.status = status
+		cont.hasStatus = true
+		terminated := status.State.Terminated
+		if terminated != nil && cont.waitingUntil.IsZero() && vkube.ShouldRestart(cpod.pod.Spec.RestartPolicy, terminated.ExitCode) {
+			p.scheduleRestart(cpod, name, cont, terminated)
+		}
+	}
+	p.Store.Unlock()
+	p.Store.UpdateStatus(cpod)
+}
+
+func (p *CRIProvider) SupportedVolumeTypes() []string {
+	return providers.VolumeTypesMountable
+}
@@ -0,0 +1,607 @@
+package cri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/manager"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/testutil"
+
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	utilexec "k8s.io/utils/exec"
+)
+
+// fakeRuntime is a CRI runtime that keeps sandboxes and containers in memory and records the calls it gets
+type fakeRuntime struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+	runtimeapi.UnimplementedImageServiceServer
+
+	lock       sync.Mutex
+	calls      []string
+	images     map[string]bool
+	sandboxes  map[string]runtimeapi.PodSandboxState
+	containers map[string]*runtimeapi.ContainerStatus
+	pullAuth   map[string]*runtimeapi.AuthConfig
+	failing    map[string]bool
+	nextID     int
+}
+
+func (f *fakeRuntime) record(format string, args ...interface{}) {
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeRuntime) getCalls() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+func (f *fakeRuntime) Version(ctx context.Context, req *runtimeapi.VersionRequest) (*runtimeapi.VersionResponse, error) {
+	return &runtimeapi.VersionResponse{RuntimeName: "fake", RuntimeVersion: "0.1", RuntimeApiVersion: "v1"}, nil
+}
+
+func (f *fakeRuntime) RunPodSandbox(ctx context.Context, req *runtimeapi.RunPodSandboxRequest) (*runtimeapi.RunPodSandboxResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nextID++
+	id := fmt.Sprintf("sandbox-%d", f.nextID)
+	f.sandboxes[id] = runtimeapi.PodSandboxState_SANDBOX_READY
+	f.record("RunPodSandbox %s/%s", req.Config.Metadata.Namespace, req.Config.Metadata.Name)
+	return &runtimeapi.RunPodSandboxResponse{PodSandboxId: id}, nil
+}
+
+func (f *fakeRuntime) StopPodSandbox(ctx context.Context, req *runtimeapi.StopPodSandboxRequest) (*runtimeapi.StopPodSandboxResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.failing["StopPodSandbox"] {
+		return nil, fmt.Errorf("sandbox %s didn't stop", req.PodSandboxId)
+	}
+	f.sandboxes[req.PodSandboxId] = runtimeapi.PodSandboxState_SANDBOX_NOTREADY
+	f.record("StopPodSandbox %s", req.PodSandboxId)
+	return &runtimeapi.StopPodSandboxResponse{}, nil
+}
+
+func (f *fakeRuntime) RemovePodSandbox(ctx context.Context, req *runtimeapi.RemovePodSandboxRequest) (*runtimeapi.RemovePodSandboxResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.sandboxes, req.PodSandboxId)
+	f.record("RemovePodSandbox %s", req.PodSandboxId)
+	return &runtimeapi.RemovePodSandboxResponse{}, nil
+}
+
+func (f *fakeRuntime) PodSandboxStatus(ctx context.Context, req *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	state, found := f.sandboxes[req.PodSandboxId]
+	if !found {
+		return nil, fmt.Errorf("sandbox %s not found", req.PodSandboxId)
+	}
+	return &runtimeapi.PodSandboxStatusResponse{Status: &runtimeapi.PodSandboxStatus{
+		Id:      req.PodSandboxId,
+		State:   state,
+		Network: &runtimeapi.PodSandboxNetworkStatus{Ip: "10.88.0.5"},
+	}}, nil
+}
+
+func (f *fakeRuntime) CreateContainer(ctx context.Context, req *runtimeapi.CreateContainerRequest) (*runtimeapi.CreateContainerResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, found := f.sandboxes[req.PodSandboxId]; !found {
+		return nil, fmt.Errorf("sandbox %s not found", req.PodSandboxId)
+	}
+	f.nextID++
+	id := fmt.Sprintf("container-%d", f.nextID)
+	f.containers[id] = &runtimeapi.ContainerStatus{
+		Id:       id,
+		Metadata: req.Config.Metadata,
+		State:    runtimeapi.ContainerState_CONTAINER_CREATED,
+		ImageRef: req.Config.Image.Image,
+	}
+	//the runtime creates the log file, every attempt writes its own
+	if req.SandboxConfig != nil {
+		logPath := filepath.Join(req.SandboxConfig.LogDirectory, req.Config.LogPath)
+		os.MkdirAll(filepath.Dir(logPath), 0755)
+		line := fmt.Sprintf("%s stdout F attempt %d\n", time.Now().Format(time.RFC3339Nano), req.Config.Metadata.Attempt)
+		ioutil.WriteFile(logPath, []byte(line), 0644)
+	}
+	f.record("CreateContainer %s", req.Config.Metadata.Name)
+	return &runtimeapi.CreateContainerResponse{ContainerId: id}, nil
+}
+
+func (f *fakeRuntime) StartContainer(ctx context.Context, req *runtimeapi.StartContainerRequest) (*runtimeapi.StartContainerResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	status, found := f.containers[req.ContainerId]
+	if !found {
+		return nil, fmt.Errorf("container %s not found", req.ContainerId)
+	}
+	status.State = runtimeapi.ContainerState_CONTAINER_RUNNING
+	status.StartedAt = time.Now().UnixNano()
+	f.record("StartContainer %s", req.ContainerId)
+	return &runtimeapi.StartContainerResponse{}, nil
+}
+
+func (f *fakeRuntime) StopContainer(ctx context.Context, req *runtimeapi.StopContainerRequest) (*runtimeapi.StopContainerResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.failing["StopContainer"] {
+		return nil, fmt.Errorf("container %s didn't stop", req.ContainerId)
+	}
+	if status, found := f.containers[req.ContainerId]; found {
+		status.State = runtimeapi.ContainerState_CONTAINER_EXITED
+		status.FinishedAt = time.Now().UnixNano()
+	}
+	f.record("StopContainer %s %d", req.ContainerId, req.Timeout)
+	return &runtimeapi.StopContainerResponse{}, nil
+}
+
+func (f *fakeRuntime) RemoveContainer(ctx context.Context, req *runtimeapi.RemoveContainerRequest) (*runtimeapi.RemoveContainerResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.containers, req.ContainerId)
+	f.record("RemoveContainer %s", req.ContainerId)
+	return &runtimeapi.RemoveContainerResponse{}, nil
+}
+
+// exit lets a running container exit with code
+func (f *fakeRuntime) exit(id string, code int32) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	status := f.containers[id]
+	status.State = runtimeapi.ContainerState_CONTAINER_EXITED
+	status.ExitCode = code
+	status.FinishedAt = time.Now().UnixNano()
+}
+
+func (f *fakeRuntime) ContainerStatus(ctx context.Context, req *runtimeapi.ContainerStatusRequest) (*runtimeapi.ContainerStatusResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	status, found := f.containers[req.ContainerId]
+	if !found {
+		return nil, fmt.Errorf("container %s not found", req.ContainerId)
+	}
+	copied := *status
+	return &runtimeapi.ContainerStatusResponse{Status: &copied}, nil
+}
+
+// ExecSync echoes the command, a command named fail exits with 2
+func (f *fakeRuntime) ExecSync(ctx context.Context, req *runtimeapi.ExecSyncRequest) (*runtimeapi.ExecSyncResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.record("ExecSync %s %v", req.ContainerId, req.Cmd)
+	if len(req.Cmd) > 0 && req.Cmd[0] == "fail" {
+		return &runtimeapi.ExecSyncResponse{Stderr: []byte("failed\n"), ExitCode: 2}, nil
+	}
+	return &runtimeapi.ExecSyncResponse{Stdout: []byte(fmt.Sprintf("%v\n", req.Cmd))}, nil
+}
+
+func (f *fakeRuntime) ImageStatus(ctx context.Context, req *runtimeapi.ImageStatusRequest) (*runtimeapi.ImageStatusResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.images[req.Image.Image] {
+		return &runtimeapi.ImageStatusResponse{}, nil
+	}
+	return &runtimeapi.ImageStatusResponse{Image: &runtimeapi.Image{Id: req.Image.Image, RepoTags: []string{req.Image.Image}}}, nil
+}
+
+func (f *fakeRuntime) PullImage(ctx context.Context, req *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.images[req.Image.Image] = true
+	f.pullAuth[req.Image.Image] = req.Auth
+	f.record("PullImage %s", req.Image.Image)
+	return &runtimeapi.PullImageResponse{ImageRef: req.Image.Image}, nil
+}
+
+// newTestProvider serves a fake runtime on a unix socket in a temporary directory and connects a provider to it
+func newTestProvider(t *testing.T) (*CRIProvider, *fakeRuntime) {
+	config.Cfg = &config.Config{DeviceIP: "10.0.0.1"}
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "cri.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeRuntime{
+		images:     make(map[string]bool),
+		sandboxes:  make(map[string]runtimeapi.PodSandboxState),
+		containers: make(map[string]*runtimeapi.ContainerStatus),
+		pullAuth:   make(map[string]*runtimeapi.AuthConfig),
+		failing:    make(map[string]bool),
+	}
+	server := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(server, fake)
+	runtimeapi.RegisterImageServiceServer(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	p, err := NewCRIProvider(config.CRIConfig{Endpoint: "unix://" + socket, Timeout: 5, PodLogDir: filepath.Join(dir, "pods")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.conn.Close()
+	})
+	return p, fake
+}
+
+func newTestPod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "app", Image: "docker.io/library/nginx:latest"}},
+		},
+	}
+}
+
+func TestCreatePodRunsSandboxAndContainers(t *testing.T) {
+	p, fake := newTestProvider(t)
+	pod := newTestPod("web")
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Status.Phase != "" {
+		t.Fatalf("the caller's pod was changed, phase %s", pod.Status.Phase)
+	}
+
+	calls := fake.getCalls()
+	want := []string{
+		"RunPodSandbox default/web",
+		"PullImage docker.io/library/nginx:latest",
+		"CreateContainer app",
+		"StartContainer container-2",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Fatalf("runtime got calls %v, want %v", calls, want)
+	}
+
+	got, err := p.GetPod(context.Background(), "default", "web")
+	if err != nil || got == nil {
+		t.Fatalf("pod not found: %v", err)
+	}
+	if got.Status.PodIP != "10.88.0.5" {
+		t.Fatalf("pod ip %q, want the sandbox's", got.Status.PodIP)
+	}
+	if len(got.Status.ContainerStatuses) != 1 || got.Status.ContainerStatuses[0].State.Running == nil {
+		t.Fatalf("container isn't running: %+v", got.Status.ContainerStatuses)
+	}
+	if id := got.Status.ContainerStatuses[0].ContainerID; id != "fake://container-2" {
+		t.Fatalf("container id %s", id)
+	}
+}
+
+func TestCreatePodSkipsPresentImage(t *testing.T) {
+	p, fake := newTestProvider(t)
+	fake.images["docker.io/library/nginx:latest"] = true
+	if err := p.CreatePod(context.Background(), newTestPod("web")); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range fake.getCalls() {
+		if call == "PullImage docker.io/library/nginx:latest" {
+			t.Fatal("present image was pulled again")
+		}
+	}
+}
+
+func TestCreatePodRemovesSandboxWhenImageMissing(t *testing.T) {
+	p, fake := newTestProvider(t)
+	pod := newTestPod("web")
+	pod.Spec.Containers[0].ImagePullPolicy = v1.PullNever
+	if err := p.CreatePod(context.Background(), pod); err == nil {
+		t.Fatal("pod with a missing image that is never pulled was created")
+	}
+
+	calls := fake.getCalls()
+	if last := calls[len(calls)-1]; last != "RemovePodSandbox sandbox-1" {
+		t.Fatalf("sandbox wasn't removed, calls %v", calls)
+	}
+	if got, _ := p.GetPod(context.Background(), "default", "web"); got != nil {
+		t.Fatal("failed pod is still known")
+	}
+}
+
+func TestCreatePodRejectsInitContainers(t *testing.T) {
+	p, fake := newTestProvider(t)
+	pod := newTestPod("web")
+	pod.Spec.InitContainers = []v1.Container{{Name: "migrate", Image: "docker.io/library/busybox:latest"}}
+	if err := p.CreatePod(context.Background(), pod); !providers.IsRuntimeUnavailable(err) {
+		t.Fatalf("creating a pod with init containers returned %v", err)
+	}
+	if calls := fake.getCalls(); len(calls) != 0 {
+		t.Fatalf("runtime got calls %v", calls)
+	}
+}
+
+func TestExecInContainer(t *testing.T) {
+	p, fake := newTestProvider(t)
+	if err := p.CreatePod(context.Background(), newTestPod("web")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []struct {
+		name string
+		uid  types.UID
+	}{
+		{name: "default_web"},
+		{name: "other", uid: "uid-web"},
+	} {
+		out := &testutil.BufferCloser{}
+		if err := p.ExecInContainer(target.name, target.uid, "app", []string{"ls", "/"}, nil, out, nil, false, nil, time.Second); err != nil {
+			t.Fatalf("exec in pod %s/%s: %v", target.name, target.uid, err)
+		}
+		if out.String() != "[ls /]\n" {
+			t.Fatalf("exec output %q", out.String())
+		}
+	}
+
+	errOut := &testutil.BufferCloser{}
+	err := p.ExecInContainer("default_web", "", "app", []string{"fail"}, nil, nil, errOut, false, nil, time.Second)
+	if exitErr, ok := err.(utilexec.CodeExitError); !ok || exitErr.Code != 2 {
+		t.Fatalf("exec of a failing command returned %v", err)
+	}
+	if errOut.String() != "failed\n" {
+		t.Fatalf("exec stderr %q", errOut.String())
+	}
+
+	if err := p.ExecInContainer("default_db", "", "app", []string{"ls"}, nil, nil, nil, false, nil, time.Second); err == nil {
+		t.Fatal("exec in an unknown pod succeeded")
+	}
+	//a bare pod name could be a pod of any namespace
+	if err := p.ExecInContainer("web", "", "app", []string{"ls"}, nil, nil, nil, false, nil, time.Second); err == nil {
+		t.Fatal("exec by the bare pod name succeeded")
+	}
+	execs := 0
+	for _, call := range fake.getCalls() {
+		if call == "ExecSync container-2 [ls /]" {
+			execs++
+		}
+	}
+	if execs != 2 {
+		t.Fatalf("%d execs reached the container, want 2", execs)
+	}
+}
+
+func TestDeletePodStopsContainersAndSandbox(t *testing.T) {
+	p, fake := newTestProvider(t)
+	pod := newTestPod("web")
+	var gracePeriod int64 = 5
+	pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fake.getCalls()
+	want := []string{"StopContainer container-2 5", "StopPodSandbox sandbox-1", "RemovePodSandbox sandbox-1"}
+	if fmt.Sprint(calls[len(calls)-3:]) != fmt.Sprint(want) {
+		t.Fatalf("runtime got calls %v, want them to end with %v", calls, want)
+	}
+	if got, _ := p.GetPod(context.Background(), "default", "web"); got != nil {
+		t.Fatal("deleted pod is still known")
+	}
+	if err := p.DeletePod(context.Background(), pod); err == nil {
+		t.Fatal("deleting an unknown pod succeeded")
+	}
+}
+
+func TestDeletePodFailureIsRetried(t *testing.T) {
+	for _, method := range []string{"StopContainer", "StopPodSandbox"} {
+		p, fake := newTestProvider(t)
+		pod := newTestPod("web")
+		if err := p.CreatePod(context.Background(), pod); err != nil {
+			t.Fatal(err)
+		}
+		fake.lock.Lock()
+		fake.failing[method] = true
+		fake.lock.Unlock()
+		if err := p.DeletePod(context.Background(), pod); err == nil {
+			t.Fatalf("deleting the pod succeeded while %s failed", method)
+		}
+		//the pod is kept until its sandbox is removed
+		if got, _ := p.GetPod(context.Background(), "default", "web"); got == nil {
+			t.Fatalf("pod is forgotten while %s failed", method)
+		}
+
+		fake.lock.Lock()
+		fake.failing[method] = false
+		fake.lock.Unlock()
+		if err := p.DeletePod(context.Background(), pod); err != nil {
+			t.Fatal(err)
+		}
+		fake.lock.Lock()
+		sandboxes := len(fake.sandboxes)
+		fake.lock.Unlock()
+		if got, _ := p.GetPod(context.Background(), "default", "web"); got != nil || sandboxes != 0 {
+			t.Fatalf("%s: %d sandboxes left after deleting the pod again", method, sandboxes)
+		}
+	}
+}
+
+func TestDeletePodUsesDeletionGracePeriod(t *testing.T) {
+	p, fake := newTestProvider(t)
+	pod := newTestPod("web")
+	var gracePeriod, deletionGracePeriod int64 = 30, 2
+	pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	pod.DeletionGracePeriodSeconds = &deletionGracePeriod
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, call := range fake.getCalls() {
+		found = found || call == "StopContainer container-2 2"
+	}
+	if !found {
+		t.Fatalf("container wasn't stopped with the grace period of the deletion, calls %v", fake.getCalls())
+	}
+}
+
+// exitContainer lets the current container of the pod exit and has the provider poll its state
+func exitContainer(t *testing.T, p *CRIProvider, fake *fakeRuntime, code int32) {
+	cpod := p.Store.Get("default", "web").(*criPod)
+	p.Store.Lock()
+	id := cpod.containers["app"].id
+	p.Store.Unlock()
+	fake.exit(id, code)
+	p.refreshPod(context.Background(), cpod)
+}
+
+func TestRestartPolicy(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return 10 * time.Millisecond }
+
+	tests := []struct {
+		name     string
+		policy   v1.RestartPolicy
+		exitCode int32
+		restarts bool
+	}{
+		{name: "never", policy: v1.RestartPolicyNever, exitCode: 3},
+		{name: "on failure after failure", policy: v1.RestartPolicyOnFailure, exitCode: 3, restarts: true},
+		{name: "on failure after success", policy: v1.RestartPolicyOnFailure, exitCode: 0},
+		{name: "always", policy: v1.RestartPolicyAlways, exitCode: 0, restarts: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, fake := newTestProvider(t)
+			pod := newTestPod("web")
+			pod.Spec.RestartPolicy = test.policy
+			if err := p.CreatePod(context.Background(), pod); err != nil {
+				t.Fatal(err)
+			}
+			exitContainer(t, p, fake, test.exitCode)
+
+			if !test.restarts {
+				status := testutil.WaitForContainerStatus(t, p, "default", "web", func(status v1.ContainerStatus) bool { return status.State.Terminated != nil })
+				if status.State.Terminated.ExitCode != test.exitCode {
+					t.Fatalf("expected exit code %d, got %d", test.exitCode, status.State.Terminated.ExitCode)
+				}
+				time.Sleep(100 * time.Millisecond)
+				if status := testutil.WaitForContainerStatus(t, p, "default", "web", func(v1.ContainerStatus) bool { return true }); status.RestartCount != 0 {
+					t.Fatal("the container should not have been restarted")
+				}
+				return
+			}
+
+			status := testutil.WaitForContainerStatus(t, p, "default", "web", func(status v1.ContainerStatus) bool { return status.RestartCount == 1 && status.State.Running != nil })
+			if last := status.LastTerminationState.Terminated; last == nil || last.ExitCode != test.exitCode {
+				t.Fatalf("expected the last termination with exit code %d, got %v", test.exitCode, last)
+			}
+			if status.ContainerID != "fake://container-3" {
+				t.Fatalf("the restarted container should be a new one, got %s", status.ContainerID)
+			}
+			if logs := testutil.ReadLogs(t, p, "default", "web", "app", providers.ContainerLogOpts{}); !strings.Contains(logs, "attempt 1") {
+				t.Fatalf("the new run should log to its own file, got %q", logs)
+			}
+			if logs := testutil.ReadLogs(t, p, "default", "web", "app", providers.ContainerLogOpts{Previous: true}); !strings.Contains(logs, "attempt 0") {
+				t.Fatalf("the logs of the previous run should be kept, got %q", logs)
+			}
+
+			//only the container of the previous run is kept
+			exitContainer(t, p, fake, test.exitCode)
+			testutil.WaitForContainerStatus(t, p, "default", "web", func(status v1.ContainerStatus) bool { return status.RestartCount == 2 && status.State.Running != nil })
+			removed := false
+			for _, call := range fake.getCalls() {
+				removed = removed || call == "RemoveContainer container-2"
+			}
+			if !removed {
+				t.Fatalf("the container of the first run should be removed, calls %v", fake.getCalls())
+			}
+			if _, err := os.Stat(filepath.Join(PodLogDirectory(p.podLogDir, pod), ContainerLogPath("app", 0))); !os.IsNotExist(err) {
+				t.Fatal("the log of the first run should be removed")
+			}
+		})
+	}
+}
+
+func TestCrashLoopBackOff(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return time.Minute }
+
+	p, fake := newTestProvider(t)
+	pod := newTestPod("web")
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	exitContainer(t, p, fake, 3)
+
+	status := testutil.WaitForContainerStatus(t, p, "default", "web", func(status v1.ContainerStatus) bool { return status.State.Waiting != nil })
+	if status.State.Waiting.Reason != "CrashLoopBackOff" {
+		t.Fatalf("expected CrashLoopBackOff, got %s", status.State.Waiting.Reason)
+	}
+	if status.LastTerminationState.Terminated == nil || status.LastTerminationState.Terminated.ExitCode != 3 {
+		t.Fatal("the exit of the container should be the last termination state")
+	}
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImagePullUsesPullSecrets(t *testing.T) {
+	dockerConfig := `{"auths":{"registry.example.com":{"auth":"dXNlcjpzZWNyZXQ="}}}`
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/namespaces/default/secrets/regcred":
+			json.NewEncoder(w).Encode(&v1.Secret{
+				TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "regcred"},
+				Type:       v1.SecretTypeDockerConfigJson,
+				Data:       map[string][]byte{v1.DockerConfigJsonKey: []byte(dockerConfig)},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+		}
+	}))
+	defer api.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: api.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm, _ := manager.NewResourceManager(nil, client)
+
+	p, fake := newTestProvider(t)
+	p.UseResourceManager(rm)
+	pod := newTestPod("web")
+	pod.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "regcred"}}
+	pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: "private", Image: "registry.example.com/team/app:v1"})
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if auth := fake.pullAuth["registry.example.com/team/app:v1"]; auth == nil || auth.Username != "user" || auth.Password != "secret" {
+		t.Fatalf("image of the registry in the pull secret was pulled with %v", auth)
+	}
+	if auth := fake.pullAuth["docker.io/library/nginx:latest"]; auth != nil {
+		t.Fatalf("image of another registry was pulled with %v", auth)
+	}
+}
@@ -0,0 +1,240 @@
+package cri
+
+import (
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// labels the kubelet puts on sandboxes and containers, other tools (crictl, log collectors) rely on them
+const (
+	podNameLabel       = "io.kubernetes.pod.name"
+	podNamespaceLabel  = "io.kubernetes.pod.namespace"
+	podUIDLabel        = "io.kubernetes.pod.uid"
+	containerNameLabel = "io.kubernetes.container.name"
+)
+
+const (
+	cpuPeriod    = 100000
+	minCpuShares = 2
+)
+
+// PodLogDirectory is where the runtime writes the logs of a pod's containers
+func PodLogDirectory(podLogDir string, pod *v1.Pod) string {
+	return filepath.Join(podLogDir, fmt.Sprintf("%s_%s_%s", pod.Namespace, pod.Name, pod.UID))
+}
+
+// ContainerLogPath is the log file of a container, relative to the pod's log directory
+func ContainerLogPath(containerName string, restartCount int) string {
+	return filepath.Join(containerName, fmt.Sprintf("%d.log", restartCount))
+}
+
+func podLabels(pod *v1.Pod) map[string]string {
+	labels := map[string]string{}
+	for k, v := range pod.Labels {
+		labels[k] = v
+	}
+	labels[podNameLabel] = pod.Name
+	labels[podNamespaceLabel] = pod.Namespace
+	labels[podUIDLabel] = string(pod.UID)
+	return labels
+}
+
+func namespaceOptions(pod *v1.Pod) *runtimeapi.NamespaceOption {
+	options := &runtimeapi.NamespaceOption{
+		Network: runtimeapi.NamespaceMode_POD,
+		Pid:     runtimeapi.NamespaceMode_CONTAINER,
+		Ipc:     runtimeapi.NamespaceMode_POD,
+	}
+	if pod.Spec.HostNetwork {
+		options.Network = runtimeapi.NamespaceMode_NODE
+	}
+	if pod.Spec.HostPID {
+		options.Pid = runtimeapi.NamespaceMode_NODE
+	} else if pod.Spec.ShareProcessNamespace != nil && *pod.Spec.ShareProcessNamespace {
+		options.Pid = runtimeapi.NamespaceMode_POD
+	}
+	if pod.Spec.HostIPC {
+		options.Ipc = runtimeapi.NamespaceMode_NODE
+	}
+	return options
+}
+
+func portMappings(pod *v1.Pod) []*runtimeapi.PortMapping {
+	mappings := []*runtimeapi.PortMapping{}
+	for _, cont := range pod.Spec.Containers {
+		for _, port := range cont.Ports {
+			protocol := runtimeapi.Protocol_TCP
+			switch port.Protocol {
+			case v1.ProtocolUDP:
+				protocol = runtimeapi.Protocol_UDP
+			case v1.ProtocolSCTP:
+				protocol = runtimeapi.Protocol_SCTP
+			}
+			mappings = append(mappings, &runtimeapi.PortMapping{
+				Protocol:      protocol,
+				ContainerPort: port.ContainerPort,
+				HostPort:      port.HostPort,
+				HostIp:        port.HostIP,
+			})
+		}
+	}
+	return mappings
+}
+
+// BuildSandboxConfig translates a pod into the CRI sandbox that holds its shared namespaces
+func BuildSandboxConfig(podLogDir string, pod *v1.Pod) *runtimeapi.PodSandboxConfig {
+	hostname := pod.Name
+	if pod.Spec.Hostname != "" {
+		hostname = pod.Spec.Hostname
+	}
+
+	sandboxConfig := &runtimeapi.PodSandboxConfig{
+		Metadata: &runtimeapi.PodSandboxMetadata{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Uid:       string(pod.UID),
+		},
+		Hostname:     hostname,
+		LogDirectory: PodLogDirectory(podLogDir, pod),
+		PortMappings: portMappings(pod),
+		Labels:       podLabels(pod),
+		Annotations:  pod.Annotations,
+		Linux: &runtimeapi.LinuxPodSandboxConfig{
+			SecurityContext: &runtimeapi.LinuxSandboxSecurityContext{
+				NamespaceOptions: namespaceOptions(pod),
+			},
+		},
+	}
+	if pod.Spec.DNSConfig != nil {
+		sandboxConfig.DnsConfig = &runtimeapi.DNSConfig{
+			Servers:  pod.Spec.DNSConfig.Nameservers,
+			Searches: pod.Spec.DNSConfig.Searches,
+		}
+		for _, opt := range pod.Spec.DNSConfig.Options {
+			if opt.Value != nil {
+				sandboxConfig.DnsConfig.Options = append(sandboxConfig.DnsConfig.Options, opt.Name+":"+*opt.Value)
+			} else {
+				sandboxConfig.DnsConfig.Options = append(sandboxConfig.DnsConfig.Options, opt.Name)
+			}
+		}
+	}
+	return sandboxConfig
+}
+
+// ContainerResources converts limits into cgroup settings, requests are used for cpu shares
+func ContainerResources(dc *v1.Container) *runtimeapi.LinuxContainerResources {
+	resources := &runtimeapi.LinuxContainerResources{}
+
+	if cpu := dc.Resources.Limits.Cpu(); !cpu.IsZero() {
+		resources.CpuPeriod = cpuPeriod
+		resources.CpuQuota = cpu.MilliValue() * cpuPeriod / 1000
+	}
+	cpuRequest := dc.Resources.Requests.Cpu()
+	if cpuRequest.IsZero() {
+		cpuRequest = dc.Resources.Limits.Cpu()
+	}
+	shares := cpuRequest.MilliValue() * 1024 / 1000
+	if shares < minCpuShares {
+		shares = minCpuShares
+	}
+	resources.CpuShares = shares
+
+	if mem := dc.Resources.Limits.Memory(); !mem.IsZero() {
+		resources.MemoryLimitInBytes = mem.Value()
+	}
+	return resources
+}
+
+// BuildMounts maps the container's volume mounts to host paths, volumes that can't be mounted are skipped
+func BuildMounts(pod *v1.Pod, dc *v1.Container) []*runtimeapi.Mount {
+	mounts := []*runtimeapi.Mount{}
+	for _, volMount := range dc.VolumeMounts {
+		var volume *v1.Volume
+		for i := range pod.Spec.Volumes {
+			if pod.Spec.Volumes[i].Name == volMount.Name {
+				volume = &pod.Spec.Volumes[i]
+			}
+		}
+		if volume == nil {
+			fmt.Printf("No volume found for mount %s\n", volMount.Name)
+			continue
+		}
+		hostPath := vkube.GetHostMountPath(pod, *volume)
+		if hostPath == nil {
+			continue
+		}
+		mounts = append(mounts, &runtimeapi.Mount{
+			ContainerPath: volMount.MountPath,
+			HostPath:      filepath.Join(*hostPath, volMount.SubPath),
+			Readonly:      volMount.ReadOnly,
+		})
+	}
+	return mounts
+}
+
+// BuildContainerConfig translates a container of the pod into a CRI container config
+func BuildContainerConfig(pod *v1.Pod, dc *v1.Container, restartCount int) *runtimeapi.ContainerConfig {
+	envs := []*runtimeapi.KeyValue{}
+	for _, evar := range dc.Env {
+		if evar.ValueFrom == nil {
+			envs = append(envs, &runtimeapi.KeyValue{Key: evar.Name, Value: evar.Value})
+		}
+	}
+
+	labels := podLabels(pod)
+	labels[containerNameLabel] = dc.Name
+
+	containerConfig := &runtimeapi.ContainerConfig{
+		Metadata: &runtimeapi.ContainerMetadata{
+			Name:    dc.Name,
+			Attempt: uint32(restartCount),
+		},
+		Image:      &runtimeapi.ImageSpec{Image: dc.Image},
+		Command:    dc.Command,
+		Args:       dc.Args,
+		WorkingDir: dc.WorkingDir,
+		Envs:       envs,
+		Mounts:     BuildMounts(pod, dc),
+		Labels:     labels,
+		LogPath:    ContainerLogPath(dc.Name, restartCount),
+		Stdin:      dc.Stdin,
+		StdinOnce:  dc.StdinOnce,
+		Tty:        dc.TTY,
+		Linux: &runtimeapi.LinuxContainerConfig{
+			Resources: ContainerResources(dc),
+			SecurityContext: &runtimeapi.LinuxContainerSecurityContext{
+				NamespaceOptions: namespaceOptions(pod),
+			},
+		},
+	}
+	if sc := dc.SecurityContext; sc != nil {
+		linuxSc := containerConfig.Linux.SecurityContext
+		if sc.Privileged != nil {
+			linuxSc.Privileged = *sc.Privileged
+		}
+		if sc.RunAsUser != nil {
+			linuxSc.RunAsUser = &runtimeapi.Int64Value{Value: *sc.RunAsUser}
+		}
+		if sc.RunAsGroup != nil {
+			linuxSc.RunAsGroup = &runtimeapi.Int64Value{Value: *sc.RunAsGroup}
+		}
+		if sc.ReadOnlyRootFilesystem != nil {
+			linuxSc.ReadonlyRootfs = *sc.ReadOnlyRootFilesystem
+		}
+		if sc.Capabilities != nil {
+			linuxSc.Capabilities = &runtimeapi.Capability{}
+			for _, c := range sc.Capabilities.Add {
+				linuxSc.Capabilities.AddCapabilities = append(linuxSc.Capabilities.AddCapabilities, strings.ToUpper(string(c)))
+			}
+			for _, c := range sc.Capabilities.Drop {
+				linuxSc.Capabilities.DropCapabilities = append(linuxSc.Capabilities.DropCapabilities, strings.ToUpper(string(c)))
+			}
+		}
+	}
+	return containerConfig
+}
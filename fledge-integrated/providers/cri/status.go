@@ -0,0 +1,54 @@
+package cri
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// ConvertContainerStatus translates the runtime's view of a container into a kubernetes container status
+func ConvertContainerStatus(runtimeName string, image string, status *runtimeapi.ContainerStatus) v1.ContainerStatus {
+	state := v1.ContainerState{}
+	switch status.State {
+	case runtimeapi.ContainerState_CONTAINER_RUNNING:
+		state.Running = &v1.ContainerStateRunning{
+			StartedAt: metav1.NewTime(time.Unix(0, status.StartedAt)),
+		}
+	case runtimeapi.ContainerState_CONTAINER_EXITED:
+		reason := status.Reason
+		if reason == "" {
+			reason = "Completed"
+			if status.ExitCode != 0 {
+				reason = "Error"
+			}
+		}
+		state.Terminated = &v1.ContainerStateTerminated{
+			ExitCode:    status.ExitCode,
+			Reason:      reason,
+			Message:     status.Message,
+			StartedAt:   metav1.NewTime(time.Unix(0, status.StartedAt)),
+			FinishedAt:  metav1.NewTime(time.Unix(0, status.FinishedAt)),
+			ContainerID: fmt.Sprintf("%s://%s", runtimeName, status.Id),
+		}
+	case runtimeapi.ContainerState_CONTAINER_CREATED:
+		state.Waiting = &v1.ContainerStateWaiting{Reason: "ContainerCreating"}
+	default:
+		state.Waiting = &v1.ContainerStateWaiting{Reason: "ContainerStatusUnknown", Message: status.Message}
+	}
+
+	name := ""
+	if status.Metadata != nil {
+		name = status.Metadata.Name
+	}
+	return v1.ContainerStatus{
+		Name:        name,
+		State:       state,
+		Ready:       status.State == runtimeapi.ContainerState_CONTAINER_RUNNING,
+		Image:       image,
+		ImageID:     status.ImageRef,
+		ContainerID: fmt.Sprintf("%s://%s", runtimeName, status.Id),
+	}
+}
@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"context"
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// DockerProvider runs pods on dockerd, it keeps its own runtime interface so it can be used next to containerd
+type DockerProvider struct {
+	runtime *vkube.DockerRuntimeInterface
+}
+
+func NewDockerProvider(cfg config.DockerConfig) (*DockerProvider, error) {
+	runtime, err := vkube.NewDockerRuntimeInterface(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DockerProvider{runtime: runtime}, nil
+}
+
+func (p *DockerProvider) PodsChanged() bool {
+	return p.runtime.PodsChanged()
+}
+
+func (p *DockerProvider) ResetChanges() {
+	p.runtime.ResetFlags()
+}
+
+func (p *DockerProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Creating docker pod namespace %s name %s\n", pod.Namespace, pod.Name)
+	return p.runtime.StartPod(ctx, pod)
+}
+
+// UpdatePod recreates the pod's containers with the new spec
+func (p *DockerProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Updating pod namespace %s name %s\n", pod.Namespace, pod.Name)
+
+	if err := p.runtime.StopPod(ctx, pod); err != nil && !strongerrors.IsNotFound(err) {
+		return err
+	}
+	return p.runtime.StartPod(ctx, pod)
+}
+
+func (p *DockerProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Deleting pod namespace %s name %s\n", pod.Namespace, pod.Name)
+	return p.runtime.StopPod(ctx, pod)
+}
+
+func (p *DockerProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	pod, found := p.runtime.GetPod(namespace, name)
+	if !found {
+		return nil, nil
+	}
+	return pod, nil
+}
+
+// GetContainerLogs returns the logs of a container running in a pod by name.
+func (p *DockerProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	return p.runtime.ContainerLogs(ctx, namespace, podName, containerName, opts)
+}
+
+// ExecInContainer executes a command in a container in the pod, copying data
+// between in/out/err and the container's stdin/stdout/stderr.
+func (p *DockerProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	return p.runtime.ExecInContainer(name, uid, container, cmd, in, out, err, tty, resize, timeout)
+}
+
+// AttachToContainer connects to the stdio of a running container.
+func (p *DockerProvider) AttachToContainer(name string, uid types.UID, container string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	return p.runtime.AttachToContainer(name, uid, container, in, out, err, tty, resize)
+}
+
+// PortForward connects stream to a port inside the network namespace of the pod.
+func (p *DockerProvider) PortForward(ctx context.Context, namespace, podName string, port int32, stream io.ReadWriteCloser) error {
+	pod, found := p.runtime.GetPod(namespace, podName)
+	if !found {
+		return strongerrors.NotFound(errors.Errorf("pod %s/%s not found", namespace, podName))
+	}
+	return vkube.ForwardPodPort(ctx, pod, port, stream)
+}
+
+// GetPodStatus retrieves the status of a given pod by name.
+func (p *DockerProvider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
+	pod, err := p.GetPod(ctx, namespace, name)
+	if pod == nil || err != nil {
+		return nil, err
+	}
+	return &pod.Status, nil
+}
+
+// GetPods retrieves a list of all pods scheduled to run.
+func (p *DockerProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	return p.runtime.GetPods(), nil
+}
+
+func (p *DockerProvider) SupportedVolumeTypes() []string {
+	return providers.VolumeTypesMountable
+}
+
+func (p *DockerProvider) SupportsExec() bool {
+	return true
+}
+
+func (p *DockerProvider) SupportsLogs() bool {
+	return true
+}
@@ -0,0 +1,103 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// RuntimeUnavailableError is returned when a pod requests a runtime that isn't available on this device.
+type RuntimeUnavailableError struct {
+	Runtime string
+	Reason  string
+}
+
+func (e *RuntimeUnavailableError) Error() string {
+	return fmt.Sprintf("runtime %q is not available on this device: %s", e.Runtime, e.Reason)
+}
+
+// IsRuntimeUnavailable returns true if the cause of err is a RuntimeUnavailableError.
+func IsRuntimeUnavailable(err error) bool {
+	_, ok := errors.Cause(err).(*RuntimeUnavailableError)
+	return ok
+}
+
+// Waiting reasons of a container that couldn't be started, as the kubelet reports them.
+const (
+	ReasonErrImagePull               = "ErrImagePull"
+	ReasonImagePullBackOff           = "ImagePullBackOff"
+	ReasonErrImageNeverPull          = "ErrImageNeverPull"
+	ReasonInvalidImageName           = "InvalidImageName"
+	ReasonCreateContainerConfigError = "CreateContainerConfigError"
+	ReasonCreateContainerError       = "CreateContainerError"
+	ReasonRunContainerError          = "RunContainerError"
+)
+
+// ImagePullError is returned when the image of a container can't be pulled.
+// Reason is ErrImagePull unless it is set, e.g. to ImagePullBackOff while pulling the image is backing off.
+type ImagePullError struct {
+	Container string
+	Image     string
+	Reason    string
+	Err       error
+}
+
+func (e *ImagePullError) Error() string {
+	return fmt.Sprintf("failed to pull image %q for container %s: %v", e.Image, e.Container, e.Err)
+}
+
+func (e *ImagePullError) Unwrap() error {
+	return e.Err
+}
+
+// CreateContainerError is returned when a container can't be created, Config is set when its spec is at fault.
+type CreateContainerError struct {
+	Container string
+	Config    bool
+	Err       error
+}
+
+func (e *CreateContainerError) Error() string {
+	return fmt.Sprintf("failed to create container %s: %v", e.Container, e.Err)
+}
+
+func (e *CreateContainerError) Unwrap() error {
+	return e.Err
+}
+
+// RunContainerError is returned when a created container can't be started.
+type RunContainerError struct {
+	Container string
+	Err       error
+}
+
+func (e *RunContainerError) Error() string {
+	return fmt.Sprintf("failed to start container %s: %v", e.Container, e.Err)
+}
+
+func (e *RunContainerError) Unwrap() error {
+	return e.Err
+}
+
+// ContainerWaitingReason returns the container that couldn't be started because of err and the waiting reason to report for it,
+// ok is false when err isn't about a single container.
+func ContainerWaitingReason(err error) (container string, reason string, ok bool) {
+	var pullErr *ImagePullError
+	var createErr *CreateContainerError
+	var runErr *RunContainerError
+	switch {
+	case errors.As(err, &pullErr):
+		if pullErr.Reason != "" {
+			return pullErr.Container, pullErr.Reason, true
+		}
+		return pullErr.Container, ReasonErrImagePull, true
+	case errors.As(err, &createErr):
+		if createErr.Config {
+			return createErr.Container, ReasonCreateContainerConfigError, true
+		}
+		return createErr.Container, ReasonCreateContainerError, true
+	case errors.As(err, &runErr):
+		return runErr.Container, ReasonRunContainerError, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,49 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestContainerWaitingReason(t *testing.T) {
+	cause := errors.New("boom")
+	cases := []struct {
+		name      string
+		err       error
+		container string
+		reason    string
+		ok        bool
+	}{
+		{"pull", &ImagePullError{Container: "app", Image: "nginx", Err: cause}, "app", ReasonErrImagePull, true},
+		{"pull backing off", &ImagePullError{Container: "app", Image: "nginx", Reason: ReasonImagePullBackOff, Err: cause}, "app", ReasonImagePullBackOff, true},
+		{"pull never", &ImagePullError{Container: "app", Image: "nginx", Reason: ReasonErrImageNeverPull, Err: cause}, "app", ReasonErrImageNeverPull, true},
+		{"create", &CreateContainerError{Container: "app", Err: cause}, "app", ReasonCreateContainerError, true},
+		{"create config", &CreateContainerError{Container: "app", Config: true, Err: cause}, "app", ReasonCreateContainerConfigError, true},
+		{"run", &RunContainerError{Container: "sidecar", Err: cause}, "sidecar", ReasonRunContainerError, true},
+		{"wrapped pull", errors.Wrap(&ImagePullError{Container: "app", Image: "nginx", Err: cause}, "failed to deploy"), "app", ReasonErrImagePull, true},
+		{"wrapped twice", errors.Wrapf(errors.Wrap(&RunContainerError{Container: "sidecar", Err: cause}, "failed to deploy"), "pod %s", "web"), "sidecar", ReasonRunContainerError, true},
+		{"runtime unavailable", &RuntimeUnavailableError{Runtime: "wasm", Reason: "down"}, "", "", false},
+		{"plain", cause, "", "", false},
+		{"nil", nil, "", "", false},
+	}
+	for _, c := range cases {
+		container, reason, ok := ContainerWaitingReason(c.err)
+		if container != c.container || reason != c.reason || ok != c.ok {
+			t.Errorf("%s: got %q, %q, %t, want %q, %q, %t", c.name, container, reason, ok, c.container, c.reason, c.ok)
+		}
+	}
+}
+
+func TestContainerErrorsUnwrap(t *testing.T) {
+	cause := errors.New("boom")
+	for _, err := range []error{
+		&ImagePullError{Container: "app", Err: cause},
+		&CreateContainerError{Container: "app", Err: cause},
+		&RunContainerError{Container: "app", Err: cause},
+	} {
+		if !errors.Is(errors.Wrap(err, "failed to deploy"), cause) {
+			t.Errorf("%T doesn't unwrap to its cause", err)
+		}
+	}
+}
@@ -5,23 +5,26 @@ import (
 	"fledge/fledge-integrated/config"
 	"fledge/fledge-integrated/manager"
 	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/selector"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
-	"time"
+	"sync"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/tools/record"
 )
 
 var reInsideWhtsp = regexp.MustCompile(`\s+`)
 
+// pods a pod provider can run when it doesn't report a limit and none is configured, the kubelet's default.
+// It is also the most pods the node runs in total.
+const DefaultMaxPods = 110
+
 type FledgeProviderConfig struct {
 	//ConfigPath      string
 	NodeName              string
@@ -31,6 +34,8 @@ type FledgeProviderConfig struct {
 	ResourceManager       *manager.ResourceManager
 	AvailablePodProviders map[string]*providers.PodProvider
 	BridgeDevice          string
+	DefaultRuntime        string
+	RuntimeClasses        map[string]string
 }
 
 type FledgeProvider struct {
@@ -40,12 +45,18 @@ type FledgeProvider struct {
 	lastMemoryPressure  bool
 	lastStoragePressure bool
 	lastStorageFull     bool
+	podOwners           map[string]podOwner
+	podOwnersLock       sync.RWMutex
+	runtimeSelector     *selector.RuntimeSelector
+	recorder            record.EventRecorder
 }
 
 func NewFledgeProvider(cfg FledgeProviderConfig) (*FledgeProvider, error) {
 	var provider FledgeProvider
 
 	provider.config = cfg
+	provider.podOwners = make(map[string]podOwner)
+	provider.runtimeSelector = selector.NewRuntimeSelector(cfg.DefaultRuntime, cfg.RuntimeClasses, provider.podProviderNames())
 
 	//force a node update first time
 	provider.lastMemoryPressure = true
@@ -65,6 +76,17 @@ func (p *FledgeProvider) Architecture() string {
 }
 
 func (p *FledgeProvider) Capacity(ctx context.Context) v1.ResourceList {
+	resources := p.Allocatable(ctx)
+
+	//not allocatable, but reported so the usage of the device can be followed
+	for name, val := range p.GetContainerResources(ctx) {
+		resources[name] = val
+	}
+	return resources
+}
+
+// Allocatable returns the resources pods can be scheduled on, GPUs are only offered if a pod provider can use them
+func (p *FledgeProvider) Allocatable(ctx context.Context) v1.ResourceList {
 	resources := v1.ResourceList{} //make(map[v1.ResourceName]string)
 	cpu, _ := resource.ParseQuantity(manager.CpuCores())
 	resources[v1.ResourceCPU] = cpu
@@ -72,28 +94,19 @@ func (p *FledgeProvider) Capacity(ctx context.Context) v1.ResourceList {
 	resources[v1.ResourceMemory] = mem
 	stor, _ := resource.ParseQuantity(manager.TotalStorage() + "i")
 	resources[v1.ResourceStorage] = stor
-	pods, _ := resource.ParseQuantity("2")
-	resources[v1.ResourcePods] = pods
-
-	contResources := GetContainerResources()
-
-	resources[v1.ResourceRequestsCPU] = *contResources[v1.ResourceRequestsCPU]
-	resources[v1.ResourceRequestsMemory] = *contResources[v1.ResourceRequestsMemory]
-	resources[v1.ResourceRequestsStorage] = *contResources[v1.ResourceRequestsStorage]
-	resources[v1.ResourceLimitsCPU] = *contResources[v1.ResourceLimitsCPU]
-	resources[v1.ResourceLimitsMemory] = *contResources[v1.ResourceLimitsCPU]
+	resources[v1.ResourcePods] = *resource.NewQuantity(p.podCapacity(), resource.DecimalSI)
 
-	if manager.HasOpenCLCaps() {
+	gpus := p.supportedGPUs()
+	if gpus[providers.ResourceOpenCLGPU] && manager.HasOpenCLCaps() {
 		q, _ := resource.ParseQuantity("1")
-		resources["device/openclgpu"] = q
+		resources[providers.ResourceOpenCLGPU] = q
 	}
-	if manager.HasCudaCaps() {
+	if gpus[providers.ResourceCudaGPU] && manager.HasCudaCaps() {
 		q, _ := resource.ParseQuantity("1")
-		resources["device/cudagpu"] = q
+		resources[providers.ResourceCudaGPU] = q
 	}
 
 	return resources
-	//return nil
 }
 
 // NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), for updates to the node status
@@ -153,10 +166,70 @@ func (p *FledgeProvider) AddressesChanged() bool {
 	return p.config.NodeName != nodename
 }
 
-//merge those from the available podproviders
-func GetContainerResources() map[v1.ResourceName]*resource.Quantity {
-	//TODO
-	return make(map[v1.ResourceName]*resource.Quantity)
+// GetContainerResources merges the requests and limits of the pods of every pod provider
+func (p *FledgeProvider) GetContainerResources(ctx context.Context) v1.ResourceList {
+	lists := []v1.ResourceList{providers.SumPodResources(nil)}
+	for _, provName := range p.podProviderNames() {
+		prov, found := p.getPodProvider(provName)
+		if !found {
+			continue
+		}
+		if reporter, ok := prov.(providers.ResourceReporter); ok {
+			lists = append(lists, reporter.AllocatedResources(ctx))
+			continue
+		}
+		pods, err := prov.GetPods(ctx)
+		if err != nil {
+			fmt.Printf("Failed to get pods of pod provider %s: %s\n", provName, err.Error())
+			continue
+		}
+		lists = append(lists, providers.SumPodResources(pods))
+	}
+	return providers.MergeResources(lists...)
+}
+
+// podCapacity sums how many pods every pod provider can run, the maxPods of the config is used for providers
+// that don't report it. The node never runs more than maxPods pods.
+func (p *FledgeProvider) podCapacity() int64 {
+	maxPods := int64(config.Cfg.MaxPods)
+	if maxPods <= 0 {
+		maxPods = DefaultMaxPods
+	}
+	var capacity int64
+	for _, provName := range p.podProviderNames() {
+		prov, found := p.getPodProvider(provName)
+		if !found {
+			continue
+		}
+		if reporter, ok := prov.(providers.PodCapacityReporter); ok {
+			if pods := reporter.PodCapacity(); pods > 0 {
+				capacity += pods
+				continue
+			}
+		}
+		capacity += maxPods
+	}
+	if capacity > maxPods {
+		return maxPods
+	}
+	return capacity
+}
+
+// supportedGPUs returns the GPU resources at least one pod provider can hand to containers
+func (p *FledgeProvider) supportedGPUs() map[v1.ResourceName]bool {
+	gpus := make(map[v1.ResourceName]bool)
+	for _, provName := range p.podProviderNames() {
+		prov, found := p.getPodProvider(provName)
+		if !found {
+			continue
+		}
+		if gpuProv, ok := prov.(providers.GPUCapabilities); ok {
+			for _, gpu := range gpuProv.SupportedGPUs() {
+				gpus[gpu] = true
+			}
+		}
+	}
+	return gpus
 }
 
 // OperatingSystem returns the operating system for this provider.
@@ -173,56 +246,6 @@ func (p *FledgeProvider) NodeChanged() bool {
 	return aChanged || pChanged
 }
 
-func (p *FledgeProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
-	//TODO
-	return nil
-}
-
-func (p *FledgeProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
-	//TODO
-	return nil
-}
-
-func (p *FledgeProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
-	//TODO
-	return nil
-}
-
-func (p *FledgeProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
-	//TODO
-	return nil, nil
-}
-
-func (p *FledgeProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error) {
-	//TODO
-	return "", nil
-}
-
-func (p *FledgeProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
-	//TODO
-	return nil
-}
-
-// GetPodStatus retrieves the status of a given pod by name.
-func (p *FledgeProvider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
-	//TODO
-	return nil, nil
-}
-
-// GetPods retrieves a list of all pods scheduled to run.
-func (p *FledgeProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
-	//TODO
-	return nil, nil
-}
-
-func (p *FledgeProvider) PodsChanged() bool {
-	return false
-}
-
-func (p *FledgeProvider) ResetChanges() {
-
-}
-
 func (p *FledgeProvider) NodeDaemonEndpoints(ctx context.Context) *v1.NodeDaemonEndpoints {
 	return &v1.NodeDaemonEndpoints{
 		KubeletEndpoint: v1.DaemonEndpoint{
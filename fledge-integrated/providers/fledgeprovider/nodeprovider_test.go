@@ -0,0 +1,54 @@
+package fledgeprovider
+
+import (
+	"testing"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+)
+
+// plainProvider doesn't report how many pods it can run, its methods are never called
+type plainProvider struct {
+	providers.PodProvider
+}
+
+type capacityProvider struct {
+	providers.PodProvider
+	pods int64
+}
+
+func (p capacityProvider) PodCapacity() int64 {
+	return p.pods
+}
+
+func TestPodCapacity(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxPods   int
+		providers []providers.PodProvider
+		want      int64
+	}{
+		{name: "one provider", providers: []providers.PodProvider{plainProvider{}}, want: DefaultMaxPods},
+		{name: "providers without limits share the default", providers: []providers.PodProvider{plainProvider{}, plainProvider{}, plainProvider{}}, want: DefaultMaxPods},
+		{name: "configured max pods", maxPods: 20, providers: []providers.PodProvider{plainProvider{}, plainProvider{}}, want: 20},
+		{name: "reported limits below max pods", maxPods: 20, providers: []providers.PodProvider{capacityProvider{pods: 4}, capacityProvider{pods: 8}}, want: 12},
+		{name: "reported limits above max pods", maxPods: 20, providers: []providers.PodProvider{capacityProvider{pods: 16}, plainProvider{}}, want: 20},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Cfg = &config.Config{MaxPods: test.maxPods}
+			available := map[string]*providers.PodProvider{}
+			for i := range test.providers {
+				available[string(rune('a'+i))] = &test.providers[i]
+			}
+			p, err := NewFledgeProvider(FledgeProviderConfig{AvailablePodProviders: available})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if capacity := p.podCapacity(); capacity != test.want {
+				t.Fatalf("expected a capacity of %d pods, got %d", test.want, capacity)
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package fledgeprovider
+
+import (
+	"context"
+	"fledge/fledge-integrated/manager"
+	"fledge/fledge-integrated/providers"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// podOwner records which pod provider is responsible for a pod
+type podOwner struct {
+	provider  string
+	namespace string
+	name      string
+	uid       types.UID
+}
+
+func getPodKey(namespace string, name string) string {
+	return namespace + "_" + name
+}
+
+// podProviderNames returns the names of the available pod providers in a fixed order
+func (p *FledgeProvider) podProviderNames() []string {
+	names := make([]string, 0, len(p.config.AvailablePodProviders))
+	for name := range p.config.AvailablePodProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *FledgeProvider) getPodProvider(name string) (providers.PodProvider, bool) {
+	prov, found := p.config.AvailablePodProviders[name]
+	if !found || prov == nil || *prov == nil {
+		return nil, false
+	}
+	return *prov, true
+}
+
+// selectPodProvider picks the provider that will run a new pod, see selector.RuntimeSelector
+func (p *FledgeProvider) selectPodProvider(pod *v1.Pod) (string, providers.PodProvider, error) {
+	name, err := p.runtimeSelector.SelectRuntime(pod)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "no pod provider for pod %s/%s", pod.Namespace, pod.Name)
+	}
+	prov, found := p.getPodProvider(name)
+	if !found {
+		return "", nil, errors.Wrapf(&providers.RuntimeUnavailableError{Runtime: name, Reason: "pod provider not initialized"}, "no pod provider for pod %s/%s", pod.Namespace, pod.Name)
+	}
+	return name, prov, nil
+}
+
+func (p *FledgeProvider) setPodOwner(pod *v1.Pod, provider string) {
+	p.podOwnersLock.Lock()
+	defer p.podOwnersLock.Unlock()
+	p.podOwners[getPodKey(pod.Namespace, pod.Name)] = podOwner{provider: provider, namespace: pod.Namespace, name: pod.Name, uid: pod.UID}
+}
+
+func (p *FledgeProvider) removePodOwner(namespace string, name string) {
+	p.podOwnersLock.Lock()
+	defer p.podOwnersLock.Unlock()
+	delete(p.podOwners, getPodKey(namespace, name))
+}
+
+// getPodOwner returns the provider that owns a pod, if the pod is known
+func (p *FledgeProvider) getPodOwner(namespace string, name string) (string, providers.PodProvider, bool) {
+	p.podOwnersLock.RLock()
+	owner, found := p.podOwners[getPodKey(namespace, name)]
+	p.podOwnersLock.RUnlock()
+	if !found {
+		return "", nil, false
+	}
+	prov, found := p.getPodProvider(owner.provider)
+	return owner.provider, prov, found
+}
+
+// findPodOwner looks up the owner of a pod, asking every provider when the pod isn't tracked yet
+// (e.g. pods that were already running before fledge restarted)
+func (p *FledgeProvider) findPodOwner(ctx context.Context, namespace string, name string) (providers.PodProvider, *v1.Pod, error) {
+	if _, prov, found := p.getPodOwner(namespace, name); found {
+		pod, err := prov.GetPod(ctx, namespace, name)
+		return prov, pod, err
+	}
+	for _, provName := range p.podProviderNames() {
+		prov, found := p.getPodProvider(provName)
+		if !found {
+			continue
+		}
+		pod, err := prov.GetPod(ctx, namespace, name)
+		if err == nil && pod != nil {
+			p.setPodOwner(pod, provName)
+			return prov, pod, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// the api token kubernetes mounts into almost every pod, pods that don't talk to the api server run fine without it
+const serviceAccountMountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// checkVolumes makes sure the provider can mount the volumes the containers of the pod mount.
+// Volumes no container mounts are ignored, an unsupported service account token only gets a warning event.
+func (p *FledgeProvider) checkVolumes(provName string, prov providers.PodProvider, pod *v1.Pod) error {
+	volProv, ok := prov.(providers.VolumeCapabilities)
+	if !ok {
+		return nil
+	}
+	supported := make(map[string]bool)
+	for _, volType := range volProv.SupportedVolumeTypes() {
+		supported[volType] = true
+	}
+	volumes := make(map[string]v1.Volume)
+	for _, vol := range pod.Spec.Volumes {
+		volumes[vol.Name] = vol
+	}
+
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, volMount := range container.VolumeMounts {
+			vol, found := volumes[volMount.Name]
+			if !found {
+				continue
+			}
+			volType := providers.GetVolumeType(vol)
+			if supported[volType] {
+				continue
+			}
+			if volMount.MountPath == serviceAccountMountPath {
+				if p.recorder != nil {
+					p.recorder.Eventf(pod, v1.EventTypeWarning, "ServiceAccountTokenNotMounted", "Pod provider %s can't mount volume %s of type %s, container %s runs without the service account token", provName, vol.Name, volType, container.Name)
+				}
+				continue
+			}
+			return errors.Wrapf(&providers.RuntimeUnavailableError{Runtime: provName, Reason: fmt.Sprintf("volume type %s is not supported", volType)}, "can't mount volume %s in container %s", vol.Name, container.Name)
+		}
+	}
+	return nil
+}
+
+func supportsLogs(prov providers.PodProvider) bool {
+	streamProv, ok := prov.(providers.StreamingCapabilities)
+	return !ok || streamProv.SupportsLogs()
+}
+
+func supportsExec(prov providers.PodProvider) bool {
+	streamProv, ok := prov.(providers.StreamingCapabilities)
+	return !ok || streamProv.SupportsExec()
+}
+
+func (p *FledgeProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	provName, prov, err := p.selectPodProvider(pod)
+	if err != nil {
+		return err
+	}
+	if err := p.checkVolumes(provName, prov, pod); err != nil {
+		return err
+	}
+
+	fmt.Printf("Dispatching pod %s/%s to pod provider %s\n", pod.Namespace, pod.Name, provName)
+	p.setPodOwner(pod, provName)
+	if err := prov.CreatePod(ctx, pod); err != nil {
+		p.removePodOwner(pod.Namespace, pod.Name)
+		return errors.Wrapf(err, "pod provider %s failed to create pod", provName)
+	}
+	return nil
+}
+
+func (p *FledgeProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	prov, _, err := p.findPodOwner(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	if prov == nil {
+		return strongerrors.NotFound(errors.Errorf("pod %s/%s is not known by any pod provider", pod.Namespace, pod.Name))
+	}
+	return prov.UpdatePod(ctx, pod)
+}
+
+func (p *FledgeProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	prov, _, err := p.findPodOwner(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	if prov == nil {
+		return strongerrors.NotFound(errors.Errorf("pod %s/%s is not known by any pod provider", pod.Namespace, pod.Name))
+	}
+	if err := prov.DeletePod(ctx, pod); err != nil {
+		return err
+	}
+	p.removePodOwner(pod.Namespace, pod.Name)
+	return nil
+}
+
+func (p *FledgeProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	_, pod, err := p.findPodOwner(ctx, namespace, name)
+	return pod, err
+}
+
+func (p *FledgeProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	prov, _, err := p.findPodOwner(ctx, namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+	if prov == nil {
+		return nil, strongerrors.NotFound(errors.Errorf("pod %s/%s is not known by any pod provider", namespace, podName))
+	}
+	if !supportsLogs(prov) {
+		return nil, strongerrors.NotImplemented(errors.Errorf("the pod provider of pod %s/%s doesn't support logs", namespace, podName))
+	}
+	return prov.GetContainerLogs(ctx, namespace, podName, containerName, opts)
+}
+
+// getPodOwnerByKey returns the provider that owns a pod by uid, or if no pod has that uid by its pod key namespace_name
+func (p *FledgeProvider) getPodOwnerByKey(name string, uid types.UID) (string, providers.PodProvider, bool) {
+	var provName string
+	p.podOwnersLock.RLock()
+	if uid != "" {
+		for _, owner := range p.podOwners {
+			if owner.uid == uid {
+				provName = owner.provider
+				break
+			}
+		}
+	}
+	if provName == "" {
+		if owner, found := p.podOwners[name]; found {
+			provName = owner.provider
+		}
+	}
+	p.podOwnersLock.RUnlock()
+
+	prov, found := p.getPodProvider(provName)
+	return provName, prov, found
+}
+
+// ExecInContainer looks up the pod by uid, or by its pod key if the uid is not set
+func (p *FledgeProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	provName, prov, found := p.getPodOwnerByKey(name, uid)
+	if !found {
+		return strongerrors.NotFound(errors.Errorf("pod %s is not known by any pod provider", name))
+	}
+	if !supportsExec(prov) {
+		return strongerrors.NotImplemented(errors.Errorf("pod provider %s doesn't support exec", provName))
+	}
+	return prov.ExecInContainer(name, uid, container, cmd, in, out, err, tty, resize, timeout)
+}
+
+// AttachToContainer looks up the pod like ExecInContainer, its provider has to implement providers.ContainerAttacher
+func (p *FledgeProvider) AttachToContainer(name string, uid types.UID, container string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	provName, prov, found := p.getPodOwnerByKey(name, uid)
+	if !found {
+		return strongerrors.NotFound(errors.Errorf("pod %s is not known by any pod provider", name))
+	}
+	attacher, ok := prov.(providers.ContainerAttacher)
+	if !ok {
+		return strongerrors.NotImplemented(errors.Errorf("pod provider %s doesn't support attach", provName))
+	}
+	return attacher.AttachToContainer(name, uid, container, in, out, err, tty, resize)
+}
+
+// PortForward hands the stream to the provider of the pod, which has to implement providers.PortForwarder
+func (p *FledgeProvider) PortForward(ctx context.Context, namespace, pod string, port int32, stream io.ReadWriteCloser) error {
+	provName, prov, found := p.getPodOwner(namespace, pod)
+	if !found {
+		return strongerrors.NotFound(errors.Errorf("pod %s/%s is not known by any pod provider", namespace, pod))
+	}
+	forwarder, ok := prov.(providers.PortForwarder)
+	if !ok {
+		return strongerrors.NotImplemented(errors.Errorf("pod provider %s doesn't support port forwarding", provName))
+	}
+	return forwarder.PortForward(ctx, namespace, pod, port, stream)
+}
+
+// GetPodStatus retrieves the status of a given pod by name.
+func (p *FledgeProvider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
+	prov, _, err := p.findPodOwner(ctx, namespace, name)
+	if err != nil || prov == nil {
+		return nil, err
+	}
+	return prov.GetPodStatus(ctx, namespace, name)
+}
+
+// GetPods retrieves a list of all pods scheduled to run, over all pod providers.
+func (p *FledgeProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	pods := []*v1.Pod{}
+	for _, provName := range p.podProviderNames() {
+		prov, found := p.getPodProvider(provName)
+		if !found {
+			continue
+		}
+		provPods, err := prov.GetPods(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pod provider %s failed to list pods", provName)
+		}
+		for _, pod := range provPods {
+			if pod == nil {
+				continue
+			}
+			if _, _, found := p.getPodOwner(pod.Namespace, pod.Name); !found {
+				p.setPodOwner(pod, provName)
+			}
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+func (p *FledgeProvider) PodsChanged() bool {
+	changed := false
+	for _, provName := range p.podProviderNames() {
+		if prov, found := p.getPodProvider(provName); found && prov.PodsChanged() {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *FledgeProvider) ResetChanges() {
+	for _, provName := range p.podProviderNames() {
+		if prov, found := p.getPodProvider(provName); found {
+			prov.ResetChanges()
+		}
+	}
+}
+
+// NotifyPods passes the callback on to every pod provider that can notify about its pods
+func (p *FledgeProvider) NotifyPods(ctx context.Context, notify func()) {
+	for _, provName := range p.podProviderNames() {
+		if prov, found := p.getPodProvider(provName); found {
+			if notifier, ok := prov.(providers.PodNotifier); ok {
+				notifier.NotifyPods(ctx, notify)
+			}
+		}
+	}
+}
+
+// RecordPodEvents passes the recorder on to every pod provider that reports events about its pods,
+// the recorder is also used for warnings about pods before they are dispatched
+func (p *FledgeProvider) RecordPodEvents(recorder record.EventRecorder) {
+	p.recorder = recorder
+	for _, provName := range p.podProviderNames() {
+		if prov, found := p.getPodProvider(provName); found {
+			if eventRecorder, ok := prov.(providers.PodEventRecorder); ok {
+				eventRecorder.RecordPodEvents(recorder)
+			}
+		}
+	}
+}
+
+// UseResourceManager passes the resource manager on to every pod provider that reads resources of its pods
+func (p *FledgeProvider) UseResourceManager(rm *manager.ResourceManager) {
+	for _, provName := range p.podProviderNames() {
+		if prov, found := p.getPodProvider(provName); found {
+			if user, ok := prov.(providers.PodResourceUser); ok {
+				user.UseResourceManager(rm)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+//go:build linux
+// +build linux
+
+package native
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// IsRemoteImage returns true if the image is a binary or tarball that has to be downloaded
+func IsRemoteImage(image string) bool {
+	return strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://")
+}
+
+// IsTarball returns true if the image is an archive that contains the binary instead of the binary itself
+func IsTarball(image string) bool {
+	return strings.HasSuffix(image, ".tar") || strings.HasSuffix(image, ".tar.gz") || strings.HasSuffix(image, ".tgz")
+}
+
+func cacheName(image string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(image, "http://"), "https://")
+	return strings.NewReplacer("/", "_", ":", "_", "?", "_", "&", "_").Replace(name)
+}
+
+// ResolveBinary returns the executable for a container and the directory it was unpacked in, if it came from a tarball.
+// The image is either a path to a static binary on the device or an http(s) url to a binary or tarball.
+// Inside a tarball the first element of the command selects the binary, without a command the tarball has to hold a single executable.
+func (p *NativeProvider) ResolveBinary(ctx context.Context, dc *v1.Container) (string, string, error) {
+	image := strings.TrimPrefix(dc.Image, "file://")
+
+	if IsRemoteImage(image) {
+		downloaded, err := p.downloadImage(ctx, image, dc.ImagePullPolicy)
+		if err != nil {
+			return "", "", err
+		}
+		image = downloaded
+	}
+
+	if !IsTarball(image) {
+		if err := checkExecutable(image); err != nil {
+			return "", "", err
+		}
+		return image, "", nil
+	}
+
+	dir := filepath.Join(p.cacheDir, "unpacked", cacheName(dc.Image))
+	if err := unpackTarball(image, dir); err != nil {
+		return "", "", errors.Wrapf(err, "failed to unpack %s", dc.Image)
+	}
+	binary, err := findBinary(dir, dc)
+	if err != nil {
+		return "", "", err
+	}
+	return binary, dir, nil
+}
+
+func checkExecutable(path string) error {
+	if !filepath.IsAbs(path) {
+		return errors.Errorf("binary path %s is not absolute", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "binary %s not found", path)
+	}
+	if !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+		return errors.Errorf("%s is not an executable file", path)
+	}
+	return nil
+}
+
+// downloadImage fetches a remote image into the cache, an existing download is reused unless the pull policy is Always
+func (p *NativeProvider) downloadImage(ctx context.Context, url string, pullPolicy v1.PullPolicy) (string, error) {
+	path := filepath.Join(p.cacheDir, "downloads", cacheName(url))
+
+	if pullPolicy != v1.PullAlways {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		if pullPolicy == v1.PullNever {
+			return "", errors.Errorf("image %s not present and pull policy is Never", url)
+		}
+	}
+
+	fmt.Printf("Downloading native image %s\n", url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to download %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	//download next to the cached file first, so an interrupted download never replaces a good one
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", errors.Wrapf(err, "failed to download %s", url)
+	}
+	if err := tmp.Chmod(0755); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	//the tarball changed, unpack it again on next use
+	os.RemoveAll(filepath.Join(p.cacheDir, "unpacked", cacheName(url)))
+	return path, nil
+}
+
+// unpackTarball extracts regular files and directories, it does nothing if dir already exists
+func unpackTarball(tarball string, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	file, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if !strings.HasSuffix(tarball, ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tmpDir := dir + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+		target := filepath.Join(tmpDir, hdr.Name)
+		if !strings.HasPrefix(target, tmpDir+string(os.PathSeparator)) {
+			os.RemoveAll(tmpDir)
+			return errors.Errorf("tarball entry %s points outside the image", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeFile(target, tr, os.FileMode(hdr.Mode)&0777)
+		default:
+			fmt.Printf("Skipping tarball entry %s of type %c\n", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+	}
+	return os.Rename(tmpDir, dir)
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// findBinary picks the executable in an unpacked tarball
+func findBinary(dir string, dc *v1.Container) (string, error) {
+	if len(dc.Command) > 0 {
+		binary := filepath.Join(dir, dc.Command[0])
+		if err := checkExecutable(binary); err != nil {
+			return "", err
+		}
+		return binary, nil
+	}
+
+	executables := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			executables = append(executables, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(executables) == 0 {
+		return "", errors.Errorf("no executable found in image %s", dc.Image)
+	}
+	if len(executables) > 1 {
+		return "", errors.Errorf("image %s holds %d executables, set the container command to pick one", dc.Image, len(executables))
+	}
+	return executables[0], nil
+}
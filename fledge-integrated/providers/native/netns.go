@@ -0,0 +1,79 @@
+//go:build linux
+// +build linux
+
+package native
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// the network namespace of the current thread
+func threadNetNsPath() string {
+	return fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), unix.Gettid())
+}
+
+// newNetNs creates an empty network namespace for a pod.
+// The namespace lives as long as the returned file is open, so it survives container restarts.
+func newNetNs() (*os.File, error) {
+	runtime.LockOSThread()
+
+	origNs, err := os.Open(threadNetNsPath())
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer origNs.Close()
+
+	if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return nil, errors.Wrap(err, "failed to create network namespace")
+	}
+	netNs, nsErr := os.Open(threadNetNsPath())
+
+	if err := unix.Setns(int(origNs.Fd()), unix.CLONE_NEWNET); err != nil {
+		//leave the thread locked, it exits with the goroutine instead of being reused in the wrong namespace
+		if netNs != nil {
+			netNs.Close()
+		}
+		return nil, errors.Wrap(err, "failed to return to the host network namespace")
+	}
+	runtime.UnlockOSThread()
+	return netNs, nsErr
+}
+
+// netNsPath returns a path that refers to the namespace as long as netNs is open, unlike /proc/<pid>/ns/net of a process
+// in it, which is gone once that process exits
+func netNsPath(netNs *os.File) string {
+	return fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), netNs.Fd())
+}
+
+// startInNetNs starts cmd in a network namespace.
+// The child is forked from the current thread, so the thread is moved into the namespace for the duration of the fork.
+func startInNetNs(netNs *os.File, cmd *exec.Cmd) error {
+	runtime.LockOSThread()
+
+	origNs, err := os.Open(threadNetNsPath())
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origNs.Close()
+
+	if err := unix.Setns(int(netNs.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return errors.Wrap(err, "failed to enter pod network namespace")
+	}
+	startErr := cmd.Start()
+
+	if err := unix.Setns(int(origNs.Fd()), unix.CLONE_NEWNET); err != nil {
+		return errors.Wrap(err, "failed to return to the host network namespace")
+	}
+	runtime.UnlockOSThread()
+	return startErr
+}
@@ -0,0 +1,205 @@
+//go:build linux
+// +build linux
+
+package native
+
+import (
+	"context"
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/podstore"
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	defaultCacheDir = "/var/lib/fledge/native"
+	defaultLogDir   = "/var/log/fledge/native"
+)
+
+// nativePod keeps the pod spec together with its network namespace and the processes running its containers
+type nativePod struct {
+	pod       *v1.Pod
+	netNs     *os.File
+	processes map[string]*nativeProcess
+}
+
+func (npod *nativePod) Pod() *v1.Pod {
+	return npod.pod
+}
+
+func (npod *nativePod) ContainerStatus(name string) (v1.ContainerStatus, bool) {
+	proc, found := npod.processes[name]
+	if !found {
+		return v1.ContainerStatus{}, false
+	}
+	return proc.status(), true
+}
+
+// NativeProvider runs static binaries as plain processes in their own namespaces and cgroups, without containerd.
+type NativeProvider struct {
+	*podstore.Store
+
+	cacheDir string
+	logDir   string
+}
+
+func NewNativeProvider(cfg config.NativeConfig) (*NativeProvider, error) {
+	provider := NativeProvider{
+		Store:    podstore.NewStore(),
+		cacheDir: cfg.CacheDir,
+		logDir:   cfg.LogDir,
+	}
+	if provider.cacheDir == "" {
+		provider.cacheDir = defaultCacheDir
+	}
+	if provider.logDir == "" {
+		provider.logDir = defaultLogDir
+	}
+
+	if err := os.MkdirAll(provider.cacheDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create native cache dir")
+	}
+	return &provider, nil
+}
+
+// CreatePod sets up the pod's network namespace and starts a process for every container
+func (p *NativeProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	if len(pod.Spec.InitContainers) > 0 {
+		return errors.New("init containers are not supported by the native provider")
+	}
+
+	fmt.Printf("Creating native pod %s\n", podstore.PodKey(pod.Namespace, pod.Name))
+
+	pod = podstore.NewPod(pod)
+	if pod.Spec.HostNetwork {
+		pod.Status.PodIP = config.Cfg.DeviceIP
+	}
+	npod := &nativePod{
+		pod:       pod,
+		processes: make(map[string]*nativeProcess),
+	}
+	if err := p.Store.Add(npod); err != nil {
+		return err
+	}
+
+	vkube.CreateVolumes(ctx, pod)
+	if !pod.Spec.HostNetwork {
+		netNs, err := newNetNs()
+		if err != nil {
+			p.DeletePod(ctx, pod)
+			return err
+		}
+		//the namespace is linked through the file fledge holds open, it outlives restarts of the processes in it
+		podIP := vkube.BindNetNamespacePath(pod.Namespace, pod.Name, netNsPath(netNs))
+		p.Store.Lock()
+		npod.netNs = netNs
+		pod.Status.PodIP = podIP
+		p.Store.Unlock()
+	}
+
+	var startErr error
+	for i := range pod.Spec.Containers {
+		dc := &pod.Spec.Containers[i]
+		proc := p.startProcess(ctx, npod, dc)
+		p.Store.Lock()
+		npod.processes[dc.Name] = proc
+		p.Store.Unlock()
+		if proc.startErr != nil && startErr == nil {
+			startErr = errors.Wrapf(proc.startErr, "failed to start container %s", dc.Name)
+		}
+	}
+
+	if startErr != nil {
+		p.DeletePod(ctx, pod)
+		return startErr
+	}
+
+	p.Store.UpdateStatus(npod)
+	return nil
+}
+
+// UpdatePod restarts the pod's processes with the new spec
+func (p *NativeProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Updating pod namespace %s name %s\n", pod.Namespace, pod.Name)
+
+	if err := p.DeletePod(ctx, pod); err != nil && !strongerrors.IsNotFound(err) {
+		return err
+	}
+	return p.CreatePod(ctx, pod)
+}
+
+// DeletePod stops the pod's processes, tears down its network and removes the logs
+func (p *NativeProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Deleting pod namespace %s name %s\n", pod.Namespace, pod.Name)
+
+	state, err := p.Store.Remove(pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	npod := state.(*nativePod)
+	p.Store.Lock()
+	processes := []*nativeProcess{}
+	for _, proc := range npod.processes {
+		processes = append(processes, proc)
+	}
+	bound := npod.netNs != nil && npod.pod.Status.PodIP != ""
+	p.Store.Unlock()
+
+	for _, proc := range processes {
+		p.stopProcess(proc)
+		os.Remove(proc.logPath)
+	}
+
+	if npod.netNs != nil {
+		if bound {
+			vkube.RemoveNetNamespace(pod.Namespace, pod.Name)
+			vkube.FreeIP(pod.Namespace, pod.Name)
+		}
+		npod.netNs.Close()
+	}
+	return nil
+}
+
+// GetContainerLogs returns the stdout and stderr output of a process, over all of its restarts.
+func (p *NativeProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	var proc *nativeProcess
+	if npod, found := p.Store.Get(namespace, podName).(*nativePod); found {
+		p.Store.Lock()
+		proc = npod.processes[containerName]
+		p.Store.Unlock()
+	}
+
+	if proc == nil {
+		return nil, strongerrors.NotFound(errors.Errorf("container %s not found in pod %s/%s", containerName, namespace, podName))
+	}
+
+	return vkube.ReadPlainLog(proc.logPath, opts)
+}
+
+// ExecInContainer is not supported yet.
+func (p *NativeProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	return strongerrors.NotImplemented(errors.New("exec is not supported in native containers"))
+}
+
+// volumes are bound into the private mount namespace of the processes, on top of the host's filesystem
+func (p *NativeProvider) SupportedVolumeTypes() []string {
+	return providers.VolumeTypesMountable
+}
+
+func (p *NativeProvider) SupportsExec() bool {
+	return false
+}
+
+func (p *NativeProvider) SupportsLogs() bool {
+	return true
+}
@@ -0,0 +1,221 @@
+//go:build linux
+// +build linux
+
+package native
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/testutil"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestProvider(t *testing.T) *NativeProvider {
+	if os.Geteuid() != 0 {
+		t.Skip("the native provider needs root to create namespaces and trace its processes")
+	}
+	config.Cfg = &config.Config{DeviceIP: "10.0.0.1"}
+	dir := t.TempDir()
+	p, err := NewNativeProvider(config.NativeConfig{CacheDir: filepath.Join(dir, "cache"), LogDir: filepath.Join(dir, "logs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// newTestPod runs script with /bin/sh on the host network, so no cni setup is needed
+func newTestPod(name string, script string, policy v1.RestartPolicy) *v1.Pod {
+	var gracePeriod int64 = 1
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: v1.PodSpec{
+			HostNetwork:                   true,
+			RestartPolicy:                 policy,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Containers: []v1.Container{{
+				Name:    "app",
+				Image:   "/bin/sh",
+				Command: []string{"/bin/sh", "-c", script},
+			}},
+		},
+	}
+}
+
+func TestProcessRunsOnlyOncePrepared(t *testing.T) {
+	p := newTestProvider(t)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	os.MkdirAll(source, 0755)
+	if err := ioutil.WriteFile(filepath.Join(source, "hello"), []byte("hello from the volume\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//the first thing the process does is look at its volume and its cgroup, both have to be there already
+	pod := newTestPod("prepared", "cat "+target+"/hello; cat /proc/self/cgroup", v1.RestartPolicyNever)
+	pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: source}}}}
+	pod.Spec.Containers[0].VolumeMounts = []v1.VolumeMount{{Name: "data", MountPath: target, ReadOnly: true}}
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(context.Background(), pod)
+
+	status := testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.State.Terminated != nil })
+	if status.State.Terminated.ExitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", status.State.Terminated.ExitCode, testutil.ReadLogs(t, p, pod.Namespace, pod.Name, "app", providers.ContainerLogOpts{}))
+	}
+	logs := testutil.ReadLogs(t, p, pod.Namespace, pod.Name, "app", providers.ContainerLogOpts{})
+	if !strings.Contains(logs, "hello from the volume") {
+		t.Fatalf("the volume should be mounted before the process runs, got %q", logs)
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/memory/cgroup.procs"); err == nil {
+		if !strings.Contains(logs, ":memory:/vkubelet/default-prepared-app") {
+			t.Fatalf("the process should be in its memory cgroup before it runs, got %q", logs)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(target, "hello")); !os.IsNotExist(err) {
+		t.Fatal("the volume should only be mounted in the mount namespace of the process")
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return 50 * time.Millisecond }
+
+	tests := []struct {
+		name     string
+		policy   v1.RestartPolicy
+		exitCode int32
+		restarts bool
+	}{
+		{name: "never", policy: v1.RestartPolicyNever, exitCode: 3},
+		{name: "on failure after failure", policy: v1.RestartPolicyOnFailure, exitCode: 3, restarts: true},
+		{name: "on failure after success", policy: v1.RestartPolicyOnFailure, exitCode: 0},
+		{name: "always", policy: v1.RestartPolicyAlways, exitCode: 0, restarts: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			script := "echo run; exit 0"
+			if test.exitCode != 0 {
+				script = "echo run; exit 3"
+			}
+			pod := newTestPod("restart", script, test.policy)
+			if err := p.CreatePod(context.Background(), pod); err != nil {
+				t.Fatal(err)
+			}
+			defer p.DeletePod(context.Background(), pod)
+
+			if !test.restarts {
+				status := testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.State.Terminated != nil })
+				if status.State.Terminated.ExitCode != test.exitCode {
+					t.Fatalf("expected exit code %d, got %d", test.exitCode, status.State.Terminated.ExitCode)
+				}
+				time.Sleep(200 * time.Millisecond)
+				current, _ := p.GetPod(context.Background(), pod.Namespace, pod.Name)
+				if current.Status.ContainerStatuses[0].RestartCount != 0 {
+					t.Fatal("the process should not have been restarted")
+				}
+				return
+			}
+
+			status := testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.RestartCount >= 2 })
+			last := status.LastTerminationState.Terminated
+			if last == nil || last.ExitCode != test.exitCode {
+				t.Fatalf("expected the last termination with exit code %d, got %v", test.exitCode, last)
+			}
+			if runs := strings.Count(testutil.ReadLogs(t, p, pod.Namespace, pod.Name, "app", providers.ContainerLogOpts{}), "run\n"); runs < 3 {
+				t.Fatalf("the log should keep the output of every run, got %d runs", runs)
+			}
+		})
+	}
+}
+
+func TestCrashLoopBackOff(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return time.Minute }
+
+	p := newTestProvider(t)
+	pod := newTestPod("backoff", "exit 3", v1.RestartPolicyAlways)
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	status := testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.State.Waiting != nil })
+	if status.State.Waiting.Reason != "CrashLoopBackOff" {
+		t.Fatalf("expected CrashLoopBackOff, got %s", status.State.Waiting.Reason)
+	}
+	if status.LastTerminationState.Terminated == nil || status.LastTerminationState.Terminated.ExitCode != 3 {
+		t.Fatal("the exit of the process should be the last termination state")
+	}
+
+	//deleting the pod cancels the pending restart
+	start := time.Now()
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("deleting should not wait for the backoff")
+	}
+}
+
+func TestDeleteKillsProcessAfterGracePeriod(t *testing.T) {
+	p := newTestProvider(t)
+	//the process is pid 1 of its namespace, SIGTERM is ignored without a handler
+	pod := newTestPod("stops", "sleep 100", v1.RestartPolicyAlways)
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.State.Running != nil })
+
+	npod := p.Store.Get(pod.Namespace, pod.Name).(*nativePod)
+	p.Store.Lock()
+	proc := npod.processes["app"]
+	p.Store.Unlock()
+	start := time.Now()
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Fatalf("the process should be killed when its grace period of 1s runs out, took %s", elapsed)
+	}
+	if proc.exitCode != 128+int32(syscall.SIGKILL) || proc.restartCount != 0 {
+		t.Fatalf("a stopped process should be killed and not restarted, got %d after %d restarts", proc.exitCode, proc.restartCount)
+	}
+}
+
+func TestNetNsPathOutlivesProcesses(t *testing.T) {
+	newTestProvider(t)
+	netNs, err := newNetNs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netNs.Close()
+
+	var nsStat, pathStat, hostStat syscall.Stat_t
+	if err := syscall.Fstat(int(netNs.Fd()), &nsStat); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Stat(netNsPath(netNs), &pathStat); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Stat("/proc/self/ns/net", &hostStat)
+	if pathStat.Ino != nsStat.Ino {
+		t.Fatal("the path should refer to the pod's network namespace")
+	}
+	if pathStat.Ino == hostStat.Ino {
+		t.Fatal("the pod should not share the host's network namespace")
+	}
+}
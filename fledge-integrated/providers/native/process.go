@@ -0,0 +1,449 @@
+//go:build linux
+// +build linux
+
+package native
+
+import (
+	"context"
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// used when the pod doesn't set a termination grace period
+const defaultGracePeriod = 30 * time.Second
+
+// the delay before an exited process is started again, replaced by tests
+var restartBackoff = vkube.RestartBackoff
+
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// nativeProcess is the binary running for one container of a pod, restarted according to the pod's restart policy.
+type nativeProcess struct {
+	id            string
+	container     string
+	image         string
+	binary        string
+	argv          []string
+	env           []string
+	workDir       string
+	cgroup        string
+	mounts        []bindMount
+	logPath       string
+	hostPID       bool
+	netNs         *os.File
+	restartPolicy v1.RestartPolicy
+	gracePeriod   time.Duration
+
+	cmd             *exec.Cmd
+	startedAt       time.Time
+	finishedAt      time.Time
+	exitCode        int32
+	running         bool
+	startErr        error
+	restartCount    int32
+	lastTermination *v1.ContainerStateTerminated
+	backoff         time.Duration
+	waitingUntil    time.Time
+	stopping        bool
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// ProcessArgs returns argv for the binary, the command's first element becomes argv[0] when it is set
+func ProcessArgs(binary string, dc *v1.Container) []string {
+	args := []string{binary}
+	if len(dc.Command) > 0 {
+		args = append([]string{}, dc.Command...)
+	}
+	return append(args, dc.Args...)
+}
+
+// ProcessEnv returns the environment of the process, PATH gets a default if the container doesn't set it
+func ProcessEnv(pod *v1.Pod, dc *v1.Container) []string {
+	env := []string{fmt.Sprintf("HOSTNAME=%s", pod.Name)}
+	hasPath := false
+	for _, evar := range dc.Env {
+		if evar.ValueFrom != nil {
+			continue
+		}
+		if evar.Name == "PATH" {
+			hasPath = true
+		}
+		env = append(env, fmt.Sprintf("%s=%s", evar.Name, evar.Value))
+	}
+	if !hasPath {
+		env = append(env, defaultPath)
+	}
+	return env
+}
+
+// bindMount is a volume bound into the mount namespace of a container's process
+type bindMount struct {
+	source   string
+	target   string
+	readOnly bool
+}
+
+// containerMounts resolves the host directories of the container's volume mounts
+func containerMounts(pod *v1.Pod, dc *v1.Container) ([]bindMount, error) {
+	mounts := []bindMount{}
+	for _, volMount := range dc.VolumeMounts {
+		var volume *v1.Volume
+		for i := range pod.Spec.Volumes {
+			if pod.Spec.Volumes[i].Name == volMount.Name {
+				volume = &pod.Spec.Volumes[i]
+			}
+		}
+		if volume == nil {
+			return nil, errors.Errorf("volume %s mounted by container %s not found", volMount.Name, dc.Name)
+		}
+		hostPath := vkube.GetHostMountPath(pod, *volume)
+		if hostPath == nil {
+			return nil, errors.Errorf("volume %s mounted by container %s can't be provided by the native provider", volMount.Name, dc.Name)
+		}
+		mounts = append(mounts, bindMount{
+			source:   filepath.Join(*hostPath, volMount.SubPath),
+			target:   volMount.MountPath,
+			readOnly: volMount.ReadOnly,
+		})
+	}
+	return mounts, nil
+}
+
+func (p *NativeProvider) getLogPath(namespace string, podName string, containerName string) string {
+	return filepath.Join(p.logDir, fmt.Sprintf("%s_%s_%s.log", namespace, podName, containerName))
+}
+
+// startProcess resolves the container's binary, sets up its cgroup and starts it in the pod's namespaces.
+// A monitor goroutine handles exits and restarts.
+func (p *NativeProvider) startProcess(ctx context.Context, npod *nativePod, dc *v1.Container) *nativeProcess {
+	pod := npod.pod
+	proc := &nativeProcess{
+		id:            fmt.Sprintf("%s_%s_%s", pod.Namespace, pod.Name, dc.Name),
+		container:     dc.Name,
+		image:         dc.Image,
+		logPath:       p.getLogPath(pod.Namespace, pod.Name, dc.Name),
+		hostPID:       pod.Spec.HostPID,
+		netNs:         npod.netNs,
+		restartPolicy: pod.Spec.RestartPolicy,
+		gracePeriod:   defaultGracePeriod,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		proc.gracePeriod = time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+
+	failed := func(err error) *nativeProcess {
+		fmt.Printf("Failed to start native container %s: %s\n", dc.Name, err.Error())
+		proc.startErr = err
+		proc.exitCode = 128
+		proc.finishedAt = time.Now()
+		close(proc.done)
+		return proc
+	}
+
+	binary, unpackDir, err := p.ResolveBinary(ctx, dc)
+	if err != nil {
+		return failed(err)
+	}
+	proc.binary = binary
+	proc.argv = ProcessArgs(binary, dc)
+	proc.env = ProcessEnv(pod, dc)
+	proc.workDir = "/"
+	if unpackDir != "" {
+		proc.workDir = unpackDir
+	}
+	if dc.WorkingDir != "" {
+		proc.workDir = dc.WorkingDir
+	}
+	proc.mounts, err = containerMounts(pod, dc)
+	if err != nil {
+		return failed(err)
+	}
+
+	if err := os.MkdirAll(p.logDir, 0755); err != nil {
+		return failed(err)
+	}
+	proc.cgroup = vkube.SetContainerResources(pod.Namespace, pod.Name, dc)
+
+	cmd, err := launch(proc)
+	if err != nil {
+		return failed(err)
+	}
+	proc.cmd = cmd
+	proc.running = true
+	proc.startedAt = time.Now()
+	go p.monitor(proc)
+	return proc
+}
+
+// launch starts the binary in new mount and pid namespaces and in the pod's network namespace.
+// The process is traced so it stops at exec, it only runs once it is in its cgroup and its volumes are mounted.
+// It only reads the parts of proc that don't change after startProcess, so the lock doesn't have to be held.
+func launch(proc *nativeProcess) (*exec.Cmd, error) {
+	logFile, err := os.OpenFile(proc.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	//the child has its own copy of the descriptor
+	defer logFile.Close()
+
+	fmt.Printf("Starting native process %s %v\n", proc.binary, proc.argv)
+	cmd := &exec.Cmd{
+		Path:   proc.binary,
+		Args:   proc.argv,
+		Env:    proc.env,
+		Dir:    proc.workDir,
+		Stdout: logFile,
+		Stderr: logFile,
+		SysProcAttr: &syscall.SysProcAttr{
+			//unsharing the mount namespace also makes the mounts private, so they don't leak back to the host
+			Unshareflags: syscall.CLONE_NEWNS,
+		},
+	}
+	if !proc.hostPID {
+		cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWPID
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	//only the thread that started a traced process can wait for it to stop and detach from it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if proc.netNs != nil {
+		err = startInNetNs(proc.netNs, cmd)
+	} else {
+		err = cmd.Start()
+	}
+	if err == nil {
+		err = prepareProcess(proc, cmd.Process.Pid)
+	}
+	if err != nil {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// prepareProcess waits for the process to stop at exec, adds it to its cgroup, mounts its volumes and lets it run
+func prepareProcess(proc *nativeProcess, pid int) error {
+	var status syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(pid, &status, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to wait for the process to start")
+		}
+		break
+	}
+	if !status.Stopped() {
+		return errors.Errorf("process exited before it started with status %d", status.ExitStatus())
+	}
+
+	vkube.AddProcessToCgroup(proc.cgroup, pid)
+	for _, mount := range proc.mounts {
+		if err := mountVolume(pid, mount); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.PtraceDetach(pid); err != nil {
+		return errors.Wrap(err, "failed to resume the process")
+	}
+	return nil
+}
+
+// mountVolume binds a volume into the mount namespace of the process, the namespace is private so the mount
+// doesn't show up on the host
+func mountVolume(pid int, mount bindMount) error {
+	nsenter := []string{"-t", strconv.Itoa(pid), "-m", "--"}
+	steps := [][]string{
+		{"mkdir", "-p", mount.target},
+		{"mount", "--bind", mount.source, mount.target},
+	}
+	if mount.readOnly {
+		steps = append(steps, []string{"mount", "-o", "remount,bind,ro", mount.target})
+	}
+	for _, step := range steps {
+		if out, err := exec.Command("nsenter", append(nsenter, step...)...).CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "failed to mount %s at %s: %s", mount.source, mount.target, string(out))
+		}
+	}
+	return nil
+}
+
+// monitor waits for the process to exit and restarts it with an increasing delay as long as the restart policy allows it
+func (p *NativeProvider) monitor(proc *nativeProcess) {
+	defer close(proc.done)
+
+	for {
+		if proc.startErr == nil {
+			proc.cmd.Wait()
+		}
+
+		p.Store.Lock()
+		if proc.startErr == nil {
+			proc.running = false
+			proc.finishedAt = time.Now()
+			proc.exitCode = vkube.ProcessExitCode(proc.cmd.ProcessState)
+			fmt.Printf("Native container %s exited with code %d\n", proc.container, proc.exitCode)
+		}
+		proc.lastTermination = proc.terminatedState()
+		p.Store.MarkChanged()
+
+		if proc.stopping || !vkube.ShouldRestart(proc.restartPolicy, proc.exitCode) {
+			p.Store.Unlock()
+			return
+		}
+		var ranFor time.Duration
+		if proc.startErr == nil {
+			ranFor = proc.finishedAt.Sub(proc.startedAt)
+		}
+		proc.backoff = restartBackoff(proc.backoff, ranFor)
+		delay := proc.backoff
+		proc.waitingUntil = time.Now().Add(delay)
+		p.Store.Unlock()
+
+		fmt.Printf("Restarting native container %s in %s\n", proc.container, delay)
+		select {
+		case <-proc.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		p.Store.Lock()
+		stopping := proc.stopping
+		p.Store.Unlock()
+		if stopping {
+			return
+		}
+
+		//starting the process waits for it to stop at exec and mounts its volumes, the other pods aren't held up by it
+		cmd, err := launch(proc)
+
+		p.Store.Lock()
+		proc.restartCount++
+		if err != nil {
+			fmt.Printf("Failed to restart native container %s: %s\n", proc.container, err.Error())
+			proc.startErr = err
+			proc.exitCode = 128
+			proc.finishedAt = time.Now()
+		} else {
+			proc.cmd = cmd
+			proc.startErr = nil
+			proc.running = true
+			proc.startedAt = time.Now()
+			proc.waitingUntil = time.Time{}
+			//stopProcess didn't see this process, the next exit ends the loop
+			if proc.stopping {
+				cmd.Process.Kill()
+			}
+		}
+		p.Store.MarkChanged()
+		p.Store.Unlock()
+	}
+}
+
+// stopProcess cancels pending restarts, sends SIGTERM and kills the process when the grace period runs out.
+// Killing the first process of a pid namespace takes the rest of the namespace with it.
+func (p *NativeProvider) stopProcess(proc *nativeProcess) {
+	p.Store.Lock()
+	if proc.stopping {
+		p.Store.Unlock()
+		<-proc.done
+		return
+	}
+	proc.stopping = true
+	close(proc.stop)
+	var process *os.Process
+	if proc.running {
+		process = proc.cmd.Process
+	}
+	p.Store.Unlock()
+
+	if process != nil {
+		process.Signal(syscall.SIGTERM)
+		select {
+		case <-proc.done:
+		case <-time.After(proc.gracePeriod):
+			fmt.Printf("Native container %s didn't stop in time, killing\n", proc.container)
+			process.Kill()
+		}
+	}
+	<-proc.done
+
+	if proc.cgroup != "" {
+		vkube.DeleteCgroup(proc.cgroup)
+	}
+}
+
+// terminatedState describes the last exit of the process, the lock must be held
+func (proc *nativeProcess) terminatedState() *v1.ContainerStateTerminated {
+	if proc.startErr != nil {
+		return &v1.ContainerStateTerminated{
+			ExitCode:   proc.exitCode,
+			Reason:     "StartError",
+			Message:    proc.startErr.Error(),
+			FinishedAt: metav1.NewTime(proc.finishedAt),
+		}
+	}
+	reason := "Completed"
+	if proc.exitCode != 0 {
+		reason = "Error"
+	}
+	return &v1.ContainerStateTerminated{
+		ExitCode:   proc.exitCode,
+		Reason:     reason,
+		StartedAt:  metav1.NewTime(proc.startedAt),
+		FinishedAt: metav1.NewTime(proc.finishedAt),
+	}
+}
+
+// status converts the state of the process into a container status, the lock must be held
+func (proc *nativeProcess) status() v1.ContainerStatus {
+	state := v1.ContainerState{}
+	lastState := v1.ContainerState{}
+
+	if proc.running {
+		state.Running = &v1.ContainerStateRunning{
+			StartedAt: metav1.NewTime(proc.startedAt),
+		}
+		lastState.Terminated = proc.lastTermination
+	} else if time.Now().Before(proc.waitingUntil) && !proc.stopping {
+		state.Waiting = &v1.ContainerStateWaiting{
+			Reason:  "CrashLoopBackOff",
+			Message: fmt.Sprintf("back-off %s restarting failed container %s", proc.waitingUntil.Sub(proc.finishedAt).Round(time.Second), proc.container),
+		}
+		lastState.Terminated = proc.lastTermination
+	} else {
+		state.Terminated = proc.terminatedState()
+	}
+
+	return v1.ContainerStatus{
+		Name:                 proc.container,
+		State:                state,
+		LastTerminationState: lastState,
+		Ready:                proc.running,
+		RestartCount:         proc.restartCount,
+		Image:                proc.image,
+		ImageID:              proc.image,
+		ContainerID:          fmt.Sprintf("native://%s", proc.id),
+	}
+}
@@ -0,0 +1,325 @@
+package osv
+
+import (
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultLogDir = "/var/log/fledge/osv"
+
+// time to wait for a unikernel to shut down after SIGTERM before it is killed
+var stopTimeout = 10 * time.Second
+
+// the delay before an exited unikernel is booted again, replaced by tests
+var restartBackoff = vkube.RestartBackoff
+
+// osvInstance is a single unikernel VM, started by the launcher command for one container of a pod.
+// It is booted again according to the pod's restart policy.
+type osvInstance struct {
+	id            string
+	container     string
+	image         string
+	launcher      string
+	args          []string
+	workDir       string
+	logPath       string
+	restartPolicy v1.RestartPolicy
+
+	cmd             *exec.Cmd
+	startedAt       time.Time
+	finishedAt      time.Time
+	exitCode        int32
+	running         bool
+	startErr        error
+	restartCount    int32
+	lastTermination *v1.ContainerStateTerminated
+	backoff         time.Duration
+	waitingUntil    time.Time
+	stopping        bool
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// BuildLauncherArgs translates a container into arguments for a scripts/run.py style launcher.
+// CPU and memory limits (or requests if no limits are set) size the VM, the command line is passed with -e.
+func BuildLauncherArgs(launcherArgs []string, dc *v1.Container) []string {
+	args := append([]string{}, launcherArgs...)
+	args = append(args, "-i", dc.Image)
+
+	if vcpus := vmCpus(dc); vcpus > 0 {
+		args = append(args, "-c", fmt.Sprintf("%d", vcpus))
+	}
+	if memMb := vmMemoryMb(dc); memMb > 0 {
+		args = append(args, "-m", fmt.Sprintf("%dM", memMb))
+	}
+	if cmdline := vmCmdline(dc); cmdline != "" {
+		args = append(args, "-e", cmdline)
+	}
+	return args
+}
+
+func vmCpus(dc *v1.Container) int64 {
+	cpu := dc.Resources.Limits.Cpu()
+	if cpu.IsZero() {
+		cpu = dc.Resources.Requests.Cpu()
+	}
+	if cpu.IsZero() {
+		return 0
+	}
+	//a VM can't have partial cpus, so round up
+	return int64(math.Ceil(float64(cpu.MilliValue()) / 1000.0))
+}
+
+func vmMemoryMb(dc *v1.Container) int64 {
+	mem := dc.Resources.Limits.Memory()
+	if mem.IsZero() {
+		mem = dc.Resources.Requests.Memory()
+	}
+	if mem.IsZero() {
+		return 0
+	}
+	return int64(math.Ceil(float64(mem.Value()) / (1024 * 1024)))
+}
+
+// vmCmdline builds the OSv boot command line, env vars are passed with the --env runtime option.
+// The -e option replaces the image's own command line, so env vars can only be passed along with a command.
+func vmCmdline(dc *v1.Container) string {
+	if len(dc.Command) == 0 && len(dc.Args) == 0 {
+		return ""
+	}
+	parts := []string{}
+	for _, evar := range dc.Env {
+		if evar.ValueFrom == nil {
+			parts = append(parts, fmt.Sprintf("--env=%s=%s", evar.Name, evar.Value))
+		}
+	}
+	parts = append(parts, dc.Command...)
+	parts = append(parts, dc.Args...)
+	return strings.Join(parts, " ")
+}
+
+func (p *OSvProvider) getLogPath(namespace string, podName string, containerName string) string {
+	return filepath.Join(p.logDir, fmt.Sprintf("%s_%s_%s.log", namespace, podName, containerName))
+}
+
+// startInstance launches the unikernel for a container, the serial console is written to the container's log file.
+// A monitor goroutine handles exits and restarts, a launch that fails counts as an exit with code 128.
+func (p *OSvProvider) startInstance(pod *v1.Pod, dc *v1.Container) *osvInstance {
+	inst := &osvInstance{
+		id:            fmt.Sprintf("%s_%s_%s", pod.Namespace, pod.Name, dc.Name),
+		container:     dc.Name,
+		image:         dc.Image,
+		launcher:      p.launcher,
+		args:          BuildLauncherArgs(p.launcherArgs, dc),
+		workDir:       dc.WorkingDir,
+		logPath:       p.getLogPath(pod.Namespace, pod.Name, dc.Name),
+		restartPolicy: pod.Spec.RestartPolicy,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	var err error
+	if err = os.MkdirAll(p.logDir, 0755); err == nil {
+		inst.cmd, err = launch(inst)
+	}
+	if err != nil {
+		inst.failed(err)
+	} else {
+		inst.running = true
+		inst.startedAt = time.Now()
+	}
+	go p.monitor(inst)
+	return inst
+}
+
+// launch starts the launcher in its own process group, so the hypervisor started by it is stopped along with it.
+// It only reads the parts of inst that don't change after startInstance, so the lock doesn't have to be held.
+func launch(inst *osvInstance) (*exec.Cmd, error) {
+	logFile, err := os.OpenFile(inst.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	//the child has its own copy of the descriptor
+	defer logFile.Close()
+
+	fmt.Printf("Launching OSv instance %s %s\n", inst.launcher, strings.Join(inst.args, " "))
+	cmd := exec.Command(inst.launcher, inst.args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Dir = inst.workDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// failed records a launch that didn't work, the lock must be held once the monitor is running
+func (inst *osvInstance) failed(err error) {
+	fmt.Printf("Failed to launch OSv instance %s: %s\n", inst.container, err.Error())
+	inst.startErr = err
+	inst.exitCode = 128
+	inst.running = false
+	inst.finishedAt = time.Now()
+}
+
+// monitor waits for the launcher to exit and boots the unikernel again with an increasing delay as long as the
+// restart policy allows it
+func (p *OSvProvider) monitor(inst *osvInstance) {
+	defer close(inst.done)
+
+	for {
+		if inst.startErr == nil {
+			inst.cmd.Wait()
+		}
+
+		p.Store.Lock()
+		if inst.startErr == nil {
+			inst.running = false
+			inst.finishedAt = time.Now()
+			inst.exitCode = vkube.ProcessExitCode(inst.cmd.ProcessState)
+			fmt.Printf("OSv instance %s exited with code %d\n", inst.container, inst.exitCode)
+		}
+		inst.lastTermination = inst.terminatedState()
+		p.Store.MarkChanged()
+
+		if inst.stopping || !vkube.ShouldRestart(inst.restartPolicy, inst.exitCode) {
+			p.Store.Unlock()
+			return
+		}
+		var ranFor time.Duration
+		if inst.startErr == nil {
+			ranFor = inst.finishedAt.Sub(inst.startedAt)
+		}
+		inst.backoff = restartBackoff(inst.backoff, ranFor)
+		delay := inst.backoff
+		inst.waitingUntil = time.Now().Add(delay)
+		p.Store.Unlock()
+
+		fmt.Printf("Restarting OSv instance %s in %s\n", inst.container, delay)
+		select {
+		case <-inst.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		p.Store.Lock()
+		stopping := inst.stopping
+		p.Store.Unlock()
+		if stopping {
+			return
+		}
+
+		cmd, err := launch(inst)
+
+		p.Store.Lock()
+		inst.restartCount++
+		if err != nil {
+			inst.failed(err)
+		} else {
+			inst.cmd = cmd
+			inst.startErr = nil
+			inst.running = true
+			inst.startedAt = time.Now()
+			inst.waitingUntil = time.Time{}
+			//stopInstance didn't see this launcher, the next exit ends the loop
+			if inst.stopping {
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			}
+		}
+		p.Store.MarkChanged()
+		p.Store.Unlock()
+	}
+}
+
+// stopInstance cancels pending restarts, sends SIGTERM to the launcher's process group and kills it if it doesn't exit in time
+func (p *OSvProvider) stopInstance(inst *osvInstance) {
+	p.Store.Lock()
+	if inst.stopping {
+		p.Store.Unlock()
+		<-inst.done
+		return
+	}
+	inst.stopping = true
+	close(inst.stop)
+	pgid := 0
+	if inst.running {
+		pgid = -inst.cmd.Process.Pid
+	}
+	p.Store.Unlock()
+
+	if pgid != 0 {
+		syscall.Kill(pgid, syscall.SIGTERM)
+		select {
+		case <-inst.done:
+		case <-time.After(stopTimeout):
+			fmt.Printf("OSv instance %s didn't stop in time, killing\n", inst.container)
+			syscall.Kill(pgid, syscall.SIGKILL)
+		}
+	}
+	<-inst.done
+}
+
+// terminatedState describes the last exit of the instance, the lock must be held
+func (inst *osvInstance) terminatedState() *v1.ContainerStateTerminated {
+	if inst.startErr != nil {
+		return &v1.ContainerStateTerminated{
+			ExitCode:   inst.exitCode,
+			Reason:     "StartError",
+			Message:    inst.startErr.Error(),
+			FinishedAt: metav1.NewTime(inst.finishedAt),
+		}
+	}
+	reason := "Completed"
+	if inst.exitCode != 0 {
+		reason = "Error"
+	}
+	return &v1.ContainerStateTerminated{
+		ExitCode:   inst.exitCode,
+		Reason:     reason,
+		StartedAt:  metav1.NewTime(inst.startedAt),
+		FinishedAt: metav1.NewTime(inst.finishedAt),
+	}
+}
+
+// status converts the process state of the instance into a container status, the lock must be held
+func (inst *osvInstance) status() v1.ContainerStatus {
+	state := v1.ContainerState{}
+	lastState := v1.ContainerState{}
+
+	if inst.running {
+		state.Running = &v1.ContainerStateRunning{
+			StartedAt: metav1.NewTime(inst.startedAt),
+		}
+		lastState.Terminated = inst.lastTermination
+	} else if time.Now().Before(inst.waitingUntil) && !inst.stopping {
+		state.Waiting = &v1.ContainerStateWaiting{
+			Reason:  "CrashLoopBackOff",
+			Message: fmt.Sprintf("back-off %s restarting failed container %s", inst.waitingUntil.Sub(inst.finishedAt).Round(time.Second), inst.container),
+		}
+		lastState.Terminated = inst.lastTermination
+	} else {
+		state.Terminated = inst.terminatedState()
+	}
+
+	return v1.ContainerStatus{
+		Name:                 inst.container,
+		State:                state,
+		LastTerminationState: lastState,
+		Ready:                inst.running,
+		RestartCount:         inst.restartCount,
+		Image:                inst.image,
+		ImageID:              inst.image,
+		ContainerID:          fmt.Sprintf("osv://%s", inst.id),
+	}
+}
@@ -0,0 +1,278 @@
+package osv
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers/testutil"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// stubLauncher logs its arguments and then behaves as its -e command line asks: exit with a code, stop on SIGTERM
+// or ignore SIGTERM until it is killed
+const stubLauncher = `#!/bin/sh
+echo "args: $*"
+case "$*" in
+*exit0*) exit 0 ;;
+*exit3*) exit 3 ;;
+*ignoreterm*) trap '' TERM ;;
+*) trap 'echo terminated; exit 0' TERM ;;
+esac
+while true; do sleep 0.1; done
+`
+
+func newTestProvider(t *testing.T) *OSvProvider {
+	config.Cfg = &config.Config{DeviceIP: "10.0.0.1"}
+	dir := t.TempDir()
+	launcher := filepath.Join(dir, "run.sh")
+	if err := ioutil.WriteFile(launcher, []byte(stubLauncher), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewOSvProvider(config.OSvConfig{Launcher: launcher, LauncherArgs: []string{"--nogdb"}, LogDir: filepath.Join(dir, "logs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func newTestPod(name string, args ...string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Spec: v1.PodSpec{
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers:    []v1.Container{{Name: "app", Image: "app.qemu", Command: []string{"/app"}, Args: args}},
+		},
+	}
+}
+
+// waitForState polls the pod until check accepts the state of its container
+func waitForState(t *testing.T, p *OSvProvider, pod *v1.Pod, check func(v1.ContainerState) bool) *v1.Pod {
+	return testutil.WaitForPod(t, p, pod.Namespace, pod.Name, func(current *v1.Pod) bool {
+		return len(current.Status.ContainerStatuses) == 1 && check(current.Status.ContainerStatuses[0].State)
+	})
+}
+
+func TestBuildLauncherArgs(t *testing.T) {
+	dc := &v1.Container{
+		Image:   "nginx.qemu",
+		Command: []string{"/nginx"},
+		Args:    []string{"-c", "/nginx.conf"},
+		Env:     []v1.EnvVar{{Name: "A", Value: "b"}, {Name: "FROM", ValueFrom: &v1.EnvVarSource{}}},
+		Resources: v1.ResourceRequirements{
+			Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("1500m")},
+			Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("100Mi")},
+		},
+	}
+	args := BuildLauncherArgs([]string{"--nogdb"}, dc)
+	expected := []string{"--nogdb", "-i", "nginx.qemu", "-c", "2", "-m", "100M", "-e", "--env=A=b /nginx -c /nginx.conf"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+
+	args = BuildLauncherArgs(nil, &v1.Container{Image: "app.qemu"})
+	if !reflect.DeepEqual(args, []string{"-i", "app.qemu"}) {
+		t.Fatalf("a container without command or resources should only pass the image, got %v", args)
+	}
+}
+
+func TestInstanceExit(t *testing.T) {
+	p := newTestProvider(t)
+	pod := newTestPod("exits", "exit3")
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	current := waitForState(t, p, pod, func(state v1.ContainerState) bool { return state.Terminated != nil })
+	terminated := current.Status.ContainerStatuses[0].State.Terminated
+	if terminated.ExitCode != 3 || terminated.Reason != "Error" {
+		t.Fatalf("expected exit code 3 with reason Error, got %d %s", terminated.ExitCode, terminated.Reason)
+	}
+	if current.Status.Phase != v1.PodFailed {
+		t.Fatalf("expected phase Failed, got %s", current.Status.Phase)
+	}
+
+	logs, err := ioutil.ReadFile(p.getLogPath(pod.Namespace, pod.Name, "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "args: --nogdb -i app.qemu -e /app exit3") {
+		t.Fatalf("launcher got unexpected arguments: %s", logs)
+	}
+}
+
+func TestDeleteStopsInstance(t *testing.T) {
+	p := newTestProvider(t)
+	pod := newTestPod("stops")
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	current := waitForState(t, p, pod, func(state v1.ContainerState) bool { return state.Running != nil })
+	if current.Status.Phase != v1.PodRunning {
+		t.Fatalf("expected phase Running, got %s", current.Status.Phase)
+	}
+	//give the launcher time to install its trap
+	time.Sleep(200 * time.Millisecond)
+
+	opod := p.Store.Get(pod.Namespace, pod.Name).(*osvPod)
+	p.Store.Lock()
+	inst := opod.instances["app"]
+	p.Store.Unlock()
+	start := time.Now()
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= stopTimeout {
+		t.Fatal("launcher should have stopped on SIGTERM")
+	}
+	if inst.exitCode != 0 {
+		t.Fatalf("launcher should have exited cleanly on SIGTERM, got exit code %d", inst.exitCode)
+	}
+	if _, err := os.Stat(inst.logPath); !os.IsNotExist(err) {
+		t.Fatal("the console log should be removed with the pod")
+	}
+	if current, _ := p.GetPod(context.Background(), pod.Namespace, pod.Name); current != nil {
+		t.Fatal("pod should be gone after delete")
+	}
+}
+
+func TestDeleteKillsInstanceIgnoringSIGTERM(t *testing.T) {
+	defer func(timeout time.Duration) { stopTimeout = timeout }(stopTimeout)
+	stopTimeout = 300 * time.Millisecond
+
+	p := newTestProvider(t)
+	pod := newTestPod("ignores", "ignoreterm")
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, p, pod, func(state v1.ContainerState) bool { return state.Running != nil })
+	time.Sleep(200 * time.Millisecond)
+
+	opod := p.Store.Get(pod.Namespace, pod.Name).(*osvPod)
+	p.Store.Lock()
+	inst := opod.instances["app"]
+	p.Store.Unlock()
+	start := time.Now()
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < stopTimeout {
+		t.Fatal("launcher ignoring SIGTERM should only be killed after the stop timeout")
+	}
+	if inst.cmd.ProcessState == nil || inst.cmd.ProcessState.ExitCode() != -1 {
+		t.Fatal("launcher should have been killed")
+	}
+	if inst.exitCode != 128+int32(syscall.SIGKILL) {
+		t.Fatalf("a killed launcher should exit with 128 + SIGKILL, got %d", inst.exitCode)
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return 50 * time.Millisecond }
+
+	tests := []struct {
+		name     string
+		policy   v1.RestartPolicy
+		exitCode int32
+		restarts bool
+	}{
+		{name: "never", policy: v1.RestartPolicyNever, exitCode: 3},
+		{name: "on failure after failure", policy: v1.RestartPolicyOnFailure, exitCode: 3, restarts: true},
+		{name: "on failure after success", policy: v1.RestartPolicyOnFailure, exitCode: 0},
+		{name: "always", policy: v1.RestartPolicyAlways, exitCode: 0, restarts: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			pod := newTestPod("restart", fmt.Sprintf("exit%d", test.exitCode))
+			pod.Spec.RestartPolicy = test.policy
+			if err := p.CreatePod(context.Background(), pod); err != nil {
+				t.Fatal(err)
+			}
+			defer p.DeletePod(context.Background(), pod)
+
+			if !test.restarts {
+				current := waitForState(t, p, pod, func(state v1.ContainerState) bool { return state.Terminated != nil })
+				if code := current.Status.ContainerStatuses[0].State.Terminated.ExitCode; code != test.exitCode {
+					t.Fatalf("expected exit code %d, got %d", test.exitCode, code)
+				}
+				time.Sleep(200 * time.Millisecond)
+				current, _ = p.GetPod(context.Background(), pod.Namespace, pod.Name)
+				if current.Status.ContainerStatuses[0].RestartCount != 0 {
+					t.Fatal("the instance should not have been restarted")
+				}
+				return
+			}
+
+			current := testutil.WaitForPod(t, p, pod.Namespace, pod.Name, func(current *v1.Pod) bool {
+				return len(current.Status.ContainerStatuses) == 1 && current.Status.ContainerStatuses[0].RestartCount >= 2
+			})
+			last := current.Status.ContainerStatuses[0].LastTerminationState.Terminated
+			if last == nil || last.ExitCode != test.exitCode {
+				t.Fatalf("expected the last termination with exit code %d, got %v", test.exitCode, last)
+			}
+		})
+	}
+}
+
+func TestCrashLoopBackOff(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return time.Minute }
+
+	p := newTestProvider(t)
+	pod := newTestPod("backoff", "exit3")
+	pod.Spec.RestartPolicy = v1.RestartPolicyAlways
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	current := waitForState(t, p, pod, func(state v1.ContainerState) bool { return state.Waiting != nil })
+	status := current.Status.ContainerStatuses[0]
+	if status.State.Waiting.Reason != "CrashLoopBackOff" {
+		t.Fatalf("expected CrashLoopBackOff, got %s", status.State.Waiting.Reason)
+	}
+	if status.LastTerminationState.Terminated == nil || status.LastTerminationState.Terminated.ExitCode != 3 {
+		t.Fatal("the exit of the instance should be the last termination state")
+	}
+
+	//deleting the pod cancels the pending restart
+	start := time.Now()
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("deleting should not wait for the backoff")
+	}
+}
+
+func TestLaunchFailureKeepsPod(t *testing.T) {
+	p := newTestProvider(t)
+	os.Remove(p.launcher)
+	pod := newTestPod("nolauncher")
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatalf("a launch that failed should be reported in the container status, got %s", err)
+	}
+	defer p.DeletePod(context.Background(), pod)
+
+	current := waitForState(t, p, pod, func(state v1.ContainerState) bool { return state.Terminated != nil })
+	terminated := current.Status.ContainerStatuses[0].State.Terminated
+	if terminated.Reason != "StartError" || terminated.ExitCode != 128 || terminated.Message == "" {
+		t.Fatalf("expected a StartError with exit code 128, got %s %d %q", terminated.Reason, terminated.ExitCode, terminated.Message)
+	}
+	if current.Status.Phase != v1.PodFailed {
+		t.Fatalf("expected phase Failed, got %s", current.Status.Phase)
+	}
+}
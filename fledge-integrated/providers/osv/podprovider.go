@@ -2,156 +2,173 @@ package osv
 
 import (
 	"context"
-	"errors"
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/podstore"
 	"fledge/fledge-integrated/vkube"
 	"fmt"
 	"io"
-	"log"
+	"os"
+	"os/exec"
 	"time"
 
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/remotecommand"
 )
 
-type OSvProvider struct {
+// osvPod keeps the pod spec together with the unikernel instances running its containers
+type osvPod struct {
+	pod       *v1.Pod
+	instances map[string]*osvInstance
 }
 
-func NewOSvProvider() (*OSvProvider, error) {
-	if CheckProviderRequirements() {
-		var provider OSvProvider
-		return &provider, nil
-	} else {
-		return nil, errors.New("Containerd not found")
-	}
+func (opod *osvPod) Pod() *v1.Pod {
+	return opod.pod
 }
 
-func CheckProviderRequirements() bool {
-	//check for qemu-kvm (uhh how to determine binary name?) and check scripts/run.py path from config
-	//alternatively, include scripts/run.py in FLEDGE? maybe later
-	return true
+func (opod *osvPod) ContainerStatus(name string) (v1.ContainerStatus, bool) {
+	inst, found := opod.instances[name]
+	if !found {
+		return v1.ContainerStatus{}, false
+	}
+	return inst.status(), true
 }
 
-func (p *OSvProvider) PodsChanged() bool {
-	return false
+type OSvProvider struct {
+	*podstore.Store
+
+	launcher     string
+	launcherArgs []string
+	logDir       string
 }
 
-func (p *OSvProvider) ResetChanges() {
+// NewOSvProvider creates an OSv provider that boots images with the configured launcher command.
+func NewOSvProvider(cfg config.OSvConfig) (*OSvProvider, error) {
+	launcher, err := CheckProviderRequirements(cfg)
+	if err != nil {
+		return nil, err
+	}
 
+	provider := OSvProvider{
+		Store:        podstore.NewStore(),
+		launcher:     launcher,
+		launcherArgs: cfg.LauncherArgs,
+		logDir:       cfg.LogDir,
+	}
+	if provider.logDir == "" {
+		provider.logDir = defaultLogDir
+	}
+	return &provider, nil
+}
+
+// CheckProviderRequirements checks that the launcher (scripts/run.py or a wrapper around it) exists and returns its path
+func CheckProviderRequirements(cfg config.OSvConfig) (string, error) {
+	if cfg.Launcher == "" {
+		return "", errors.New("OSv launcher not configured")
+	}
+	launcher, err := exec.LookPath(cfg.Launcher)
+	if err != nil {
+		return "", errors.Wrap(err, "OSv launcher not found")
+	}
+	return launcher, nil
 }
 
-// CreatePod accepts a Pod definition and forwards the call to the web endpoint
+// CreatePod boots a unikernel for every container in the pod
 func (p *OSvProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
-	fmt.Println("CreatePod")
+	if len(pod.Spec.InitContainers) > 0 {
+		return errors.New("init containers are not supported by the OSv provider")
+	}
+
+	fmt.Printf("Creating OSv pod %s\n", podstore.PodKey(pod.Namespace, pod.Name))
+
+	pod = podstore.NewPod(pod)
+	opod := &osvPod{
+		pod:       pod,
+		instances: make(map[string]*osvInstance),
+	}
+	if err := p.Store.Add(opod); err != nil {
+		return err
+	}
 
+	//an instance that couldn't be launched stays in the pod with a StartError and is retried per restart policy
+	for i := range pod.Spec.Containers {
+		dc := &pod.Spec.Containers[i]
+		inst := p.startInstance(pod, dc)
+		p.Store.Lock()
+		opod.instances[dc.Name] = inst
+		p.Store.Unlock()
+	}
+
+	p.Store.UpdateStatus(opod)
 	return nil
 }
 
-// UpdatePod accepts a Pod definition and forwards the call to the web endpoint
+// UpdatePod restarts the pod's unikernels, a running VM can't be changed in place
 func (p *OSvProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
-	name := pod.ObjectMeta.Name
-	namespace := pod.ObjectMeta.Namespace
-
-	fmt.Printf("Updating pod namespace %s name %s\n", namespace, name)
+	fmt.Printf("Updating pod namespace %s name %s\n", pod.Namespace, pod.Name)
 
-	vkube.Cri.UpdatePod(pod)
-	return nil
+	if err := p.DeletePod(ctx, pod); err != nil && !strongerrors.IsNotFound(err) {
+		return err
+	}
+	return p.CreatePod(ctx, pod)
 }
 
-// DeletePod accepts a Pod definition and forwards the call to the web endpoint
+// DeletePod stops the pod's unikernels and removes their console logs
 func (p *OSvProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
-	name := pod.ObjectMeta.Name
-	namespace := pod.ObjectMeta.Namespace
+	fmt.Printf("Deleting pod namespace %s name %s\n", pod.Namespace, pod.Name)
 
-	fmt.Printf("Deleting pod namespace %s name %s\n", namespace, name)
+	state, err := p.Store.Remove(pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	opod := state.(*osvPod)
+	p.Store.Lock()
+	instances := []*osvInstance{}
+	for _, inst := range opod.instances {
+		instances = append(instances, inst)
+	}
+	p.Store.Unlock()
 
-	vkube.Cri.DeletePod(pod)
+	for _, inst := range instances {
+		p.stopInstance(inst)
+		os.Remove(inst.logPath)
+	}
 	return nil
 }
 
-// GetPod returns a pod by name that is being managed by the web server
-func (p *OSvProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
-	return nil, nil
-}
+// GetContainerLogs returns the serial console output of a unikernel.
+func (p *OSvProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	var inst *osvInstance
+	if opod, found := p.Store.Get(namespace, podName).(*osvPod); found {
+		p.Store.Lock()
+		inst = opod.instances[containerName]
+		p.Store.Unlock()
+	}
 
-// GetContainerLogs returns the logs of a container running in a pod by name.
-func (p *OSvProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error) {
-	return "", nil
-}
+	if inst == nil {
+		return nil, strongerrors.NotFound(errors.Errorf("container %s not found in pod %s/%s", containerName, namespace, podName))
+	}
 
-// Get full pod name as defined in the provider context
-// TODO: Implementation
-func (p *OSvProvider) GetPodFullName(namespace string, pod string) string {
-	//TODO check how web provider did it
-	return ""
+	return vkube.ReadPlainLog(inst.logPath, opts)
 }
 
-// ExecInContainer executes a command in a container in the pod, copying data
-// between in/out/err and the container's stdin/stdout/stderr.
-// TODO: Implementation
+// ExecInContainer is not supported, unikernels run a single process and have no shell.
 func (p *OSvProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
-	log.Printf("receive ExecInContainer %q\n", container)
-	return nil
+	return strongerrors.NotImplemented(errors.New("exec is not supported in OSv unikernels"))
 }
 
-// GetPodStatus retrieves the status of a given pod by name.
-func (p *OSvProvider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
-	return nil, nil
+// unikernel images can't mount host directories
+func (p *OSvProvider) SupportedVolumeTypes() []string {
+	return []string{}
 }
 
-// GetPods retrieves a list of all pods scheduled to run.
-func (p *OSvProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
-	return nil, nil
+func (p *OSvProvider) SupportsExec() bool {
+	return false
 }
 
-func GetContainerResources() map[v1.ResourceName]*resource.Quantity {
-	cpuRequest, _ := resource.ParseQuantity("0")
-	memRequest, _ := resource.ParseQuantity("0")
-	storRequest, _ := resource.ParseQuantity("0")
-	cpuLimit, _ := resource.ParseQuantity("0")
-	memLimit, _ := resource.ParseQuantity("0")
-
-	podSpecs := []*v1.Pod{} //vkube.Cri.GetPods()
-	fmt.Printf("Podspecs count %d\n", len(podSpecs))
-	//iterate pods => podspec => iterate containers => resourcerequirements
-	for _, pod := range podSpecs {
-		fmt.Printf("Tallying podspec %s\n", pod.ObjectMeta.Name)
-		for _, container := range pod.Spec.Containers {
-			fmt.Printf("Tallying container %s\n", container.Name)
-			if container.Resources.Requests != nil {
-				val := container.Resources.Requests.Cpu()
-				if val != nil {
-					cpuRequest.Add(*val)
-				}
-				val = container.Resources.Requests.Memory()
-				if val != nil {
-					memRequest.Add(*val)
-				}
-				sval, err := container.Resources.Requests[v1.ResourceStorage]
-				if !err {
-					storRequest.Add(sval)
-				}
-				val = container.Resources.Limits.Cpu()
-				if val != nil {
-					cpuLimit.Add(*val)
-				}
-				val = container.Resources.Limits.Memory()
-				if val != nil {
-					memLimit.Add(*val)
-				}
-			}
-		}
-	}
-
-	resources := make(map[v1.ResourceName]*resource.Quantity)
-	resources[v1.ResourceRequestsCPU] = &cpuRequest
-	resources[v1.ResourceRequestsMemory] = &memRequest
-	resources[v1.ResourceRequestsStorage] = &storRequest
-	resources[v1.ResourceLimitsCPU] = &cpuLimit
-	resources[v1.ResourceLimitsMemory] = &memLimit
-	fmt.Println("Resources used")
-	fmt.Println(resources)
-
-	return resources
+func (p *OSvProvider) SupportsLogs() bool {
+	return true
 }
@@ -0,0 +1,462 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/plugin/pluginapi"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+// APIVersion is the version of the plugin API this fledge speaks
+const APIVersion = "v1"
+
+// capabilities a plugin can advertise in its handshake
+const (
+	CapabilityLogs = "logs"
+	CapabilityExec = "exec"
+)
+
+const (
+	connectTimeout = 5 * time.Second
+	callTimeout    = 30 * time.Second
+	execChunkSize  = 32 * 1024
+)
+
+// PluginProvider is a pod provider running in a separate process, reached over a unix socket.
+type PluginProvider struct {
+	name         string
+	socket       string
+	conn         *grpc.ClientConn
+	client       pluginapi.PodProviderClient
+	health       healthpb.HealthClient
+	capabilities map[string]bool
+
+	lock    sync.RWMutex
+	healthy bool
+}
+
+// Connect dials a plugin socket, checks its health and does the handshake
+func Connect(socket string) (*PluginProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+	}
+	conn, err := grpc.DialContext(ctx, socket, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithContextDialer(dialer))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to plugin %s", socket)
+	}
+
+	p := &PluginProvider{
+		socket:       socket,
+		conn:         conn,
+		client:       pluginapi.NewPodProviderClient(conn),
+		health:       healthpb.NewHealthClient(conn),
+		capabilities: make(map[string]bool),
+	}
+
+	if err := p.CheckHealth(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := p.client.Handshake(ctx, &pluginapi.HandshakeRequest{ApiVersion: APIVersion})
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "handshake with plugin %s failed", socket)
+	}
+	if resp.ApiVersion != APIVersion {
+		conn.Close()
+		return nil, errors.Errorf("plugin %s speaks API %s, expected %s", socket, resp.ApiVersion, APIVersion)
+	}
+	if resp.Name == "" {
+		conn.Close()
+		return nil, errors.Errorf("plugin %s didn't advertise a name", socket)
+	}
+	p.name = resp.Name
+	for _, capability := range resp.Capabilities {
+		p.capabilities[capability] = true
+	}
+	fmt.Printf("Connected to plugin %s at %s, capabilities %s\n", p.name, socket, strings.Join(resp.Capabilities, ","))
+	return p, nil
+}
+
+func (p *PluginProvider) Name() string {
+	return p.name
+}
+
+func (p *PluginProvider) HasCapability(capability string) bool {
+	return p.capabilities[capability]
+}
+
+func (p *PluginProvider) SupportsExec() bool {
+	return p.HasCapability(CapabilityExec)
+}
+
+func (p *PluginProvider) SupportsLogs() bool {
+	return p.HasCapability(CapabilityLogs)
+}
+
+func (p *PluginProvider) Close() error {
+	return p.conn.Close()
+}
+
+// CheckHealth asks the plugin's health service whether it is serving and records the result
+func (p *PluginProvider) CheckHealth(ctx context.Context) error {
+	resp, err := p.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err == nil && resp.Status != healthpb.HealthCheckResponse_SERVING {
+		err = errors.Errorf("plugin is %s", resp.Status.String())
+	}
+
+	p.lock.Lock()
+	p.healthy = err == nil
+	p.lock.Unlock()
+	if err != nil {
+		return errors.Wrapf(err, "health check of plugin %s failed", p.socket)
+	}
+	return nil
+}
+
+func (p *PluginProvider) IsHealthy() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.healthy
+}
+
+func (p *PluginProvider) checkAvailable() error {
+	if !p.IsHealthy() {
+		return &providers.RuntimeUnavailableError{Runtime: p.name, Reason: "plugin is not healthy"}
+	}
+	return nil
+}
+
+func (p *PluginProvider) podRequest(pod *v1.Pod) (*pluginapi.PodRequest, error) {
+	podJson, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginapi.PodRequest{Pod: podJson}, nil
+}
+
+// CreatePod takes a Kubernetes Pod and deploys it within the provider.
+func (p *PluginProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	req, err := p.podRequest(pod)
+	if err != nil {
+		return err
+	}
+	_, err = p.client.CreatePod(ctx, req)
+	return p.fromStatus(err)
+}
+
+// UpdatePod takes a Kubernetes Pod and updates it within the provider.
+func (p *PluginProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	req, err := p.podRequest(pod)
+	if err != nil {
+		return err
+	}
+	_, err = p.client.UpdatePod(ctx, req)
+	return p.fromStatus(err)
+}
+
+// DeletePod takes a Kubernetes Pod and deletes it from the provider.
+func (p *PluginProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+	req, err := p.podRequest(pod)
+	if err != nil {
+		return err
+	}
+	_, err = p.client.DeletePod(ctx, req)
+	return p.fromStatus(err)
+}
+
+// GetPod retrieves a pod by name from the plugin.
+func (p *PluginProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	if err := p.checkAvailable(); err != nil {
+		return nil, err
+	}
+	resp, err := p.client.GetPod(ctx, &pluginapi.GetPodRequest{Namespace: namespace, Name: name})
+	if err != nil {
+		return nil, p.fromStatus(err)
+	}
+	if !resp.Found {
+		return nil, nil
+	}
+	pod := &v1.Pod{}
+	if err := json.Unmarshal(resp.Pod, pod); err != nil {
+		return nil, errors.Wrapf(err, "plugin %s returned an invalid pod", p.name)
+	}
+	return pod, nil
+}
+
+// GetContainerLogs returns the log chunks streamed by the plugin as they arrive, closing the logs ends the call.
+func (p *PluginProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	if !p.HasCapability(CapabilityLogs) {
+		return nil, strongerrors.NotImplemented(errors.Errorf("plugin %s doesn't support logs", p.name))
+	}
+	if err := p.checkAvailable(); err != nil {
+		return nil, err
+	}
+	req := &pluginapi.ContainerLogsRequest{
+		Namespace:     namespace,
+		PodName:       podName,
+		ContainerName: containerName,
+		Tail:          int32(opts.Tail),
+		LimitBytes:    int64(opts.LimitBytes),
+		Timestamps:    opts.Timestamps,
+		Follow:        opts.Follow,
+		Previous:      opts.Previous,
+	}
+	if !opts.SinceTime.IsZero() {
+		req.SinceTime = opts.SinceTime.UnixNano()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := p.client.GetContainerLogs(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, p.fromStatus(err)
+	}
+	//the first message tells whether the plugin found the container
+	first, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		cancel()
+		return nil, p.fromStatus(err)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer cancel()
+		if err == io.EOF {
+			writer.Close()
+			return
+		}
+		for chunk := first; ; {
+			if len(chunk.Data) > 0 {
+				if _, err := writer.Write(chunk.Data); err != nil {
+					return
+				}
+			}
+			chunk, err = stream.Recv()
+			if err == io.EOF {
+				writer.Close()
+				return
+			}
+			if err != nil {
+				writer.CloseWithError(p.fromStatus(err))
+				return
+			}
+		}
+	}()
+	return &pluginLogs{PipeReader: reader, cancel: cancel}, nil
+}
+
+// pluginLogs cancels the logs call of the plugin when it is closed
+type pluginLogs struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (l *pluginLogs) Close() error {
+	l.cancel()
+	return l.PipeReader.Close()
+}
+
+// ExecInContainer forwards stdin and terminal resizes to the plugin and copies its output to out and err.
+func (p *PluginProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	if !p.HasCapability(CapabilityExec) {
+		return strongerrors.NotImplemented(errors.Errorf("plugin %s doesn't support exec", p.name))
+	}
+	if err := p.checkAvailable(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := p.client.ExecInContainer(ctx)
+	if err != nil {
+		return p.fromStatus(err)
+	}
+
+	//a grpc stream doesn't allow concurrent sends
+	var sendLock sync.Mutex
+	send := func(req *pluginapi.ExecRequest) error {
+		sendLock.Lock()
+		defer sendLock.Unlock()
+		return stream.Send(req)
+	}
+
+	err = send(&pluginapi.ExecRequest{Msg: &pluginapi.ExecRequest_Start{Start: &pluginapi.ExecStart{
+		Name:           name,
+		Uid:            string(uid),
+		Container:      container,
+		Cmd:            cmd,
+		Tty:            tty,
+		Stdin:          in != nil,
+		Stdout:         out != nil,
+		Stderr:         errOut != nil,
+		TimeoutSeconds: int64(timeout.Seconds()),
+	}}})
+	if err != nil {
+		return p.fromStatus(err)
+	}
+
+	if in != nil {
+		go func() {
+			buf := make([]byte, execChunkSize)
+			for {
+				n, err := in.Read(buf)
+				if n > 0 {
+					data := append([]byte{}, buf[:n]...)
+					if send(&pluginapi.ExecRequest{Msg: &pluginapi.ExecRequest_Stdin{Stdin: data}}) != nil {
+						return
+					}
+				}
+				if err != nil {
+					send(&pluginapi.ExecRequest{Msg: &pluginapi.ExecRequest_StdinClosed{StdinClosed: true}})
+					return
+				}
+			}
+		}()
+	}
+	if resize != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case size, ok := <-resize:
+					if !ok {
+						return
+					}
+					err := send(&pluginapi.ExecRequest{Msg: &pluginapi.ExecRequest_Resize{Resize: &pluginapi.TerminalSize{
+						Width:  uint32(size.Width),
+						Height: uint32(size.Height),
+					}}})
+					if err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return errors.Errorf("plugin %s ended exec without an exit status", p.name)
+		}
+		if err != nil {
+			return p.fromStatus(err)
+		}
+		switch msg := resp.Msg.(type) {
+		case *pluginapi.ExecResponse_Stdout:
+			if out != nil {
+				out.Write(msg.Stdout)
+			}
+		case *pluginapi.ExecResponse_Stderr:
+			if errOut != nil {
+				errOut.Write(msg.Stderr)
+			}
+		case *pluginapi.ExecResponse_Exit:
+			if msg.Exit.Error != "" {
+				return errors.New(msg.Exit.Error)
+			}
+			if msg.Exit.ExitCode != 0 {
+				return utilexec.CodeExitError{
+					Err:  fmt.Errorf("command terminated with exit code %d", msg.Exit.ExitCode),
+					Code: int(msg.Exit.ExitCode),
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// GetPodStatus retrieves the status of a pod by name from the plugin.
+func (p *PluginProvider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
+	if err := p.checkAvailable(); err != nil {
+		return nil, err
+	}
+	resp, err := p.client.GetPodStatus(ctx, &pluginapi.GetPodRequest{Namespace: namespace, Name: name})
+	if err != nil {
+		return nil, p.fromStatus(err)
+	}
+	if !resp.Found {
+		return nil, nil
+	}
+	status := &v1.PodStatus{}
+	if err := json.Unmarshal(resp.Status, status); err != nil {
+		return nil, errors.Wrapf(err, "plugin %s returned an invalid pod status", p.name)
+	}
+	return status, nil
+}
+
+// GetPods retrieves a list of all pods running in the plugin.
+func (p *PluginProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	if err := p.checkAvailable(); err != nil {
+		return nil, err
+	}
+	resp, err := p.client.GetPods(ctx, &pluginapi.GetPodsRequest{})
+	if err != nil {
+		return nil, p.fromStatus(err)
+	}
+	pods := []*v1.Pod{}
+	for _, podJson := range resp.Pods {
+		pod := &v1.Pod{}
+		if err := json.Unmarshal(podJson, pod); err != nil {
+			return nil, errors.Wrapf(err, "plugin %s returned an invalid pod", p.name)
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// PodsChanged asks the plugin whether pod states changed, calls to an unhealthy plugin are skipped
+func (p *PluginProvider) PodsChanged() bool {
+	if !p.IsHealthy() {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	resp, err := p.client.PodsChanged(ctx, &pluginapi.Empty{})
+	if err != nil {
+		fmt.Printf("PodsChanged call to plugin %s failed: %s\n", p.name, err.Error())
+		return false
+	}
+	return resp.Changed
+}
+
+func (p *PluginProvider) ResetChanges() {
+	if !p.IsHealthy() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	if _, err := p.client.ResetChanges(ctx, &pluginapi.Empty{}); err != nil {
+		fmt.Printf("ResetChanges call to plugin %s failed: %s\n", p.name, err.Error())
+	}
+}
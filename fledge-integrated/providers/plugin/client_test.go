@@ -0,0 +1,314 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/plugin/pluginapi"
+	"fledge/fledge-integrated/providers/testutil"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+// fakeProvider fails CreatePod with createErr, has logs for container app of pod default/web
+// and runs exec commands by echoing stdin, a command named fail exits with 2
+type fakeProvider struct {
+	createErr error
+}
+
+func (f *fakeProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	return f.createErr
+}
+
+func (f *fakeProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	return nil
+}
+
+func (f *fakeProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	return nil
+}
+
+func (f *fakeProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	if namespace != "default" || name != "web" {
+		return nil, nil
+	}
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+}
+
+func (f *fakeProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	if namespace != "default" || podName != "web" || containerName != "app" {
+		return nil, strongerrors.NotFound(errors.Errorf("container %s not found in pod %s/%s", containerName, namespace, podName))
+	}
+	return ioutil.NopCloser(strings.NewReader(fmt.Sprintf("hello\ntail %d\n", opts.Tail))), nil
+}
+
+func (f *fakeProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	if name != "default_web" {
+		return strongerrors.NotFound(errors.Errorf("pod %s not found", name))
+	}
+	if in != nil {
+		io.Copy(out, in)
+	}
+	if len(cmd) > 0 && cmd[0] == "fail" {
+		err.Write([]byte("failed\n"))
+		return utilexec.CodeExitError{Err: errors.New("command terminated with exit code 2"), Code: 2}
+	}
+	return nil
+}
+
+func (f *fakeProvider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
+	return nil, nil
+}
+
+func (f *fakeProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	return nil, nil
+}
+
+func (f *fakeProvider) PodsChanged() bool {
+	return false
+}
+
+func (f *fakeProvider) ResetChanges() {}
+
+// handshakeServer answers the handshake with the given version and name instead of the server's own
+type handshakeServer struct {
+	*Server
+	version string
+	name    string
+}
+
+func (s *handshakeServer) Handshake(ctx context.Context, req *pluginapi.HandshakeRequest) (*pluginapi.HandshakeResponse, error) {
+	return &pluginapi.HandshakeResponse{Name: s.name, ApiVersion: s.version}, nil
+}
+
+// serve runs a plugin on a unix socket in a temporary directory and returns the socket
+func serve(t *testing.T, impl pluginapi.PodProviderServer, healthServer *health.Server) string {
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	pluginapi.RegisterPodProviderServer(grpcServer, impl)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return socket
+}
+
+// connect serves provider as a plugin named test and connects to it
+func connect(t *testing.T, provider providers.PodProvider, capabilities ...string) (*PluginProvider, *Server) {
+	server := NewServer("test", provider, capabilities...)
+	p, err := Connect(serve(t, server, server.health))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.Close()
+	})
+	return p, server
+}
+
+func TestErrorMappingRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		message string
+		check   func(err error) bool
+	}{
+		{name: "not found", err: strongerrors.NotFound(errors.New("gone")), message: "gone", check: strongerrors.IsNotFound},
+		{name: "not implemented", err: strongerrors.NotImplemented(errors.New("nope")), message: "nope", check: strongerrors.IsNotImplemented},
+		{name: "invalid argument", err: strongerrors.InvalidArgument(errors.New("bad")), message: "bad", check: strongerrors.IsInvalidArgument},
+		{name: "already exists", err: strongerrors.AlreadyExists(errors.New("twice")), message: "twice", check: strongerrors.IsAlreadyExists},
+		{name: "runtime unavailable", err: &providers.RuntimeUnavailableError{Runtime: "inner", Reason: "down"}, message: "down", check: providers.IsRuntimeUnavailable},
+		{name: "other", err: errors.New("broken"), message: "broken", check: func(err error) bool {
+			return !strongerrors.IsNotFound(err) && !providers.IsRuntimeUnavailable(err) && strings.Contains(err.Error(), "plugin test")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, _ := connect(t, &fakeProvider{createErr: test.err})
+			err := p.CreatePod(context.Background(), &v1.Pod{})
+			if err == nil || !test.check(err) {
+				t.Fatalf("error %v came back as %v", test.err, err)
+			}
+			if !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("the message of %v got lost, got %v", test.err, err)
+			}
+		})
+	}
+
+	if err := toStatus(nil); err != nil {
+		t.Fatalf("no error became %v", err)
+	}
+	p := &PluginProvider{name: "test"}
+	if err := p.fromStatus(status.Error(codes.DeadlineExceeded, "slow")); !strongerrors.IsDeadline(err) {
+		t.Fatalf("deadline exceeded became %v", err)
+	}
+}
+
+func TestConnectChecksHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		plugin  string
+		ok      bool
+	}{
+		{name: "matching version", version: APIVersion, plugin: "test", ok: true},
+		{name: "other version", version: "v2", plugin: "test"},
+		{name: "no name", version: APIVersion},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer("test", &fakeProvider{})
+			socket := serve(t, &handshakeServer{Server: server, version: test.version, name: test.plugin}, server.health)
+			p, err := Connect(socket)
+			if test.ok != (err == nil) {
+				t.Fatalf("connect returned %v", err)
+			}
+			if p != nil {
+				p.Close()
+			}
+		})
+	}
+
+	//the server refuses fledges that speak another version
+	server := NewServer("test", &fakeProvider{})
+	_, err := server.Handshake(context.Background(), &pluginapi.HandshakeRequest{ApiVersion: "v0"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("handshake with an unknown version returned %v", err)
+	}
+}
+
+func TestConnectRecordsCapabilities(t *testing.T) {
+	p, _ := connect(t, &fakeProvider{}, CapabilityLogs)
+	if p.Name() != "test" {
+		t.Fatalf("plugin is named %s", p.Name())
+	}
+	if !p.SupportsLogs() || p.SupportsExec() {
+		t.Fatalf("capabilities %v, want only logs", p.capabilities)
+	}
+	err := p.ExecInContainer("default_web", "", "app", []string{"ls"}, nil, nil, nil, false, nil, time.Second)
+	if !strongerrors.IsNotImplemented(err) {
+		t.Fatalf("exec without the exec capability returned %v", err)
+	}
+}
+
+func TestUnhealthyPluginIsUnavailable(t *testing.T) {
+	p, server := connect(t, &fakeProvider{})
+	server.SetServing(false)
+	if err := p.CheckHealth(context.Background()); err == nil {
+		t.Fatal("a plugin that isn't serving passed the health check")
+	}
+	if err := p.CreatePod(context.Background(), &v1.Pod{}); !providers.IsRuntimeUnavailable(err) {
+		t.Fatalf("creating a pod in an unhealthy plugin returned %v", err)
+	}
+
+	server.SetServing(true)
+	if err := p.CheckHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreatePod(context.Background(), &v1.Pod{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetContainerLogs(t *testing.T) {
+	p, _ := connect(t, &fakeProvider{}, CapabilityLogs)
+	logs, err := p.GetContainerLogs(context.Background(), "default", "web", "app", providers.ContainerLogOpts{Tail: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(logs)
+	logs.Close()
+	if err != nil || string(content) != "hello\ntail 5\n" {
+		t.Fatalf("logs %q, %v", content, err)
+	}
+
+	if _, err := p.GetContainerLogs(context.Background(), "default", "web", "db", providers.ContainerLogOpts{}); !strongerrors.IsNotFound(err) {
+		t.Fatalf("logs of an unknown container returned %v", err)
+	}
+}
+
+func TestExecInContainer(t *testing.T) {
+	p, _ := connect(t, &fakeProvider{}, CapabilityExec)
+
+	out := &testutil.BufferCloser{}
+	if err := p.ExecInContainer("default_web", "", "app", []string{"cat"}, strings.NewReader("hello\n"), out, nil, false, nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\n" {
+		t.Fatalf("exec output %q", out.String())
+	}
+
+	errOut := &testutil.BufferCloser{}
+	err := p.ExecInContainer("default_web", "", "app", []string{"fail"}, nil, nil, errOut, false, nil, time.Second)
+	if exitErr, ok := err.(utilexec.CodeExitError); !ok || exitErr.Code != 2 {
+		t.Fatalf("exec of a failing command returned %v", err)
+	}
+	if errOut.String() != "failed\n" {
+		t.Fatalf("exec stderr %q", errOut.String())
+	}
+
+	if err := p.ExecInContainer("default_db", "", "app", []string{"ls"}, nil, nil, nil, false, nil, time.Second); !strongerrors.IsNotFound(err) {
+		t.Fatalf("exec in an unknown pod returned %v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.sock", "b.sock"} {
+		socket := filepath.Join(dir, name)
+		go NewServer("test", &fakeProvider{}).Serve(socket)
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(socket); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//both sockets serve a plugin named test, only the first one is used
+	plugins := Discover(dir)
+	if len(plugins) != 1 {
+		t.Fatalf("discovered %d plugins, want 1", len(plugins))
+	}
+	defer plugins[0].Close()
+	if plugins[0].Name() != "test" || filepath.Base(plugins[0].socket) != "a.sock" {
+		t.Fatalf("discovered plugin %s at %s", plugins[0].Name(), plugins[0].socket)
+	}
+	pod, err := plugins[0].GetPod(context.Background(), "default", "web")
+	if err != nil || pod == nil {
+		t.Fatalf("discovered plugin didn't return its pod: %v", err)
+	}
+
+	if plugins := Discover(filepath.Join(dir, "missing")); len(plugins) != 0 {
+		t.Fatal("a missing plugin dir should have no plugins")
+	}
+}
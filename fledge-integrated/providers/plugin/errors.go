@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"fledge/fledge-integrated/providers"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// toStatus converts a provider error into a gRPC status, so its kind survives the trip to fledge
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	code := codes.Unknown
+	switch {
+	case strongerrors.IsNotFound(err):
+		code = codes.NotFound
+	case strongerrors.IsNotImplemented(err):
+		code = codes.Unimplemented
+	case strongerrors.IsInvalidArgument(err):
+		code = codes.InvalidArgument
+	case strongerrors.IsAlreadyExists(err):
+		code = codes.AlreadyExists
+	case providers.IsRuntimeUnavailable(err):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+// fromStatus converts a gRPC status returned by a plugin back into the errors the providers use
+func (p *PluginProvider) fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	msgErr := errors.New(st.Message())
+	switch st.Code() {
+	case codes.NotFound:
+		return strongerrors.NotFound(msgErr)
+	case codes.Unimplemented:
+		return strongerrors.NotImplemented(msgErr)
+	case codes.InvalidArgument:
+		return strongerrors.InvalidArgument(msgErr)
+	case codes.AlreadyExists:
+		return strongerrors.AlreadyExists(msgErr)
+	case codes.Unavailable:
+		return &providers.RuntimeUnavailableError{Runtime: p.name, Reason: st.Message()}
+	case codes.DeadlineExceeded:
+		return strongerrors.Deadline(msgErr)
+	}
+	return errors.Wrapf(msgErr, "plugin %s", p.name)
+}
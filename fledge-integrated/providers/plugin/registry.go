@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const DefaultPluginDir = "/run/fledge/plugins"
+
+const healthCheckInterval = 10 * time.Second
+
+// Discover connects to every plugin socket in dir.
+// Plugins that don't answer, fail their health check or handshake are skipped, the rest is health checked in the background.
+func Discover(dir string) []*PluginProvider {
+	if dir == "" {
+		dir = DefaultPluginDir
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Failed to read plugin dir %s: %s\n", dir, err.Error())
+		}
+		return nil
+	}
+
+	plugins := []*PluginProvider{}
+	names := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+		socket := filepath.Join(dir, entry.Name())
+		plugin, err := Connect(socket)
+		if err != nil {
+			fmt.Printf("Skipping plugin %s: %s\n", socket, err.Error())
+			continue
+		}
+		if other, found := names[plugin.Name()]; found {
+			fmt.Printf("Skipping plugin %s: name %s is already used by %s\n", socket, plugin.Name(), other)
+			plugin.Close()
+			continue
+		}
+		names[plugin.Name()] = socket
+		go plugin.monitorHealth()
+		plugins = append(plugins, plugin)
+	}
+	return plugins
+}
+
+// monitorHealth keeps the health state of the plugin up to date, pods aren't sent to unhealthy plugins
+func (p *PluginProvider) monitorHealth() {
+	for {
+		time.Sleep(healthCheckInterval)
+		wasHealthy := p.IsHealthy()
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		err := p.CheckHealth(ctx)
+		cancel()
+		if err != nil && wasHealthy {
+			fmt.Printf("Plugin %s became unhealthy: %s\n", p.name, err.Error())
+		} else if err == nil && !wasHealthy {
+			fmt.Printf("Plugin %s is healthy again\n", p.name)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/plugin/pluginapi"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+// Server exposes a providers.PodProvider as a plugin, so runtimes written in Go can be shipped as a separate binary.
+type Server struct {
+	pluginapi.UnimplementedPodProviderServer
+
+	name         string
+	provider     providers.PodProvider
+	capabilities []string
+	health       *health.Server
+}
+
+func NewServer(name string, provider providers.PodProvider, capabilities ...string) *Server {
+	return &Server{
+		name:         name,
+		provider:     provider,
+		capabilities: capabilities,
+		health:       health.NewServer(),
+	}
+}
+
+// Serve listens on a unix socket, which should be placed in fledge's plugin directory, and blocks until the listener fails
+func (s *Server) Serve(socket string) error {
+	//a socket left behind by a previous run would make listen fail
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", socket)
+	}
+
+	grpcServer := grpc.NewServer()
+	pluginapi.RegisterPodProviderServer(grpcServer, s)
+	healthpb.RegisterHealthServer(grpcServer, s.health)
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	return grpcServer.Serve(listener)
+}
+
+// SetServing changes what the health service reports, fledge stops sending pods to a plugin that isn't serving
+func (s *Server) SetServing(serving bool) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if !serving {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	s.health.SetServingStatus("", status)
+}
+
+func (s *Server) Handshake(ctx context.Context, req *pluginapi.HandshakeRequest) (*pluginapi.HandshakeResponse, error) {
+	if req.ApiVersion != APIVersion {
+		return nil, toStatus(strongerrors.InvalidArgument(errors.Errorf("unsupported API version %s", req.ApiVersion)))
+	}
+	return &pluginapi.HandshakeResponse{
+		Name:         s.name,
+		ApiVersion:   APIVersion,
+		Capabilities: s.capabilities,
+	}, nil
+}
+
+func decodePod(req *pluginapi.PodRequest) (*v1.Pod, error) {
+	pod := &v1.Pod{}
+	if err := json.Unmarshal(req.Pod, pod); err != nil {
+		return nil, toStatus(strongerrors.InvalidArgument(errors.Wrap(err, "invalid pod")))
+	}
+	return pod, nil
+}
+
+func (s *Server) CreatePod(ctx context.Context, req *pluginapi.PodRequest) (*pluginapi.Empty, error) {
+	pod, err := decodePod(req)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginapi.Empty{}, toStatus(s.provider.CreatePod(ctx, pod))
+}
+
+func (s *Server) UpdatePod(ctx context.Context, req *pluginapi.PodRequest) (*pluginapi.Empty, error) {
+	pod, err := decodePod(req)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginapi.Empty{}, toStatus(s.provider.UpdatePod(ctx, pod))
+}
+
+func (s *Server) DeletePod(ctx context.Context, req *pluginapi.PodRequest) (*pluginapi.Empty, error) {
+	pod, err := decodePod(req)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginapi.Empty{}, toStatus(s.provider.DeletePod(ctx, pod))
+}
+
+func (s *Server) GetPod(ctx context.Context, req *pluginapi.GetPodRequest) (*pluginapi.PodResponse, error) {
+	pod, err := s.provider.GetPod(ctx, req.Namespace, req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if pod == nil {
+		return &pluginapi.PodResponse{}, nil
+	}
+	podJson, err := json.Marshal(pod)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pluginapi.PodResponse{Found: true, Pod: podJson}, nil
+}
+
+func (s *Server) GetPodStatus(ctx context.Context, req *pluginapi.GetPodRequest) (*pluginapi.PodStatusResponse, error) {
+	status, err := s.provider.GetPodStatus(ctx, req.Namespace, req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if status == nil {
+		return &pluginapi.PodStatusResponse{}, nil
+	}
+	statusJson, err := json.Marshal(status)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pluginapi.PodStatusResponse{Found: true, Status: statusJson}, nil
+}
+
+func (s *Server) GetPods(ctx context.Context, req *pluginapi.GetPodsRequest) (*pluginapi.PodsResponse, error) {
+	pods, err := s.provider.GetPods(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pluginapi.PodsResponse{}
+	for _, pod := range pods {
+		podJson, err := json.Marshal(pod)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		resp.Pods = append(resp.Pods, podJson)
+	}
+	return resp, nil
+}
+
+// GetContainerLogs sends an empty chunk once the logs are found, followed by the logs as they are read
+func (s *Server) GetContainerLogs(req *pluginapi.ContainerLogsRequest, stream pluginapi.PodProvider_GetContainerLogsServer) error {
+	opts := providers.ContainerLogOpts{
+		Tail:       int(req.Tail),
+		LimitBytes: int(req.LimitBytes),
+		Timestamps: req.Timestamps,
+		Follow:     req.Follow,
+		Previous:   req.Previous,
+	}
+	if req.SinceTime != 0 {
+		opts.SinceTime = time.Unix(0, req.SinceTime)
+	}
+	logs, err := s.provider.GetContainerLogs(stream.Context(), req.Namespace, req.PodName, req.ContainerName, opts)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer logs.Close()
+
+	if err := stream.Send(&pluginapi.LogChunk{}); err != nil {
+		return err
+	}
+	buf := make([]byte, execChunkSize)
+	for {
+		n, err := logs.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&pluginapi.LogChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+// streamWriter sends everything written to it as exec output
+type streamWriter struct {
+	lock   *sync.Mutex
+	stream pluginapi.PodProvider_ExecInContainerServer
+	stderr bool
+}
+
+func (w *streamWriter) Write(data []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	msg := &pluginapi.ExecResponse{Msg: &pluginapi.ExecResponse_Stdout{Stdout: append([]byte{}, data...)}}
+	if w.stderr {
+		msg = &pluginapi.ExecResponse{Msg: &pluginapi.ExecResponse_Stderr{Stderr: append([]byte{}, data...)}}
+	}
+	if err := w.stream.Send(msg); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *streamWriter) Close() error {
+	return nil
+}
+
+func (s *Server) ExecInContainer(stream pluginapi.PodProvider_ExecInContainerServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := first.GetStart()
+	if start == nil {
+		return toStatus(strongerrors.InvalidArgument(errors.New("exec stream must start with an ExecStart message")))
+	}
+
+	var sendLock sync.Mutex
+	var stdin io.Reader
+	var stdinWriter *io.PipeWriter
+	if start.Stdin {
+		stdin, stdinWriter = io.Pipe()
+	}
+	var stdout, stderr io.WriteCloser
+	if start.Stdout {
+		stdout = &streamWriter{lock: &sendLock, stream: stream}
+	}
+	if start.Stderr {
+		stderr = &streamWriter{lock: &sendLock, stream: stream, stderr: true}
+	}
+	var resize chan remotecommand.TerminalSize
+	if start.Tty {
+		resize = make(chan remotecommand.TerminalSize, 1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if stdinWriter != nil {
+					stdinWriter.Close()
+				}
+				return
+			}
+			switch msg := req.Msg.(type) {
+			case *pluginapi.ExecRequest_Stdin:
+				if stdinWriter != nil {
+					stdinWriter.Write(msg.Stdin)
+				}
+			case *pluginapi.ExecRequest_StdinClosed:
+				if stdinWriter != nil {
+					stdinWriter.Close()
+				}
+			case *pluginapi.ExecRequest_Resize:
+				if resize != nil {
+					select {
+					case resize <- remotecommand.TerminalSize{Width: uint16(msg.Resize.Width), Height: uint16(msg.Resize.Height)}:
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	execErr := s.provider.ExecInContainer(start.Name, types.UID(start.Uid), start.Container, start.Cmd, stdin, stdout, stderr, start.Tty, resize, time.Duration(start.TimeoutSeconds)*time.Second)
+	close(done)
+
+	exit := &pluginapi.ExecExit{}
+	if execErr != nil {
+		if exitErr, ok := execErr.(utilexec.ExitError); ok {
+			exit.ExitCode = int32(exitErr.ExitStatus())
+		} else if strongerrors.IsNotFound(execErr) || strongerrors.IsNotImplemented(execErr) {
+			return toStatus(execErr)
+		} else {
+			exit.Error = execErr.Error()
+		}
+	}
+
+	sendLock.Lock()
+	defer sendLock.Unlock()
+	if err := stream.Send(&pluginapi.ExecResponse{Msg: &pluginapi.ExecResponse_Exit{Exit: exit}}); err != nil {
+		fmt.Printf("Failed to send exec exit status: %s\n", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *Server) PodsChanged(ctx context.Context, req *pluginapi.Empty) (*pluginapi.PodsChangedResponse, error) {
+	return &pluginapi.PodsChangedResponse{Changed: s.provider.PodsChanged()}, nil
+}
+
+func (s *Server) ResetChanges(ctx context.Context, req *pluginapi.Empty) (*pluginapi.Empty, error) {
+	s.provider.ResetChanges()
+	return &pluginapi.Empty{}, nil
+}
@@ -0,0 +1,207 @@
+package podstore
+
+import (
+	"context"
+	"sync"
+
+	"fledge/fledge-integrated/vkube"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PodState is what a pod provider keeps about one of its pods, like the processes running its containers
+type PodState interface {
+	// Pod returns the provider's copy of the pod, see NewPod
+	Pod() *v1.Pod
+	// ContainerStatus returns the current status of a container of the pod, false if it has none yet.
+	// It is called with the store locked.
+	ContainerStatus(name string) (v1.ContainerStatus, bool)
+}
+
+// Store keeps the pods of a pod provider by their pod key.
+// Providers embed it for the PodProvider methods that only read their pods: GetPod, GetPodStatus, GetPods,
+// PodsChanged and ResetChanges. Its lock also guards the state the provider keeps in its PodStates.
+type Store struct {
+	lock    sync.Mutex
+	pods    map[string]PodState
+	changed bool
+}
+
+func NewStore() *Store {
+	return &Store{pods: make(map[string]PodState)}
+}
+
+func PodKey(namespace string, name string) string {
+	return namespace + "_" + name
+}
+
+// NewPod returns the provider's own copy of a pod it is about to create, with the status of a starting pod.
+// The informer's pod must not be changed, the status of the copy is only written under the lock once it is stored.
+func NewPod(pod *v1.Pod) *v1.Pod {
+	pod = pod.DeepCopy()
+	vkube.UpdatePostCreationPodStatus(pod, false)
+	return pod
+}
+
+func (s *Store) Lock() {
+	s.lock.Lock()
+}
+
+func (s *Store) Unlock() {
+	s.lock.Unlock()
+}
+
+// MarkChanged tells the sync loop that a pod of the provider changed, the store must be locked
+func (s *Store) MarkChanged() {
+	s.changed = true
+}
+
+func (s *Store) PodsChanged() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.changed
+}
+
+func (s *Store) ResetChanges() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.changed = false
+}
+
+// Add stores a new pod, it fails if a pod with the same name is already there
+func (s *Store) Add(state PodState) error {
+	pod := state.Pod()
+	key := PodKey(pod.Namespace, pod.Name)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, found := s.pods[key]; found {
+		return errors.Errorf("pod %s already exists", key)
+	}
+	s.pods[key] = state
+	s.changed = true
+	return nil
+}
+
+// Remove takes a pod out of the store and returns its state, so the provider can stop what is running for it
+func (s *Store) Remove(namespace string, name string) (PodState, error) {
+	key := PodKey(namespace, name)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state, found := s.pods[key]
+	if !found {
+		return nil, strongerrors.NotFound(errors.Errorf("pod %s not found", key))
+	}
+	delete(s.pods, key)
+	s.changed = true
+	return state, nil
+}
+
+// Forget takes the pod of state out of the store, unless another pod with the same name replaced it
+func (s *Store) Forget(state PodState) {
+	pod := state.Pod()
+	key := PodKey(pod.Namespace, pod.Name)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pods[key] == state {
+		delete(s.pods, key)
+		s.changed = true
+	}
+}
+
+// Get returns the state of a pod, nil if the pod isn't stored
+func (s *Store) Get(namespace string, name string) PodState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pods[PodKey(namespace, name)]
+}
+
+// Find returns the state of the pod an exec or attach is meant for, by the uid of the pod if it is set and otherwise by its pod key
+func (s *Store) Find(key string, uid types.UID) PodState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if uid != "" {
+		for _, state := range s.pods {
+			if state.Pod().UID == uid {
+				return state
+			}
+		}
+	}
+	return s.pods[key]
+}
+
+// States returns the states of all stored pods
+func (s *Store) States() []PodState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	states := make([]PodState, 0, len(s.pods))
+	for _, state := range s.pods {
+		states = append(states, state)
+	}
+	return states
+}
+
+// UpdateStatus derives the status of a pod from its containers, pods that were removed in the meantime are skipped
+func (s *Store) UpdateStatus(state PodState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pod := state.Pod()
+	if s.pods[PodKey(pod.Namespace, pod.Name)] != state {
+		return
+	}
+	s.updateStatus(state)
+}
+
+// updateStatus derives the status of a pod from its containers, the lock must be held
+func (s *Store) updateStatus(state PodState) {
+	pod := state.Pod()
+	containerStatuses := []v1.ContainerStatus{}
+	for _, cont := range pod.Spec.Containers {
+		if status, found := state.ContainerStatus(cont.Name); found {
+			containerStatuses = append(containerStatuses, status)
+		}
+	}
+	if vkube.UpdatePodStatusFromContainers(pod, containerStatuses) {
+		s.changed = true
+	}
+}
+
+// GetPod returns a copy of the pod with an up to date status
+func (s *Store) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, found := s.pods[PodKey(namespace, name)]
+	if !found {
+		return nil, nil
+	}
+	s.updateStatus(state)
+	return state.Pod().DeepCopy(), nil
+}
+
+// GetPodStatus retrieves the status of a given pod by name.
+func (s *Store) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
+	pod, err := s.GetPod(ctx, namespace, name)
+	if pod == nil || err != nil {
+		return nil, err
+	}
+	return &pod.Status, nil
+}
+
+// GetPods retrieves a list of all pods scheduled to run.
+func (s *Store) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pods := []*v1.Pod{}
+	for _, state := range s.pods {
+		s.updateStatus(state)
+		pods = append(pods, state.Pod().DeepCopy())
+	}
+	return pods, nil
+}
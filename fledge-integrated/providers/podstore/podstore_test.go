@@ -0,0 +1,167 @@
+package podstore
+
+import (
+	"context"
+	"testing"
+
+	"fledge/fledge-integrated/config"
+
+	"github.com/cpuguy83/strongerrors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type testState struct {
+	pod      *v1.Pod
+	statuses map[string]v1.ContainerStatus
+}
+
+func (s *testState) Pod() *v1.Pod {
+	return s.pod
+}
+
+func (s *testState) ContainerStatus(name string) (v1.ContainerStatus, bool) {
+	status, found := s.statuses[name]
+	return status, found
+}
+
+func newTestState(name string) (*v1.Pod, *testState) {
+	config.Cfg = &config.Config{DeviceIP: "10.0.0.1"}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app", Image: "app"}}},
+	}
+	return pod, &testState{pod: NewPod(pod), statuses: map[string]v1.ContainerStatus{}}
+}
+
+func TestNewPodCopies(t *testing.T) {
+	pod, state := newTestState("copy")
+	if state.pod == pod {
+		t.Fatal("the provider should get its own copy of the pod")
+	}
+	if pod.Status.Phase != "" || pod.Status.StartTime != nil {
+		t.Fatal("the status of the original pod should not be touched")
+	}
+	if state.pod.Status.Phase != v1.PodPending {
+		t.Fatalf("expected phase Pending, got %s", state.pod.Status.Phase)
+	}
+}
+
+func TestStoreAddRemove(t *testing.T) {
+	s := NewStore()
+	_, state := newTestState("add")
+	if err := s.Add(state); err != nil {
+		t.Fatal(err)
+	}
+	if !s.PodsChanged() {
+		t.Fatal("adding a pod should mark the pods as changed")
+	}
+	s.ResetChanges()
+	if err := s.Add(state); err == nil {
+		t.Fatal("adding the same pod twice should fail")
+	}
+	if s.Get("default", "add") != state {
+		t.Fatal("expected the stored state")
+	}
+
+	removed, err := s.Remove("default", "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != state || !s.PodsChanged() {
+		t.Fatal("removing should return the state and mark the pods as changed")
+	}
+	if _, err := s.Remove("default", "add"); !strongerrors.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if pod, err := s.GetPod(context.Background(), "default", "add"); pod != nil || err != nil {
+		t.Fatal("removed pod should not be returned")
+	}
+}
+
+func TestStoreForget(t *testing.T) {
+	s := NewStore()
+	_, state := newTestState("forget")
+	_, replacement := newTestState("forget")
+	if err := s.Add(replacement); err != nil {
+		t.Fatal(err)
+	}
+	s.ResetChanges()
+	s.Forget(state)
+	if s.Get("default", "forget") != replacement || s.PodsChanged() {
+		t.Fatal("forgetting a pod should keep the pod that replaced it")
+	}
+	s.Forget(replacement)
+	if s.Get("default", "forget") != nil || !s.PodsChanged() {
+		t.Fatal("forgotten pod should be removed")
+	}
+}
+
+func TestStoreUpdatesStatus(t *testing.T) {
+	s := NewStore()
+	_, state := newTestState("status")
+	if err := s.Add(state); err != nil {
+		t.Fatal(err)
+	}
+	s.ResetChanges()
+
+	s.Lock()
+	state.statuses["app"] = v1.ContainerStatus{
+		Name:  "app",
+		Ready: true,
+		State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: metav1.Now()}},
+	}
+	s.Unlock()
+
+	pods, err := s.GetPods(context.Background())
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("expected one pod, got %d and %v", len(pods), err)
+	}
+	if pods[0].Status.Phase != v1.PodRunning {
+		t.Fatalf("expected phase Running, got %s", pods[0].Status.Phase)
+	}
+	if pods[0] == state.pod {
+		t.Fatal("GetPods should return copies")
+	}
+	if !s.PodsChanged() {
+		t.Fatal("a changed status should mark the pods as changed")
+	}
+
+	//a pod that was removed while it was being started is left alone
+	s.Remove("default", "status")
+	s.ResetChanges()
+	s.UpdateStatus(state)
+	if s.PodsChanged() {
+		t.Fatal("updating a removed pod should not mark the pods as changed")
+	}
+}
+
+func TestStoreFind(t *testing.T) {
+	s := NewStore()
+	_, web := newTestState("web")
+	web.pod.UID = "uid-web"
+	_, db := newTestState("db")
+	db.pod.Namespace = "other"
+	s.Add(web)
+	s.Add(db)
+
+	tests := []struct {
+		key  string
+		uid  types.UID
+		want PodState
+	}{
+		{key: "default_web", want: web},
+		{key: "other_db", want: db},
+		{key: "unknown", uid: "uid-web", want: web},
+		//a uid that matches no pod falls back to the key
+		{key: "other_db", uid: "uid-gone", want: db},
+		//a bare pod name could be a pod of any namespace
+		{key: "db", want: nil},
+	}
+	for _, test := range tests {
+		if got := s.Find(test.key, test.uid); got != test.want {
+			t.Fatalf("find %s/%s returned %v, want %v", test.key, test.uid, got, test.want)
+		}
+	}
+}
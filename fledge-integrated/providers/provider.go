@@ -5,8 +5,11 @@ import (
 	"io"
 	"time"
 
+	"fledge/fledge-integrated/manager"
+
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/remotecommand"
 	stats "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
 )
@@ -17,6 +20,9 @@ type NodeProvider interface {
 	// Capacity returns a resource list with the capacity constraints of the provider.
 	Capacity(context.Context) v1.ResourceList
 
+	// Allocatable returns a resource list with the resources pods can be scheduled on.
+	Allocatable(context.Context) v1.ResourceList
+
 	// NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), which is
 	// polled periodically to update the node status within Kubernetes.
 	NodeConditions(context.Context) []v1.NodeCondition
@@ -51,7 +57,7 @@ type NodeProvider interface {
 	GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error)
 
 	// GetContainerLogs retrieves the logs of a container by name from the provider.
-	GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error)
+	GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts ContainerLogOpts) (io.ReadCloser, error)
 
 	// ExecInContainer executes a command in a container in the pod, copying data
 	// between in/out/err and the container's stdin/stdout/stderr.
@@ -82,7 +88,7 @@ type PodProvider interface {
 	GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error)
 
 	// GetContainerLogs retrieves the logs of a container by name from the provider.
-	GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error)
+	GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts ContainerLogOpts) (io.ReadCloser, error)
 
 	// ExecInContainer executes a command in a container in the pod, copying data
 	// between in/out/err and the container's stdin/stdout/stderr.
@@ -98,7 +104,54 @@ type PodProvider interface {
 	ResetChanges()
 }
 
+// ContainerLogOpts are the options of a container logs request, as passed by kubectl logs
+type ContainerLogOpts struct {
+	// Tail is the number of lines to return from the end of the logs, all lines if it isn't positive
+	Tail int
+	// LimitBytes stops the logs after this many bytes, unlimited if it isn't positive
+	LimitBytes int
+	Timestamps bool
+	// Follow keeps streaming new output until the container stops or the request is cancelled
+	Follow bool
+	// Previous returns the logs of the previous run of a restarted container
+	Previous bool
+	// SinceTime skips the output written before it, if set
+	SinceTime time.Time
+}
+
 // PodMetricsProvider is an optional interface that providers can implement to expose pod stats
 type PodMetricsProvider interface {
 	GetStatsSummary(context.Context) (*stats.Summary, error)
 }
+
+// ContainerAttacher is an optional interface for providers that can attach to the stdio of running containers
+type ContainerAttacher interface {
+	// AttachToContainer copies the output of the container to out and err and in to its stdin,
+	// until the container stops or the input ends
+	AttachToContainer(name string, uid types.UID, container string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error
+}
+
+// PortForwarder is an optional interface for providers that can connect to ports inside their pods
+type PortForwarder interface {
+	// PortForward copies data between stream and a port of the pod, until both sides are done
+	PortForward(ctx context.Context, namespace, pod string, port int32, stream io.ReadWriteCloser) error
+}
+
+// PodNotifier is an optional interface for providers that can tell when their pods changed,
+// so pod statuses don't have to wait for the next PodsChanged poll
+type PodNotifier interface {
+	// NotifyPods registers a callback that is called after PodsChanged became true, the callback must not block
+	NotifyPods(ctx context.Context, notify func())
+}
+
+// PodEventRecorder is an optional interface for providers that report events about their pods, like failed lifecycle hooks
+type PodEventRecorder interface {
+	// RecordPodEvents registers the recorder that events about pods are sent to
+	RecordPodEvents(recorder record.EventRecorder)
+}
+
+// PodResourceUser is an optional interface for providers that read resources of their pods from the api server, like image pull secrets
+type PodResourceUser interface {
+	// UseResourceManager registers the resource manager that secrets and other resources of pods are read from
+	UseResourceManager(rm *manager.ResourceManager)
+}
@@ -0,0 +1,28 @@
+package register
+
+import (
+	"fmt"
+
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/plugin"
+)
+
+// RegisterPlugins discovers out-of-process pod providers in dir and registers them under their advertised names.
+// Built-in providers win when a plugin uses the same name.
+func RegisterPlugins(dir string) []string {
+	names := []string{}
+	for _, prov := range plugin.Discover(dir) {
+		name := prov.Name()
+		if IsRegistered(name) {
+			fmt.Printf("Plugin %s conflicts with a built-in pod provider, ignoring it\n", name)
+			prov.Close()
+			continue
+		}
+		p := prov
+		register(name, func(PodInitConfig) (providers.PodProvider, error) {
+			return p, nil
+		})
+		names = append(names, name)
+	}
+	return names
+}
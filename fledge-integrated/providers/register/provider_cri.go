@@ -0,0 +1,18 @@
+//go:build !no_cri_provider
+// +build !no_cri_provider
+
+package register
+
+import (
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	cri "fledge/fledge-integrated/providers/cri"
+)
+
+func init() {
+	register("cri", initCRI)
+}
+
+func initCRI(cfg PodInitConfig) (providers.PodProvider, error) {
+	return cri.NewCRIProvider(config.Cfg.CRI)
+}
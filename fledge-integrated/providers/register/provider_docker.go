@@ -0,0 +1,18 @@
+//go:build !no_docker_provider
+// +build !no_docker_provider
+
+package register
+
+import (
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	docker "fledge/fledge-integrated/providers/docker"
+)
+
+func init() {
+	register("docker", initDocker)
+}
+
+func initDocker(cfg PodInitConfig) (providers.PodProvider, error) {
+	return docker.NewDockerProvider(config.Cfg.Docker)
+}
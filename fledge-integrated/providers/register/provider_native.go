@@ -0,0 +1,18 @@
+//go:build linux && !no_native_provider
+// +build linux,!no_native_provider
+
+package register
+
+import (
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	native "fledge/fledge-integrated/providers/native"
+)
+
+func init() {
+	register("native", initNative)
+}
+
+func initNative(cfg PodInitConfig) (providers.PodProvider, error) {
+	return native.NewNativeProvider(config.Cfg.Native)
+}
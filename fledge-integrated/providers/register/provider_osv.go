@@ -4,6 +4,7 @@
 package register
 
 import (
+	"fledge/fledge-integrated/config"
 	"fledge/fledge-integrated/providers"
 	osv "fledge/fledge-integrated/providers/osv"
 )
@@ -13,5 +14,5 @@ func init() {
 }
 
 func initOSV(cfg PodInitConfig) (providers.PodProvider, error) {
-	return osv.NewOSvProvider()
+	return osv.NewOSvProvider(config.Cfg.OSv)
 }
@@ -0,0 +1,18 @@
+//go:build !no_wasm_provider
+// +build !no_wasm_provider
+
+package register
+
+import (
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	wasm "fledge/fledge-integrated/providers/wasm"
+)
+
+func init() {
+	register("wasm", initWasm)
+}
+
+func initWasm(cfg PodInitConfig) (providers.PodProvider, error) {
+	return wasm.NewWasmProvider(config.Cfg.Wasm)
+}
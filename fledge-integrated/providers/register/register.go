@@ -1,6 +1,8 @@
 package register
 
 import (
+	"sort"
+
 	"fledge/fledge-integrated/manager"
 	"fledge/fledge-integrated/providers"
 
@@ -37,3 +39,19 @@ func GetPodProvider(name string) (providers.PodProvider, error) {
 func register(name string, f initFunc) {
 	providerInits[name] = f
 }
+
+// GetPodProviderNames returns the names of all registered pod providers, sorted
+func GetPodProviderNames() []string {
+	names := make([]string, 0, len(providerInits))
+	for name := range providerInits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsRegistered returns true if a pod provider with the given name was compiled in
+func IsRegistered(name string) bool {
+	_, ok := providerInits[name]
+	return ok
+}
@@ -0,0 +1,89 @@
+package selector
+
+import (
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/register"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// RuntimeAnnotation can be set on a pod to request a pod provider by name, it takes precedence over the RuntimeClass.
+const RuntimeAnnotation = "fledge/runtime"
+
+// RuntimeSelector decides which pod provider should run a pod.
+// In order of precedence it looks at the fledge runtime annotation, the pod's RuntimeClass and the node default.
+// The node doesn't watch RuntimeClass objects, so a pod's RuntimeClass is mapped by its name and the handler of the
+// RuntimeClass is not looked at, give the RuntimeClass the name of the provider or map it in runtimeClasses.
+type RuntimeSelector struct {
+	defaultRuntime string
+	runtimeClasses map[string]string
+	available      map[string]bool
+}
+
+// NewRuntimeSelector creates a selector for the given available pod providers.
+// runtimeClasses maps RuntimeClass names to pod provider names, RuntimeClasses named after a provider don't need an entry.
+func NewRuntimeSelector(defaultRuntime string, runtimeClasses map[string]string, available []string) *RuntimeSelector {
+	s := RuntimeSelector{
+		defaultRuntime: defaultRuntime,
+		runtimeClasses: make(map[string]string),
+		available:      make(map[string]bool),
+	}
+	for class, runtime := range runtimeClasses {
+		s.runtimeClasses[class] = runtime
+	}
+	for _, name := range available {
+		s.available[name] = true
+	}
+	return &s
+}
+
+// SelectRuntime returns the name of the pod provider that should run the pod.
+func (s *RuntimeSelector) SelectRuntime(pod *v1.Pod) (string, error) {
+	if runtime, ok := pod.Annotations[RuntimeAnnotation]; ok && runtime != "" {
+		return s.checkRuntime(runtime, fmt.Sprintf("requested by annotation %s", RuntimeAnnotation))
+	}
+
+	if pod.Spec.RuntimeClassName != nil && *pod.Spec.RuntimeClassName != "" {
+		class := *pod.Spec.RuntimeClassName
+		runtime, ok := s.runtimeClasses[class]
+		if !ok {
+			if !register.IsRegistered(class) {
+				return "", &providers.RuntimeUnavailableError{Runtime: class, Reason: "RuntimeClass is not mapped to a pod provider"}
+			}
+			runtime = class
+		}
+		return s.checkRuntime(runtime, fmt.Sprintf("requested by RuntimeClass %s", class))
+	}
+
+	if s.defaultRuntime != "" {
+		return s.checkRuntime(s.defaultRuntime, "node default")
+	}
+
+	names := s.AvailableRuntimes()
+	if len(names) == 0 {
+		return "", &providers.RuntimeUnavailableError{Runtime: s.defaultRuntime, Reason: "no pod providers available"}
+	}
+	return names[0], nil
+}
+
+// AvailableRuntimes returns the sorted names of the available pod providers.
+func (s *RuntimeSelector) AvailableRuntimes() []string {
+	names := make([]string, 0, len(s.available))
+	for name := range s.available {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *RuntimeSelector) checkRuntime(runtime string, requestedBy string) (string, error) {
+	if s.available[runtime] {
+		return runtime, nil
+	}
+	if !register.IsRegistered(runtime) {
+		return "", &providers.RuntimeUnavailableError{Runtime: runtime, Reason: fmt.Sprintf("unknown pod provider (%s)", requestedBy)}
+	}
+	return "", &providers.RuntimeUnavailableError{Runtime: runtime, Reason: fmt.Sprintf("pod provider not loaded (%s)", requestedBy)}
+}
@@ -0,0 +1,67 @@
+package selector
+
+import (
+	"testing"
+
+	"fledge/fledge-integrated/providers"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPod(annotation string, runtimeClass string) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	if annotation != "" {
+		pod.Annotations = map[string]string{RuntimeAnnotation: annotation}
+	}
+	if runtimeClass != "" {
+		pod.Spec.RuntimeClassName = &runtimeClass
+	}
+	return pod
+}
+
+func TestSelectRuntime(t *testing.T) {
+	classes := map[string]string{"runc": "containerd", "tiny": "wasm", "gone": "osv"}
+	tests := []struct {
+		name           string
+		defaultRuntime string
+		available      []string
+		pod            *v1.Pod
+		want           string
+		unavailable    bool
+	}{
+		{name: "annotation", defaultRuntime: "containerd", available: []string{"containerd", "wasm"}, pod: testPod("wasm", ""), want: "wasm"},
+		{name: "annotation before runtime class", defaultRuntime: "containerd", available: []string{"containerd", "wasm", "native"}, pod: testPod("native", "tiny"), want: "native"},
+		{name: "annotation not loaded", defaultRuntime: "containerd", available: []string{"containerd"}, pod: testPod("wasm", ""), unavailable: true},
+		{name: "annotation unknown", defaultRuntime: "containerd", available: []string{"containerd"}, pod: testPod("gvisor", ""), unavailable: true},
+		{name: "mapped runtime class", defaultRuntime: "containerd", available: []string{"containerd", "wasm"}, pod: testPod("", "tiny"), want: "wasm"},
+		{name: "runtime class named after provider", defaultRuntime: "containerd", available: []string{"containerd", "native"}, pod: testPod("", "native"), want: "native"},
+		{name: "mapped runtime class not loaded", defaultRuntime: "containerd", available: []string{"containerd"}, pod: testPod("", "gone"), unavailable: true},
+		{name: "unmapped runtime class", defaultRuntime: "containerd", available: []string{"containerd"}, pod: testPod("", "kata"), unavailable: true},
+		{name: "default", defaultRuntime: "containerd", available: []string{"containerd", "wasm"}, pod: testPod("", ""), want: "containerd"},
+		{name: "default not loaded", defaultRuntime: "containerd", available: []string{"osv", "wasm"}, pod: testPod("", ""), unavailable: true},
+		{name: "no default", available: []string{"wasm", "osv"}, pod: testPod("", ""), want: "osv"},
+		{name: "no default on a wasm-only device", available: []string{"wasm"}, pod: testPod("", ""), want: "wasm"},
+		{name: "no default on a native-only device", available: []string{"native"}, pod: testPod("", ""), want: "native"},
+		{name: "nothing loaded", available: []string{}, pod: testPod("", ""), unavailable: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewRuntimeSelector(test.defaultRuntime, classes, test.available)
+			runtime, err := s.SelectRuntime(test.pod)
+			if test.unavailable {
+				if !providers.IsRuntimeUnavailable(err) {
+					t.Fatalf("expected a RuntimeUnavailableError, got %q and %v", runtime, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runtime != test.want {
+				t.Fatalf("expected runtime %s, got %s", test.want, runtime)
+			}
+		})
+	}
+}
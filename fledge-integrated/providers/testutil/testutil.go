@@ -0,0 +1,79 @@
+// Package testutil has the helpers the tests of the pod providers share
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/providers"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// how long a pod gets to reach the state a test waits for
+const waitTimeout = 10 * time.Second
+
+// PodGetter is the part of a pod provider the pods are polled through
+type PodGetter interface {
+	GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error)
+}
+
+// LogGetter is the part of a pod provider the logs are read through
+type LogGetter interface {
+	GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error)
+}
+
+// WaitForPod polls the pod until done accepts it and returns it, the test fails if that takes too long
+func WaitForPod(t *testing.T, p PodGetter, namespace, name string, done func(pod *v1.Pod) bool) *v1.Pod {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		pod, err := p.GetPod(context.Background(), namespace, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pod != nil && done(pod) {
+			return pod
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("pod %s/%s didn't reach the expected state in time", namespace, name)
+	return nil
+}
+
+// WaitForContainerStatus waits until done accepts the status of the pod's only container and returns it
+func WaitForContainerStatus(t *testing.T, p PodGetter, namespace, name string, done func(status v1.ContainerStatus) bool) v1.ContainerStatus {
+	t.Helper()
+	pod := WaitForPod(t, p, namespace, name, func(pod *v1.Pod) bool {
+		return len(pod.Status.ContainerStatuses) == 1 && done(pod.Status.ContainerStatuses[0])
+	})
+	return pod.Status.ContainerStatuses[0]
+}
+
+// ReadLogs returns the logs of the container, the test fails if they can't be read
+func ReadLogs(t *testing.T, p LogGetter, namespace, name, container string, opts providers.ContainerLogOpts) string {
+	t.Helper()
+	logs, err := p.GetContainerLogs(context.Background(), namespace, name, container, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	content, err := ioutil.ReadAll(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// BufferCloser collects the output of exec and attach
+type BufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *BufferCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,332 @@
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const wasmPageSize = 64 * 1024
+
+// wasm32 can't address more than 4GiB, which is 65536 pages
+const maxMemoryPages = 65536
+
+// the delay before an exited module is run again, replaced by tests
+var restartBackoff = vkube.RestartBackoff
+
+// wasmInstance is a single WASI module running in-process for one container of a pod,
+// it is run again according to the pod's restart policy.
+type wasmInstance struct {
+	id            string
+	container     string
+	image         string
+	logPath       string
+	restartPolicy v1.RestartPolicy
+	cancel        context.CancelFunc
+
+	startedAt       time.Time
+	finishedAt      time.Time
+	exitCode        int32
+	running         bool
+	startErr        error
+	restartCount    int32
+	lastTermination *v1.ContainerStateTerminated
+	backoff         time.Duration
+	waitingUntil    time.Time
+	stopping        bool
+	done            chan struct{}
+}
+
+// MemoryLimitPages converts the container's memory limit into wasm pages, using the same default as containerd containers.
+func MemoryLimitPages(dc *v1.Container) uint32 {
+	mem := dc.Resources.Limits.Memory()
+	if mem.IsZero() {
+		mem = dc.Resources.Requests.Memory()
+	}
+	if mem.IsZero() {
+		defaultMem := resource.MustParse("150Mi")
+		mem = &defaultMem
+	}
+	pages := mem.Value() / wasmPageSize
+	if pages > maxMemoryPages {
+		pages = maxMemoryPages
+	}
+	if pages < 1 {
+		pages = 1
+	}
+	return uint32(pages)
+}
+
+// ModuleArgs returns argv for the module, the command replaces the module name as argv[0] when it is set
+func ModuleArgs(dc *v1.Container) []string {
+	args := []string{}
+	if len(dc.Command) > 0 {
+		args = append(args, dc.Command...)
+	} else {
+		args = append(args, dc.Name)
+	}
+	return append(args, dc.Args...)
+}
+
+// buildFSConfig preopens the container's volume mounts as directories
+func buildFSConfig(pod *v1.Pod, dc *v1.Container) wazero.FSConfig {
+	fsConfig := wazero.NewFSConfig()
+	for _, volMount := range dc.VolumeMounts {
+		var volume *v1.Volume
+		for i := range pod.Spec.Volumes {
+			if pod.Spec.Volumes[i].Name == volMount.Name {
+				volume = &pod.Spec.Volumes[i]
+			}
+		}
+		if volume == nil {
+			fmt.Printf("No volume found for mount %s\n", volMount.Name)
+			continue
+		}
+		hostPath := vkube.GetHostMountPath(pod, *volume)
+		if hostPath == nil {
+			continue
+		}
+		source := filepath.Join(*hostPath, volMount.SubPath)
+		if volMount.ReadOnly {
+			fsConfig = fsConfig.WithReadOnlyDirMount(source, volMount.MountPath)
+		} else {
+			fsConfig = fsConfig.WithDirMount(source, volMount.MountPath)
+		}
+	}
+	return fsConfig
+}
+
+func (p *WasmProvider) getLogPath(namespace string, podName string, containerName string) string {
+	return filepath.Join(p.logDir, fmt.Sprintf("%s_%s_%s.log", namespace, podName, containerName))
+}
+
+// startInstance loads and compiles the module, then runs it in the background.
+// Output of the module goes to the container's log file.
+func (p *WasmProvider) startInstance(ctx context.Context, pod *v1.Pod, dc *v1.Container) *wasmInstance {
+	inst := &wasmInstance{
+		id:            fmt.Sprintf("%s_%s_%s", pod.Namespace, pod.Name, dc.Name),
+		container:     dc.Name,
+		image:         dc.Image,
+		logPath:       p.getLogPath(pod.Namespace, pod.Name, dc.Name),
+		restartPolicy: pod.Spec.RestartPolicy,
+		done:          make(chan struct{}),
+	}
+
+	failed := func(err error) *wasmInstance {
+		fmt.Printf("Failed to start wasm container %s: %s\n", dc.Name, err.Error())
+		inst.startErr = err
+		inst.exitCode = 128
+		inst.finishedAt = time.Now()
+		close(inst.done)
+		return inst
+	}
+
+	wasmBytes, err := p.LoadModule(ctx, dc)
+	if err != nil {
+		return failed(err)
+	}
+
+	if err := os.MkdirAll(p.logDir, 0755); err != nil {
+		return failed(err)
+	}
+	logFile, err := os.OpenFile(inst.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return failed(err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	inst.cancel = cancel
+
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(MemoryLimitPages(dc)).
+		WithCloseOnContextDone(true)
+	if p.compilationCache != nil {
+		runtimeConfig = runtimeConfig.WithCompilationCache(p.compilationCache)
+	}
+	runtime := wazero.NewRuntimeWithConfig(runCtx, runtimeConfig)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(runCtx, runtime); err != nil {
+		runtime.Close(runCtx)
+		logFile.Close()
+		cancel()
+		return failed(errors.Wrap(err, "failed to instantiate WASI"))
+	}
+	compiled, err := runtime.CompileModule(runCtx, wasmBytes)
+	if err != nil {
+		runtime.Close(runCtx)
+		logFile.Close()
+		cancel()
+		return failed(errors.Wrap(err, "failed to compile module"))
+	}
+
+	moduleConfig := wazero.NewModuleConfig().
+		WithName(dc.Name).
+		WithArgs(ModuleArgs(dc)...).
+		WithStdout(logFile).
+		WithStderr(logFile).
+		WithFSConfig(buildFSConfig(pod, dc)).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	for _, evar := range dc.Env {
+		if evar.ValueFrom == nil {
+			moduleConfig = moduleConfig.WithEnv(evar.Name, evar.Value)
+		}
+	}
+
+	inst.running = true
+	inst.startedAt = time.Now()
+
+	go func() {
+		defer logFile.Close()
+		defer runtime.Close(context.Background())
+		p.runInstance(runCtx, inst, runtime, compiled, moduleConfig)
+	}()
+	return inst
+}
+
+// runInstance runs the module until it exits and runs it again with an increasing delay as long as the restart policy allows it.
+// The compiled module and its config are reused by every run.
+func (p *WasmProvider) runInstance(runCtx context.Context, inst *wasmInstance, runtime wazero.Runtime, compiled wazero.CompiledModule, moduleConfig wazero.ModuleConfig) {
+	defer close(inst.done)
+
+	for {
+		mod, err := runtime.InstantiateModule(runCtx, compiled, moduleConfig)
+		if mod != nil {
+			mod.Close(context.Background())
+		}
+
+		p.Store.Lock()
+		p.finishInstance(inst, err)
+		if inst.stopping || !vkube.ShouldRestart(inst.restartPolicy, inst.exitCode) {
+			p.Store.Unlock()
+			return
+		}
+		inst.backoff = restartBackoff(inst.backoff, inst.finishedAt.Sub(inst.startedAt))
+		delay := inst.backoff
+		inst.waitingUntil = time.Now().Add(delay)
+		p.Store.Unlock()
+
+		fmt.Printf("Restarting wasm container %s in %s\n", inst.container, delay)
+		select {
+		case <-runCtx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		p.Store.Lock()
+		if inst.stopping {
+			p.Store.Unlock()
+			return
+		}
+		inst.restartCount++
+		inst.running = true
+		inst.startedAt = time.Now()
+		inst.waitingUntil = time.Time{}
+		p.Store.MarkChanged()
+		p.Store.Unlock()
+	}
+}
+
+// finishInstance records the exit of a module, a sys.ExitError carries the exit code passed to proc_exit. The lock must be held.
+func (p *WasmProvider) finishInstance(inst *wasmInstance, err error) {
+	inst.running = false
+	inst.finishedAt = time.Now()
+	inst.exitCode = 0
+	if err != nil {
+		var exitErr *sys.ExitError
+		if errors.As(err, &exitErr) {
+			if exitErr.ExitCode() == sys.ExitCodeContextCanceled {
+				//stopped by us, report it like a SIGTERM'd process
+				inst.exitCode = 143
+			} else {
+				inst.exitCode = int32(exitErr.ExitCode())
+			}
+		} else {
+			fmt.Printf("Wasm container %s trapped: %s\n", inst.container, err.Error())
+			inst.exitCode = 1
+		}
+	}
+	inst.lastTermination = inst.terminatedState()
+	p.Store.MarkChanged()
+	fmt.Printf("Wasm container %s exited with code %d\n", inst.container, inst.exitCode)
+}
+
+// stopInstance cancels pending restarts and stops the running module
+func (p *WasmProvider) stopInstance(inst *wasmInstance) {
+	if inst.cancel == nil {
+		return
+	}
+	p.Store.Lock()
+	inst.stopping = true
+	p.Store.Unlock()
+	inst.cancel()
+	<-inst.done
+}
+
+// terminatedState describes the last exit of the module, the lock must be held
+func (inst *wasmInstance) terminatedState() *v1.ContainerStateTerminated {
+	if inst.startErr != nil {
+		return &v1.ContainerStateTerminated{
+			ExitCode:   inst.exitCode,
+			Reason:     "StartError",
+			Message:    inst.startErr.Error(),
+			FinishedAt: metav1.NewTime(inst.finishedAt),
+		}
+	}
+	reason := "Completed"
+	if inst.exitCode != 0 {
+		reason = "Error"
+	}
+	return &v1.ContainerStateTerminated{
+		ExitCode:   inst.exitCode,
+		Reason:     reason,
+		StartedAt:  metav1.NewTime(inst.startedAt),
+		FinishedAt: metav1.NewTime(inst.finishedAt),
+	}
+}
+
+// status converts the state of the module into a container status, the lock must be held
+func (inst *wasmInstance) status() v1.ContainerStatus {
+	state := v1.ContainerState{}
+	lastState := v1.ContainerState{}
+
+	if inst.running {
+		state.Running = &v1.ContainerStateRunning{
+			StartedAt: metav1.NewTime(inst.startedAt),
+		}
+		lastState.Terminated = inst.lastTermination
+	} else if time.Now().Before(inst.waitingUntil) && !inst.stopping {
+		state.Waiting = &v1.ContainerStateWaiting{
+			Reason:  "CrashLoopBackOff",
+			Message: fmt.Sprintf("back-off %s restarting failed container %s", inst.waitingUntil.Sub(inst.finishedAt).Round(time.Second), inst.container),
+		}
+		lastState.Terminated = inst.lastTermination
+	} else {
+		state.Terminated = inst.terminatedState()
+	}
+
+	return v1.ContainerStatus{
+		Name:                 inst.container,
+		State:                state,
+		LastTerminationState: lastState,
+		Ready:                inst.running,
+		RestartCount:         inst.restartCount,
+		Image:                inst.image,
+		ImageID:              inst.image,
+		ContainerID:          fmt.Sprintf("wasm://%s", inst.id),
+	}
+}
@@ -0,0 +1,286 @@
+package wasm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	dockerremote "github.com/containerd/containerd/remotes/docker"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// media types used for wasm layers by the common OCI artifact tooling (wasm-to-oci, oras)
+var wasmLayerMediaTypes = map[string]bool{
+	"application/wasm":                                  true,
+	"application/vnd.wasm.content.layer.v1+wasm":        true,
+	"application/vnd.module.wasm.content.layer.v1+wasm": true,
+}
+
+// IsLocalModule returns true if the image refers to a .wasm file on the device instead of an OCI artifact
+func IsLocalModule(image string) bool {
+	if strings.HasPrefix(image, "file://") {
+		return true
+	}
+	return strings.HasSuffix(image, ".wasm") && (strings.HasPrefix(image, "/") || strings.HasPrefix(image, "./"))
+}
+
+func localModulePath(image string) string {
+	return strings.TrimPrefix(image, "file://")
+}
+
+// cachedModulePath is where a module pulled for image is stored
+func (p *WasmProvider) cachedModulePath(ref string) string {
+	name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(ref)
+	return filepath.Join(p.cacheDir, "modules", name+".wasm")
+}
+
+// LoadModule returns the wasm binary for a container, pulling it from a registry when needed.
+// The container's image pull policy is honoured for OCI artifacts.
+func (p *WasmProvider) LoadModule(ctx context.Context, dc *v1.Container) ([]byte, error) {
+	if IsLocalModule(dc.Image) {
+		return ioutil.ReadFile(localModulePath(dc.Image))
+	}
+
+	named, err := docker.ParseDockerRef(dc.Image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid image reference %s", dc.Image)
+	}
+	ref := named.String()
+	cached := p.cachedModulePath(ref)
+
+	if dc.ImagePullPolicy != v1.PullAlways {
+		if wasmBytes, err := ioutil.ReadFile(cached); err == nil {
+			return wasmBytes, nil
+		}
+		if dc.ImagePullPolicy == v1.PullNever {
+			return nil, errors.Errorf("module %s not present and pull policy is Never", ref)
+		}
+	}
+
+	fmt.Printf("Pulling wasm module %s\n", ref)
+	wasmBytes, err := pullModule(ctx, dockerremote.NewResolver(dockerremote.ResolverOptions{}), ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to pull module %s", ref)
+	}
+
+	if err := writeCacheFile(cached, wasmBytes); err != nil {
+		return nil, errors.Wrapf(err, "failed to cache module %s", ref)
+	}
+	return wasmBytes, nil
+}
+
+// writeCacheFile writes to a temporary file that is renamed to path once it is complete,
+// so a crash can't leave a truncated module behind that is loaded later on
+func writeCacheFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// pullModule fetches a wasm module stored as an OCI artifact or as the only .wasm file in a wasi/wasm image.
+// Every blob is checked against the digest it is referenced by.
+func pullModule(ctx context.Context, resolver remotes.Resolver, ref string) ([]byte, error) {
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if images.IsIndexType(desc.MediaType) {
+		var index ocispec.Index
+		if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+			return nil, err
+		}
+		if len(index.Manifests) == 0 {
+			return nil, errors.New("image index has no manifests")
+		}
+		desc = index.Manifests[0]
+		for _, m := range index.Manifests {
+			if m.Platform != nil && (m.Platform.OS == "wasi" || m.Platform.Architecture == "wasm") {
+				desc = m
+				break
+			}
+		}
+	}
+
+	var manifest ocispec.Manifest
+	if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest.Layers) == 0 {
+		return nil, errors.New("image has no layers")
+	}
+
+	for _, layer := range manifest.Layers {
+		if wasmLayerMediaTypes[layer.MediaType] {
+			return fetchBlob(ctx, fetcher, layer)
+		}
+	}
+
+	//regular image layers, look for the module inside the filesystem
+	for _, layer := range manifest.Layers {
+		if !images.IsLayerType(layer.MediaType) {
+			continue
+		}
+		wasmBytes, err := findModuleInLayer(ctx, fetcher, layer)
+		if err != nil {
+			return nil, err
+		}
+		if wasmBytes != nil {
+			return wasmBytes, nil
+		}
+	}
+	return nil, errors.New("no wasm module found in image")
+}
+
+// blobReader hashes the content of a blob while it is read, so it can be checked against the descriptor of the blob
+type blobReader struct {
+	rc       io.ReadCloser
+	r        io.Reader
+	desc     ocispec.Descriptor
+	verifier digest.Verifier
+	read     int64
+}
+
+func openBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) (*blobReader, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid digest %q", desc.Digest)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	blob := &blobReader{rc: rc, desc: desc, verifier: desc.Digest.Verifier()}
+	//reading one byte more than the descriptor says is enough to tell the blob is too large
+	blob.r = io.TeeReader(io.LimitReader(rc, desc.Size+1), blob.verifier)
+	return blob, nil
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// verify reads what is left of the blob and checks its size and digest
+func (b *blobReader) verify() error {
+	if _, err := io.Copy(ioutil.Discard, b); err != nil {
+		return err
+	}
+	if b.read != b.desc.Size {
+		return errors.Errorf("blob %s has size %d, expected %d", b.desc.Digest, b.read, b.desc.Size)
+	}
+	if !b.verifier.Verified() {
+		return errors.Errorf("blob content doesn't match digest %s", b.desc.Digest)
+	}
+	return nil
+}
+
+func (b *blobReader) Close() error {
+	return b.rc.Close()
+}
+
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	blob, err := openBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	content, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+	if err := blob.verify(); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func fetchJSON(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, v interface{}) error {
+	b, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// findModuleInLayer returns the first .wasm file in the layer, the whole layer is read to check its digest
+func findModuleInLayer(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	blob, err := openBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	wasmBytes, err := readModuleFromLayer(blob, desc.MediaType)
+	if err != nil {
+		return nil, err
+	}
+	if err := blob.verify(); err != nil {
+		return nil, err
+	}
+	return wasmBytes, nil
+}
+
+func readModuleFromLayer(blob io.Reader, mediaType string) ([]byte, error) {
+	r := blob
+	if strings.HasSuffix(mediaType, "gzip") {
+		gz, err := gzip.NewReader(blob)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && strings.HasSuffix(hdr.Name, ".wasm") {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
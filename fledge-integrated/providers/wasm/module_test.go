@@ -0,0 +1,146 @@
+package wasm
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// fakeRegistry resolves a single reference to a manifest and serves blobs by their digest
+type fakeRegistry struct {
+	manifest ocispec.Descriptor
+	blobs    map[digest.Digest][]byte
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{blobs: map[digest.Digest][]byte{}}
+}
+
+func (r *fakeRegistry) add(mediaType string, content []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(content), Size: int64(len(content))}
+	r.blobs[desc.Digest] = content
+	return desc
+}
+
+func (r *fakeRegistry) addManifest(layers ...ocispec.Descriptor) {
+	manifest, _ := json.Marshal(ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Layers: layers})
+	r.manifest = r.add(ocispec.MediaTypeImageManifest, manifest)
+}
+
+func (r *fakeRegistry) Resolve(ctx context.Context, ref string) (string, ocispec.Descriptor, error) {
+	return ref, r.manifest, nil
+}
+
+func (r *fakeRegistry) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	return r, nil
+}
+
+func (r *fakeRegistry) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	return nil, errors.New("push is not supported")
+}
+
+func (r *fakeRegistry) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	content, found := r.blobs[desc.Digest]
+	if !found {
+		return nil, errors.Errorf("blob %s not found", desc.Digest)
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func tarLayer(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content)
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestPullModuleFromArtifact(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest(registry.add("application/vnd.wasm.content.layer.v1+wasm", exitModule(0)))
+
+	wasmBytes, err := pullModule(context.Background(), registry, "example.com/module:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wasmBytes, exitModule(0)) {
+		t.Fatal("pulled module differs from the pushed one")
+	}
+}
+
+func TestPullModuleFromImageLayer(t *testing.T) {
+	registry := newFakeRegistry()
+	layer := tarLayer(t, map[string][]byte{"app.wasm": exitModule(0)})
+	registry.addManifest(registry.add(images.MediaTypeDockerSchema2Layer, layer))
+
+	wasmBytes, err := pullModule(context.Background(), registry, "example.com/module:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wasmBytes, exitModule(0)) {
+		t.Fatal("pulled module differs from the one in the layer")
+	}
+}
+
+func TestPullModuleRejectsTamperedBlobs(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+		content   []byte
+		tampered  []byte
+	}{
+		{name: "artifact layer", mediaType: "application/wasm", content: exitModule(0), tampered: exitModule(1)},
+		{name: "truncated artifact layer", mediaType: "application/wasm", content: exitModule(0), tampered: exitModule(0)[:10]},
+		{name: "image layer", mediaType: images.MediaTypeDockerSchema2Layer, content: tarLayer(t, map[string][]byte{"app.wasm": exitModule(0)}),
+			tampered: tarLayer(t, map[string][]byte{"app.wasm": exitModule(1)})},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newFakeRegistry()
+			layer := registry.add(test.mediaType, test.content)
+			registry.addManifest(layer)
+			registry.blobs[layer.Digest] = test.tampered
+
+			if _, err := pullModule(context.Background(), registry, "example.com/module:v1"); err == nil {
+				t.Fatal("a blob that doesn't match its digest should be rejected")
+			}
+		})
+	}
+}
+
+func TestWriteCacheFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "modules", "module.wasm")
+	if err := writeCacheFile(path, exitModule(0)); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(content, exitModule(0)) {
+		t.Fatalf("cached module should be complete, got %d bytes and %v", len(content), err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	for _, file := range files {
+		if strings.Contains(file.Name(), ".tmp-") {
+			t.Fatalf("temporary file %s should be renamed", file.Name())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package wasm
+
+import (
+	"context"
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/podstore"
+	"fledge/fledge-integrated/vkube"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	defaultCacheDir = "/var/lib/fledge/wasm"
+	defaultLogDir   = "/var/log/fledge/wasm"
+)
+
+// wasmPod keeps the pod spec together with the modules running its containers
+type wasmPod struct {
+	pod       *v1.Pod
+	instances map[string]*wasmInstance
+}
+
+func (wpod *wasmPod) Pod() *v1.Pod {
+	return wpod.pod
+}
+
+func (wpod *wasmPod) ContainerStatus(name string) (v1.ContainerStatus, bool) {
+	inst, found := wpod.instances[name]
+	if !found {
+		return v1.ContainerStatus{}, false
+	}
+	return inst.status(), true
+}
+
+// WasmProvider runs WASI modules in-process, so pods can run on devices without a container runtime.
+type WasmProvider struct {
+	*podstore.Store
+
+	cacheDir         string
+	logDir           string
+	compilationCache wazero.CompilationCache
+}
+
+func NewWasmProvider(cfg config.WasmConfig) (*WasmProvider, error) {
+	provider := WasmProvider{
+		Store:    podstore.NewStore(),
+		cacheDir: cfg.CacheDir,
+		logDir:   cfg.LogDir,
+	}
+	if provider.cacheDir == "" {
+		provider.cacheDir = defaultCacheDir
+	}
+	if provider.logDir == "" {
+		provider.logDir = defaultLogDir
+	}
+
+	if err := os.MkdirAll(provider.cacheDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create wasm cache dir")
+	}
+	//compiled code is reused over restarts, compiling is expensive on small devices
+	cache, err := wazero.NewCompilationCacheWithDir(filepath.Join(provider.cacheDir, "compiled"))
+	if err != nil {
+		fmt.Printf("Wasm compilation cache disabled: %s\n", err.Error())
+	} else {
+		provider.compilationCache = cache
+	}
+	return &provider, nil
+}
+
+// CreatePod starts a module for every container in the pod
+func (p *WasmProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	if len(pod.Spec.InitContainers) > 0 {
+		return errors.New("init containers are not supported by the wasm provider")
+	}
+
+	fmt.Printf("Creating wasm pod %s\n", podstore.PodKey(pod.Namespace, pod.Name))
+
+	pod = podstore.NewPod(pod)
+	wpod := &wasmPod{
+		pod:       pod,
+		instances: make(map[string]*wasmInstance),
+	}
+	if err := p.Store.Add(wpod); err != nil {
+		return err
+	}
+
+	vkube.CreateVolumes(ctx, pod)
+
+	var startErr error
+	for i := range pod.Spec.Containers {
+		dc := &pod.Spec.Containers[i]
+		inst := p.startInstance(ctx, pod, dc)
+		p.Store.Lock()
+		wpod.instances[dc.Name] = inst
+		p.Store.Unlock()
+		if inst.startErr != nil && startErr == nil {
+			startErr = errors.Wrapf(inst.startErr, "failed to start container %s", dc.Name)
+		}
+	}
+
+	if startErr != nil {
+		p.DeletePod(ctx, pod)
+		return startErr
+	}
+
+	p.Store.UpdateStatus(wpod)
+	return nil
+}
+
+// UpdatePod restarts the pod's modules with the new spec
+func (p *WasmProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Updating pod namespace %s name %s\n", pod.Namespace, pod.Name)
+
+	if err := p.DeletePod(ctx, pod); err != nil && !strongerrors.IsNotFound(err) {
+		return err
+	}
+	return p.CreatePod(ctx, pod)
+}
+
+// DeletePod stops the pod's modules and removes their logs
+func (p *WasmProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	fmt.Printf("Deleting pod namespace %s name %s\n", pod.Namespace, pod.Name)
+
+	state, err := p.Store.Remove(pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	wpod := state.(*wasmPod)
+	p.Store.Lock()
+	instances := []*wasmInstance{}
+	for _, inst := range wpod.instances {
+		instances = append(instances, inst)
+	}
+	p.Store.Unlock()
+
+	for _, inst := range instances {
+		p.stopInstance(inst)
+		os.Remove(inst.logPath)
+	}
+	return nil
+}
+
+// GetContainerLogs returns the stdout and stderr output of a module, over all of its restarts.
+func (p *WasmProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	var inst *wasmInstance
+	if wpod, found := p.Store.Get(namespace, podName).(*wasmPod); found {
+		p.Store.Lock()
+		inst = wpod.instances[containerName]
+		p.Store.Unlock()
+	}
+
+	if inst == nil {
+		return nil, strongerrors.NotFound(errors.Errorf("container %s not found in pod %s/%s", containerName, namespace, podName))
+	}
+
+	return vkube.ReadPlainLog(inst.logPath, opts)
+}
+
+// ExecInContainer is not supported, a module is a single process without a shell.
+func (p *WasmProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	return strongerrors.NotImplemented(errors.New("exec is not supported in wasm containers"))
+}
+
+// SupportedVolumeTypes returns the volumes that can be preopened as directories
+func (p *WasmProvider) SupportedVolumeTypes() []string {
+	return providers.VolumeTypesMountable
+}
+
+func (p *WasmProvider) SupportsExec() bool {
+	return false
+}
+
+func (p *WasmProvider) SupportsLogs() bool {
+	return true
+}
@@ -0,0 +1,166 @@
+package wasm
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers/testutil"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var wasiImport = append(append([]byte{0x16}, "wasi_snapshot_preview1"...), append([]byte{0x09}, "proc_exit"...)...)
+
+// buildModule assembles a WASI command whose _start runs body, body can call proc_exit as function 0
+func buildModule(body []byte) []byte {
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	section := func(id byte, content []byte) {
+		module = append(module, id, byte(len(content)))
+		module = append(module, content...)
+	}
+	//(i32) -> () for proc_exit and () -> () for _start
+	section(0x01, []byte{0x02, 0x60, 0x01, 0x7f, 0x00, 0x60, 0x00, 0x00})
+	section(0x02, append(append([]byte{0x01}, wasiImport...), 0x00, 0x00))
+	section(0x03, []byte{0x01, 0x01})
+	section(0x07, append(append([]byte{0x01, 0x06}, "_start"...), 0x00, 0x01))
+	code := append([]byte{0x00}, body...)
+	section(0x0a, append([]byte{0x01, byte(len(code))}, code...))
+	return module
+}
+
+// exitModule calls proc_exit with code
+func exitModule(code byte) []byte {
+	return buildModule([]byte{0x41, code, 0x10, 0x00, 0x0b})
+}
+
+// loopModule spins until it is stopped
+func loopModule() []byte {
+	return buildModule([]byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b})
+}
+
+func newTestProvider(t *testing.T) *WasmProvider {
+	config.Cfg = &config.Config{DeviceIP: "10.0.0.1"}
+	dir := t.TempDir()
+	p, err := NewWasmProvider(config.WasmConfig{CacheDir: filepath.Join(dir, "cache"), LogDir: filepath.Join(dir, "logs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func newTestPod(t *testing.T, name string, module []byte, policy v1.RestartPolicy) *v1.Pod {
+	path := filepath.Join(t.TempDir(), "app.wasm")
+	if err := ioutil.WriteFile(path, module, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: v1.PodSpec{
+			RestartPolicy: policy,
+			Containers:    []v1.Container{{Name: "app", Image: "file://" + path}},
+		},
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return 50 * time.Millisecond }
+
+	tests := []struct {
+		name     string
+		policy   v1.RestartPolicy
+		exitCode byte
+		restarts bool
+	}{
+		{name: "never", policy: v1.RestartPolicyNever, exitCode: 3},
+		{name: "on failure after failure", policy: v1.RestartPolicyOnFailure, exitCode: 3, restarts: true},
+		{name: "on failure after success", policy: v1.RestartPolicyOnFailure, exitCode: 0},
+		{name: "always", policy: v1.RestartPolicyAlways, exitCode: 0, restarts: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			pod := newTestPod(t, "restart", exitModule(test.exitCode), test.policy)
+			if err := p.CreatePod(context.Background(), pod); err != nil {
+				t.Fatal(err)
+			}
+			defer p.DeletePod(context.Background(), pod)
+
+			if !test.restarts {
+				status := testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.State.Terminated != nil })
+				if status.State.Terminated.ExitCode != int32(test.exitCode) {
+					t.Fatalf("expected exit code %d, got %d", test.exitCode, status.State.Terminated.ExitCode)
+				}
+				time.Sleep(200 * time.Millisecond)
+				current, _ := p.GetPod(context.Background(), pod.Namespace, pod.Name)
+				if current.Status.ContainerStatuses[0].RestartCount != 0 {
+					t.Fatal("the module should not have been restarted")
+				}
+				return
+			}
+
+			status := testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.RestartCount >= 2 })
+			last := status.LastTerminationState.Terminated
+			if last == nil || last.ExitCode != int32(test.exitCode) {
+				t.Fatalf("expected the last termination with exit code %d, got %v", test.exitCode, last)
+			}
+		})
+	}
+}
+
+func TestCrashLoopBackOff(t *testing.T) {
+	defer func(backoff func(time.Duration, time.Duration) time.Duration) { restartBackoff = backoff }(restartBackoff)
+	restartBackoff = func(previous time.Duration, ranFor time.Duration) time.Duration { return time.Minute }
+
+	p := newTestProvider(t)
+	pod := newTestPod(t, "backoff", exitModule(3), v1.RestartPolicyAlways)
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	status := testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.State.Waiting != nil })
+	if status.State.Waiting.Reason != "CrashLoopBackOff" {
+		t.Fatalf("expected CrashLoopBackOff, got %s", status.State.Waiting.Reason)
+	}
+	if status.LastTerminationState.Terminated == nil || status.LastTerminationState.Terminated.ExitCode != 3 {
+		t.Fatal("the exit of the module should be the last termination state")
+	}
+
+	//deleting the pod cancels the pending restart
+	start := time.Now()
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("deleting should not wait for the backoff")
+	}
+}
+
+func TestDeleteStopsModule(t *testing.T) {
+	p := newTestProvider(t)
+	pod := newTestPod(t, "stops", loopModule(), v1.RestartPolicyAlways)
+	if err := p.CreatePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitForContainerStatus(t, p, pod.Namespace, pod.Name, func(status v1.ContainerStatus) bool { return status.State.Running != nil })
+
+	wpod := p.Store.Get(pod.Namespace, pod.Name).(*wasmPod)
+	p.Store.Lock()
+	inst := wpod.instances["app"]
+	p.Store.Unlock()
+	if err := p.DeletePod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if inst.exitCode != 143 || inst.restartCount != 0 {
+		t.Fatalf("a stopped module should exit with 143 and not be restarted, got %d after %d restarts", inst.exitCode, inst.restartCount)
+	}
+	if current, _ := p.GetPod(context.Background(), pod.Namespace, pod.Name); current != nil {
+		t.Fatal("pod should be gone after delete")
+	}
+}
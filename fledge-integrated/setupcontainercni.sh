@@ -1,7 +1,7 @@
 #! /bin/sh
 
 if [[ -z "${6:-}" ]]; then
-  echo "Use: setupcontainercni.sh containername pid cniif containerip subnetsize gwip" 1>&2
+  echo "Use: setupcontainercni.sh containername pid|nspath cniif containerip subnetsize gwip" 1>&2
   exit 1
 fi
 
@@ -18,10 +18,13 @@ shift
 gwip=${1}
 
 #create netns folder if it doesn't exist (it should, mounted by Docker)
-#soft link the process to the container's network namespace
+#soft link the process to the container's network namespace, or link the namespace file fledge holds open
 
 mkdir -p /var/run/netns
-ln -s /host/proc/$pid/ns/net /var/run/netns/$containername
+case $pid in
+/*) ln -s $pid /var/run/netns/$containername ;;
+*) ln -s /host/proc/$pid/ns/net /var/run/netns/$containername ;;
+esac
 
 #generate device name and create veth, linking it to container device
 rand=$(tr -dc 'A-F0-9' < /dev/urandom | head -c4)
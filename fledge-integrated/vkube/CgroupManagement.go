@@ -3,6 +3,9 @@ package vkube
 import (
 	"fledge/fledge-integrated/manager"
 	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func GetCgroup(namespace string, podname string, container string) string {
@@ -57,3 +60,69 @@ func SetCpuLimit(cgName string, cpus float64) {
 	//cmd = fmt.Sprintf("cgset -r cpu.cfs_quota_us=%d %s", int64(100000*cpus), cgName)
 	manager.ExecCmdBash(cmd)
 }
+
+// AddProcessToCgroup moves a process into the memory and cpu controllers of a cgroup
+func AddProcessToCgroup(cgName string, pid int) {
+	cmd := fmt.Sprintf("echo %d > /sys/fs/cgroup/memory/%s/cgroup.procs", pid, cgName)
+	manager.ExecCmdBash(cmd)
+	cmd = fmt.Sprintf("echo %d > /sys/fs/cgroup/cpu/%s/cgroup.procs", pid, cgName)
+	manager.ExecCmdBash(cmd)
+}
+
+// SetContainerResources creates the container's cgroup and applies its cpu and memory limits.
+// Missing limits are taken from the requests, or set to a default when neither is given.
+func SetContainerResources(namespace string, podname string, dc *v1.Container) string {
+	//some default values
+	oneCpu, _ := resource.ParseQuantity("1")
+	defaultMem, _ := resource.ParseQuantity("150Mi")
+
+	fmt.Printf("Checking cpu limiting support\n")
+	supportCheck, _ := manager.ExecCmdBash("ls /sys/fs/cgroup/cpu/ | grep -E 'cpu.cfs_[a-z]*_us'")
+	cpuSupported := supportCheck != ""
+	fmt.Printf("Cpu limit support %t\n", cpuSupported)
+
+	var cpuLimit float64
+	var memLimit int64
+
+	if dc.Resources.Limits == nil {
+		dc.Resources.Limits = v1.ResourceList{}
+	}
+	if dc.Resources.Requests == nil {
+		dc.Resources.Requests = v1.ResourceList{}
+	}
+	memory := dc.Resources.Limits.Memory()
+	if memory.IsZero() {
+		//if the memory limit isn't filled in, set it to request
+		memory = dc.Resources.Requests.Memory()
+		dc.Resources.Limits[v1.ResourceMemory] = *memory
+	}
+	if !memory.IsZero() {
+		memLimit = memory.Value()
+	} else {
+		//this means neither was set, so update both limit and request with a default value
+		dc.Resources.Limits[v1.ResourceMemory] = defaultMem
+		dc.Resources.Requests[v1.ResourceMemory] = defaultMem
+		memLimit = 150 * 1024 * 1024 //150 Mi
+	}
+
+	if cpuSupported {
+		cpu := dc.Resources.Limits.Cpu()
+		if cpu.IsZero() {
+			//same if cpu limit isn't filled in
+			cpu = dc.Resources.Requests.Cpu()
+			dc.Resources.Limits[v1.ResourceCPU] = *cpu
+		}
+		if !cpu.IsZero() {
+			cpuLimit = float64(cpu.MilliValue()) / 1000.0
+		} else {
+			dc.Resources.Limits[v1.ResourceCPU] = oneCpu
+			dc.Resources.Requests[v1.ResourceCPU] = oneCpu
+			cpuLimit = 1 // 1 CPU
+		}
+	}
+
+	cgroup := CreateCgroupIfNotExists(namespace, podname, dc.Name)
+	SetMemoryLimit(cgroup, memLimit)
+	SetCpuLimit(cgroup, cpuLimit)
+	return cgroup
+}
@@ -0,0 +1,316 @@
+package vkube
+
+import (
+	"compress/gzip"
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/manager"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	DefaultLogMaxSize  = 10 * 1024 * 1024
+	DefaultLogMaxFiles = 5
+
+	//how often the log budget and disk pressure are checked
+	logGCInterval = time.Minute
+	//suffix of rotated log files, like 0.log.20210102-150405.000000, they sort in the order they were rotated
+	logRotationTimeFormat = "20060102-150405.000000"
+)
+
+// whether the disk is filling up, replaced by tests
+var storagePressure = manager.IsStoragePressure
+
+// ContainerLogManager rotates the log files of containers and keeps all container logs within a node-wide budget.
+// Rotated files are named after the file they were rotated from, and gzipped if compression is enabled.
+type ContainerLogManager struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	compress bool
+	//total size of the logs of all containers, unlimited if 0
+	budget int64
+
+	lock sync.Mutex
+	//the writers of running containers by their path, these files are never removed
+	writers map[string]*CRILogWriter
+}
+
+// NewContainerLogManager uses the defaults for settings that are missing or invalid
+func NewContainerLogManager(cfg config.ContainerdConfig) *ContainerLogManager {
+	m := &ContainerLogManager{
+		dir:      cfg.LogDir,
+		maxSize:  DefaultLogMaxSize,
+		maxFiles: cfg.LogMaxFiles,
+		compress: cfg.LogCompress,
+		writers:  make(map[string]*CRILogWriter),
+	}
+	if m.dir == "" {
+		m.dir = DefaultContainerLogDir
+	}
+	if m.maxFiles <= 0 {
+		m.maxFiles = DefaultLogMaxFiles
+	}
+	if cfg.LogMaxSize != "" {
+		if size, err := parseLogSize(cfg.LogMaxSize); err != nil {
+			fmt.Printf("Invalid log max size %s, using the default: %s\n", cfg.LogMaxSize, err.Error())
+		} else {
+			m.maxSize = size
+		}
+	}
+	if cfg.LogBudget != "" {
+		if budget, err := parseLogSize(cfg.LogBudget); err != nil {
+			fmt.Printf("Invalid log budget %s, logs are only removed under disk pressure: %s\n", cfg.LogBudget, err.Error())
+		} else {
+			m.budget = budget
+		}
+	}
+	return m
+}
+
+// parseLogSize parses a size like 10Mi
+func parseLogSize(size string) (int64, error) {
+	q, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, err
+	}
+	return q.Value(), nil
+}
+
+func (m *ContainerLogManager) Dir() string {
+	return m.dir
+}
+
+// NewWriter creates the log file of a container run, the file is rotated when it reaches the max size
+func (m *ContainerLogManager) NewWriter(path string) (*CRILogWriter, error) {
+	w, err := NewCRILogWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	m.addWriter(w)
+	return w, nil
+}
+
+// ReopenWriter continues the log file of a container run that is still going, e.g. after a restart of fledge
+func (m *ContainerLogManager) ReopenWriter(path string) (*CRILogWriter, error) {
+	w, err := OpenCRILogWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	m.addWriter(w)
+	return w, nil
+}
+
+func (m *ContainerLogManager) addWriter(w *CRILogWriter) {
+	w.manager = m
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.writers[w.path] = w
+}
+
+func (m *ContainerLogManager) removeWriter(w *CRILogWriter) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.writers[w.path] == w {
+		delete(m.writers, w.path)
+	}
+}
+
+// RemoveLogs removes the log file of a container run together with its rotated files
+func (m *ContainerLogManager) RemoveLogs(path string) {
+	for _, file := range append(rotatedLogFiles(path), path) {
+		os.Remove(file)
+	}
+}
+
+// rotate renames the current file and continues in a new one at the same path, the writer's lock must be held
+func (w *CRILogWriter) rotate() error {
+	m := w.manager
+	if err := w.file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close log file")
+	}
+	w.file = nil
+
+	rotated := w.path + "." + time.Now().Format(logRotationTimeFormat)
+	if err := os.Rename(w.path, rotated); err != nil {
+		//keep writing to the same file
+		rotated = ""
+	}
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.Wrap(err, "failed to create log file")
+	}
+	w.file = file
+	if rotated == "" {
+		return errors.New("failed to rename log file")
+	}
+	w.size = 0
+
+	m.pruneRotated(w.path)
+	if m.compress {
+		go compressLogFile(rotated)
+	}
+	return nil
+}
+
+// pruneRotated removes the oldest rotated files of path, maxFiles includes the current file
+func (m *ContainerLogManager) pruneRotated(path string) {
+	rotated := rotatedLogFiles(path)
+	for len(rotated) > m.maxFiles-1 {
+		name := strings.TrimSuffix(rotated[0], ".gz")
+		os.Remove(name)
+		os.Remove(name + ".gz")
+		rotated = rotated[1:]
+	}
+}
+
+// rotatedLogFiles returns the files rotated away from path, oldest first
+func rotatedLogFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	sort.Strings(matches)
+	files := []string{}
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		if strings.HasSuffix(match, ".tmp") {
+			continue
+		}
+		//a file that is being compressed is listed once
+		name := strings.TrimSuffix(match, ".gz")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		files = append(files, match)
+	}
+	return files
+}
+
+// compressLogFile replaces a rotated log file by a gzipped copy
+func compressLogFile(path string) {
+	if err := gzipFile(path, path+".gz"); err != nil {
+		fmt.Printf("Failed to compress log file %s: %s\n", path, err.Error())
+		return
+	}
+	os.Remove(path)
+}
+
+func gzipFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// GCLoop periodically runs GC
+func (m *ContainerLogManager) GCLoop() {
+	for {
+		time.Sleep(logGCInterval)
+		m.GC()
+	}
+}
+
+// GC removes logs while they are over budget or the disk is under pressure. The logs of terminated containers
+// go first, then the rotated files of running containers, oldest first. Files that are written to are kept.
+func (m *ContainerLogManager) GC() {
+	files, total := m.removableLogFiles()
+	pressure := storagePressure()
+	overBudget := func() bool {
+		return m.budget > 0 && total > m.budget
+	}
+	for _, file := range files {
+		if !overBudget() && !pressure {
+			return
+		}
+		if err := os.Remove(file.path); err != nil {
+			continue
+		}
+		fmt.Printf("Removed container log %s to free space\n", file.path)
+		total -= file.size
+		if pressure {
+			pressure = storagePressure()
+		}
+	}
+}
+
+type logFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+	//the file was rotated from the file of a running container
+	running bool
+}
+
+// removableLogFiles lists the log files in the order GC removes them, and the size of all logs
+func (m *ContainerLogManager) removableLogFiles() ([]logFileInfo, int64) {
+	m.lock.Lock()
+	active := make(map[string]bool)
+	for path := range m.writers {
+		active[path] = true
+	}
+	m.lock.Unlock()
+
+	files := []logFileInfo{}
+	var total int64
+	filepath.Walk(m.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		total += info.Size()
+		if active[path] {
+			return nil
+		}
+		files = append(files, logFileInfo{
+			path:    path,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			running: active[logBasePath(path)],
+		})
+		return nil
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].running != files[j].running {
+			return !files[i].running
+		}
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, total
+}
+
+// logBasePath returns the path of the log file a rotated file was rotated from
+func logBasePath(path string) string {
+	base := filepath.Base(path)
+	if i := strings.Index(base, ".log."); i >= 0 {
+		return filepath.Join(filepath.Dir(path), base[:i+len(".log")])
+	}
+	return path
+}
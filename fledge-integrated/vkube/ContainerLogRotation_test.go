@@ -0,0 +1,143 @@
+package vkube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+)
+
+func TestParseLogSize(t *testing.T) {
+	cases := []struct {
+		size string
+		want int64
+		err  bool
+	}{
+		{"10Mi", 10 * 1024 * 1024, false},
+		{"100Ki", 100 * 1024, false},
+		{"1G", 1000 * 1000 * 1000, false},
+		{"500", 500, false},
+		{"lots", 0, true},
+	}
+	for _, c := range cases {
+		size, err := parseLogSize(c.size)
+		if (err != nil) != c.err || size != c.want {
+			t.Errorf("parseLogSize(%q) = %d, %v, want %d", c.size, size, err, c.want)
+		}
+	}
+}
+
+func TestLogRotationKeepsMaxFiles(t *testing.T) {
+	m := NewContainerLogManager(config.ContainerdConfig{LogDir: t.TempDir(), LogMaxFiles: 3})
+	m.maxSize = 100
+	path := filepath.Join(m.Dir(), "pod", "app", "0.log")
+	w, err := m.NewWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLogLines(t, w, 0, 30)
+	w.Close()
+
+	//the current file counts as one of them
+	if rotated := rotatedLogFiles(path); len(rotated) != 2 {
+		t.Fatalf("rotated files %v, want 2", rotated)
+	}
+	out := readCRILog(t, path, providers.ContainerLogOpts{})
+	if !strings.HasSuffix(out, logLines(29, 1)) || strings.Contains(out, "line 00") {
+		t.Fatalf("got %q, want the newest lines only", out)
+	}
+}
+
+func TestRotatedLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "0.log")
+	for _, name := range []string{
+		"0.log",
+		"0.log.20210102-150405.000002",
+		"0.log.20210102-150405.000001.gz",
+		//being compressed
+		"0.log.20210102-150405.000003",
+		"0.log.20210102-150405.000003.gz.tmp",
+		"1.log.20210102-150405.000000",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{path + ".20210102-150405.000001.gz", path + ".20210102-150405.000002", path + ".20210102-150405.000003"}
+	if rotated := rotatedLogFiles(path); strings.Join(rotated, ",") != strings.Join(want, ",") {
+		t.Fatalf("rotated files %v, want %v", rotated, want)
+	}
+	if base := logBasePath(want[0]); base != path {
+		t.Fatalf("base path %s, want %s", base, path)
+	}
+}
+
+// writeLogFile writes size bytes to a log file that was last modified age ago
+func writeLogFile(t *testing.T, path string, size int, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, make([]byte, size), 0640); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogGC(t *testing.T) {
+	pressure := false
+	defer func(original func() bool) { storagePressure = original }(storagePressure)
+	storagePressure = func() bool { return pressure }
+
+	cases := []struct {
+		name     string
+		budget   string
+		pressure bool
+		removed  []string
+	}{
+		{"within budget", "10Ki", false, nil},
+		{"no budget", "", false, nil},
+		{"terminated containers first", "3000", false, []string{"old/0.log", "new/0.log"}},
+		{"oldest rotated files of running containers next", "2000", false, []string{"old/0.log", "new/0.log", "running/1.log.20210102-150405.000001"}},
+		//files that are written to are kept even when the budget can't be met
+		{"everything but running files", "100", false, []string{"old/0.log", "new/0.log", "running/1.log.20210102-150405.000001", "running/1.log.20210102-150405.000002"}},
+		{"disk pressure", "", true, []string{"old/0.log", "new/0.log", "running/1.log.20210102-150405.000001", "running/1.log.20210102-150405.000002"}},
+	}
+	for _, c := range cases {
+		m := NewContainerLogManager(config.ContainerdConfig{LogDir: t.TempDir(), LogBudget: c.budget})
+		pressure = c.pressure
+		dir := filepath.Join(m.Dir(), "default_web_uid")
+		//the rotated files of the running container are older than the logs of terminated ones
+		writeLogFile(t, filepath.Join(dir, "running", "1.log.20210102-150405.000001"), 1000, 4*time.Hour)
+		writeLogFile(t, filepath.Join(dir, "running", "1.log.20210102-150405.000002"), 1000, 3*time.Hour)
+		writeLogFile(t, filepath.Join(dir, "old", "0.log"), 1000, 2*time.Hour)
+		writeLogFile(t, filepath.Join(dir, "new", "0.log"), 1000, time.Hour)
+		w, err := m.ReopenWriter(filepath.Join(dir, "running", "1.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeLogLines(t, w, 0, 10)
+
+		m.GC()
+
+		removed := []string{}
+		for _, name := range []string{"old/0.log", "new/0.log", "running/1.log.20210102-150405.000001", "running/1.log.20210102-150405.000002", "running/1.log"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+				removed = append(removed, name)
+			}
+		}
+		if strings.Join(removed, ",") != strings.Join(c.removed, ",") {
+			t.Errorf("%s: removed %v, want %v", c.name, removed, c.removed)
+		}
+		w.Close()
+	}
+}
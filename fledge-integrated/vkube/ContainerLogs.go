@@ -0,0 +1,587 @@
+package vkube
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fledge/fledge-integrated/providers"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Container logs are kept in the CRI logging format, like the kubelet and CRI runtimes do.
+// Every line is "<RFC3339Nano timestamp> <stream> <tag> <message>", where the tag is F for a full line
+// and P for a part of a line that was too long.
+
+const (
+	DefaultContainerLogDir = "/var/log/fledge/pods"
+
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+
+	logTagFull    = "F"
+	logTagPartial = "P"
+
+	//longer lines are split in partial entries
+	maxLogLineSize = 16 * 1024
+	//how often a followed log is checked for new output
+	logFollowInterval = 250 * time.Millisecond
+)
+
+var errLogLimitReached = errors.New("log limit reached")
+
+// PodLogDir is the directory with the logs of the pod's containers, named like the kubelet's pod log directories
+func PodLogDir(logDir string, pod *v1.Pod) string {
+	return filepath.Join(logDir, pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name+"_"+string(pod.ObjectMeta.UID))
+}
+
+// ContainerLogFile is the log file of one run of a container, every restart writes a new one
+func ContainerLogFile(podLogDir string, containerName string, restartCount int32) string {
+	return filepath.Join(podLogDir, containerName, fmt.Sprintf("%d.log", restartCount))
+}
+
+// CRILogWriter writes the stdout and stderr of a container to a log file in the CRI logging format
+type CRILogWriter struct {
+	lock   sync.Mutex
+	path   string
+	file   *os.File
+	size   int64
+	stdout *criLogStream
+	stderr *criLogStream
+	//rotates the file, if the writer was created by a ContainerLogManager
+	manager *ContainerLogManager
+}
+
+// criLogStream turns the output of one stream into log entries, it keeps the unfinished line until it ends
+type criLogStream struct {
+	writer *CRILogWriter
+	stream string
+	line   []byte
+}
+
+// NewCRILogWriter creates the log file, an existing file is truncated. The file isn't rotated.
+func NewCRILogWriter(path string) (*CRILogWriter, error) {
+	return openCRILogWriter(path, os.O_TRUNC)
+}
+
+// OpenCRILogWriter continues an existing log file, or creates it. The file isn't rotated.
+func OpenCRILogWriter(path string) (*CRILogWriter, error) {
+	return openCRILogWriter(path, os.O_APPEND)
+}
+
+func openCRILogWriter(path string, flag int) (*CRILogWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create log directory")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|flag, 0640)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create log file")
+	}
+	w := &CRILogWriter{path: path, file: file}
+	if info, err := file.Stat(); err == nil && flag&os.O_APPEND != 0 {
+		w.size = info.Size()
+	}
+	w.stdout = &criLogStream{writer: w, stream: LogStreamStdout}
+	w.stderr = &criLogStream{writer: w, stream: LogStreamStderr}
+	return w, nil
+}
+
+func (w *CRILogWriter) Stdout() io.Writer {
+	return w.stdout
+}
+
+func (w *CRILogWriter) Stderr() io.Writer {
+	return w.stderr
+}
+
+// Close writes the unfinished lines and closes the log file, output written afterwards is dropped
+func (w *CRILogWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	for _, stream := range []*criLogStream{w.stdout, w.stderr} {
+		if len(stream.line) > 0 {
+			w.writeEntry(stream.stream, logTagFull, stream.line)
+			stream.line = nil
+		}
+	}
+	err := w.file.Close()
+	w.file = nil
+	if w.manager != nil {
+		w.manager.removeWriter(w)
+	}
+	return err
+}
+
+// writeEntry appends a log entry, the lock must be held
+func (w *CRILogWriter) writeEntry(stream string, tag string, msg []byte) error {
+	if w.file == nil {
+		return nil
+	}
+	entry := make([]byte, 0, len(msg)+64)
+	entry = append(entry, time.Now().Format(time.RFC3339Nano)...)
+	entry = append(entry, ' ')
+	entry = append(entry, stream...)
+	entry = append(entry, ' ')
+	entry = append(entry, tag...)
+	entry = append(entry, ' ')
+	entry = append(entry, msg...)
+	entry = append(entry, '\n')
+	n, err := w.file.Write(entry)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if w.manager != nil && w.manager.maxSize > 0 && w.size >= w.manager.maxSize {
+		if err := w.rotate(); err != nil {
+			fmt.Printf("Failed to rotate log file %s: %s\n", w.path, err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *criLogStream) Write(p []byte) (int, error) {
+	s.writer.lock.Lock()
+	defer s.writer.lock.Unlock()
+
+	s.line = append(s.line, p...)
+	start := 0
+	for {
+		end := bytes.IndexByte(s.line[start:], '\n')
+		if end < 0 {
+			break
+		}
+		line := s.line[start : start+end]
+		for len(line) > maxLogLineSize {
+			if err := s.writer.writeEntry(s.stream, logTagPartial, line[:maxLogLineSize]); err != nil {
+				return 0, err
+			}
+			line = line[maxLogLineSize:]
+		}
+		if err := s.writer.writeEntry(s.stream, logTagFull, line); err != nil {
+			return 0, err
+		}
+		start += end + 1
+	}
+	for len(s.line)-start >= maxLogLineSize {
+		if err := s.writer.writeEntry(s.stream, logTagPartial, s.line[start:start+maxLogLineSize]); err != nil {
+			return 0, err
+		}
+		start += maxLogLineSize
+	}
+	s.line = append(s.line[:0], s.line[start:]...)
+	return len(p), nil
+}
+
+// criLogEntry is a parsed line of a CRI log file
+type criLogEntry struct {
+	timestamp time.Time
+	stream    string
+	partial   bool
+	message   []byte
+}
+
+func parseCRILogEntry(line []byte) (*criLogEntry, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	fields := bytes.SplitN(line, []byte(" "), 4)
+	if len(fields) < 3 {
+		return nil, errors.Errorf("invalid CRI log entry %q", line)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CRI log timestamp")
+	}
+	entry := &criLogEntry{
+		timestamp: timestamp,
+		stream:    string(fields[1]),
+		partial:   string(fields[2]) == logTagPartial,
+	}
+	if len(fields) == 4 {
+		entry.message = fields[3]
+	}
+	return entry, nil
+}
+
+// ReadCRILog streams the logs of a container run with the options of a logs request, the files rotated away
+// from path come first. When following, the log is read until the context is done or running returns false.
+func ReadCRILog(ctx context.Context, path string, opts providers.ContainerLogOpts, running func() bool) (io.ReadCloser, error) {
+	segments := append(rotatedLogFiles(path), path)
+	if _, err := os.Stat(path); os.IsNotExist(err) && len(segments) == 1 {
+		return nil, strongerrors.NotFound(errors.Errorf("log file %s not found", path))
+	}
+	first, skip := 0, 0
+	if opts.Tail > 0 {
+		var err error
+		if first, skip, err = tailSegments(segments, opts.Tail); err != nil {
+			return nil, errors.Wrap(err, "failed to read log file")
+		}
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		c := &criLogCopier{out: writer, opts: opts, skip: skip, lineStart: true}
+		if opts.LimitBytes > 0 {
+			c.out = &limitWriter{writer: writer, left: opts.LimitBytes}
+		}
+		var err error
+		for i := first; i < len(segments) && err == nil; i++ {
+			if i == len(segments)-1 && opts.Follow {
+				err = c.follow(ctx, path, running)
+			} else {
+				err = c.copySegment(segments[i])
+			}
+		}
+		if err == errLogLimitReached {
+			err = nil
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, nil
+}
+
+// openLogSegment opens a log file, rotated files may be gzipped
+func openLogSegment(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) && !strings.HasSuffix(path, ".gz") {
+		//it was compressed after it was listed
+		path += ".gz"
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, file}, nil
+}
+
+// tailSegments returns the segment the last n lines start in and the number of lines to skip in it
+func tailSegments(segments []string, n int) (int, int, error) {
+	for i := len(segments) - 1; i >= 0; i-- {
+		count, err := countSegmentLines(segments[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		if count >= n {
+			return i, count - n, nil
+		}
+		n -= count
+	}
+	return 0, 0, nil
+}
+
+// countSegmentLines counts the lines of a log file, partial entries belong to the line they end in
+func countSegmentLines(path string) (int, error) {
+	file, err := openLogSegment(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	ended := true
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		entry, perr := parseCRILogEntry(line)
+		if perr != nil {
+			continue
+		}
+		if entry.partial {
+			ended = false
+			continue
+		}
+		count++
+		ended = true
+	}
+	if !ended {
+		count++
+	}
+	return count, nil
+}
+
+// criLogCopier writes the messages of log entries to out, its state carries over from one log file to the next
+type criLogCopier struct {
+	out  io.Writer
+	opts providers.ContainerLogOpts
+	//number of lines that are left out at the start
+	skip      int
+	lineStart bool
+	//timestamp of the last entry that was written, entries up to after are left out
+	last  time.Time
+	after time.Time
+}
+
+func (c *criLogCopier) copySegment(path string) error {
+	file, err := openLogSegment(path)
+	if os.IsNotExist(err) {
+		//removed to free space
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			//an unfinished entry is still being written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.writeEntry(line); err != nil {
+			return err
+		}
+	}
+}
+
+// follow copies the current log file and waits for new entries, switching to the new file at path after a rotation
+func (c *criLogCopier) follow(ctx context.Context, path string, running func() bool) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	var pending []byte
+	stopped := false
+	draining := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == nil {
+			if len(pending) > 0 {
+				line = append(pending, line...)
+				pending = nil
+			}
+			if err := c.writeEntry(line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		//the entry is still being written
+		pending = append(pending, line...)
+		if logRotated(file, path) {
+			if !draining {
+				//read what was written before the file was renamed
+				draining = true
+				continue
+			}
+			if next, err := os.Open(path); err == nil {
+				file.Close()
+				file = next
+				reader.Reset(file)
+				pending = nil
+				draining = false
+				//the file may have been rotated more than once since it was read
+				c.after = c.last
+				for _, segment := range rotatedLogFiles(path) {
+					if err := c.copySegment(segment); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		if stopped {
+			return nil
+		}
+		//read once more after the container stopped, to get its last output
+		stopped = running == nil || !running()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logFollowInterval):
+		}
+	}
+}
+
+// logRotated tells whether path was replaced by a new file since file was opened
+func logRotated(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && !os.SameFile(opened, current)
+}
+
+func (c *criLogCopier) writeEntry(line []byte) error {
+	entry, err := parseCRILogEntry(line)
+	if err != nil {
+		return nil
+	}
+	if c.skip > 0 {
+		if !entry.partial {
+			c.skip--
+		}
+		return nil
+	}
+	if !c.opts.SinceTime.IsZero() && entry.timestamp.Before(c.opts.SinceTime) {
+		return nil
+	}
+	if !c.after.IsZero() && !entry.timestamp.After(c.after) {
+		return nil
+	}
+	c.last = entry.timestamp
+	if c.opts.Timestamps && c.lineStart {
+		if _, err := io.WriteString(c.out, entry.timestamp.Format(time.RFC3339Nano)+" "); err != nil {
+			return err
+		}
+	}
+	if _, err := c.out.Write(entry.message); err != nil {
+		return err
+	}
+	if !entry.partial {
+		if _, err := c.out.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	c.lineStart = !entry.partial
+	return nil
+}
+
+// limitWriter writes up to left bytes and fails with errLogLimitReached after that
+type limitWriter struct {
+	writer io.Writer
+	left   int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.left <= 0 {
+		return 0, errLogLimitReached
+	}
+	if len(p) <= w.left {
+		n, err := w.writer.Write(p)
+		w.left -= n
+		return n, err
+	}
+	n, err := w.writer.Write(p[:w.left])
+	w.left -= n
+	if err == nil {
+		err = errLogLimitReached
+	}
+	return n, err
+}
+
+// LimitReadCloser returns at most n bytes of logs
+func LimitReadCloser(logs io.ReadCloser, n int) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(logs, int64(n)), logs}
+}
+
+// ReadPlainLog streams a log file without timestamps, only the tail and byte limit of the options apply to it
+func ReadPlainLog(path string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	switch {
+	case opts.Previous:
+		return nil, strongerrors.InvalidArgument(errors.New("logs of previous runs aren't kept"))
+	case opts.Follow:
+		return nil, strongerrors.InvalidArgument(errors.New("following the logs isn't supported"))
+	case !opts.SinceTime.IsZero():
+		return nil, strongerrors.InvalidArgument(errors.New("the logs have no timestamps to start at a time"))
+	case opts.Timestamps:
+		return nil, strongerrors.InvalidArgument(errors.New("the logs have no timestamps"))
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, strongerrors.NotFound(errors.Errorf("log file %s not found", path))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read container log")
+	}
+	if opts.Tail > 0 {
+		offset, err := tailOffset(file, opts.Tail)
+		if err == nil {
+			_, err = file.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "failed to read container log")
+		}
+	}
+	if opts.LimitBytes > 0 {
+		return LimitReadCloser(file, opts.LimitBytes), nil
+	}
+	return file, nil
+}
+
+// tailOffset returns the offset the last n lines of the file start at, it reads the file backwards from its end
+func tailOffset(file *os.File, n int) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	buf := make([]byte, 32*1024)
+	//the newline at the end of the file doesn't start another line
+	newlines := -1
+	for end > 0 {
+		size := int64(len(buf))
+		if end < size {
+			size = end
+		}
+		start := end - size
+		if _, err := file.ReadAt(buf[:size], start); err != nil {
+			return 0, err
+		}
+		for i := size - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				if newlines < 0 {
+					newlines = 0
+				}
+				continue
+			}
+			if newlines++; newlines == n {
+				return start + i + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -0,0 +1,278 @@
+package vkube
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+
+	"github.com/cpuguy83/strongerrors"
+)
+
+// newTestLogManager rotates logs after a few entries and keeps all rotated files
+func newTestLogManager(t *testing.T, compress bool) *ContainerLogManager {
+	m := NewContainerLogManager(config.ContainerdConfig{LogDir: t.TempDir(), LogMaxFiles: 100, LogCompress: compress})
+	m.maxSize = 100
+	return m
+}
+
+func writeLogLines(t *testing.T, w *CRILogWriter, first int, n int) {
+	t.Helper()
+	for i := first; i < first+n; i++ {
+		if _, err := fmt.Fprintf(w.Stdout(), "line %02d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func logLines(first int, n int) string {
+	lines := ""
+	for i := first; i < first+n; i++ {
+		lines += fmt.Sprintf("line %02d\n", i)
+	}
+	return lines
+}
+
+// waitCompressed waits until all files rotated away from path are gzipped
+func waitCompressed(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		compressed := true
+		for _, file := range rotatedLogFiles(path) {
+			compressed = compressed && strings.HasSuffix(file, ".gz")
+		}
+		if compressed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated files weren't compressed: %v", rotatedLogFiles(path))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readCRILog(t *testing.T, path string, opts providers.ContainerLogOpts) string {
+	t.Helper()
+	logs, err := ReadCRILog(context.Background(), path, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	out, err := ioutil.ReadAll(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// writeCRILogFile writes entries a second apart, messages starting with "P:" are partial
+func writeCRILogFile(t *testing.T, path string, start time.Time, messages ...string) {
+	t.Helper()
+	content := ""
+	for i, msg := range messages {
+		tag := logTagFull
+		if strings.HasPrefix(msg, "P:") {
+			tag = logTagPartial
+			msg = msg[2:]
+		}
+		content += fmt.Sprintf("%s stdout %s %s\n", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339Nano), tag, msg)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadCRILogTailAcrossRotatedFiles(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		m := newTestLogManager(t, compress)
+		path := filepath.Join(m.Dir(), "pod", "app", "0.log")
+		w, err := m.NewWriter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeLogLines(t, w, 0, 30)
+		w.Close()
+		if compress {
+			waitCompressed(t, path)
+		}
+		if rotated := rotatedLogFiles(path); len(rotated) < 5 {
+			t.Fatalf("compress %t: %d rotated files, want the log rotated several times", compress, len(rotated))
+		}
+
+		cases := []struct {
+			tail int
+			want string
+		}{
+			{0, logLines(0, 30)},
+			{1, logLines(29, 1)},
+			{10, logLines(20, 10)},
+			{30, logLines(0, 30)},
+			{100, logLines(0, 30)},
+		}
+		for _, c := range cases {
+			if out := readCRILog(t, path, providers.ContainerLogOpts{Tail: c.tail}); out != c.want {
+				t.Errorf("compress %t, tail %d: got %q, want %q", compress, c.tail, out, c.want)
+			}
+		}
+	}
+}
+
+func TestReadCRILogOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0.log")
+	start := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	writeCRILogFile(t, path, start, "zero", "one", "P:tw", "o", "three", "four")
+
+	cases := []struct {
+		name string
+		opts providers.ContainerLogOpts
+		want string
+	}{
+		{"all", providers.ContainerLogOpts{}, "zero\none\ntwo\nthree\nfour\n"},
+		{"tail joins partial entries", providers.ContainerLogOpts{Tail: 3}, "two\nthree\nfour\n"},
+		{"limit bytes", providers.ContainerLogOpts{LimitBytes: 7}, "zero\non"},
+		{"limit bytes of tail", providers.ContainerLogOpts{Tail: 2, LimitBytes: 8}, "three\nfo"},
+		{"since time", providers.ContainerLogOpts{SinceTime: start.Add(4 * time.Second)}, "three\nfour\n"},
+		{"since time between entries", providers.ContainerLogOpts{SinceTime: start.Add(1500 * time.Millisecond)}, "two\nthree\nfour\n"},
+		{"timestamps", providers.ContainerLogOpts{Tail: 2, Timestamps: true},
+			start.Add(4*time.Second).Format(time.RFC3339Nano) + " three\n" + start.Add(5*time.Second).Format(time.RFC3339Nano) + " four\n"},
+	}
+	for _, c := range cases {
+		if out := readCRILog(t, path, c.opts); out != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, out, c.want)
+		}
+	}
+}
+
+func TestReadCRILogNotFound(t *testing.T) {
+	_, err := ReadCRILog(context.Background(), filepath.Join(t.TempDir(), "0.log"), providers.ContainerLogOpts{}, nil)
+	if !strongerrors.IsNotFound(err) {
+		t.Fatalf("got %v, want a not found error", err)
+	}
+}
+
+func TestReadPlainLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("zero\none\n\nthree\nfour"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	//a tail that spans more than one block read from the end
+	long := strings.Repeat(strings.Repeat("x", 999)+"\n", 100)
+	longPath := filepath.Join(dir, "long.log")
+	if err := ioutil.WriteFile(longPath, []byte(long), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		path string
+		opts providers.ContainerLogOpts
+		want string
+	}{
+		{"all", path, providers.ContainerLogOpts{}, "zero\none\n\nthree\nfour"},
+		{"tail of an unfinished line", path, providers.ContainerLogOpts{Tail: 1}, "four"},
+		{"tail with an empty line", path, providers.ContainerLogOpts{Tail: 3}, "\nthree\nfour"},
+		{"tail longer than the log", path, providers.ContainerLogOpts{Tail: 10}, "zero\none\n\nthree\nfour"},
+		{"limit bytes of tail", path, providers.ContainerLogOpts{Tail: 2, LimitBytes: 3}, "thr"},
+		{"tail across blocks", longPath, providers.ContainerLogOpts{Tail: 40}, long[60*1000:]},
+	}
+	for _, c := range cases {
+		logs, err := ReadPlainLog(c.path, c.opts)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		out, err := ioutil.ReadAll(logs)
+		logs.Close()
+		if err != nil || string(out) != c.want {
+			t.Errorf("%s: got %q, %v, want %q", c.name, out, err, c.want)
+		}
+	}
+
+	if _, err := ReadPlainLog(filepath.Join(dir, "missing.log"), providers.ContainerLogOpts{}); !strongerrors.IsNotFound(err) {
+		t.Fatalf("missing log returned %v", err)
+	}
+	for _, opts := range []providers.ContainerLogOpts{{Previous: true}, {Follow: true}, {SinceTime: time.Now()}, {Timestamps: true}} {
+		if _, err := ReadPlainLog(path, opts); !strongerrors.IsInvalidArgument(err) {
+			t.Errorf("options %+v returned %v, want an invalid argument", opts, err)
+		}
+	}
+}
+
+func TestReadCRILogFollowAcrossRotation(t *testing.T) {
+	m := newTestLogManager(t, false)
+	path := filepath.Join(m.Dir(), "pod", "app", "0.log")
+	w, err := m.NewWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLogLines(t, w, 0, 5)
+
+	var running int32 = 1
+	logs, err := ReadCRILog(context.Background(), path, providers.ContainerLogOpts{Tail: 2, Follow: true}, func() bool {
+		return atomic.LoadInt32(&running) == 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	read := make(chan string, 1)
+	go func() {
+		out, _ := ioutil.ReadAll(logs)
+		read <- string(out)
+	}()
+
+	//the log is rotated several times while it is followed, also between two reads
+	for i := 5; i < 25; i += 5 {
+		time.Sleep(logFollowInterval / 2)
+		writeLogLines(t, w, i, 5)
+	}
+	w.Close()
+	atomic.StoreInt32(&running, 0)
+
+	select {
+	case out := <-read:
+		if want := logLines(3, 22); out != want {
+			t.Fatalf("got %q, want %q", out, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("following didn't stop with the container")
+	}
+}
+
+func TestReadCRILogFollowStopsWithContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0.log")
+	writeCRILogFile(t, path, time.Now(), "zero")
+	ctx, cancel := context.WithCancel(context.Background())
+	logs, err := ReadCRILog(ctx, path, providers.ContainerLogOpts{Follow: true}, func() bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	read := make(chan string, 1)
+	go func() {
+		out, _ := ioutil.ReadAll(logs)
+		read <- string(out)
+	}()
+	cancel()
+	select {
+	case out := <-read:
+		if out != "zero\n" {
+			t.Fatalf("got %q", out)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("following didn't stop with the request")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var baseSubnetIP int
@@ -13,20 +14,41 @@ var subnetMask int
 var gatewayIP string
 
 var usedAddresses map[int]string
+var addressLock sync.Mutex
 
 func InitContainerNetworking(nodeSubnet string, subMask string) {
+	addressLock.Lock()
+	defer addressLock.Unlock()
 	subnetMask, _ = strconv.Atoi(subMask)
 	baseSubnetIP, _ = IPStringToInt(nodeSubnet)
 	maxSubnetIP = baseSubnetIP + int(math.Pow(2, float64(subnetMask)))
 	gatewayIP, _ = IPIntToString(baseSubnetIP + 1)
-	usedAddresses = make(map[int]string)
+	//addresses of pods that were adopted after a restart may already be reserved
+	if usedAddresses == nil {
+		usedAddresses = make(map[int]string)
+	}
+}
+
+// PodAddressCapacity returns how many pods can get an address in the node subnet, 0 before networking is set up
+func PodAddressCapacity() int {
+	addressLock.Lock()
+	defer addressLock.Unlock()
+	//the network and gateway addresses aren't handed out
+	if capacity := maxSubnetIP - baseSubnetIP - 2; capacity > 0 {
+		return capacity
+	}
+	return 0
 }
 
 func RequestIP(namespace string, pod string) (string, error) {
+	addressLock.Lock()
+	defer addressLock.Unlock()
 	freeIP := baseSubnetIP + 2
 	podName := namespace + "_" + pod
-	_, taken := usedAddresses[freeIP]
-	for taken {
+	for {
+		if _, taken := usedAddresses[freeIP]; !taken {
+			break
+		}
 		freeIP++
 	}
 	if freeIP < maxSubnetIP {
@@ -38,7 +60,25 @@ func RequestIP(namespace string, pod string) (string, error) {
 	}
 }
 
+// ReserveIP marks the address of a pod that already has one as used, e.g. a pod that was adopted after a restart
+func ReserveIP(namespace string, pod string, ip string) error {
+	addressLock.Lock()
+	defer addressLock.Unlock()
+	ipInt, _ := IPStringToInt(ip)
+	podName := namespace + "_" + pod
+	if owner, taken := usedAddresses[ipInt]; taken && owner != podName {
+		return errors.New("IP address " + ip + " is already used by pod " + owner)
+	}
+	if usedAddresses == nil {
+		usedAddresses = make(map[int]string)
+	}
+	usedAddresses[ipInt] = podName
+	return nil
+}
+
 func FreeIP(namespace string, pod string) {
+	addressLock.Lock()
+	defer addressLock.Unlock()
 	var foundIp int = 0
 	podName := namespace + "_" + pod
 	for ip, cName := range usedAddresses {
@@ -1,7 +1,9 @@
 package vkube
 
 import (
+	"context"
 	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
 	"fmt"
 	"io"
 	"regexp"
@@ -10,6 +12,8 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
 var reInsideWhtsp = regexp.MustCompile(`\s+`)
@@ -20,13 +24,18 @@ type ContainerRuntimeInterface interface {
 	//Init() ContainerRuntimeInterface
 	GetContainerName(namespace string, pod v1.Pod, dc v1.Container) string
 	GetContainerNameAlt(namespace string, podName string, dcName string) string
-	DeployPod(pod *v1.Pod)
+	//DeployPod and UpdatePod return a providers.ImagePullError, CreateContainerError or RunContainerError
+	//when a container couldn't be started
+	DeployPod(pod *v1.Pod) error
 	DeployContainer(namespace string, pod *v1.Pod, dc *v1.Container) (string, error)
-	UpdatePod(pod *v1.Pod)
-	DeletePod(pod *v1.Pod)
+	UpdatePod(pod *v1.Pod) error
+	//DeletePod returns an error when a container couldn't be stopped, the pod is kept then so the deletion can be retried
+	DeletePod(pod *v1.Pod) error
 	GetPod(namespace string, name string) (*v1.Pod, bool)
 	GetPods() []*v1.Pod
-	FetchContainerLogs(namespace string, podName string, containerName string, tail string, timestamps bool) *io.ReadCloser
+	FetchContainerLogs(ctx context.Context, namespace string, podName string, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error)
+	ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error
+	AttachToContainer(name string, uid types.UID, container string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error
 	ShutdownPods()
 	PodsChanged() bool
 	ResetFlags()
@@ -68,7 +77,7 @@ func IgnoreKubeProxy(pod *v1.Pod) {
 	pod.Status.Conditions = append(pod.Status.Conditions, cond)
 }
 
-func UpdatePodStatus(podStatus v1.PodCondition, containerStatuses []v1.ContainerStatus, pod *v1.Pod, noErrors bool, allContainersRunning bool, allContainersDone bool) bool {
+func UpdatePodStatus(containerStatuses []v1.ContainerStatus, pod *v1.Pod, noErrors bool, allContainersRunning bool, allContainersDone bool) bool {
 	pod.Status.ContainerStatuses = containerStatuses
 
 	changed := false
@@ -95,55 +104,101 @@ func UpdatePodStatus(podStatus v1.PodCondition, containerStatuses []v1.Container
 		changed = true
 	}
 
-	if allContainersRunning && podStatus.Type != v1.PodReady {
-		cond := v1.PodCondition{
-			Type:               v1.PodReady,
-			Status:             v1.ConditionTrue,
-			LastProbeTime:      metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "Running",
-			Message:            "All containers up and running",
+	//a pod is ready while all its containers run and pass their readiness probes
+	unready := []string{}
+	for _, status := range containerStatuses {
+		if !status.Ready {
+			unready = append(unready, status.Name)
 		}
-		pod.Status.Conditions = append(pod.Status.Conditions, cond)
-
-		cond = v1.PodCondition{
-			Type:               v1.ContainersReady,
-			Status:             v1.ConditionTrue,
-			LastProbeTime:      metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "Running",
-			Message:            "All containers up and running",
+	}
+	cond := v1.PodCondition{
+		Status:  v1.ConditionTrue,
+		Reason:  "Running",
+		Message: "All containers up and running",
+	}
+	if !allContainersRunning || len(unready) > 0 {
+		cond.Status = v1.ConditionFalse
+		cond.Reason = "ContainersNotReady"
+		cond.Message = fmt.Sprintf("containers with unready status: [%s]", strings.Join(unready, " "))
+	}
+	for _, condType := range []v1.PodConditionType{v1.ContainersReady, v1.PodReady} {
+		cond.Type = condType
+		if SetPodCondition(pod, cond) {
+			changed = true
 		}
-		pod.Status.Conditions = append(pod.Status.Conditions, cond)
-		changed = true
 	}
 	return changed
 }
 
-func UpdateInitPodStatus(pod *v1.Pod, noErrors bool, allContainersDone bool) {
-	pod.Status.Phase = v1.PodPending
-	if noErrors && allContainersDone {
-		//set initialized status + true
-		conditions := []v1.PodCondition{}
-		for _, cond := range pod.Status.Conditions {
-			if cond.Type != v1.PodInitialized {
-				conditions = append(conditions, cond)
-			}
-			initCond := v1.PodCondition{
-				Type:               v1.PodInitialized,
-				Status:             v1.ConditionTrue,
-				LastProbeTime:      metav1.Now(),
-				LastTransitionTime: metav1.Now(),
-				Reason:             "Initialized",
-				Message:            "Init containers done",
-			}
-			conditions = append(conditions, initCond)
+// SetPodCondition adds or replaces a condition of the pod, the transition time only changes along with its status
+func SetPodCondition(pod *v1.Pod, cond v1.PodCondition) bool {
+	now := metav1.Now()
+	cond.LastProbeTime = now
+	cond.LastTransitionTime = now
+	for i, existing := range pod.Status.Conditions {
+		if existing.Type != cond.Type {
+			continue
 		}
-		pod.Status.Conditions = conditions
-	} else {
+		if existing.Status == cond.Status && existing.Reason == cond.Reason && existing.Message == cond.Message {
+			return false
+		}
+		if existing.Status == cond.Status {
+			cond.LastTransitionTime = existing.LastTransitionTime
+		}
+		pod.Status.Conditions[i] = cond
+		return true
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, cond)
+	return true
+}
+
+// UpdateInitPodStatus sets the phase and Initialized condition of a pod that runs its init containers,
+// incomplete are the init containers that didn't complete yet and failed is set once one of them failed for good
+func UpdateInitPodStatus(pod *v1.Pod, failed bool, incomplete []string) bool {
+	curPhase := pod.Status.Phase
+	if failed {
 		pod.Status.Phase = v1.PodFailed
 		pod.Status.Reason = "Failed"
-		pod.Status.Message = "Container errors detected"
+		pod.Status.Message = "Init container errors detected"
+	} else {
+		pod.Status.Phase = v1.PodPending
+		pod.Status.Reason = "Initializing"
+		pod.Status.Message = "Running init containers"
+	}
+	changed := curPhase != pod.Status.Phase
+
+	cond := v1.PodCondition{
+		Type:    v1.PodInitialized,
+		Status:  v1.ConditionTrue,
+		Reason:  "Initialized",
+		Message: "Init containers done",
+	}
+	if len(incomplete) > 0 {
+		cond.Status = v1.ConditionFalse
+		cond.Reason = "ContainersNotInitialized"
+		cond.Message = fmt.Sprintf("containers with incomplete status: [%s]", strings.Join(incomplete, " "))
+	}
+	if SetPodCondition(pod, cond) {
+		changed = true
+	}
+	return changed
+}
+
+// WaitingContainerStatus is the status of a container that wasn't created yet
+func WaitingContainerStatus(cont v1.Container, reason string, message string) v1.ContainerStatus {
+	return v1.ContainerStatus{
+		Name: cont.Name,
+		State: v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{
+				Reason:  reason,
+				Message: message,
+			},
+		},
+		Ready:        false,
+		RestartCount: 0,
+		Image:        cont.Image,
+		ImageID:      "",
+		Started:      new(bool),
 	}
 }
 
@@ -153,25 +208,14 @@ func UpdatePostCreationPodStatus(pod *v1.Pod, initContainers bool) {
 	time := metav1.Now()
 	pod.Status.StartTime = &time
 
+	//app containers wait for the init containers to complete
+	waitingReason, waitingMessage := "Starting", "Starting container"
+	if initContainers {
+		waitingReason, waitingMessage = "PodInitializing", ""
+	}
 	containerStatuses := []v1.ContainerStatus{}
 	for _, cont := range pod.Spec.Containers {
-		state := v1.ContainerState{
-			Waiting: &v1.ContainerStateWaiting{
-				Reason:  "Starting",
-				Message: "Starting container",
-			},
-		}
-
-		status := v1.ContainerStatus{
-			Name:         cont.Name,
-			State:        state,
-			Ready:        false,
-			RestartCount: 0,
-			Image:        cont.Image,
-			ImageID:      "",
-		}
-
-		containerStatuses = append(containerStatuses, status)
+		containerStatuses = append(containerStatuses, WaitingContainerStatus(cont, waitingReason, waitingMessage))
 	}
 	pod.Status.ContainerStatuses = containerStatuses
 
@@ -0,0 +1,230 @@
+package vkube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// containerStdio connects the stdio of a container's tasks to its log and to the clients attached to it,
+// every task gets its own taskStdio
+type containerStdio struct {
+	ctx       context.Context
+	tty       bool
+	stdin     bool
+	stdinOnce bool
+
+	lock    sync.Mutex
+	current *taskStdio
+}
+
+// taskStdio copies the output of a task to its log and the attached clients, and their input to its stdin
+type taskStdio struct {
+	parent *containerStdio
+	task   containerd.Task
+
+	lock    sync.Mutex
+	clients map[*attachClient]bool
+	stdin   *io.PipeWriter
+
+	//closed once the output of the task ended
+	done      chan struct{}
+	closeOnce sync.Once
+	stdinOnce sync.Once
+}
+
+type attachClient struct {
+	stdout io.Writer
+	stderr io.Writer
+	//closed when writing to the client failed
+	failed   chan struct{}
+	failOnce sync.Once
+}
+
+func newContainerStdio(ctx context.Context, dc *v1.Container) *containerStdio {
+	return &containerStdio{
+		ctx:       ctx,
+		tty:       dc.TTY,
+		stdin:     dc.Stdin,
+		stdinOnce: dc.StdinOnce,
+	}
+}
+
+// newTask returns the stdio of a new task and the creator of its IO, output is written to logs
+func (s *containerStdio) newTask(logs *CRILogWriter) (*taskStdio, cio.Creator) {
+	ts, opts := s.taskIO(logs)
+	return ts, cio.NewCreator(opts...)
+}
+
+// attachTask returns the stdio of a task that was started before fledge restarted and the attacher to its IO
+func (s *containerStdio) attachTask(logs *CRILogWriter) (*taskStdio, cio.Attach) {
+	ts, opts := s.taskIO(logs)
+	return ts, cio.NewAttach(opts...)
+}
+
+func (s *containerStdio) taskIO(logs *CRILogWriter) (*taskStdio, []cio.Opt) {
+	ts := &taskStdio{
+		parent:  s,
+		clients: make(map[*attachClient]bool),
+		done:    make(chan struct{}),
+	}
+	var stdin io.Reader
+	if s.stdin {
+		stdin, ts.stdin = io.Pipe()
+	}
+	stdout := &stdioWriter{ts: ts, log: logs.Stdout()}
+	if s.tty {
+		//a terminal merges stderr into stdout
+		return ts, []cio.Opt{cio.WithStreams(stdin, stdout, nil), cio.WithTerminal}
+	}
+	stderr := &stdioWriter{ts: ts, log: logs.Stderr(), stderr: true}
+	return ts, []cio.Opt{cio.WithStreams(stdin, stdout, stderr)}
+}
+
+// setTask makes ts the stdio clients attach to, once its task was started
+func (s *containerStdio) setTask(ts *taskStdio, task containerd.Task) {
+	ts.task = task
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current = ts
+}
+
+func (s *containerStdio) currentTask() *taskStdio {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.current
+}
+
+// close detaches all clients, it is called when the output of the task ended
+func (ts *taskStdio) close() {
+	ts.closeOnce.Do(func() {
+		close(ts.done)
+		ts.closeStdin()
+	})
+}
+
+// closeAfterIO closes ts once all output of its task was copied
+func (ts *taskStdio) closeAfterIO() {
+	if ts.task != nil {
+		if taskIO := ts.task.IO(); taskIO != nil {
+			taskIO.Wait()
+		}
+	}
+	ts.close()
+}
+
+// closeStdin ends the input of the task, the task only sees it once containerd closed its side as well
+func (ts *taskStdio) closeStdin() {
+	if ts.stdin == nil {
+		return
+	}
+	ts.stdinOnce.Do(func() {
+		ts.stdin.Close()
+		if ts.task != nil {
+			ts.task.CloseIO(ts.parent.ctx, containerd.WithStdinCloser)
+		}
+	})
+}
+
+func (ts *taskStdio) addClient(client *attachClient) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.clients[client] = true
+}
+
+func (ts *taskStdio) removeClient(client *attachClient) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	delete(ts.clients, client)
+}
+
+// stdioWriter writes one output stream of a task to its log and every attached client
+type stdioWriter struct {
+	ts     *taskStdio
+	log    io.Writer
+	stderr bool
+}
+
+func (w *stdioWriter) Write(p []byte) (int, error) {
+	n, err := w.log.Write(p)
+
+	w.ts.lock.Lock()
+	defer w.ts.lock.Unlock()
+	for client := range w.ts.clients {
+		out := client.stdout
+		if w.stderr {
+			out = client.stderr
+		}
+		if out == nil {
+			continue
+		}
+		if _, werr := out.Write(p); werr != nil {
+			delete(w.ts.clients, client)
+			client.failOnce.Do(func() { close(client.failed) })
+		}
+	}
+	return n, err
+}
+
+// AttachToContainer connects to the stdio of the container's running task until the task stops,
+// the client's input ends or writing to the client fails. Input is dropped if the container has no stdin.
+func (dri *ContainerdRuntimeInterface) AttachToContainer(name string, uid types.UID, container string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	pc := dri.findContainer(name, uid, container)
+	if pc == nil {
+		return strongerrors.NotFound(errors.Errorf("container %s not found in pod %s", container, name))
+	}
+	pc.lock.Lock()
+	running := pc.task != nil && !pc.exited && !pc.stopping
+	stdio := pc.stdio
+	pc.lock.Unlock()
+	ts := stdio.currentTask()
+	if !running || ts == nil {
+		return strongerrors.Conflict(errors.Errorf("container %s in pod %s is not running", container, name))
+	}
+
+	client := &attachClient{failed: make(chan struct{})}
+	if out != nil {
+		client.stdout = out
+	}
+	if errOut != nil && !stdio.tty {
+		client.stderr = errOut
+	}
+	ts.addClient(client)
+	defer ts.removeClient(client)
+
+	stdinDone := make(chan struct{})
+	if in != nil && ts.stdin != nil {
+		go func() {
+			defer close(stdinDone)
+			io.Copy(ts.stdin, in)
+			if stdio.stdinOnce {
+				ts.closeStdin()
+			}
+		}()
+	}
+	if tty && stdio.tty && resize != nil {
+		go func() {
+			for size := range resize {
+				if err := ts.task.Resize(dri.ctx, uint32(size.Width), uint32(size.Height)); err != nil {
+					fmt.Printf("Failed to resize terminal of container %s: %s\n", container, err.Error())
+				}
+			}
+		}()
+	}
+
+	select {
+	case <-ts.done:
+	case <-client.failed:
+	case <-stdinDone:
+	}
+	return nil
+}
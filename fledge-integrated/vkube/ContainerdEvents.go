@@ -0,0 +1,215 @@
+package vkube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd"
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/typeurl"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	//the event subscription does the actual work, the resync only catches what it missed
+	containerdResyncInterval = 30 * time.Second
+	//wait before subscribing again after the event stream broke, e.g. when containerd restarted
+	containerdResubscribeDelay = time.Second
+)
+
+var containerdEventFilters = []string{
+	`topic=="/tasks/start"`,
+	`topic=="/tasks/exit"`,
+	`topic=="/tasks/oom"`,
+}
+
+// NotifyPods registers a callback that is called whenever the pods changed, it must not block
+func (dri *ContainerdRuntimeInterface) NotifyPods(ctx context.Context, notify func()) {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	dri.notify = notify
+}
+
+// setPodsChanged flags the pods as changed and tells the registered callback about it
+func (dri *ContainerdRuntimeInterface) setPodsChanged() {
+	dri.lock.Lock()
+	dri.podsChanged = true
+	notify := dri.notify
+	dri.lock.Unlock()
+
+	if notify != nil {
+		notify()
+	}
+}
+
+// EventLoop subscribes to the task events of containerd and updates the pods on every event,
+// the subscription is renewed when it fails
+func (dri *ContainerdRuntimeInterface) EventLoop() {
+	if dri.client == nil {
+		return
+	}
+	for {
+		ctx, cancel := context.WithCancel(dri.ctx)
+		eventC, errC := dri.client.Subscribe(ctx, containerdEventFilters...)
+
+		//events may have been missed while there was no subscription
+		dri.Resync()
+
+		err := dri.handleEvents(eventC, errC)
+		cancel()
+		fmt.Printf("Containerd event subscription failed, subscribing again: %s\n", err.Error())
+		time.Sleep(containerdResubscribeDelay)
+	}
+}
+
+// PollLoop periodically resyncs all pods as a safety net for missed events
+func (dri *ContainerdRuntimeInterface) PollLoop() {
+	for {
+		time.Sleep(containerdResyncInterval)
+		dri.Resync()
+	}
+}
+
+// Resync handles the exits of tasks that weren't reported by an event and updates the status of every pod
+func (dri *ContainerdRuntimeInterface) Resync() {
+	for _, pod := range dri.GetPods() {
+		for _, pc := range dri.getPodContainers(pod) {
+			dri.checkTaskExit(pc)
+		}
+		dri.refreshPodStatus(pod)
+	}
+}
+
+func (dri *ContainerdRuntimeInterface) handleEvents(eventC <-chan *events.Envelope, errC <-chan error) error {
+	namespace, _ := namespaces.Namespace(dri.ctx)
+	for {
+		select {
+		case err := <-errC:
+			if err == nil {
+				err = errors.New("event stream closed")
+			}
+			return err
+		case envelope := <-eventC:
+			if envelope == nil || envelope.Event == nil || envelope.Namespace != namespace {
+				continue
+			}
+			event, err := typeurl.UnmarshalAny(envelope.Event)
+			if err != nil {
+				fmt.Printf("Failed to decode containerd event %s: %s\n", envelope.Topic, err.Error())
+				continue
+			}
+			dri.handleEvent(event)
+		}
+	}
+}
+
+func (dri *ContainerdRuntimeInterface) handleEvent(event interface{}) {
+	switch e := event.(type) {
+	case *apievents.TaskStart:
+		pc := dri.getPodContainer(e.ContainerID)
+		if pc == nil {
+			return
+		}
+		fmt.Printf("Container %s started with pid %d\n", e.ContainerID, e.Pid)
+		dri.refreshPodStatus(pc.pod)
+		dri.setPodsChanged()
+	case *apievents.TaskExit:
+		//exec processes report their exits with their own id
+		if e.ID != e.ContainerID {
+			return
+		}
+		pc := dri.getPodContainer(e.ContainerID)
+		if pc == nil {
+			return
+		}
+		if dri.handleTaskExit(e.ContainerID, pc, e.Pid, int32(e.ExitStatus), e.ExitedAt) {
+			dri.refreshPodStatus(pc.pod)
+		}
+	case *apievents.TaskOOM:
+		pc := dri.getPodContainer(e.ContainerID)
+		if pc == nil {
+			return
+		}
+		fmt.Printf("Container %s ran out of memory\n", e.ContainerID)
+		pc.lock.Lock()
+		pc.oomKilled = true
+		pc.lock.Unlock()
+	}
+}
+
+// handleTaskExit handles the exit of the container's current task once, it returns false for exits of
+// previous tasks, exits that were already handled and exits caused by stopping the container
+func (dri *ContainerdRuntimeInterface) handleTaskExit(fullName string, pc *PodContainer, pid uint32, exitCode int32, exitedAt time.Time) bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	if pc.stopping || pc.exited || pc.task == nil || pc.task.Pid() != pid {
+		return false
+	}
+	pc.exited = true
+	fmt.Printf("Container %s exited with code %d\n", fullName, exitCode)
+	dri.prober.StopContainer(fullName)
+	//attached clients are detached once they got all output
+	if ts := pc.stdio.currentTask(); ts != nil {
+		go ts.closeAfterIO()
+	}
+	dri.handleExit(fullName, pc, pc.terminatedState(exitCode, exitedAt, ""))
+	return true
+}
+
+// checkTaskExit asks containerd whether the container's task stopped, for exits that happened without an event
+func (dri *ContainerdRuntimeInterface) checkTaskExit(pc *PodContainer) {
+	pc.lock.Lock()
+	task := pc.task
+	pc.lock.Unlock()
+	if task == nil {
+		return
+	}
+	status, err := task.Status(dri.ctx)
+	if err != nil || status.Status != containerd.Stopped {
+		return
+	}
+	dri.handleTaskExit(pc.container.ID(), pc, task.Pid(), int32(status.ExitStatus), status.ExitTime)
+}
+
+// refreshPodStatus queues a status update of the pod in its pod worker, it doesn't wait for it
+func (dri *ContainerdRuntimeInterface) refreshPodStatus(pod *v1.Pod) {
+	namespace, name := pod.ObjectMeta.Namespace, pod.ObjectMeta.Name
+	dri.workers.sync(namespace+"_"+name, func() {
+		dri.syncPodStatus(namespace, name)
+	})
+}
+
+// syncPodStatus updates the status of a copy of the stored pod and stores it, it runs in the pod worker
+func (dri *ContainerdRuntimeInterface) syncPodStatus(namespace string, name string) {
+	pod, found := dri.GetPod(namespace, name)
+	if !found {
+		return
+	}
+	dri.UpdatePodStatus(namespace, pod)
+	dri.setPodSpec(pod)
+}
+
+func (dri *ContainerdRuntimeInterface) getPodContainer(fullName string) *PodContainer {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	return dri.containerNameTaskMapping[fullName]
+}
+
+// getPodContainers returns the containers of the pod that have been deployed
+func (dri *ContainerdRuntimeInterface) getPodContainers(pod *v1.Pod) []*PodContainer {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	pcs := []*PodContainer{}
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, cont := range containers {
+			if pc, found := dri.containerNameTaskMapping[dri.GetContainerName(pod.ObjectMeta.Namespace, *pod, cont)]; found {
+				pcs = append(pcs, pc)
+			}
+		}
+	}
+	return pcs
+}
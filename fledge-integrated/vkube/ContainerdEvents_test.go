@@ -0,0 +1,191 @@
+package vkube
+
+import (
+	"testing"
+	"time"
+
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/typeurl"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// eventStream feeds events to handleEvents like a containerd subscription
+type eventStream struct {
+	t      *testing.T
+	eventC chan *events.Envelope
+	errC   chan error
+	done   chan error
+}
+
+func newEventStream(t *testing.T, dri *ContainerdRuntimeInterface) *eventStream {
+	s := &eventStream{t: t, eventC: make(chan *events.Envelope), errC: make(chan error), done: make(chan error, 1)}
+	go func() {
+		s.done <- dri.handleEvents(s.eventC, s.errC)
+	}()
+	return s
+}
+
+// send returns once the event was received, since events are handled in order the previous one was handled by then
+func (s *eventStream) send(namespace string, topic string, event interface{}) {
+	s.t.Helper()
+	payload, err := typeurl.MarshalAny(event)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.eventC <- &events.Envelope{Timestamp: time.Now(), Namespace: namespace, Topic: topic, Event: payload}
+}
+
+// close ends the stream, which has handled all events once it returns
+func (s *eventStream) close() {
+	s.t.Helper()
+	s.errC <- errors.New("closed")
+	if err := <-s.done; err == nil || err.Error() != "closed" {
+		s.t.Fatalf("handleEvents returned %v, want the stream error", err)
+	}
+}
+
+// storeTestPod stores the pod as initialized, so status updates report its containers
+func storeTestPod(dri *ContainerdRuntimeInterface, pod *v1.Pod) {
+	pod.Status.Conditions = []v1.PodCondition{
+		{Type: v1.PodScheduled, Status: v1.ConditionTrue},
+		{Type: v1.PodInitialized, Status: v1.ConditionTrue},
+	}
+	dri.setPodSpec(pod)
+}
+
+func storedContainerStatus(t *testing.T, dri *ContainerdRuntimeInterface, pod *v1.Pod) v1.ContainerStatus {
+	t.Helper()
+	waitIdle(t, dri.workers)
+	stored, found := dri.GetPod(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	if !found || len(stored.Status.ContainerStatuses) != 1 {
+		t.Fatalf("pod status wasn't updated: %+v", stored)
+	}
+	return stored.Status.ContainerStatuses[0]
+}
+
+func TestTaskExitEvent(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyNever, "app")
+	storeTestPod(dri, pod)
+	task := newFakeTask("app", 42)
+	fullName, pc := addTestContainer(dri, pod, "app", task)
+	notified := make(chan struct{}, 10)
+	dri.NotifyPods(dri.ctx, func() { notified <- struct{}{} })
+
+	stream := newEventStream(t, dri)
+	//events of other namespaces, of exec processes and of previous tasks are ignored
+	stream.send("other", "/tasks/exit", &apievents.TaskExit{ContainerID: fullName, ID: fullName, Pid: 42, ExitStatus: 1})
+	stream.send("default", "/tasks/exit", &apievents.TaskExit{ContainerID: fullName, ID: "exec-1", Pid: 50, ExitStatus: 1})
+	stream.send("default", "/tasks/exit", &apievents.TaskExit{ContainerID: fullName, ID: fullName, Pid: 41, ExitStatus: 1})
+	stream.send("default", "/tasks/exit", &apievents.TaskExit{ContainerID: "unknown", ID: "unknown", Pid: 42, ExitStatus: 1})
+	stream.send("default", "/tasks/start", &apievents.TaskStart{ContainerID: fullName, Pid: 42})
+	stream.close()
+	if pc.exited || pc.terminated != nil {
+		t.Fatal("ignored exit was handled")
+	}
+	if status := storedContainerStatus(t, dri, pod); status.State.Running == nil {
+		t.Fatalf("state %+v after the start, want running", status.State)
+	}
+	select {
+	case <-notified:
+	default:
+		t.Fatal("start didn't notify")
+	}
+
+	task.exit(1)
+	stream = newEventStream(t, dri)
+	stream.send("default", "/tasks/exit", &apievents.TaskExit{ContainerID: fullName, ID: fullName, Pid: 42, ExitStatus: 1, ExitedAt: time.Now()})
+	stream.close()
+	status := storedContainerStatus(t, dri, pod)
+	if status.State.Terminated == nil || status.State.Terminated.ExitCode != 1 || status.State.Terminated.Reason != "Error" {
+		t.Fatalf("state %+v after the exit, want terminated with an error", status.State)
+	}
+}
+
+func TestTaskOOMEvent(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	task := newFakeTask("app", 42)
+	fullName, pc := addTestContainer(dri, pod, "app", task)
+
+	task.exit(137)
+	stream := newEventStream(t, dri)
+	stream.send("default", "/tasks/oom", &apievents.TaskOOM{ContainerID: fullName})
+	stream.send("default", "/tasks/exit", &apievents.TaskExit{ContainerID: fullName, ID: fullName, Pid: 42, ExitStatus: 137, ExitedAt: time.Now()})
+	stream.close()
+
+	status := dri.GetContainerStatus(pod.Spec.Containers[0], pc)
+	if status.State.Waiting == nil || status.State.Waiting.Reason != "CrashLoopBackOff" {
+		t.Fatalf("state %+v, want CrashLoopBackOff", status.State)
+	}
+	if last := status.LastTerminationState.Terminated; last == nil || last.Reason != "OOMKilled" || last.ExitCode != 137 {
+		t.Fatalf("last termination %+v, want OOMKilled", last)
+	}
+}
+
+func TestEventStreamClosed(t *testing.T) {
+	dri := newTestContainerd(t)
+	errC := make(chan error)
+	close(errC)
+	if err := dri.handleEvents(make(chan *events.Envelope), errC); err == nil {
+		t.Fatal("closed stream didn't fail")
+	}
+}
+
+func TestExitBeforeTaskStored(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyNever, "app")
+	fullName, pc := addTestContainer(dri, pod, "app", nil)
+
+	//the task exits while it is being started, before it is stored in the container
+	task := newFakeTask("app", 42)
+	task.exit(3)
+	stream := newEventStream(t, dri)
+	stream.send("default", "/tasks/exit", &apievents.TaskExit{ContainerID: fullName, ID: fullName, Pid: 42, ExitStatus: 3, ExitedAt: time.Now()})
+	stream.close()
+	if pc.exited {
+		t.Fatal("exit of a task that wasn't stored was handled")
+	}
+
+	pc.lock.Lock()
+	pc.task = task
+	pc.lock.Unlock()
+	dri.taskStarted(fullName, pc, task)
+
+	if status := dri.GetContainerStatus(pod.Spec.Containers[0], pc); status.State.Terminated == nil || status.State.Terminated.ExitCode != 3 {
+		t.Fatalf("state %+v, want terminated with 3", status.State)
+	}
+	if dri.prober.Started(fullName) {
+		t.Fatal("probes of the exited task are still running")
+	}
+}
+
+func TestResyncHandlesMissedExits(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyOnFailure, "app")
+	storeTestPod(dri, pod)
+	task := newFakeTask("app", 42)
+	_, pc := addTestContainer(dri, pod, "app", task)
+
+	dri.Resync()
+	if status := storedContainerStatus(t, dri, pod); status.State.Running == nil {
+		t.Fatalf("state %+v, want running", status.State)
+	}
+
+	//the exit event got lost, e.g. while fledge resubscribed
+	task.exit(0)
+	dri.Resync()
+	if !pc.exited {
+		t.Fatal("resync didn't handle the exit")
+	}
+	if status := storedContainerStatus(t, dri, pod); status.State.Terminated == nil || status.State.Terminated.Reason != "Completed" {
+		t.Fatalf("state %+v, want completed", status.State)
+	}
+	//the exit is handled once
+	dri.Resync()
+	if pc.lastTermination != nil {
+		t.Fatal("exit was handled again")
+	}
+}
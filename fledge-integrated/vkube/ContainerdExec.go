@@ -0,0 +1,180 @@
+package vkube
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+// ExecInContainer runs a command in the running task of a container, the pod is looked up by uid or by its pod key.
+// A command that exits with a non-zero code returns an exec.CodeExitError.
+func (dri *ContainerdRuntimeInterface) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	pc := dri.findContainer(name, uid, container)
+	if pc == nil {
+		return strongerrors.NotFound(errors.Errorf("container %s not found in pod %s", container, name))
+	}
+	return dri.execInPodContainer(pc, cmd, in, out, errOut, tty, resize, timeout)
+}
+
+// execInPodContainer runs a command in the running task of the container, like ExecInContainer
+func (dri *ContainerdRuntimeInterface) execInPodContainer(pc *PodContainer, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	container := pc.containerName
+	pc.lock.Lock()
+	task := pc.task
+	//a stopping container still runs its preStop hook
+	running := task != nil && !pc.exited
+	pc.lock.Unlock()
+	if !running {
+		return strongerrors.Conflict(errors.Errorf("container %s in pod %s is not running", container, pc.podName))
+	}
+
+	ctx, cancel := context.WithCancel(dri.ctx)
+	defer cancel()
+	spec, err := pc.container.Spec(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get container spec")
+	}
+	pspec := *spec.Process
+	pspec.Args = cmd
+	pspec.Terminal = tty
+
+	//stdin has to be closed in containerd as well, or the command never sees the end of its input
+	stdin := &execStdin{reader: in, started: make(chan struct{})}
+	var stdinReader io.Reader
+	if in != nil {
+		stdinReader = stdin
+	}
+	var stdout, stderr io.Writer
+	if out != nil {
+		stdout = out
+	}
+	if errOut != nil && !tty {
+		stderr = errOut
+	}
+	opts := []cio.Opt{cio.WithStreams(stdinReader, stdout, stderr)}
+	if tty {
+		opts = append(opts, cio.WithTerminal)
+	}
+
+	execID, err := newExecID()
+	if err != nil {
+		return err
+	}
+	process, err := task.Exec(ctx, execID, &pspec, cio.NewCreator(opts...))
+	if err != nil {
+		stdin.start(func() {})
+		return errors.Wrap(err, "failed to create exec process")
+	}
+	defer process.Delete(dri.ctx, containerd.WithProcessKill)
+	stdin.start(func() {
+		process.CloseIO(dri.ctx, containerd.WithStdinCloser)
+	})
+
+	statusC, err := process.Wait(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to wait for exec process")
+	}
+	if err := process.Start(ctx); err != nil {
+		return errors.Wrap(err, "failed to start exec process")
+	}
+
+	if tty && resize != nil {
+		go func() {
+			for size := range resize {
+				if err := process.Resize(ctx, uint32(size.Width), uint32(size.Height)); err != nil {
+					fmt.Printf("Failed to resize terminal of exec in %s: %s\n", container, err.Error())
+				}
+			}
+		}()
+	}
+
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	var status containerd.ExitStatus
+	select {
+	case status = <-statusC:
+	case <-timeoutC:
+		process.Kill(dri.ctx, syscall.SIGKILL)
+		<-statusC
+		return errors.Errorf("command timed out after %s", timeout)
+	}
+	//all output has to be copied before the process is deleted
+	process.IO().Wait()
+
+	code, _, err := status.Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to get exit status of exec process")
+	}
+	if code != 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with exit code %d", code),
+			Code: int(code),
+		}
+	}
+	return nil
+}
+
+// findContainer returns the container by the pod key in name, or by pod uid if it is set
+func (dri *ContainerdRuntimeInterface) findContainer(name string, uid types.UID, container string) *PodContainer {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	for key, pod := range dri.podSpecs {
+		if (uid != "" && pod.ObjectMeta.UID == uid) || key == name {
+			return dri.containerNameTaskMapping[dri.GetContainerNameAlt(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, container)]
+		}
+	}
+	return nil
+}
+
+func newExecID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate exec id")
+	}
+	return "exec-" + hex.EncodeToString(id), nil
+}
+
+// execStdin closes the stdin of the exec process once the client's input ends,
+// which can happen before the process was created
+type execStdin struct {
+	reader  io.Reader
+	started chan struct{}
+	closer  func()
+	once    sync.Once
+}
+
+func (s *execStdin) start(closer func()) {
+	s.closer = closer
+	close(s.started)
+}
+
+func (s *execStdin) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if err == io.EOF {
+		s.once.Do(func() {
+			go func() {
+				<-s.started
+				s.closer()
+			}()
+		})
+	}
+	return n, err
+}
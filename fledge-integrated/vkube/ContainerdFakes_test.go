@@ -0,0 +1,259 @@
+package vkube
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeTask is a containerd task whose state is set by the test, methods the runtime doesn't use aren't implemented
+type fakeTask struct {
+	containerd.Task
+	id  string
+	pid uint32
+	//the task exits with 128 + signal on these signals, SIGKILL always ends it
+	exitOn []syscall.Signal
+
+	lock    sync.Mutex
+	status  containerd.Status
+	signals []signalAt
+	deleted bool
+	exited  chan struct{}
+}
+
+type signalAt struct {
+	signal syscall.Signal
+	at     time.Time
+}
+
+func newFakeTask(id string, pid uint32, exitOn ...syscall.Signal) *fakeTask {
+	return &fakeTask{
+		id:     id,
+		pid:    pid,
+		exitOn: exitOn,
+		status: containerd.Status{Status: containerd.Running},
+		exited: make(chan struct{}),
+	}
+}
+
+func (t *fakeTask) ID() string {
+	return t.id
+}
+
+func (t *fakeTask) Pid() uint32 {
+	return t.pid
+}
+
+func (t *fakeTask) Start(ctx context.Context) error {
+	return nil
+}
+
+func (t *fakeTask) IO() cio.IO {
+	return nil
+}
+
+func (t *fakeTask) Status(ctx context.Context) (containerd.Status, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status, nil
+}
+
+func (t *fakeTask) Wait(ctx context.Context) (<-chan containerd.ExitStatus, error) {
+	statusC := make(chan containerd.ExitStatus, 1)
+	go func() {
+		<-t.exited
+		t.lock.Lock()
+		status := t.status
+		t.lock.Unlock()
+		statusC <- *containerd.NewExitStatus(status.ExitStatus, status.ExitTime, nil)
+	}()
+	return statusC, nil
+}
+
+func (t *fakeTask) Kill(ctx context.Context, signal syscall.Signal, opts ...containerd.KillOpts) error {
+	t.lock.Lock()
+	if t.status.Status == containerd.Stopped {
+		t.lock.Unlock()
+		return errdefs.ErrNotFound
+	}
+	t.signals = append(t.signals, signalAt{signal: signal, at: time.Now()})
+	exits := signal == syscall.SIGKILL
+	for _, s := range t.exitOn {
+		exits = exits || s == signal
+	}
+	t.lock.Unlock()
+	if exits {
+		t.exit(128 + uint32(signal))
+	}
+	return nil
+}
+
+func (t *fakeTask) Delete(ctx context.Context, opts ...containerd.ProcessDeleteOpts) (*containerd.ExitStatus, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.deleted = true
+	return containerd.NewExitStatus(t.status.ExitStatus, t.status.ExitTime, nil), nil
+}
+
+// exit stops the task with the exit code, it happens once
+func (t *fakeTask) exit(code uint32) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.status.Status == containerd.Stopped {
+		return
+	}
+	t.status = containerd.Status{Status: containerd.Stopped, ExitStatus: code, ExitTime: time.Now()}
+	close(t.exited)
+}
+
+func (t *fakeTask) receivedSignals() []signalAt {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]signalAt{}, t.signals...)
+}
+
+// fakeContainer is a containerd container with labels and an optional task
+type fakeContainer struct {
+	containerd.Container
+	id string
+
+	lock    sync.Mutex
+	labels  map[string]string
+	task    *fakeTask
+	tasks   int
+	deleted bool
+}
+
+func newFakeContainer(id string, labels map[string]string, task *fakeTask) *fakeContainer {
+	copied := map[string]string{}
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return &fakeContainer{id: id, labels: copied, task: task}
+}
+
+func (c *fakeContainer) ID() string {
+	return c.id
+}
+
+func (c *fakeContainer) Labels(ctx context.Context) (map[string]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	labels := map[string]string{}
+	for key, value := range c.labels {
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func (c *fakeContainer) SetLabels(ctx context.Context, labels map[string]string) (map[string]string, error) {
+	c.lock.Lock()
+	for key, value := range labels {
+		c.labels[key] = value
+	}
+	c.lock.Unlock()
+	return c.Labels(ctx)
+}
+
+func (c *fakeContainer) Task(ctx context.Context, attach cio.Attach) (containerd.Task, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.task == nil {
+		return nil, errdefs.ErrNotFound
+	}
+	return c.task, nil
+}
+
+// NewTask starts a new running task with the next pid
+func (c *fakeContainer) NewTask(ctx context.Context, creator cio.Creator, opts ...containerd.NewTaskOpts) (containerd.Task, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tasks++
+	c.task = newFakeTask(c.id, uint32(1000+c.tasks), syscall.SIGTERM)
+	return c.task, nil
+}
+
+func (c *fakeContainer) Image(ctx context.Context) (containerd.Image, error) {
+	return nil, errdefs.ErrNotFound
+}
+
+func (c *fakeContainer) Delete(ctx context.Context, opts ...containerd.DeleteOpts) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deleted = true
+	return nil
+}
+
+func (c *fakeContainer) isDeleted() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.deleted
+}
+
+// newTestContainerd returns a runtime without a containerd client, its containers are added by the test
+func newTestContainerd(t *testing.T) *ContainerdRuntimeInterface {
+	config.Cfg = &config.Config{DeviceIP: "10.0.0.1"}
+	dri := &ContainerdRuntimeInterface{
+		ctx:                      namespaces.WithNamespace(context.Background(), "default"),
+		containerNameTaskMapping: make(map[string]*PodContainer),
+		sandboxes:                make(map[string]*podSandbox),
+		deployErrors:             make(map[string]error),
+		podSpecs:                 make(map[string]*v1.Pod),
+		workers:                  newPodWorkers(),
+		logs:                     NewContainerLogManager(config.ContainerdConfig{LogDir: t.TempDir()}),
+		prober:                   NewProber(),
+	}
+	//restarts that are still scheduled find their container gone
+	t.Cleanup(func() {
+		dri.lock.Lock()
+		dri.containerNameTaskMapping = make(map[string]*PodContainer)
+		dri.lock.Unlock()
+	})
+	return dri
+}
+
+func newContainerdTestPod(name string, policy v1.RestartPolicy, containers ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Spec:       v1.PodSpec{RestartPolicy: policy, HostNetwork: true},
+	}
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: container, Image: "docker.io/library/busybox:latest"})
+	}
+	return pod
+}
+
+// addTestContainer stores a container of the pod with task as its current task, like deployContainer does
+func addTestContainer(dri *ContainerdRuntimeInterface, pod *v1.Pod, name string, task *fakeTask) (string, *PodContainer) {
+	fullName := dri.GetContainerNameAlt(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, name)
+	dc := getContainerSpec(pod, name)
+	pc := &PodContainer{
+		podName:       pod.ObjectMeta.Name,
+		containerName: name,
+		container:     newFakeContainer(fullName, containerLabels(pod, name), task),
+		podLogDir:     PodLogDir(dri.logs.Dir(), pod),
+		stdio:         newContainerStdio(dri.ctx, dc),
+		pod:           pod.DeepCopy(),
+		restartPolicy: containerRestartPolicy(pod, name),
+		startedAt:     time.Now(),
+	}
+	if task != nil {
+		pc.task = task
+	}
+	dri.lock.Lock()
+	dri.containerNameTaskMapping[fullName] = pc
+	dri.lock.Unlock()
+	return fullName, pc
+}
@@ -0,0 +1,190 @@
+package vkube
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/images"
+	"fledge/fledge-integrated/manager"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// insecureRegistryClient talks https to registries without verifying their certificate
+var insecureRegistryClient = &http.Client{Transport: &http.Transport{
+	Proxy:           http.ProxyFromEnvironment,
+	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+}}
+
+// containerdPuller lets the image manager pull images into containerd
+type containerdPuller struct {
+	client *containerd.Client
+}
+
+func (p *containerdPuller) Present(ctx context.Context, ref string) (string, bool, error) {
+	image, err := p.client.GetImage(ctx, ref)
+	if errdefs.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return image.Target().Digest.String(), true, nil
+}
+
+// Resolve only fetches the manifest of the image, which is all that's needed to know whether it changed
+func (p *containerdPuller) Resolve(ctx context.Context, ref string, auth images.Keyring) (string, error) {
+	_, desc, err := newResolver(auth).Resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest.String(), nil
+}
+
+func (p *containerdPuller) Pull(ctx context.Context, ref string, auth images.Keyring) (string, error) {
+	image, err := p.client.Pull(ctx, ref, containerd.WithPullUnpack, containerd.WithResolver(newResolver(auth)))
+	if err != nil {
+		return "", err
+	}
+	return image.Target().Digest.String(), nil
+}
+
+// newResolver returns a resolver that logs in to registries with the credentials in auth,
+// and reaches them through the mirrors in the config
+func newResolver(auth images.Keyring) remotes.Resolver {
+	authorizer := docker.NewDockerAuthorizer(docker.WithAuthCreds(auth.Resolve))
+	cfg := config.Cfg.Containerd.Registry
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts: func(registry string) ([]docker.RegistryHost, error) {
+			return registryHosts(cfg, registry, authorizer)
+		},
+	})
+}
+
+// registryHosts lists the hosts an image of the registry is fetched from, its mirrors first in the order they are
+// configured and the registry itself last. Mirrors only serve pulls, the registry is the only one images are pushed to.
+func registryHosts(cfg config.RegistryConfig, registry string, authorizer docker.Authorizer) ([]docker.RegistryHost, error) {
+	hosts := []docker.RegistryHost{}
+	for _, mirror := range registryMirrors(cfg, registry) {
+		host, err := registryHost(cfg, mirror, authorizer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid mirror %s of registry %s", mirror, registry)
+		}
+		host.Capabilities = docker.HostCapabilityPull | docker.HostCapabilityResolve
+		hosts = append(hosts, host)
+	}
+	upstream := registry
+	if registry == "docker.io" {
+		upstream = "registry-1.docker.io"
+	}
+	host, err := registryHost(cfg, upstream, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	host.Capabilities = docker.HostCapabilityPull | docker.HostCapabilityResolve | docker.HostCapabilityPush
+	return append(hosts, host), nil
+}
+
+// registryMirrors returns the mirrors of a registry, docker hub's mirrors can be configured under any of its names
+func registryMirrors(cfg config.RegistryConfig, registry string) []string {
+	if mirrors, found := cfg.Mirrors[registry]; found {
+		return mirrors
+	}
+	if registry == "docker.io" {
+		for _, alias := range []string{"index.docker.io", "registry-1.docker.io"} {
+			if mirrors, found := cfg.Mirrors[alias]; found {
+				return mirrors
+			}
+		}
+	}
+	return nil
+}
+
+// registryHost parses a registry or mirror written as a host or a url, e.g. cache.local:5000 or http://cache.local:5000/v2.
+// Insecure hosts and localhost use plain http when no scheme is given, and skip verifying certificates over https.
+func registryHost(cfg config.RegistryConfig, address string, authorizer docker.Authorizer) (docker.RegistryHost, error) {
+	scheme := ""
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return docker.RegistryHost{}, err
+		}
+		scheme, address = u.Scheme, u.Host+u.Path
+	}
+	host, path := address, "/v2"
+	if i := strings.IndexRune(address, '/'); i >= 0 {
+		host, path = address[:i], strings.TrimSuffix(address[i:], "/")
+		if !strings.HasSuffix(path, "/v2") {
+			path += "/v2"
+		}
+	}
+
+	insecure := false
+	for _, registry := range cfg.Insecure {
+		if registry == host {
+			insecure = true
+		}
+	}
+	if local, _ := docker.MatchLocalhost(host); local {
+		insecure = true
+	}
+	if scheme == "" {
+		scheme = "https"
+		if insecure {
+			scheme = "http"
+		}
+	}
+
+	client := http.DefaultClient
+	if insecure && scheme == "https" {
+		client = insecureRegistryClient
+	}
+	return docker.RegistryHost{
+		Client:     client,
+		Authorizer: authorizer,
+		Host:       host,
+		Scheme:     scheme,
+		Path:       path,
+	}, nil
+}
+
+// UseResourceManager registers the resource manager the image pull secrets of pods are read from
+func (dri *ContainerdRuntimeInterface) UseResourceManager(rm *manager.ResourceManager) {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	dri.resources = rm
+}
+
+// imagePullKeyring collects the credentials the images of the pod are pulled with. The image pull secrets of the pod
+// go first, then those of its service account and then the credentials of the node from the config.
+func (dri *ContainerdRuntimeInterface) imagePullKeyring(pod *v1.Pod) images.Keyring {
+	keyring := images.Keyring{}
+	dri.lock.Lock()
+	rm := dri.resources
+	dri.lock.Unlock()
+
+	if rm != nil {
+		var missing []string
+		keyring, missing = images.PodKeyring(dri.ctx, rm, pod)
+		if len(missing) > 0 {
+			dri.recordEvent(pod, v1.EventTypeWarning, "FailedToRetrieveImagePullSecret",
+				fmt.Sprintf("Unable to retrieve some image pull secrets (%s); attempting to pull the image may not succeed.", strings.Join(missing, ", ")))
+		}
+	}
+
+	nodeKeyring := images.Keyring{}
+	for host, creds := range config.Cfg.Containerd.RegistryCredentials {
+		nodeKeyring.Add(host, images.Credentials{Username: creds.Username, Password: creds.Password, IdentityToken: creds.IdentityToken})
+	}
+	return keyring.Merge(nodeKeyring)
+}
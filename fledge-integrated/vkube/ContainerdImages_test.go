@@ -0,0 +1,88 @@
+package vkube
+
+import (
+	"strings"
+	"testing"
+
+	"fledge/fledge-integrated/config"
+
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+func TestRegistryHosts(t *testing.T) {
+	cfg := config.RegistryConfig{
+		Mirrors: map[string][]string{
+			"index.docker.io": {"http://cache.local:5000", "mirror.example.com/hub/"},
+			"ghcr.io":         {"https://cache.local:5001/v2"},
+		},
+		Insecure: []string{"cache.local:5001", "registry.local:5000"},
+	}
+	pull := docker.HostCapabilityPull | docker.HostCapabilityResolve
+	all := pull | docker.HostCapabilityPush
+
+	tests := []struct {
+		registry string
+		want     []string
+	}{
+		{registry: "docker.io", want: []string{
+			"http://cache.local:5000/v2 pull",
+			"https://mirror.example.com/hub/v2 pull",
+			"https://registry-1.docker.io/v2 push",
+		}},
+		{registry: "ghcr.io", want: []string{
+			"https://cache.local:5001/v2 pull insecure",
+			"https://ghcr.io/v2 push",
+		}},
+		{registry: "quay.io", want: []string{"https://quay.io/v2 push"}},
+		{registry: "registry.local:5000", want: []string{"http://registry.local:5000/v2 push"}},
+		{registry: "localhost:5000", want: []string{"http://localhost:5000/v2 push"}},
+	}
+
+	for _, test := range tests {
+		hosts, err := registryHosts(cfg, test.registry, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, host := range hosts {
+			description := host.Scheme + "://" + host.Host + host.Path
+			switch host.Capabilities {
+			case pull:
+				description += " pull"
+			case all:
+				description += " push"
+			}
+			if host.Client == insecureRegistryClient {
+				description += " insecure"
+			}
+			got = append(got, description)
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Fatalf("expected the hosts of %s to be %v, got %v", test.registry, test.want, got)
+		}
+	}
+}
+
+func TestRegistryMirrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		mirrors  map[string][]string
+		registry string
+		want     []string
+	}{
+		{name: "exact", mirrors: map[string][]string{"ghcr.io": {"a"}}, registry: "ghcr.io", want: []string{"a"}},
+		{name: "none", mirrors: map[string][]string{"ghcr.io": {"a"}}, registry: "quay.io"},
+		{name: "docker hub", mirrors: map[string][]string{"docker.io": {"a"}}, registry: "docker.io", want: []string{"a"}},
+		{name: "docker hub as index.docker.io", mirrors: map[string][]string{"index.docker.io": {"a"}}, registry: "docker.io", want: []string{"a"}},
+		{name: "docker hub as registry-1.docker.io", mirrors: map[string][]string{"registry-1.docker.io": {"a"}}, registry: "docker.io", want: []string{"a"}},
+		{name: "docker.io goes first", mirrors: map[string][]string{"docker.io": {"a"}, "index.docker.io": {"b"}}, registry: "docker.io", want: []string{"a"}},
+		{name: "aliases only for docker hub", mirrors: map[string][]string{"index.docker.io": {"a"}}, registry: "ghcr.io"},
+	}
+
+	for _, test := range tests {
+		got := registryMirrors(config.RegistryConfig{Mirrors: test.mirrors}, test.registry)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package vkube
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// RecordPodEvents registers the recorder that events about pods, like failed lifecycle hooks, are sent to
+func (dri *ContainerdRuntimeInterface) RecordPodEvents(recorder record.EventRecorder) {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	dri.recorder = recorder
+}
+
+// recordEvent sends an event about the pod, when a recorder was registered
+func (dri *ContainerdRuntimeInterface) recordEvent(pod *v1.Pod, eventType, reason, message string) {
+	dri.lock.Lock()
+	recorder := dri.recorder
+	dri.lock.Unlock()
+	if recorder != nil {
+		recorder.Event(pod, eventType, reason, message)
+	}
+}
+
+// runPostStart runs the postStart hook of the container's current task, a container whose hook fails is killed
+func (dri *ContainerdRuntimeInterface) runPostStart(fullName string, pc *PodContainer) {
+	target, ok := dri.containerTarget(fullName, pc)
+	if !ok || target.Container.Lifecycle == nil || target.Container.Lifecycle.PostStart == nil {
+		return
+	}
+	go func() {
+		if err := RunLifecycleHandler(context.Background(), target.Container.Lifecycle.PostStart, target); err != nil {
+			message := fmt.Sprintf("PostStartHook of container %s failed: %s", target.Container.Name, err.Error())
+			fmt.Println(message)
+			dri.recordEvent(pc.pod, v1.EventTypeWarning, "FailedPostStartHook", message)
+			dri.killContainer(fullName, pc, fmt.Sprintf("Container %s failed its postStart hook, will be killed", target.Container.Name), nil)
+		}
+	}()
+}
+
+// terminateTask runs the preStop hook of the container and sends the stop signal of its image,
+// the task is killed when it didn't exit within the grace period
+func (dri *ContainerdRuntimeInterface) terminateTask(fullName string, pc *PodContainer, task containerd.Task, gracePeriod time.Duration) error {
+	deadline := time.Now().Add(gracePeriod)
+	//waiting starts before the task is signalled, so its exit can't be missed
+	statusC, err := task.Wait(dri.ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to wait for task of container %s", fullName)
+	}
+
+	if target, ok := dri.containerTarget(fullName, pc); ok && target.Container.Lifecycle != nil && target.Container.Lifecycle.PreStop != nil {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := RunLifecycleHandler(ctx, target.Container.Lifecycle.PreStop, target)
+		cancel()
+		if err != nil {
+			message := fmt.Sprintf("PreStopHook of container %s failed: %s", target.Container.Name, err.Error())
+			fmt.Println(message)
+			dri.recordEvent(pc.pod, v1.EventTypeWarning, "FailedPreStopHook", message)
+		}
+	}
+
+	signal, err := containerd.GetStopSignal(dri.ctx, pc.container, syscall.SIGTERM)
+	if err != nil {
+		signal = syscall.SIGTERM
+	}
+	remaining := time.Until(deadline)
+	if remaining < minimumGracePeriod {
+		remaining = minimumGracePeriod
+	}
+	fmt.Printf("Stopping container %s with signal %d, killing it in %s\n", fullName, signal, remaining)
+	//a task that already exited can't be signalled anymore
+	if err := task.Kill(dri.ctx, signal); err != nil && !errdefs.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to signal task of container %s", fullName)
+	}
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-statusC:
+		return nil
+	case <-timer.C:
+	}
+	fmt.Printf("Container %s didn't stop within its grace period, killing it\n", fullName)
+	if err := task.Kill(dri.ctx, syscall.SIGKILL); err != nil && !errdefs.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to kill task of container %s", fullName)
+	}
+	select {
+	case <-statusC:
+		return nil
+	case <-time.After(killTimeout):
+		return errors.Errorf("task of container %s didn't exit within %s after it was killed", fullName, killTimeout)
+	}
+}
@@ -0,0 +1,163 @@
+package vkube
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// preStopServer serves the preStop hooks of test containers, /slow doesn't answer until the hook is cancelled
+type preStopServer struct {
+	*httptest.Server
+	lock   sync.Mutex
+	called time.Time
+}
+
+func newPreStopServer(t *testing.T) *preStopServer {
+	s := &preStopServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.called = time.Now()
+		s.lock.Unlock()
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *preStopServer) calledAt() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.called
+}
+
+// addPreStopContainer adds a container of a pod on the host network whose preStop hook gets path from the server
+func (s *preStopServer) addPreStopContainer(t *testing.T, dri *ContainerdRuntimeInterface, path string, task *fakeTask) (string, *PodContainer) {
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	pod.Spec.Containers[0].Lifecycle = &v1.Lifecycle{PreStop: &v1.LifecycleHandler{
+		HTTPGet: &v1.HTTPGetAction{Path: path, Port: intstr.FromInt(listenerPort(t, s.Listener.Addr()))},
+	}}
+	return addTestContainer(dri, pod, "app", task)
+}
+
+func TestTerminateTaskOrder(t *testing.T) {
+	dri := newTestContainerd(t)
+	server := newPreStopServer(t)
+	//the task ignores its stop signal
+	task := newFakeTask("app", 42)
+	fullName, pc := server.addPreStopContainer(t, dri, "/prestop", task)
+
+	start := time.Now()
+	if err := dri.terminateTask(fullName, pc, task, 2500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	signals := task.receivedSignals()
+	if len(signals) != 2 || signals[0].signal != syscall.SIGTERM || signals[1].signal != syscall.SIGKILL {
+		t.Fatalf("signals %v, want SIGTERM and SIGKILL", signals)
+	}
+	hookAt := server.calledAt()
+	if hookAt.IsZero() || hookAt.After(signals[0].at) {
+		t.Fatal("preStop hook didn't run before the stop signal")
+	}
+	//killed once the grace period that started with the termination is over
+	if killedAfter := signals[1].at.Sub(start); killedAfter < 2500*time.Millisecond || killedAfter > 4*time.Second {
+		t.Fatalf("killed after %s, want after the grace period of 2.5s", killedAfter)
+	}
+	if status, _ := task.Status(dri.ctx); status.Status != containerd.Stopped || status.ExitStatus != 128+uint32(syscall.SIGKILL) {
+		t.Fatalf("task status %+v, want killed", status)
+	}
+}
+
+func TestTerminateTaskPreStopLongerThanGracePeriod(t *testing.T) {
+	dri := newTestContainerd(t)
+	server := newPreStopServer(t)
+	task := newFakeTask("app", 42)
+	fullName, pc := server.addPreStopContainer(t, dri, "/slow", task)
+
+	start := time.Now()
+	if err := dri.terminateTask(fullName, pc, task, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	signals := task.receivedSignals()
+	if len(signals) != 2 || signals[0].signal != syscall.SIGTERM || signals[1].signal != syscall.SIGKILL {
+		t.Fatalf("signals %v, want SIGTERM and SIGKILL", signals)
+	}
+	//the hook is cancelled at the end of the grace period, the task still gets the minimum grace period for its stop signal
+	if termAfter := signals[0].at.Sub(start); termAfter < time.Second || termAfter > 2*time.Second {
+		t.Fatalf("stop signal after %s, want it when the grace period ended", termAfter)
+	}
+	if graceAfterSignal := signals[1].at.Sub(signals[0].at); graceAfterSignal < minimumGracePeriod {
+		t.Fatalf("killed %s after the stop signal, want at least %s", graceAfterSignal, minimumGracePeriod)
+	}
+}
+
+func TestTerminateTaskStopSignal(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	task := newFakeTask("app", 42, syscall.SIGQUIT)
+	fullName, pc := addTestContainer(dri, pod, "app", task)
+	pc.container.SetLabels(dri.ctx, map[string]string{containerd.StopSignalLabel: "SIGQUIT"})
+
+	start := time.Now()
+	if err := dri.terminateTask(fullName, pc, task, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if signals := task.receivedSignals(); len(signals) != 1 || signals[0].signal != syscall.SIGQUIT {
+		t.Fatalf("signals %v, want the stop signal of the image", signals)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("stopping took %s, want it done once the task exited", took)
+	}
+}
+
+func TestTerminateExitedTask(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	task := newFakeTask("app", 42)
+	fullName, pc := addTestContainer(dri, pod, "app", task)
+
+	task.exit(0)
+	if err := dri.terminateTask(fullName, pc, task, 30*time.Second); err != nil {
+		t.Fatalf("stopping an exited task failed: %v", err)
+	}
+	if signals := task.receivedSignals(); len(signals) != 0 {
+		t.Fatalf("exited task got signals %v", signals)
+	}
+}
+
+func TestTerminationGracePeriod(t *testing.T) {
+	seconds := func(s int64) *int64 { return &s }
+	cases := []struct {
+		name     string
+		spec     *int64
+		deletion *int64
+		override *int64
+		want     time.Duration
+	}{
+		{"default", nil, nil, nil, defaultGracePeriod},
+		{"spec", seconds(10), nil, nil, 10 * time.Second},
+		{"deletion", seconds(10), seconds(5), nil, 5 * time.Second},
+		{"override", seconds(10), seconds(5), seconds(1), time.Second},
+		{"zero", seconds(0), nil, nil, 0},
+	}
+	for _, c := range cases {
+		pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+		pod.Spec.TerminationGracePeriodSeconds = c.spec
+		pod.ObjectMeta.DeletionGracePeriodSeconds = c.deletion
+		if got := TerminationGracePeriod(pod, c.override); got != c.want {
+			t.Errorf("%s: %s, want %s", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package vkube
+
+import (
+	"sync"
+)
+
+// podWorkers runs the operations on a pod one at a time, in the order they were submitted, like the kubelet's pod workers.
+// Creating, updating, deleting and syncing the status of the same pod never interleave, operations on different pods
+// run concurrently.
+type podWorkers struct {
+	lock sync.Mutex
+	//the operations of a pod that are running or waiting for their turn, the first one is running
+	queues map[string][]chan struct{}
+	//pods with a status sync that didn't start yet
+	syncPending map[string]bool
+}
+
+func newPodWorkers() *podWorkers {
+	return &podWorkers{
+		queues:      make(map[string][]chan struct{}),
+		syncPending: make(map[string]bool),
+	}
+}
+
+// run waits until the operations submitted earlier for the pod are done, and runs op in the calling goroutine
+func (w *podWorkers) run(key string, op func()) {
+	turn := make(chan struct{})
+	w.lock.Lock()
+	queue := w.queues[key]
+	w.queues[key] = append(queue, turn)
+	w.lock.Unlock()
+
+	if len(queue) > 0 {
+		<-turn
+	}
+	//the next operation gets its turn even when op panics
+	defer w.done(key)
+	op()
+}
+
+// done hands the turn to the next operation of the pod
+func (w *podWorkers) done(key string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	queue := w.queues[key][1:]
+	if len(queue) == 0 {
+		delete(w.queues, key)
+		return
+	}
+	w.queues[key] = queue
+	close(queue[0])
+}
+
+// sync queues a status sync of the pod without waiting for it,
+// a sync that is still waiting for its turn covers the ones requested after it
+func (w *podWorkers) sync(key string, op func()) {
+	w.lock.Lock()
+	if w.syncPending[key] {
+		w.lock.Unlock()
+		return
+	}
+	w.syncPending[key] = true
+	w.lock.Unlock()
+
+	go w.run(key, func() {
+		w.lock.Lock()
+		delete(w.syncPending, key)
+		w.lock.Unlock()
+		op()
+	})
+}
@@ -0,0 +1,214 @@
+package vkube
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitGroupTimeout fails the test when the operations don't finish in time
+func waitGroupTimeout(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pod operations didn't finish")
+	}
+}
+
+// waitIdle fails the test when operations of the workers are still queued after a while
+func waitIdle(t *testing.T, w *podWorkers) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.lock.Lock()
+		queues, pending := len(w.queues), len(w.syncPending)
+		w.lock.Unlock()
+		if queues == 0 && pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d queues and %d pending syncs left", queues, pending)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPodWorkersSerializeSamePod(t *testing.T) {
+	w := newPodWorkers()
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	op := func() {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run("default_web", op)
+		}()
+		go w.sync("default_web", op)
+	}
+	waitGroupTimeout(t, &wg)
+	waitIdle(t, w)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if maxRunning != 1 {
+		t.Fatalf("%d operations of the same pod ran at the same time", maxRunning)
+	}
+}
+
+func TestPodWorkersKeepOrder(t *testing.T) {
+	w := newPodWorkers()
+	first := make(chan struct{})
+	release := make(chan struct{})
+	var lock sync.Mutex
+	order := []int{}
+
+	go w.run("default_web", func() {
+		close(first)
+		<-release
+		lock.Lock()
+		order = append(order, 0)
+		lock.Unlock()
+	})
+	<-first
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run("default_web", func() {
+				lock.Lock()
+				order = append(order, i)
+				lock.Unlock()
+			})
+		}()
+		//each operation is queued before the next one is submitted
+		for {
+			w.lock.Lock()
+			queued := len(w.queues["default_web"])
+			w.lock.Unlock()
+			if queued == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(release)
+	waitGroupTimeout(t, &wg)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("operations ran in order %v", order)
+		}
+	}
+}
+
+func TestPodWorkersRunPodsInParallel(t *testing.T) {
+	w := newPodWorkers()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		w.run("default_web", func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+	go func() {
+		defer wg.Done()
+		//never runs if it waits for the operation of the other pod
+		w.run("default_db", func() {
+			close(release)
+		})
+	}()
+	waitGroupTimeout(t, &wg)
+}
+
+func TestPodWorkersCoalesceSyncs(t *testing.T) {
+	w := newPodWorkers()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var syncs int32
+
+	go w.run("default_web", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	for i := 0; i < 10; i++ {
+		w.sync("default_web", func() {
+			atomic.AddInt32(&syncs, 1)
+		})
+	}
+	close(release)
+	waitIdle(t, w)
+
+	if n := atomic.LoadInt32(&syncs); n != 1 {
+		t.Fatalf("%d syncs ran, want 1", n)
+	}
+}
+
+func TestPodWorkersCleanUpAfterDelete(t *testing.T) {
+	w := newPodWorkers()
+	deleted := false
+	w.run("default_web", func() {})
+	w.sync("default_web", func() {})
+	w.run("default_web", func() {
+		deleted = true
+	})
+	if !deleted {
+		t.Fatal("delete didn't run")
+	}
+	waitIdle(t, w)
+}
+
+func TestPodWorkersContinueAfterPanic(t *testing.T) {
+	w := newPodWorkers()
+	func() {
+		defer func() {
+			recover()
+		}()
+		w.run("default_web", func() {
+			panic("operation failed")
+		})
+	}()
+
+	ran := make(chan struct{})
+	go w.run("default_web", func() {
+		close(ran)
+	})
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pod's worker is stuck after an operation panicked")
+	}
+	waitIdle(t, w)
+}
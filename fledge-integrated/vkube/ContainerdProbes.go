@@ -0,0 +1,114 @@
+package vkube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// startProbes probes the container from the start of its current task, the pod status is refreshed when a result changes
+func (dri *ContainerdRuntimeInterface) startProbes(fullName string, pc *PodContainer) {
+	if target, ok := dri.containerTarget(fullName, pc); ok {
+		dri.prober.StartContainer(fullName, target)
+	}
+}
+
+// containerTarget is what probes and lifecycle hooks use to reach the container
+func (dri *ContainerdRuntimeInterface) containerTarget(fullName string, pc *PodContainer) (ProbeTarget, bool) {
+	pod := pc.pod
+	dc := getContainerSpec(pod, pc.containerName)
+	if dc == nil {
+		return ProbeTarget{}, false
+	}
+	return ProbeTarget{
+		Pod:       pod,
+		Container: dc,
+		Exec: func(ctx context.Context, cmd []string) ([]byte, error) {
+			var timeout time.Duration
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = time.Until(deadline)
+			}
+			out := &probeOutput{}
+			//the pod may already be gone when its preStop hooks run
+			err := dri.execInPodContainer(pc, cmd, nil, out, out, false, nil, timeout)
+			return out.Bytes(), err
+		},
+		Kill: func(reason string, gracePeriod *int64) {
+			dri.killContainer(fullName, pc, reason, gracePeriod)
+		},
+		Changed: func() {
+			dri.refreshPodStatus(pod)
+		},
+	}, true
+}
+
+// killContainer stops the running task of a container, its exit is handled like any other
+func (dri *ContainerdRuntimeInterface) killContainer(fullName string, pc *PodContainer, reason string, gracePeriod *int64) {
+	pc.lock.Lock()
+	task := pc.task
+	running := task != nil && !pc.exited && !pc.stopping
+	pc.lock.Unlock()
+	if !running {
+		return
+	}
+	fmt.Println(reason)
+	dri.recordEvent(pc.pod, v1.EventTypeNormal, "Killing", reason)
+	if err := dri.terminateTask(fullName, pc, task, TerminationGracePeriod(pc.pod, gracePeriod)); err != nil {
+		fmt.Printf("Failed to kill container %s: %s\n", fullName, err.Error())
+	}
+}
+
+// getContainerSpec returns the spec of a container of the pod by its name
+func getContainerSpec(pod *v1.Pod, name string) *v1.Container {
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			if containers[i].Name == name {
+				return &containers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// isInitContainer returns whether name is one of the init containers of the pod
+func isInitContainer(pod *v1.Pod, name string) bool {
+	for _, cont := range pod.Spec.InitContainers {
+		if cont.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// probeOutput collects the start of the output of an exec probe, stdout and stderr are written concurrently
+type probeOutput struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (o *probeOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if room := maxProbeBody - o.buf.Len(); room > 0 {
+		if len(p) > room {
+			o.buf.Write(p[:room])
+		} else {
+			o.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (o *probeOutput) Close() error {
+	return nil
+}
+
+func (o *probeOutput) Bytes() []byte {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.buf.Bytes()
+}
@@ -0,0 +1,316 @@
+package vkube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	v1 "k8s.io/api/core/v1"
+)
+
+// the host changes reconcile makes for pods, replaced by tests
+var (
+	setContainerResources = SetContainerResources
+	deleteCgroup          = DeleteCgroup
+	removeNetNamespace    = RemoveNetNamespace
+)
+
+// reconcile adopts the pods that were running on containerd before fledge restarted. Their containers are found by
+// their labels and their tasks, IPs and cgroups are taken over again. Containers of pods that aren't stored anymore
+// are removed, and so are stored pods whose sandbox is gone, the pod controller deploys those again.
+// Adopted pods that were deleted from the api server in the meantime are removed by the pod controller as well.
+func (dri *ContainerdRuntimeInterface) reconcile() {
+	if dri.client == nil {
+		return
+	}
+	pods, err := dri.store.LoadPods()
+	if err != nil {
+		fmt.Printf("Failed to load the stored pods: %s\n", err.Error())
+	}
+	containers, err := dri.client.Containers(dri.ctx, `labels."`+labelPodName+`"`)
+	if err != nil {
+		fmt.Printf("Failed to list the containers of pods: %s\n", err.Error())
+		return
+	}
+
+	containerLabelsByID := make(map[string]map[string]string)
+	labelled := []containerd.Container{}
+	for _, container := range containers {
+		labels, err := container.Labels(dri.ctx)
+		if err != nil {
+			fmt.Printf("Failed to get the labels of container %s: %s\n", container.ID(), err.Error())
+			continue
+		}
+		containerLabelsByID[container.ID()] = labels
+		labelled = append(labelled, container)
+	}
+	dri.reconcileContainers(pods, labelled, containerLabelsByID)
+}
+
+// podReconcile is what reconcile found in containerd for the pod with the name key
+type podReconcile struct {
+	key string
+	//the stored pod, nil if it isn't stored
+	pod *v1.Pod
+	//the containers of the stored pod, they are adopted if its sandbox is among them
+	adopt []containerd.Container
+	//containers of a pod that isn't stored, or of an earlier pod with the same name
+	remove []containerd.Container
+}
+
+// classifyContainers sorts the containers by the pod they belong to. A container only belongs to a stored pod when
+// it has the uid of the pod, a pod that was deleted and created again while fledge was down has another one.
+// Stored pods without any of their containers left are returned as well.
+func classifyContainers(pods []*v1.Pod, containers []containerd.Container, labels map[string]map[string]string) ([]*podReconcile, []*v1.Pod) {
+	storedPods := make(map[string]*v1.Pod)
+	for _, pod := range pods {
+		storedPods[pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name] = pod
+	}
+
+	found := make(map[string]*podReconcile)
+	keys := []string{}
+	for _, container := range containers {
+		containerLabels := labels[container.ID()]
+		key := containerLabels[labelPodNamespace] + "_" + containerLabels[labelPodName]
+		r, ok := found[key]
+		if !ok {
+			r = &podReconcile{key: key, pod: storedPods[key]}
+			found[key] = r
+			keys = append(keys, key)
+		}
+		if r.pod != nil && containerLabels[labelPodUID] == string(r.pod.ObjectMeta.UID) {
+			r.adopt = append(r.adopt, container)
+		} else {
+			r.remove = append(r.remove, container)
+		}
+	}
+
+	result := []*podReconcile{}
+	for _, key := range keys {
+		result = append(result, found[key])
+	}
+	gone := []*v1.Pod{}
+	for _, pod := range pods {
+		if r, ok := found[pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name]; !ok || len(r.adopt) == 0 {
+			gone = append(gone, pod)
+		}
+	}
+	return result, gone
+}
+
+// reconcileContainers adopts the stored pods whose sandbox still runs and removes every other container
+func (dri *ContainerdRuntimeInterface) reconcileContainers(pods []*v1.Pod, containers []containerd.Container, labels map[string]map[string]string) {
+	found, gone := classifyContainers(pods, containers, labels)
+	for _, r := range found {
+		if len(r.adopt) > 0 && dri.adoptPod(r.pod, r.adopt, labels) {
+			fmt.Printf("Adopted pod %s\n", r.key)
+		} else {
+			if len(r.adopt) > 0 {
+				r.remove = append(r.remove, r.adopt...)
+				dri.forgetPod(r.pod)
+			}
+			podLabels := labels[r.remove[0].ID()]
+			removeNetNamespace(podLabels[labelPodNamespace], podLabels[labelPodName])
+		}
+		removedUIDs := make(map[string]bool)
+		for _, container := range r.remove {
+			fmt.Printf("Removing container %s of pod %s\n", container.ID(), r.key)
+			dri.removeOrphanContainer(container, labels[container.ID()])
+			removedUIDs[labels[container.ID()][labelPodUID]] = true
+		}
+		//an adopted pod keeps its logs, those of an earlier pod with the same name are removed
+		for uid := range removedUIDs {
+			os.RemoveAll(filepath.Join(dri.logs.Dir(), r.key+"_"+uid))
+		}
+	}
+	//pods without any container left can't be adopted
+	for _, pod := range gone {
+		fmt.Printf("Containers of pod %s_%s are gone, forgetting it\n", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		dri.forgetPod(pod)
+	}
+	dri.setPodsChanged()
+}
+
+// adoptPod takes over the running sandbox of the pod and its containers, it returns false when the sandbox isn't running
+func (dri *ContainerdRuntimeInterface) adoptPod(pod *v1.Pod, containers []containerd.Container, labels map[string]map[string]string) bool {
+	namespace := pod.ObjectMeta.Namespace
+	key := namespace + "_" + pod.ObjectMeta.Name
+
+	var sandbox *podSandbox
+	for _, container := range containers {
+		if labels[container.ID()][labelContainerName] != sandboxContainerName {
+			continue
+		}
+		task, err := container.Task(dri.ctx, nil)
+		if err != nil {
+			fmt.Printf("Failed to load the sandbox task of pod %s: %s\n", key, err.Error())
+			return false
+		}
+		if status, err := task.Status(dri.ctx); err != nil || status.Status != containerd.Running {
+			fmt.Printf("Sandbox of pod %s isn't running\n", key)
+			return false
+		}
+		sandbox = &podSandbox{container: container, task: task}
+	}
+	if sandbox == nil {
+		fmt.Printf("Sandbox of pod %s not found\n", key)
+		return false
+	}
+	if !pod.Spec.HostNetwork && pod.Status.PodIP != "" {
+		if err := ReserveIP(namespace, pod.ObjectMeta.Name, pod.Status.PodIP); err != nil {
+			fmt.Printf("Failed to restore the IP of pod %s: %s\n", key, err.Error())
+			return false
+		}
+	}
+
+	dri.lock.Lock()
+	dri.sandboxes[key] = sandbox
+	dri.lock.Unlock()
+	dri.setPodSpec(pod)
+
+	for _, container := range containers {
+		name := labels[container.ID()][labelContainerName]
+		if name == sandboxContainerName {
+			continue
+		}
+		dc := getContainerSpec(pod, name)
+		if dc == nil {
+			dri.removeOrphanContainer(container, labels[container.ID()])
+			continue
+		}
+		dri.adoptContainer(pod, dc, container, labels[container.ID()])
+	}
+	//containers that weren't created yet are deployed by the status updates
+	dri.setPodSpec(pod)
+	return true
+}
+
+// adoptContainer takes over the current task of the container and continues its log, a container without a task
+// is handled as if its task failed to start
+func (dri *ContainerdRuntimeInterface) adoptContainer(pod *v1.Pod, dc *v1.Container, container containerd.Container, labels map[string]string) {
+	fullName := container.ID()
+	restartCount, _ := strconv.Atoi(labels[labelRestartCount])
+	startedAt, err := time.Parse(time.RFC3339Nano, labels[labelStartedAt])
+	if err != nil {
+		startedAt = time.Now()
+	}
+	imageID := ""
+	if image, err := container.Image(dri.ctx); err == nil {
+		imageID = image.Name() + "@" + image.Target().Digest.String()
+	}
+
+	//the cgroup is created again with the limits of the container, in case it was removed
+	setContainerResources(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, dc)
+
+	pc := &PodContainer{
+		podName:       pod.ObjectMeta.Name,
+		containerName: dc.Name,
+		container:     container,
+		imageID:       imageID,
+		podLogDir:     PodLogDir(dri.logs.Dir(), pod),
+		stdio:         newContainerStdio(dri.ctx, dc),
+		pod:           pod.DeepCopy(),
+		restartPolicy: containerRestartPolicy(pod, dc.Name),
+		startedAt:     startedAt,
+		restartCount:  int32(restartCount),
+	}
+
+	task, err := container.Task(dri.ctx, nil)
+	if err == nil {
+		//only a task that still runs has output to log, the exit of a stopped one is handled by the resync
+		if status, err := task.Status(dri.ctx); err == nil && status.Status != containerd.Stopped {
+			dri.attachAdoptedTask(pc, container)
+		}
+		pc.lock.Lock()
+		if pc.task == nil {
+			pc.task = task
+		}
+		pc.lock.Unlock()
+	} else if !errdefs.IsNotFound(err) {
+		fmt.Printf("Failed to load the task of container %s: %s\n", fullName, err.Error())
+	}
+
+	dri.lock.Lock()
+	dri.containerNameTaskMapping[fullName] = pc
+	dri.lock.Unlock()
+
+	pc.lock.Lock()
+	if pc.task == nil {
+		pc.exited = true
+		dri.handleExit(fullName, pc, pc.terminatedState(128, time.Now(), "task of the container was lost while fledge wasn't running"))
+		pc.lock.Unlock()
+		return
+	}
+	pc.lock.Unlock()
+	//the postStart hook ran when the task was started, only the probes start again
+	dri.startProbes(fullName, pc)
+}
+
+// attachAdoptedTask connects the output of a running task to the log of the run it belongs to
+func (dri *ContainerdRuntimeInterface) attachAdoptedTask(pc *PodContainer, container containerd.Container) {
+	logs, err := dri.logs.ReopenWriter(ContainerLogFile(pc.podLogDir, pc.containerName, pc.restartCount))
+	if err != nil {
+		fmt.Printf("Failed to open the log of container %s: %s\n", container.ID(), err.Error())
+		return
+	}
+	ts, attach := pc.stdio.attachTask(logs)
+	task, err := container.Task(dri.ctx, attach)
+	if err != nil {
+		fmt.Printf("Failed to attach to the task of container %s: %s\n", container.ID(), err.Error())
+		ts.close()
+		logs.Close()
+		return
+	}
+	pc.stdio.setTask(ts, task)
+	pc.lock.Lock()
+	pc.task = task
+	pc.logs = logs
+	pc.lock.Unlock()
+}
+
+// removeOrphanContainer kills the task of a container that doesn't belong to a known pod and removes it with its cgroup
+func (dri *ContainerdRuntimeInterface) removeOrphanContainer(container containerd.Container, labels map[string]string) {
+	if task, err := container.Task(dri.ctx, nil); err == nil {
+		if _, err := task.Delete(dri.ctx, containerd.WithProcessKill); err != nil && !errdefs.IsNotFound(err) {
+			fmt.Printf("Failed to delete the task of container %s: %s\n", container.ID(), err.Error())
+		}
+	}
+	if err := container.Delete(dri.ctx, containerd.WithSnapshotCleanup); err != nil && !errdefs.IsNotFound(err) {
+		fmt.Printf("Failed to delete container %s: %s\n", container.ID(), err.Error())
+	}
+	if name := labels[labelContainerName]; name != sandboxContainerName {
+		deleteCgroup(GetCgroup(labels[labelPodNamespace], labels[labelPodName], name))
+	}
+}
+
+// forgetPod removes a pod that couldn't be adopted from the store, the pod controller creates it again
+func (dri *ContainerdRuntimeInterface) forgetPod(pod *v1.Pod) {
+	if err := dri.store.DeletePod(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name); err != nil {
+		fmt.Printf("Failed to remove pod %s from the store: %s\n", pod.ObjectMeta.Name, err.Error())
+	}
+}
+
+// recordTaskStart labels the container with the run its current task belongs to, so it can be adopted with it
+func (dri *ContainerdRuntimeInterface) recordTaskStart(pc *PodContainer) {
+	_, err := pc.container.SetLabels(dri.ctx, map[string]string{
+		labelRestartCount: strconv.Itoa(int(pc.restartCount)),
+		labelStartedAt:    pc.startedAt.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		fmt.Printf("Failed to label container %s: %s\n", pc.container.ID(), err.Error())
+	}
+}
+
+// containerRestartPolicy is the restart policy of a container of the pod,
+// init containers only run again after a failure, even in pods that always restart their containers
+func containerRestartPolicy(pod *v1.Pod, name string) v1.RestartPolicy {
+	restartPolicy := pod.Spec.RestartPolicy
+	if isInitContainer(pod, name) && restartPolicy == v1.RestartPolicyAlways {
+		restartPolicy = v1.RestartPolicyOnFailure
+	}
+	return restartPolicy
+}
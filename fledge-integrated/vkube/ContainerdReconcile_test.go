@@ -0,0 +1,234 @@
+package vkube
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// containerdState is what reconcile finds in containerd after a restart of fledge
+type containerdState struct {
+	containers []containerd.Container
+	labels     map[string]map[string]string
+}
+
+// add creates a container of the pod with the uid of the pod, a nil task means its task is gone
+func (s *containerdState) add(dri *ContainerdRuntimeInterface, pod *v1.Pod, name string, task *fakeTask) *fakeContainer {
+	if s.labels == nil {
+		s.labels = make(map[string]map[string]string)
+	}
+	container := newFakeContainer(dri.GetContainerNameAlt(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, name), containerLabels(pod, name), task)
+	s.containers = append(s.containers, container)
+	s.labels[container.ID()], _ = container.Labels(dri.ctx)
+	return container
+}
+
+func podKeys(pods []*v1.Pod) string {
+	keys := []string{}
+	for _, pod := range pods {
+		keys = append(keys, pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func containerIDs(containers []containerd.Container) string {
+	ids := []string{}
+	for _, container := range containers {
+		ids = append(ids, container.ID())
+	}
+	return strings.Join(ids, ",")
+}
+
+// stubHostChanges keeps reconcile from touching the cgroups and network namespaces of the host
+func stubHostChanges(t *testing.T) *[]string {
+	removedNetNamespaces := []string{}
+	originalResources, originalCgroup, originalNetNs := setContainerResources, deleteCgroup, removeNetNamespace
+	setContainerResources = func(namespace string, podname string, dc *v1.Container) string { return "" }
+	deleteCgroup = func(cgName string) {}
+	removeNetNamespace = func(namespace string, pod string) {
+		removedNetNamespaces = append(removedNetNamespaces, namespace+"_"+pod)
+	}
+
+	addressLock.Lock()
+	originalAddresses := usedAddresses
+	usedAddresses = make(map[int]string)
+	addressLock.Unlock()
+	t.Cleanup(func() {
+		setContainerResources, deleteCgroup, removeNetNamespace = originalResources, originalCgroup, originalNetNs
+		addressLock.Lock()
+		usedAddresses = originalAddresses
+		addressLock.Unlock()
+	})
+	return &removedNetNamespaces
+}
+
+func TestClassifyContainers(t *testing.T) {
+	dri := newTestContainerd(t)
+	web := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	recreated := newContainerdTestPod("recreated", v1.RestartPolicyAlways, "app")
+	gone := newContainerdTestPod("gone", v1.RestartPolicyAlways, "app")
+	earlier := recreated.DeepCopy()
+	earlier.ObjectMeta.UID = types.UID("uid-earlier")
+	earlier.Spec.Containers[0].Name = "worker"
+	orphan := newContainerdTestPod("orphan", v1.RestartPolicyAlways, "app")
+
+	state := &containerdState{}
+	state.add(dri, web, sandboxContainerName, nil)
+	state.add(dri, web, "app", nil)
+	state.add(dri, recreated, sandboxContainerName, nil)
+	state.add(dri, earlier, "worker", nil)
+	state.add(dri, orphan, sandboxContainerName, nil)
+	state.add(dri, orphan, "app", nil)
+	//a pod whose containers all belong to an earlier pod with the same name
+	reused := newContainerdTestPod("reused", v1.RestartPolicyAlways, "app")
+	reusedEarlier := reused.DeepCopy()
+	reusedEarlier.ObjectMeta.UID = types.UID("uid-earlier")
+	state.add(dri, reusedEarlier, sandboxContainerName, nil)
+
+	found, forget := classifyContainers([]*v1.Pod{web, recreated, gone, reused}, state.containers, state.labels)
+
+	want := map[string]struct {
+		stored bool
+		adopt  string
+		remove string
+	}{
+		"default_web":       {true, "default_web_POD,default_web_app", ""},
+		"default_recreated": {true, "default_recreated_POD", "default_recreated_worker"},
+		"default_orphan":    {false, "", "default_orphan_POD,default_orphan_app"},
+		"default_reused":    {true, "", "default_reused_POD"},
+	}
+	if len(found) != len(want) {
+		t.Fatalf("found %d pods, want %d", len(found), len(want))
+	}
+	for _, r := range found {
+		w, ok := want[r.key]
+		if !ok {
+			t.Errorf("unexpected pod %s", r.key)
+			continue
+		}
+		if (r.pod != nil) != w.stored || containerIDs(r.adopt) != w.adopt || containerIDs(r.remove) != w.remove {
+			t.Errorf("%s: stored %t, adopt %s, remove %s, want %t, %s and %s", r.key, r.pod != nil, containerIDs(r.adopt), containerIDs(r.remove), w.stored, w.adopt, w.remove)
+		}
+	}
+	if keys := podKeys(forget); keys != "default_gone,default_reused" {
+		t.Fatalf("forgetting %s, want the pods without containers of their own", keys)
+	}
+}
+
+func TestReconcileContainers(t *testing.T) {
+	removedNetNamespaces := stubHostChanges(t)
+	dri := newTestContainerd(t)
+	store, err := OpenContainerdStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	dri.store = store
+
+	//running pod on the pod network, with a container whose task was lost
+	web := newContainerdTestPod("web", v1.RestartPolicyAlways, "app", "lost")
+	web.Spec.HostNetwork = false
+	web.Status.PodIP = "10.1.0.5"
+	//pod whose sandbox stopped while fledge was down
+	stopped := newContainerdTestPod("stopped", v1.RestartPolicyAlways, "app")
+	//pod whose ip was handed out again
+	conflict := newContainerdTestPod("conflict", v1.RestartPolicyAlways, "app")
+	conflict.Spec.HostNetwork = false
+	conflict.Status.PodIP = "10.1.0.9"
+	//pod that was deleted and created again, the containers of the earlier one are left
+	earlier := newContainerdTestPod("web", v1.RestartPolicyAlways, "worker")
+	earlier.ObjectMeta.UID = types.UID("uid-earlier")
+	//stored pod without containers, and containers without a stored pod
+	gone := newContainerdTestPod("gone", v1.RestartPolicyAlways, "app")
+	orphan := newContainerdTestPod("orphan", v1.RestartPolicyAlways, "app")
+	for _, pod := range []*v1.Pod{web, stopped, conflict, gone} {
+		if err := store.SavePod(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ReserveIP("default", "other", "10.1.0.9"); err != nil {
+		t.Fatal(err)
+	}
+	for _, pod := range []*v1.Pod{web, earlier} {
+		if err := os.MkdirAll(PodLogDir(dri.logs.Dir(), pod), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := &containerdState{}
+	webSandbox := state.add(dri, web, sandboxContainerName, newFakeTask("POD", 10))
+	webApp := state.add(dri, web, "app", newFakeTask("app", 11))
+	state.add(dri, web, "lost", nil)
+	earlierWorker := state.add(dri, earlier, "worker", newFakeTask("worker", 12))
+	stoppedSandboxTask := newFakeTask("POD", 20)
+	stoppedSandboxTask.exit(137)
+	stoppedSandbox := state.add(dri, stopped, sandboxContainerName, stoppedSandboxTask)
+	stoppedApp := state.add(dri, stopped, "app", newFakeTask("app", 21))
+	conflictSandbox := state.add(dri, conflict, sandboxContainerName, newFakeTask("POD", 30))
+	orphanApp := state.add(dri, orphan, "app", newFakeTask("app", 40))
+
+	pods, err := store.LoadPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dri.reconcileContainers(pods, state.containers, state.labels)
+
+	//the pod that is still running is adopted with its ip
+	if sandbox := dri.getSandbox(web); sandbox == nil || sandbox.container != webSandbox {
+		t.Fatal("sandbox of the running pod wasn't adopted")
+	}
+	if _, found := dri.GetPod("default", "web"); !found {
+		t.Fatal("running pod isn't known")
+	}
+	pc := dri.getPodContainer(webApp.ID())
+	if pc == nil || pc.task == nil || pc.task.Pid() != 11 || webApp.isDeleted() {
+		t.Fatal("running container wasn't adopted with its task")
+	}
+	if status := dri.GetContainerStatus(web.Spec.Containers[0], pc); status.State.Running == nil {
+		t.Fatalf("adopted container state %+v, want running", status.State)
+	}
+	lost := dri.getPodContainer(dri.GetContainerNameAlt("default", "web", "lost"))
+	if lost == nil || lost.lastTermination == nil || lost.lastTermination.Reason != "StartError" {
+		t.Fatal("container whose task was lost isn't restarted")
+	}
+	if err := ReserveIP("default", "another", "10.1.0.5"); err == nil {
+		t.Fatal("ip of the adopted pod can be handed out again")
+	}
+	//the containers and logs of the earlier pod with the same name are removed, the pod network is kept
+	if !earlierWorker.isDeleted() || dri.getPodContainer(earlierWorker.ID()) != nil {
+		t.Fatal("container of the earlier pod wasn't removed")
+	}
+	if _, err := os.Stat(PodLogDir(dri.logs.Dir(), earlier)); !os.IsNotExist(err) {
+		t.Fatal("logs of the earlier pod weren't removed")
+	}
+	if _, err := os.Stat(PodLogDir(dri.logs.Dir(), web)); err != nil {
+		t.Fatal("logs of the adopted pod were removed")
+	}
+
+	//everything else is removed, and so are the stored pods that couldn't be adopted
+	for name, container := range map[string]*fakeContainer{"stopped sandbox": stoppedSandbox, "stopped app": stoppedApp, "conflicting sandbox": conflictSandbox, "orphan": orphanApp} {
+		if !container.isDeleted() {
+			t.Errorf("%s wasn't removed", name)
+		}
+	}
+	if dri.getSandbox(stopped) != nil || dri.getSandbox(conflict) != nil {
+		t.Fatal("sandbox of a pod that wasn't adopted is known")
+	}
+	if names := loadPodNames(t, store); strings.Join(names, ",") != "default_web" {
+		t.Fatalf("stored pods %v, want the adopted one only", names)
+	}
+	sort.Strings(*removedNetNamespaces)
+	if netns := strings.Join(*removedNetNamespaces, ","); netns != "default_conflict,default_orphan,default_stopped" {
+		t.Fatalf("removed the network namespaces of %s", netns)
+	}
+	//the ip is left to the pod it was handed out to
+	if err := ReserveIP("default", "other", "10.1.0.9"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,280 @@
+package vkube
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	//restart delays double from restartInitialBackoff up to restartMaxBackoff, like the kubelet's CrashLoopBackOff
+	restartInitialBackoff = 10 * time.Second
+	restartMaxBackoff     = 5 * time.Minute
+	//a container that ran this long before exiting starts over at restartInitialBackoff
+	restartBackoffReset = 10 * time.Minute
+)
+
+// ShouldRestart applies the pod's restart policy to an exited container
+func ShouldRestart(policy v1.RestartPolicy, exitCode int32) bool {
+	switch policy {
+	case v1.RestartPolicyNever:
+		return false
+	case v1.RestartPolicyOnFailure:
+		return exitCode != 0
+	default:
+		return true
+	}
+}
+
+// RestartBackoff returns how long to wait before restarting a container that exited after running for ranFor,
+// previous is the delay of its last restart or 0 if it wasn't restarted yet
+func RestartBackoff(previous time.Duration, ranFor time.Duration) time.Duration {
+	if previous == 0 || ranFor >= restartBackoffReset {
+		return restartInitialBackoff
+	}
+	if previous*2 > restartMaxBackoff {
+		return restartMaxBackoff
+	}
+	return previous * 2
+}
+
+// ProcessExitCode returns the exit code of a process, a process killed by a signal exits with 128 + signal like in a shell
+func ProcessExitCode(state *os.ProcessState) int32 {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return int32(128 + status.Signal())
+	}
+	return int32(state.ExitCode())
+}
+
+// startTask creates and starts a new task for the container that logs to logPath and that clients can attach to through stdio,
+// its exit is reported by the event loop
+func (dri *ContainerdRuntimeInterface) startTask(container containerd.Container, logPath string, stdio *containerStdio) (containerd.Task, *CRILogWriter, error) {
+	logs, err := dri.logs.NewWriter(logPath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to create log of container %s", container.ID())
+	}
+	ts, taskIO := stdio.newTask(logs)
+	task, err := container.NewTask(dri.ctx, taskIO)
+	if err != nil {
+		ts.close()
+		logs.Close()
+		return nil, nil, errors.Wrapf(err, "failed to create task for container %s", container.ID())
+	}
+	fmt.Println("Task created")
+
+	if err := task.Start(dri.ctx); err != nil {
+		task.Delete(dri.ctx)
+		ts.close()
+		logs.Close()
+		return nil, nil, errors.Wrapf(err, "failed to start task of container %s", container.ID())
+	}
+	stdio.setTask(ts, task)
+	fmt.Println("Task started")
+	return task, logs, nil
+}
+
+// handleExit records the termination and schedules a restart with backoff when allowed, the lock must be held
+func (dri *ContainerdRuntimeInterface) handleExit(fullName string, pc *PodContainer, terminated *v1.ContainerStateTerminated) {
+	dri.setPodsChanged()
+	if !ShouldRestart(pc.restartPolicy, terminated.ExitCode) {
+		pc.terminated = terminated
+		//the log of a terminated container can be removed when space runs out
+		if pc.task != nil && pc.logs != nil {
+			task, logs := pc.task, pc.logs
+			go func() {
+				if taskIO := task.IO(); taskIO != nil {
+					taskIO.Wait()
+				}
+				logs.Close()
+			}()
+		}
+		return
+	}
+	pc.lastTermination = terminated
+	pc.backoff = RestartBackoff(pc.backoff, terminated.FinishedAt.Sub(pc.startedAt))
+	delay := pc.backoff
+	pc.waitingUntil = time.Now().Add(delay)
+
+	fmt.Printf("Restarting container %s in %s\n", fullName, delay)
+	podKey := pc.pod.ObjectMeta.Namespace + "_" + pc.podName
+	time.AfterFunc(delay, func() {
+		//restarts wait for their turn in the pod worker, so they never interleave with a deployment, update or deletion of the pod
+		dri.workers.run(podKey, func() {
+			dri.restartContainer(fullName, pc)
+		})
+	})
+}
+
+// restartContainer replaces the exited task of the container by a new one, it runs in the pod worker.
+// The lock isn't held while containerd creates and starts the task.
+func (dri *ContainerdRuntimeInterface) restartContainer(fullName string, pc *PodContainer) {
+	//the pod was deleted or its container replaced while the restart waited
+	if dri.getPodContainer(fullName) != pc {
+		fmt.Printf("Container %s is gone, not restarting it\n", fullName)
+		return
+	}
+
+	pc.lock.Lock()
+	if pc.stopping {
+		pc.lock.Unlock()
+		return
+	}
+	pc.waitingUntil = time.Time{}
+	pc.restartCount++
+	pc.exited = false
+	pc.oomKilled = false
+	oldTask, oldLogs := pc.task, pc.logs
+	pc.task, pc.logs = nil, nil
+	restartCount := pc.restartCount
+	pc.lock.Unlock()
+	dri.setPodsChanged()
+
+	if oldTask != nil {
+		if _, err := oldTask.Delete(dri.ctx); err != nil {
+			fmt.Printf("Failed to delete exited task of container %s: %s\n", fullName, err.Error())
+		}
+	}
+	if oldLogs != nil {
+		oldLogs.Close()
+	}
+	//only the logs of the previous run are kept
+	if restartCount >= 2 {
+		dri.logs.RemoveLogs(ContainerLogFile(pc.podLogDir, pc.containerName, restartCount-2))
+	}
+
+	task, logs, err := dri.startTask(pc.container, ContainerLogFile(pc.podLogDir, pc.containerName, restartCount), pc.stdio)
+
+	pc.lock.Lock()
+	if err != nil {
+		fmt.Println(err.Error())
+		pc.startedAt = time.Now()
+		pc.exited = true
+		dri.handleExit(fullName, pc, pc.terminatedState(128, time.Now(), err.Error()))
+		pc.lock.Unlock()
+		return
+	}
+	pc.task = task
+	pc.logs = logs
+	pc.startedAt = time.Now()
+	pc.lock.Unlock()
+	//the run is only changed in the pod worker, so it can be read without the lock
+	dri.recordTaskStart(pc)
+	dri.taskStarted(fullName, pc, task)
+}
+
+// taskStarted starts the probes and the postStart hook of a task that was just stored, the lock isn't held.
+// An exit of the task that came in before it was stored is handled right away instead of by the next resync.
+func (dri *ContainerdRuntimeInterface) taskStarted(fullName string, pc *PodContainer, task containerd.Task) {
+	dri.startProbes(fullName, pc)
+	dri.runPostStart(fullName, pc)
+	dri.checkTaskExit(pc)
+	//an exit handled while the probes were started would leave them running
+	pc.lock.Lock()
+	exited := pc.exited && pc.task == task
+	pc.lock.Unlock()
+	if exited {
+		dri.prober.StopContainer(fullName)
+	}
+}
+
+// terminatedState describes an exit of the container's task, message is set when the task couldn't be started
+func (pc *PodContainer) terminatedState(exitCode int32, finishedAt time.Time, message string) *v1.ContainerStateTerminated {
+	reason := "Completed"
+	if message != "" {
+		reason = "StartError"
+	} else if pc.oomKilled {
+		reason = "OOMKilled"
+	} else if exitCode != 0 {
+		reason = "Error"
+	}
+	return &v1.ContainerStateTerminated{
+		ExitCode:    exitCode,
+		Reason:      reason,
+		Message:     message,
+		StartedAt:   metav1.NewTime(pc.startedAt),
+		FinishedAt:  metav1.NewTime(finishedAt),
+		ContainerID: pc.containerID(),
+	}
+}
+
+// containerID is the id of the container as reported in its status
+func (pc *PodContainer) containerID() string {
+	return "containerd://" + pc.container.ID()
+}
+
+// getTaskStatus returns the status of the container's current task, which is unknown while it has none
+func (dri *ContainerdRuntimeInterface) getTaskStatus(pc *PodContainer) containerd.Status {
+	pc.lock.Lock()
+	task := pc.task
+	pc.lock.Unlock()
+	if task == nil {
+		return containerd.Status{Status: containerd.Unknown}
+	}
+	status, err := task.Status(dri.ctx)
+	if err != nil {
+		return containerd.Status{Status: containerd.Unknown}
+	}
+	return status
+}
+
+// GetContainerStatus reports the container state the way the kubelet does, including restarts and CrashLoopBackOff
+func (dri *ContainerdRuntimeInterface) GetContainerStatus(cont v1.Container, pc *PodContainer) v1.ContainerStatus {
+	taskStatus := dri.getTaskStatus(pc)
+
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	status := v1.ContainerStatus{
+		Name:         cont.Name,
+		RestartCount: pc.restartCount,
+		Image:        cont.Image,
+		ImageID:      pc.imageID,
+		ContainerID:  pc.containerID(),
+		Started:      new(bool),
+	}
+	if pc.lastTermination != nil {
+		status.LastTerminationState.Terminated = pc.lastTermination
+	}
+
+	backOff := &v1.ContainerStateWaiting{
+		Reason:  "CrashLoopBackOff",
+		Message: fmt.Sprintf("back-off %s restarting failed container %s", pc.backoff, cont.Name),
+	}
+	switch {
+	case pc.terminated != nil:
+		status.State.Terminated = pc.terminated
+	case !pc.waitingUntil.IsZero():
+		status.State.Waiting = backOff
+	case taskStatus.Status == containerd.Created:
+		status.State.Waiting = &v1.ContainerStateWaiting{
+			Reason:  "Starting",
+			Message: "Starting container",
+		}
+	case taskStatus.Status == containerd.Running || taskStatus.Status == containerd.Paused || taskStatus.Status == containerd.Pausing:
+		status.State.Running = &v1.ContainerStateRunning{
+			StartedAt: metav1.NewTime(pc.startedAt),
+		}
+		//probes decide when a running container counts as started and ready
+		if taskStatus.Status == containerd.Running {
+			*status.Started = dri.prober.Started(pc.container.ID())
+			status.Ready = dri.prober.Ready(pc.container.ID())
+		}
+	case taskStatus.Status == containerd.Stopped:
+		//the exit hasn't been handled by the event loop yet
+		exitCode := int32(taskStatus.ExitStatus)
+		if ShouldRestart(pc.restartPolicy, exitCode) {
+			status.State.Waiting = backOff
+		} else {
+			status.State.Terminated = pc.terminatedState(exitCode, taskStatus.ExitTime, "")
+		}
+	default:
+		status.State.Waiting = &v1.ContainerStateWaiting{Reason: "ContainerStatusUnknown"}
+	}
+	return status
+}
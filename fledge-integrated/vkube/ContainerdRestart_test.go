@@ -0,0 +1,266 @@
+package vkube
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestShouldRestart(t *testing.T) {
+	cases := []struct {
+		policy   v1.RestartPolicy
+		exitCode int32
+		restart  bool
+	}{
+		{v1.RestartPolicyAlways, 0, true},
+		{v1.RestartPolicyAlways, 1, true},
+		{v1.RestartPolicyOnFailure, 0, false},
+		{v1.RestartPolicyOnFailure, 1, true},
+		{v1.RestartPolicyOnFailure, 137, true},
+		{v1.RestartPolicyNever, 0, false},
+		{v1.RestartPolicyNever, 1, false},
+		//an unset policy is Always
+		{"", 0, true},
+	}
+	for _, c := range cases {
+		if restart := ShouldRestart(c.policy, c.exitCode); restart != c.restart {
+			t.Errorf("ShouldRestart(%q, %d) = %t, want %t", c.policy, c.exitCode, restart, c.restart)
+		}
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	cases := []struct {
+		name     string
+		previous time.Duration
+		ranFor   time.Duration
+		backoff  time.Duration
+	}{
+		{"first restart", 0, time.Second, 10 * time.Second},
+		{"doubles", 10 * time.Second, time.Second, 20 * time.Second},
+		{"doubles again", 80 * time.Second, time.Minute, 160 * time.Second},
+		{"capped", 160 * time.Second, time.Second, 5 * time.Minute},
+		{"stays at the cap", 5 * time.Minute, time.Second, 5 * time.Minute},
+		{"just short of the reset", 5 * time.Minute, 10*time.Minute - time.Second, 5 * time.Minute},
+		{"reset after 10m", 5 * time.Minute, 10 * time.Minute, 10 * time.Second},
+	}
+	for _, c := range cases {
+		if backoff := RestartBackoff(c.previous, c.ranFor); backoff != c.backoff {
+			t.Errorf("%s: RestartBackoff(%s, %s) = %s, want %s", c.name, c.previous, c.ranFor, backoff, c.backoff)
+		}
+	}
+}
+
+func TestHandleTaskExitRestartPolicy(t *testing.T) {
+	cases := []struct {
+		policy   v1.RestartPolicy
+		exitCode int32
+		restart  bool
+		reason   string
+	}{
+		{v1.RestartPolicyAlways, 0, true, "Completed"},
+		{v1.RestartPolicyOnFailure, 0, false, "Completed"},
+		{v1.RestartPolicyOnFailure, 2, true, "Error"},
+		{v1.RestartPolicyNever, 2, false, "Error"},
+	}
+	for _, c := range cases {
+		dri := newTestContainerd(t)
+		pod := newContainerdTestPod("web", c.policy, "app")
+		task := newFakeTask("app", 42)
+		fullName, pc := addTestContainer(dri, pod, "app", task)
+
+		task.exit(uint32(c.exitCode))
+		if !dri.handleTaskExit(fullName, pc, 42, c.exitCode, time.Now()) {
+			t.Fatalf("%s/%d: exit wasn't handled", c.policy, c.exitCode)
+		}
+		status := dri.GetContainerStatus(pod.Spec.Containers[0], pc)
+		if c.restart {
+			if status.State.Waiting == nil || status.State.Waiting.Reason != "CrashLoopBackOff" {
+				t.Errorf("%s/%d: state %+v, want CrashLoopBackOff", c.policy, c.exitCode, status.State)
+				continue
+			}
+			last := status.LastTerminationState.Terminated
+			if last == nil || last.ExitCode != c.exitCode || last.Reason != c.reason {
+				t.Errorf("%s/%d: last termination %+v, want %s with %d", c.policy, c.exitCode, last, c.reason, c.exitCode)
+			}
+		} else {
+			terminated := status.State.Terminated
+			if terminated == nil || terminated.ExitCode != c.exitCode || terminated.Reason != c.reason {
+				t.Errorf("%s/%d: state %+v, want terminated with %s", c.policy, c.exitCode, status.State, c.reason)
+			}
+			if !pc.waitingUntil.IsZero() {
+				t.Errorf("%s/%d: restart scheduled", c.policy, c.exitCode)
+			}
+		}
+	}
+}
+
+func TestHandleExitBackoff(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	fullName, pc := addTestContainer(dri, pod, "app", nil)
+
+	exitAfter := func(ranFor time.Duration) time.Duration {
+		pc.lock.Lock()
+		defer pc.lock.Unlock()
+		now := time.Now()
+		pc.startedAt = now.Add(-ranFor)
+		dri.handleExit(fullName, pc, pc.terminatedState(1, now, ""))
+		if until := time.Until(pc.waitingUntil); until <= 0 || until > pc.backoff {
+			t.Errorf("restart in %s with a backoff of %s", until, pc.backoff)
+		}
+		return pc.backoff
+	}
+
+	for i, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second, 5 * time.Minute, 5 * time.Minute} {
+		if backoff := exitAfter(time.Second); backoff != want {
+			t.Fatalf("exit %d: backoff %s, want %s", i, backoff, want)
+		}
+	}
+	if backoff := exitAfter(11 * time.Minute); backoff != 10*time.Second {
+		t.Fatalf("backoff %s after a long run, want it reset to 10s", backoff)
+	}
+	status := dri.GetContainerStatus(pod.Spec.Containers[0], pc)
+	if status.State.Waiting == nil || !strings.Contains(status.State.Waiting.Message, "back-off 10s") {
+		t.Fatalf("state %+v, want the backoff in the message", status.State)
+	}
+}
+
+func TestHandleTaskExitIgnored(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	task := newFakeTask("app", 42)
+	fullName, pc := addTestContainer(dri, pod, "app", task)
+
+	//exits of previous tasks
+	if dri.handleTaskExit(fullName, pc, 41, 1, time.Now()) {
+		t.Fatal("exit of another pid was handled")
+	}
+
+	//exits caused by stopping the container
+	pc.lock.Lock()
+	pc.stopping = true
+	pc.lock.Unlock()
+	task.exit(143)
+	if dri.handleTaskExit(fullName, pc, 42, 143, time.Now()) {
+		t.Fatal("exit while stopping was handled")
+	}
+	if pc.lastTermination != nil || pc.terminated != nil || !pc.waitingUntil.IsZero() {
+		t.Fatal("exit while stopping was recorded")
+	}
+	//a restart that was scheduled before the container was stopped doesn't start a task
+	dri.restartContainer(fullName, pc)
+	if container := pc.container.(*fakeContainer); container.tasks != 0 || pc.restartCount != 0 {
+		t.Fatalf("container restarted while stopping, %d tasks", container.tasks)
+	}
+
+	//exits that were already handled
+	pc.lock.Lock()
+	pc.stopping = false
+	pc.lock.Unlock()
+	if !dri.handleTaskExit(fullName, pc, 42, 143, time.Now()) {
+		t.Fatal("exit wasn't handled")
+	}
+	backoff := pc.backoff
+	if dri.handleTaskExit(fullName, pc, 42, 143, time.Now()) || pc.backoff != backoff {
+		t.Fatal("exit was handled twice")
+	}
+}
+
+func TestRestartContainer(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	task := newFakeTask("app", 42)
+	fullName, pc := addTestContainer(dri, pod, "app", task)
+	container := pc.container.(*fakeContainer)
+
+	task.exit(1)
+	dri.handleTaskExit(fullName, pc, 42, 1, time.Now())
+	dri.restartContainer(fullName, pc)
+
+	if container.tasks != 1 || pc.task == task {
+		t.Fatalf("%d tasks created, want a new one", container.tasks)
+	}
+	task.lock.Lock()
+	deleted := task.deleted
+	task.lock.Unlock()
+	if !deleted {
+		t.Fatal("exited task wasn't deleted")
+	}
+	status := dri.GetContainerStatus(pod.Spec.Containers[0], pc)
+	if status.State.Running == nil || status.RestartCount != 1 {
+		t.Fatalf("state %+v with %d restarts, want running after 1 restart", status.State, status.RestartCount)
+	}
+	if last := status.LastTerminationState.Terminated; last == nil || last.ExitCode != 1 {
+		t.Fatalf("last termination %+v, want exit code 1", last)
+	}
+	if labels, _ := container.Labels(dri.ctx); labels[labelRestartCount] != "1" {
+		t.Fatalf("restart count label %q, want 1", labels[labelRestartCount])
+	}
+
+	//the next exit of the new task is handled with a doubled backoff
+	newTask := pc.task.(*fakeTask)
+	newTask.exit(1)
+	if !dri.handleTaskExit(fullName, pc, newTask.Pid(), 1, time.Now()) || pc.backoff != 20*time.Second {
+		t.Fatalf("backoff %s after the second exit, want 20s", pc.backoff)
+	}
+}
+
+func TestGetContainerStatusOfTask(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   v1.RestartPolicy
+		task     containerd.Status
+		noTask   bool
+		running  bool
+		waiting  string
+		exitCode int32
+	}{
+		{name: "created", policy: v1.RestartPolicyAlways, task: containerd.Status{Status: containerd.Created}, waiting: "Starting"},
+		{name: "running", policy: v1.RestartPolicyAlways, task: containerd.Status{Status: containerd.Running}, running: true},
+		{name: "paused", policy: v1.RestartPolicyAlways, task: containerd.Status{Status: containerd.Paused}, running: true},
+		//exits the event loop didn't handle yet
+		{name: "stopped and restarting", policy: v1.RestartPolicyAlways, task: containerd.Status{Status: containerd.Stopped, ExitStatus: 1}, waiting: "CrashLoopBackOff"},
+		{name: "stopped on failure", policy: v1.RestartPolicyOnFailure, task: containerd.Status{Status: containerd.Stopped, ExitStatus: 0}, exitCode: 0},
+		{name: "stopped never", policy: v1.RestartPolicyNever, task: containerd.Status{Status: containerd.Stopped, ExitStatus: 3}, exitCode: 3},
+		{name: "no task", policy: v1.RestartPolicyAlways, noTask: true, waiting: "ContainerStatusUnknown"},
+	}
+	for _, c := range cases {
+		dri := newTestContainerd(t)
+		pod := newContainerdTestPod("web", c.policy, "app")
+		var task *fakeTask
+		if !c.noTask {
+			task = newFakeTask("app", 42)
+			task.status = c.task
+		}
+		fullName, pc := addTestContainer(dri, pod, "app", task)
+		dri.startProbes(fullName, pc)
+
+		status := dri.GetContainerStatus(pod.Spec.Containers[0], pc)
+		switch {
+		case c.running:
+			if status.State.Running == nil {
+				t.Errorf("%s: state %+v, want running", c.name, status.State)
+			}
+			//without probes a running container is started and ready
+			if c.task.Status == containerd.Running && (!*status.Started || !status.Ready) {
+				t.Errorf("%s: started %t, ready %t", c.name, *status.Started, status.Ready)
+			}
+		case c.waiting != "":
+			if status.State.Waiting == nil || status.State.Waiting.Reason != c.waiting {
+				t.Errorf("%s: state %+v, want waiting with %s", c.name, status.State, c.waiting)
+			}
+		default:
+			if status.State.Terminated == nil || status.State.Terminated.ExitCode != c.exitCode {
+				t.Errorf("%s: state %+v, want terminated with %d", c.name, status.State, c.exitCode)
+			}
+		}
+		if status.ContainerID != "containerd://"+fullName {
+			t.Errorf("%s: container id %s", c.name, status.ContainerID)
+		}
+		dri.prober.StopContainer(fullName)
+	}
+}
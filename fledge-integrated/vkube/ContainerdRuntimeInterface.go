@@ -2,96 +2,160 @@ package vkube
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/containerd/containerd"
-	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/mount"
 
 	"io"
+	"os"
 	"strings"
+	"sync"
 
 	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/images"
 	"fledge/fledge-integrated/manager"
+	"fledge/fledge-integrated/providers"
 
 	"github.com/containerd/containerd/contrib/nvidia"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/oci"
+	"github.com/cpuguy83/strongerrors"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 type PodContainer struct {
-	podName   string
-	container containerd.Container
-	task      containerd.Task
+	podName       string
+	containerName string
+	container     containerd.Container
+	task          containerd.Task
+	//the image the container was created from, by digest
+	imageID string
+	//output of the current task, every run gets its own log file in podLogDir
+	logs      *CRILogWriter
+	podLogDir string
+	stdio     *containerStdio
+
+	//restart bookkeeping, guarded by lock since task exits are handled in their own goroutine
+	lock          sync.Mutex
+	pod           *v1.Pod
+	restartPolicy v1.RestartPolicy
+	startedAt     time.Time
+	restartCount  int32
+	//exit that was followed by a restart, reported as LastTerminationState
+	lastTermination *v1.ContainerStateTerminated
+	//final exit, set when the restart policy doesn't allow another run
+	terminated   *v1.ContainerStateTerminated
+	backoff      time.Duration
+	waitingUntil time.Time
+	stopping     bool
+	//the exit of the current task was handled, and whether containerd reported it ran out of memory
+	exited    bool
+	oomKilled bool
 }
 
 type ContainerdRuntimeInterface struct {
-	client                   *containerd.Client
-	containerNameTaskMapping map[string]PodContainer
+	client *containerd.Client
+	//lock guards the maps below, podsChanged and notify, they are used by the event loop as well
+	lock                     sync.Mutex
+	containerNameTaskMapping map[string]*PodContainer
+	sandboxes                map[string]*podSandbox
+	deployErrors             map[string]error
 	podSpecs                 map[string]*v1.Pod
 	ctx                      context.Context
 	podsChanged              bool
+	notify                   func()
+	logs                     *ContainerLogManager
+	prober                   *Prober
+	recorder                 record.EventRecorder
+	store                    *ContainerdStateStore
+	//serializes the deployment, updates, deletion and status syncs of each pod,
+	//stored pods are replaced instead of changed so they can be read while their worker runs
+	workers *podWorkers
+	images  *images.Manager
+	//the image pull secrets of pods are read through it, guarded by lock
+	resources *manager.ResourceManager
 }
 
 func (cdri *ContainerdRuntimeInterface) PodsChanged() bool {
+	cdri.lock.Lock()
+	defer cdri.lock.Unlock()
 	return cdri.podsChanged
 }
 
 func (cdri *ContainerdRuntimeInterface) ResetFlags() {
 	fmt.Println("Setting podsChanged false")
+	cdri.lock.Lock()
+	defer cdri.lock.Unlock()
 	cdri.podsChanged = false
 }
 
-func NewContainerdRuntimeInterface() ContainerdRuntimeInterface {
+func NewContainerdRuntimeInterface() *ContainerdRuntimeInterface {
 	//log.GetLogger(cdri.ctx).Logger.Level = logrus.DebugLevel
-	cdri := ContainerdRuntimeInterface{}
+	cdri := &ContainerdRuntimeInterface{}
 	cdri.ctx = namespaces.WithNamespace(context.Background(), "default")
 
 	cdri.podSpecs = make(map[string]*v1.Pod)
-	cdri.containerNameTaskMapping = make(map[string]PodContainer)
+	cdri.containerNameTaskMapping = make(map[string]*PodContainer)
+	cdri.sandboxes = make(map[string]*podSandbox)
+	cdri.deployErrors = make(map[string]error)
+	cdri.workers = newPodWorkers()
+	cdri.logs = NewContainerLogManager(config.Cfg.Containerd)
+	cdri.prober = NewProber()
 	cdri.client, _ = containerd.New("/run/containerd/containerd.sock")
 	if cdri.client == nil {
 		fmt.Println("Failed to create containerd client!")
 	}
+	store, err := OpenContainerdStateStore(config.Cfg.Containerd.StateDir)
+	if err != nil {
+		fmt.Printf("Pods won't be adopted after a restart: %s\n", err.Error())
+	} else {
+		cdri.store = store
+	}
+
+	cdri.images = images.NewManager(cdri.ctx, &containerdPuller{client: cdri.client}, config.Cfg.Containerd.MaxParallelPulls, cdri.recordEvent)
 
 	mount.SetTempMountLocation("/ctdtmp")
 
-	go func() {
-		cdri.PollLoop()
-	}()
+	//pods that kept running while fledge was down are taken over before events are handled
+	cdri.reconcile()
 
-	return cdri
-}
+	go cdri.EventLoop()
+	go cdri.PollLoop()
+	go cdri.logs.GCLoop()
 
-func (dri *ContainerdRuntimeInterface) PollLoop() {
-	for {
-		for _, pod := range dri.podSpecs {
-			dri.UpdatePodStatus(pod.ObjectMeta.Namespace, pod)
-		}
-
-		time.Sleep(3000 * time.Millisecond)
-	}
+	return cdri
 }
 
+// GetPod returns a copy of the pod, callers are free to change it
 func (cdri *ContainerdRuntimeInterface) GetPod(namespace string, name string) (*v1.Pod, bool) {
+	cdri.lock.Lock()
 	pod, found := cdri.podSpecs[namespace+"_"+name]
-	return pod, found
+	cdri.lock.Unlock()
+	if !found {
+		return nil, false
+	}
+	return pod.DeepCopy(), true
 }
 
+// GetPods returns copies of all pods
 func (cdri *ContainerdRuntimeInterface) GetPods() []*v1.Pod {
-	pods := []*v1.Pod{}
-
+	cdri.lock.Lock()
+	stored := []*v1.Pod{}
 	for _, pod := range cdri.podSpecs {
-		pods = append(pods, pod)
+		stored = append(stored, pod)
 	}
+	cdri.lock.Unlock()
 
+	pods := []*v1.Pod{}
+	for _, pod := range stored {
+		pods = append(pods, pod.DeepCopy())
+	}
 	return pods
 }
 
@@ -103,63 +167,74 @@ func (dri *ContainerdRuntimeInterface) GetContainerNameAlt(namespace string, pod
 	return namespace + "_" + podName + "_" + dcName
 }
 
-func (dri *ContainerdRuntimeInterface) DeployPod(pod *v1.Pod) {
+// DeployPod deploys a copy of the pod in its pod worker, the caller keeps its own pod.
+// A pod that fails to deploy is removed again, so it can be created anew.
+func (dri *ContainerdRuntimeInterface) DeployPod(pod *v1.Pod) error {
+	pod = pod.DeepCopy()
+	var err error
+	dri.workers.run(pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name, func() {
+		err = dri.deployPod(pod)
+	})
+	return err
+}
+
+func (dri *ContainerdRuntimeInterface) deployPod(pod *v1.Pod) error {
 	namespace := pod.ObjectMeta.Namespace
 
-	dri.podSpecs[namespace+"_"+pod.ObjectMeta.Name] = pod
+	dri.setPodSpec(pod)
 
 	if config.Cfg.IgnoreKubeProxy == "true" && strings.HasPrefix(pod.ObjectMeta.Name, "kube-proxy") {
 		IgnoreKubeProxy(pod)
-		return
+		dri.setPodSpec(pod)
+		return nil
 	}
 
 	CreateVolumes(dri.ctx, pod)
 
-	initContainers := false
-	var containers []v1.Container
-	if len(pod.Spec.InitContainers) > 0 {
-		initContainers = true
-		containers = pod.Spec.InitContainers
-	} else {
-		containers = pod.Spec.Containers
+	initContainers := len(pod.Spec.InitContainers) > 0
+	UpdatePostCreationPodStatus(pod, initContainers)
+
+	//the sandbox holds the namespaces of the pod, so containers can restart without losing the pod ip
+	if _, err := dri.createSandbox(pod); err != nil {
+		dri.deletePodSpec(pod)
+		return err
+	}
+
+	if err := dri.store.SavePod(pod); err != nil {
+		fmt.Printf("Failed to store pod %s: %s\n", pod.ObjectMeta.Name, err.Error())
 	}
-	for _, cont := range containers {
-		_, err := dri.DeployContainer(namespace, pod, &cont)
+	if initContainers {
+		//init containers run one at a time, the next one is started once the previous one completed
+		dri.CheckInitContainers(namespace, pod)
+		dri.setPodSpec(pod)
+		return nil
+	}
+	for i := range pod.Spec.Containers {
+		_, err := dri.DeployContainer(namespace, pod, &pod.Spec.Containers[i])
 		if err != nil {
-			delete(dri.podSpecs, namespace+"_"+pod.ObjectMeta.Name)
-			panic(err)
+			dri.rollbackPod(pod)
+			return err
 		}
 	}
+	dri.setPodSpec(pod)
 
-	UpdatePostCreationPodStatus(pod, initContainers)
 	fmt.Println("Setting podsChanged true")
-	dri.podsChanged = true
+	dri.setPodsChanged()
+	return nil
 }
 
-func ValidPrefix(tagPrefix string) bool {
-	switch tagPrefix {
-	case "docker.io":
-		fallthrough
-	case "k8s.gcr.io":
-		return true
-	default:
-		return false
-	}
-	return false
+// rollbackPod removes the containers and the sandbox of a pod that failed to deploy, they are given no grace period
+func (dri *ContainerdRuntimeInterface) rollbackPod(pod *v1.Pod) {
+	fmt.Printf("Rolling back pod %s\n", pod.ObjectMeta.Name)
+	pod = pod.DeepCopy()
+	var noGracePeriod int64
+	pod.ObjectMeta.DeletionGracePeriodSeconds = &noGracePeriod
+	dri.deletePod(pod)
 }
 
-func (dri *ContainerdRuntimeInterface) CheckFullTag(imageName string) string {
-	urlParts := strings.Split(imageName, "/")
-	if !ValidPrefix(urlParts[0]) {
-		imageName = fmt.Sprintf("docker.io/%s", imageName)
-	}
-
-	parts := strings.Split(imageName, ":")
-	if len(parts) == 1 {
-		return fmt.Sprintf("%s:latest", imageName)
-	} else {
-		return imageName
-	}
+// CheckFullTag returns the full reference of an image, images that don't name a registry are taken from the default one in the config
+func (dri *ContainerdRuntimeInterface) CheckFullTag(imageName string) (string, error) {
+	return images.NormalizeRef(imageName, config.Cfg.Containerd.Registry.DefaultRegistry)
 }
 
 func (dri *ContainerdRuntimeInterface) SetupPorts(pod *v1.Pod, dc *v1.Container) {
@@ -170,24 +245,31 @@ func (dri *ContainerdRuntimeInterface) CleanupPorts(pod *v1.Pod, dc *v1.Containe
 
 }
 
+// DeployContainer creates and starts the container, why it failed is reported in its status until it is deployed
 func (dri *ContainerdRuntimeInterface) DeployContainer(namespace string, pod *v1.Pod, dc *v1.Container) (string, error) {
+	id, err := dri.deployContainer(namespace, pod, dc)
+	dri.setDeployError(dri.GetContainerName(namespace, *pod, *dc), err)
+	return id, err
+}
+
+func (dri *ContainerdRuntimeInterface) deployContainer(namespace string, pod *v1.Pod, dc *v1.Container) (string, error) {
 	imageName := dc.Image
 	fullName := dri.GetContainerName(namespace, *pod, *dc)
 
-	imageName = dri.CheckFullTag(imageName)
+	imageName, err := dri.CheckFullTag(imageName)
+	if err != nil {
+		return "", &providers.ImagePullError{Container: dc.Name, Image: dc.Image, Reason: providers.ReasonInvalidImageName, Err: err}
+	}
 
 	envVars := GetEnvAsStringArray(dc)
 
-	//restart policy won't be set here, instead tasks have to be monitored for their status
-	//and restarted if failed (and pod/node is not shutting down). TODO
-
-	//determine ipc and pid mode
-	var ipcMode *oci.SpecOpts = nil
-	if pod.Spec.HostIPC {
-		opt := oci.WithHostNamespace(specs.IPCNamespace)
-		ipcMode = &opt
+	//the network, ipc and uts namespaces are those of the pod sandbox
+	sandbox := dri.getSandbox(pod)
+	if sandbox == nil {
+		return "", &providers.CreateContainerError{Container: dc.Name, Err: errors.New("sandbox of pod " + pod.ObjectMeta.Name + " not found")}
 	}
 
+	//determine pid mode
 	var pidMode *oci.SpecOpts = nil
 	if pod.Spec.HostPID {
 		opt := oci.WithHostNamespace(specs.PIDNamespace)
@@ -203,29 +285,28 @@ func (dri *ContainerdRuntimeInterface) DeployContainer(namespace string, pod *v1
 	//handle volume mounts
 	vmounts := dri.BuildMounts(pod, dc)
 
-	//handle resource limits
-	cgroup := dri.SetContainerResources(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, dc)
+	//find out if a gpu should be assigned, and if we have the right type for the container
+	assignGpu, err := CheckGpuResourceRequired(dc)
+	if err != nil {
+		fmt.Println(err.Error())
+		return "", &providers.CreateContainerError{Container: dc.Name, Config: true, Err: err}
+	}
 
 	//pull image + policy
-	var image containerd.Image
-	image, err := dri.client.GetImage(dri.ctx, imageName)
-	if (err != nil && dc.ImagePullPolicy == v1.PullIfNotPresent) || dc.ImagePullPolicy == v1.PullAlways {
-		if dc.ImagePullPolicy == v1.PullAlways {
-			dri.client.ImageService().Delete(dri.ctx, imageName)
-		}
-		image, err = dri.client.Pull(dri.ctx, imageName, containerd.WithPullUnpack)
-		if err != nil {
-			fmt.Printf("Pull failed for image %s\n", imageName)
-			fmt.Println(err.Error())
-			return "", err
-		}
-	} else {
+	if _, err = dri.images.EnsureImage(dri.ctx, pod, dc.Name, imageName, dc.ImagePullPolicy, dri.imagePullKeyring(pod)); err != nil {
 		fmt.Println(err.Error())
 		return "", err
 	}
+	image, err := dri.client.GetImage(dri.ctx, imageName)
+	if err != nil {
+		return "", &providers.ImagePullError{Container: dc.Name, Image: imageName, Err: err}
+	}
 
 	fmt.Printf("Image exists or successfully pulled: %s\n", image.Name())
 
+	//handle resource limits
+	cgroup := dri.SetContainerResources(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, dc)
+
 	//generate container id + snapshot
 	snapshot := fmt.Sprintf("%s-snapshot", fullName)
 
@@ -241,12 +322,6 @@ func (dri *ContainerdRuntimeInterface) DeployContainer(namespace string, pod *v1
 		//netSpecOpts,
 	}
 
-	//find out if a gpu should be assigned, and if we have the right type for the container
-	assignGpu, err := CheckGpuResourceRequired(dc)
-	if err != nil {
-		fmt.Println(err.Error())
-		return "", err
-	}
 	if assignGpu {
 		//to be fair, it's not guaranteed to be nvidia, should really check for AMD or other devices instead of just cuda/opencv
 		//but then again, it's not like they have a container hook, so we should really just detect and advertise nvidia?
@@ -259,15 +334,12 @@ func (dri *ContainerdRuntimeInterface) DeployContainer(namespace string, pod *v1
 	if dc.WorkingDir != "" {
 		specOpts = append(specOpts, oci.WithProcessCwd(dc.WorkingDir))
 	}
-	if ipcMode != nil {
-		specOpts = append(specOpts, *ipcMode)
-	}
+	specOpts = append(specOpts, withSandboxNamespaces(pod, sandbox))
 	if pidMode != nil {
 		specOpts = append(specOpts, *pidMode)
 	}
-	//NET: trying something out
-	if pod.Spec.HostNetwork {
-		specOpts = append(specOpts, oci.WithHostNamespace(specs.NetworkNamespace))
+	if dc.TTY {
+		specOpts = append(specOpts, oci.WithTTY)
 	}
 	//assign all caps
 	if privileged {
@@ -282,42 +354,47 @@ func (dri *ContainerdRuntimeInterface) DeployContainer(namespace string, pod *v1
 		containerd.WithImage(image),
 		containerd.WithNewSnapshot(snapshot, image),
 		containerd.WithNewSpec(specOpts...),
+		containerd.WithImageStopSignal(image, "SIGTERM"),
+		containerd.WithContainerLabels(containerLabels(pod, dc.Name)),
 	)
 	if err != nil {
 		fmt.Println(err.Error())
+		DeleteCgroup(cgroup)
+		return "", &providers.CreateContainerError{Container: dc.Name, Err: err}
 	}
 
 	fmt.Printf("Successfully created container with ID %s and snapshot with ID %s\n", container.ID(), snapshot)
 
-	// create a task from the container
-	task, err := container.NewTask(dri.ctx, cio.NewCreator(cio.WithStdio))
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	fmt.Println("Task created")
-	//defer task.Delete(ctx)
-
-	// make sure we wait before calling start
-	exitStatusC, err := task.Wait(dri.ctx)
+	podLogDir := PodLogDir(dri.logs.Dir(), pod)
+	stdio := newContainerStdio(dri.ctx, dc)
+	task, logs, err := dri.startTask(container, ContainerLogFile(podLogDir, dc.Name, 0), stdio)
 	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println("Task awaited with status %d", exitStatusC)
-
-	// call start on the task to execute the redis server
-	if err := task.Start(dri.ctx); err != nil {
 		fmt.Println(err.Error())
-	}
-	fmt.Println("Task started")
-
-	//NET: trying to fix the net namespace here
-	dri.SetupPodIPs(pod, task)
-
-	dri.containerNameTaskMapping[fullName] = PodContainer{
-		podName:   pod.ObjectMeta.Name,
-		container: container,
-		task:      task,
-	}
+		container.Delete(dri.ctx, containerd.WithSnapshotCleanup)
+		DeleteCgroup(cgroup)
+		return "", &providers.RunContainerError{Container: dc.Name, Err: err}
+	}
+
+	podContainer := &PodContainer{
+		podName:       pod.ObjectMeta.Name,
+		containerName: dc.Name,
+		container:     container,
+		imageID:       image.Name() + "@" + image.Target().Digest.String(),
+		task:          task,
+		logs:          logs,
+		podLogDir:     podLogDir,
+		stdio:         stdio,
+		//probes and hooks read the pod while its worker keeps changing its own copy
+		pod:           pod.DeepCopy(),
+		restartPolicy: containerRestartPolicy(pod, dc.Name),
+		startedAt:     time.Now(),
+	}
+	dri.recordTaskStart(podContainer)
+	dri.lock.Lock()
+	dri.containerNameTaskMapping[fullName] = podContainer
+	dri.lock.Unlock()
+	//the exit event of a short-lived task may have arrived before the container was known
+	dri.taskStarted(fullName, podContainer, task)
 
 	return task.ID(), nil
 }
@@ -352,20 +429,6 @@ func CheckGpuResourceRequired(dc *v1.Container) (bool, error) {
 	return false, nil
 }
 
-func (dri *ContainerdRuntimeInterface) SetupPodIPs(pod *v1.Pod, task containerd.Task) {
-	pod.Status.HostIP = config.Cfg.DeviceIP
-	if pod.Status.PodIP == "" {
-		if pod.Spec.HostNetwork {
-			pod.Status.PodIP = config.Cfg.DeviceIP
-		} else {
-			pids, _ := task.Pids(dri.ctx)
-			pidJson, _ := json.Marshal(pids)
-			fmt.Printf("Container pids %s", string(pidJson))
-			pod.Status.PodIP = BindNetNamespace(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, int(pids[0].Pid))
-		}
-	}
-}
-
 func (dri *ContainerdRuntimeInterface) BuildMounts(pod *v1.Pod, dc *v1.Container) []specs.Mount {
 	mounts := []specs.Mount{}
 	//mountNames := make(map[string]struct{})
@@ -423,122 +486,157 @@ func (dri *ContainerdRuntimeInterface) CreateMount(pod *v1.Pod, volMount v1.Volu
 }
 
 func (dri *ContainerdRuntimeInterface) SetContainerResources(namespace string, podname string, dc *v1.Container) string {
-	//some default values
-	oneCpu, _ := resource.ParseQuantity("1")
-	defaultMem, _ := resource.ParseQuantity("150Mi")
-
-	fmt.Printf("Checking cpu limiting support\n")
-	supportCheck, _ := manager.ExecCmdBash("ls /sys/fs/cgroup/cpu/ | grep -E 'cpu.cfs_[a-z]*_us'")
-	cpuSupported := supportCheck != ""
-	fmt.Printf("Cpu limit support %s\n", cpuSupported)
-
-	var cpuLimit float64
-	var memLimit int64
-
-	if dc.Resources.Limits == nil {
-		dc.Resources.Limits = v1.ResourceList{}
-	}
-	if dc.Resources.Requests == nil {
-		dc.Resources.Requests = v1.ResourceList{}
-	}
-	memory := dc.Resources.Limits.Memory()
-	if memory.IsZero() {
-		//if the memory limit isn't filled in, set it to request
-		memory = dc.Resources.Requests.Memory()
-		dc.Resources.Limits[v1.ResourceMemory] = *memory
-	}
-	if !memory.IsZero() {
-		memLimit = memory.Value()
-	} else {
-		//this means neither was set, so update both limit and request with a default value
-		dc.Resources.Limits[v1.ResourceMemory] = defaultMem
-		dc.Resources.Requests[v1.ResourceMemory] = defaultMem
-		memLimit = 150 * 1024 * 1024 //150 Mi
-	}
-
-	if cpuSupported {
-		cpu := dc.Resources.Limits.Cpu()
-		if cpu.IsZero() {
-			//same if cpu limit isn't filled in
-			cpu = dc.Resources.Requests.Cpu()
-			dc.Resources.Limits[v1.ResourceCPU] = *cpu
-		}
-		if !cpu.IsZero() {
-			cpuLimit = float64(cpu.MilliValue()) / 1000.0
-		} else {
-			dc.Resources.Limits[v1.ResourceCPU] = oneCpu
-			dc.Resources.Requests[v1.ResourceCPU] = oneCpu
-			cpuLimit = 1 // 1 CPU
-		}
-	}
+	return SetContainerResources(namespace, podname, dc)
+}
 
-	cgroup := CreateCgroupIfNotExists(namespace, podname, dc.Name)
-	SetMemoryLimit(cgroup, memLimit)
-	SetCpuLimit(cgroup, cpuLimit)
-	return cgroup
+// UpdatePod replaces the containers of the pod by those of a copy of pod, in its pod worker.
+// Containers that fail to deploy are tried again by the status updates, the first failure is returned.
+func (dri *ContainerdRuntimeInterface) UpdatePod(pod *v1.Pod) error {
+	pod = pod.DeepCopy()
+	var err error
+	dri.workers.run(pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name, func() {
+		err = dri.updatePod(pod)
+	})
+	return err
 }
 
-func (dri *ContainerdRuntimeInterface) UpdatePod(pod *v1.Pod) {
+func (dri *ContainerdRuntimeInterface) updatePod(pod *v1.Pod) error {
 	containers := pod.Spec.Containers
 	namespace := pod.ObjectMeta.Namespace
 
-	dri.podSpecs[namespace+"_"+pod.ObjectMeta.Name] = pod
+	dri.setPodSpec(pod)
+	if err := dri.store.SavePod(pod); err != nil {
+		fmt.Printf("Failed to store pod %s: %s\n", pod.ObjectMeta.Name, err.Error())
+	}
 
-	for _, cont := range containers {
-		dri.UpdateContainer(namespace, pod, &cont)
+	var firstErr error
+	for i := range containers {
+		if err := dri.UpdateContainer(namespace, pod, &containers[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	dri.setPodSpec(pod)
 	fmt.Println("Setting podsChanged true")
-	dri.podsChanged = true
+	dri.setPodsChanged()
+	return firstErr
 }
 
-func (dri *ContainerdRuntimeInterface) UpdateContainer(namespace string, pod *v1.Pod, dc *v1.Container) {
-	dri.StopContainer(namespace, pod, dc)
-	dri.DeployContainer(namespace, pod, dc)
+func (dri *ContainerdRuntimeInterface) UpdateContainer(namespace string, pod *v1.Pod, dc *v1.Container) error {
+	if err := dri.StopContainer(namespace, pod, dc); err != nil {
+		return err
+	}
+	_, err := dri.DeployContainer(namespace, pod, dc)
+	return err
 }
 
-func (dri *ContainerdRuntimeInterface) DeletePod(pod *v1.Pod) {
-	containers := pod.Spec.Containers
-	namespace := pod.ObjectMeta.Namespace
+// DeletePod stops the containers of the pod in its pod worker, after the operations that were submitted before
+func (dri *ContainerdRuntimeInterface) DeletePod(pod *v1.Pod) error {
+	pod = pod.DeepCopy()
+	var err error
+	dri.workers.run(pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name, func() {
+		err = dri.deletePod(pod)
+	})
+	return err
+}
 
-	delete(dri.podSpecs, namespace+"_"+pod.ObjectMeta.Name)
+// deletePod stops the containers and removes the sandbox of the pod, a pod with a container that
+// couldn't be stopped is kept with its sandbox
+func (dri *ContainerdRuntimeInterface) deletePod(pod *v1.Pod) error {
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	namespace := pod.ObjectMeta.Namespace
 
-	for _, cont := range containers {
-		dri.StopContainer(namespace, pod, &cont)
+	stored, _ := dri.GetPod(namespace, pod.ObjectMeta.Name)
+	dri.deletePodSpec(pod)
+
+	//containers stop at the same time, so they all get the same grace period
+	var wg sync.WaitGroup
+	stopErrs := make([]error, len(containers))
+	for i := range containers {
+		wg.Add(1)
+		dri.setDeployError(dri.GetContainerName(namespace, *pod, containers[i]), nil)
+		go func(i int) {
+			defer wg.Done()
+			stopErrs[i] = dri.StopContainer(namespace, pod, &containers[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range stopErrs {
+		if err != nil {
+			if stored != nil {
+				dri.setPodSpec(stored)
+			}
+			dri.setPodsChanged()
+			return fmt.Errorf("failed to delete pod %s: %w", pod.ObjectMeta.Name, err)
+		}
+	}
+	dri.removeSandbox(pod)
+	if err := dri.store.DeletePod(namespace, pod.ObjectMeta.Name); err != nil {
+		fmt.Printf("Failed to remove pod %s from the store: %s\n", pod.ObjectMeta.Name, err.Error())
 	}
-	RemoveNetNamespace(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
 
-	FreeIP(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	os.RemoveAll(PodLogDir(dri.logs.Dir(), pod))
 	fmt.Println("Setting podsChanged true")
-	dri.podsChanged = true
+	dri.setPodsChanged()
+	return nil
 }
 
-func (dri *ContainerdRuntimeInterface) StopContainer(namespace string, pod *v1.Pod, dc *v1.Container) bool {
+// StopContainer stops the container within its grace period and removes it, a container whose task couldn't be
+// stopped or deleted is kept
+func (dri *ContainerdRuntimeInterface) StopContainer(namespace string, pod *v1.Pod, dc *v1.Container) error {
 	fullName := dri.GetContainerName(namespace, *pod, *dc) //namespace + "_" + pod.ObjectMeta.Name + "_" + dc.Name
 	fmt.Printf("Stopping container %s\n", fullName)
 
-	tuple, found := dri.containerNameTaskMapping[fullName]
-	if found {
-		fmt.Printf("Stopping and removing task id %s\n", tuple.task.ID())
-		//time, _ := time.ParseDuration("10s")
-		//err := dri.cli.ContainerStop(dri.ctx, contID, nil)
-		exitStatus, err := tuple.task.Delete(dri.ctx)
-		fmt.Printf("Task stopped status %d \n", exitStatus)
-
-		if err == nil {
-			delete(dri.containerNameTaskMapping, fullName)
-			fmt.Printf("Removing container %s\n", fullName)
-
-			err = tuple.container.Delete(dri.ctx, containerd.WithSnapshotCleanup)
-			DeleteCgroup(GetCgroup(namespace, pod.ObjectMeta.Name, dc.Name))
-			if err != nil {
+	tuple := dri.getPodContainer(fullName)
+	if tuple != nil {
+		//the task exit caused by stopping it must not trigger a restart
+		tuple.lock.Lock()
+		tuple.stopping = true
+		task := tuple.task
+		logs := tuple.logs
+		running := task != nil && !tuple.exited
+		tuple.lock.Unlock()
+		dri.prober.StopContainer(fullName)
+
+		//the container gets its grace period to stop before its task is removed
+		if running {
+			if err := dri.terminateTask(fullName, tuple, task, TerminationGracePeriod(pod, nil)); err != nil {
 				fmt.Println(err.Error())
+				return err
 			}
-		} else {
+		}
+
+		//a container waiting for a restart that failed has no task
+		if task != nil {
+			fmt.Printf("Stopping and removing task id %s\n", task.ID())
+			//time, _ := time.ParseDuration("10s")
+			//err := dri.cli.ContainerStop(dri.ctx, contID, nil)
+			exitStatus, err := task.Delete(dri.ctx)
+			if err != nil && !errdefs.IsNotFound(err) {
+				fmt.Println(err.Error())
+				return fmt.Errorf("failed to delete task of container %s: %w", fullName, err)
+			}
+			fmt.Printf("Task stopped status %v \n", exitStatus)
+		}
+		if logs != nil {
+			logs.Close()
+		}
+		if ts := tuple.stdio.currentTask(); ts != nil {
+			ts.close()
+		}
+
+		dri.lock.Lock()
+		delete(dri.containerNameTaskMapping, fullName)
+		dri.lock.Unlock()
+		fmt.Printf("Removing container %s\n", fullName)
+
+		//a container that is left behind without a task is removed as an orphan when fledge starts again
+		err := tuple.container.Delete(dri.ctx, containerd.WithSnapshotCleanup)
+		DeleteCgroup(GetCgroup(namespace, pod.ObjectMeta.Name, dc.Name))
+		if err != nil {
 			fmt.Println(err.Error())
 		}
-		return err == nil
 	}
-	return true
+	return nil
 }
 
 func (dri *ContainerdRuntimeInterface) UpdatePodStatus(namespace string, pod *v1.Pod) {
@@ -555,13 +653,13 @@ func (dri *ContainerdRuntimeInterface) UpdatePodStatus(namespace string, pod *v1
 		fmt.Println("Pod ready, just updating")
 		//everything good, just update statuses
 		//check pod status phases running or succeeded
-		dri.UpdateContainerStatuses(namespace, pod, *latestStatus)
+		dri.UpdateContainerStatuses(namespace, pod)
 		//dri.SetupPodIPs(pod)
 	case v1.PodInitialized:
 		if latestStatus.Status == v1.ConditionTrue {
 			fmt.Println("Pod initialized, just updating and upgrading to ready if possible")
 			//update statuses, check for PodReady, check for phase running
-			dri.UpdateContainerStatuses(namespace, pod, *latestStatus)
+			dri.UpdateContainerStatuses(namespace, pod)
 		} else {
 			fmt.Println("Pod initialized, checking init containers")
 			//check init containers
@@ -576,145 +674,188 @@ func (dri *ContainerdRuntimeInterface) UpdatePodStatus(namespace string, pod *v1
 	}
 }
 
+// CheckInitContainers starts the next init container of the pod once the previous one completed,
+// and the app containers once all of them completed
 func (dri *ContainerdRuntimeInterface) CheckInitContainers(namespace string, pod *v1.Pod) {
-	//ctx := namespaces.WithNamespace(context.Background(), namespace)
-	allContainersDone := true
-	noErrors := true
+	initStatuses, next, incomplete, failed := dri.getInitContainerStatuses(namespace, pod)
+	pod.Status.InitContainerStatuses = initStatuses
 
-	containerStatuses := []v1.ContainerStatus{}
-	for _, cont := range pod.Spec.Containers {
-		fullName := dri.GetContainerNameAlt(namespace, pod.ObjectMeta.Name, cont.Name)
-		tuple, found := dri.containerNameTaskMapping[fullName]
-		if found {
-			state := v1.ContainerState{}
+	changed := UpdateInitPodStatus(pod, failed, incomplete)
+	if failed {
+		dri.setPodsChanged()
+		return
+	}
+	if next != nil {
+		fmt.Printf("Starting init container %s of pod %s\n", next.Name, pod.ObjectMeta.Name)
+		if _, err := dri.DeployContainer(namespace, pod, next); err != nil {
+			fmt.Println(err.Error())
+			dri.recordEvent(pod, v1.EventTypeWarning, "Failed", err.Error())
+		}
+		changed = true
+	}
+	if len(incomplete) > 0 {
+		containerStatuses := []v1.ContainerStatus{}
+		for _, cont := range pod.Spec.Containers {
+			containerStatuses = append(containerStatuses, WaitingContainerStatus(cont, "PodInitializing", ""))
+		}
+		pod.Status.ContainerStatuses = containerStatuses
+		if changed {
+			dri.setPodsChanged()
+		}
+		return
+	}
 
-			taskStatus, _ := tuple.task.Status(dri.ctx)
-			switch taskStatus.Status { //contJSON.State.Status {
-			case containerd.Created: //"created":
-				allContainersDone = false
-				state.Waiting = &v1.ContainerStateWaiting{
-					Reason:  "Starting",
-					Message: "Starting container",
-				}
-			case containerd.Running: //"running":
-				fallthrough
-			case containerd.Paused: //"paused":
-				fallthrough
-			case containerd.Pausing: //"restarting":
-				allContainersDone = false
-				state.Running = &v1.ContainerStateRunning{ //add real time later
-					StartedAt: metav1.Now(),
-				}
-			case containerd.Stopped: //"dead":
-				if taskStatus.ExitStatus > 0 {
-					noErrors = false
-				}
-				state.Terminated = &v1.ContainerStateTerminated{
-					Reason:      "Stopped",
-					Message:     "Container stopped",
-					FinishedAt:  metav1.Now(), //add real time later
-					ContainerID: tuple.container.ID(),
-				}
-			}
+	//the actual containers are deployed by the status update
+	dri.UpdateContainerStatuses(namespace, pod)
+	dri.setPodsChanged()
+}
 
-			status := v1.ContainerStatus{
-				Name:         cont.Name,
-				State:        state,
-				Ready:        false,
-				RestartCount: 0,
-				Image:        cont.Image,
-				ImageID:      "",
-				ContainerID:  tuple.container.ID(),
+// getInitContainerStatuses reports the init containers of the pod, which run one at a time in order.
+// next is the init container that should be started, it is nil while one is running or once all of them completed.
+func (dri *ContainerdRuntimeInterface) getInitContainerStatuses(namespace string, pod *v1.Pod) (statuses []v1.ContainerStatus, next *v1.Container, incomplete []string, failed bool) {
+	statuses = []v1.ContainerStatus{}
+	previousCompleted := true
+	for i := range pod.Spec.InitContainers {
+		cont := &pod.Spec.InitContainers[i]
+		var status v1.ContainerStatus
+		if pc := dri.getPodContainer(dri.GetContainerNameAlt(namespace, pod.ObjectMeta.Name, cont.Name)); pc != nil {
+			status = dri.GetContainerStatus(*cont, pc)
+		} else {
+			if previousCompleted && next == nil {
+				next = cont
 			}
-
-			containerStatuses = []v1.ContainerStatus{status}
+			status = dri.missingContainerStatus(namespace, pod.ObjectMeta.Name, *cont, "PodInitializing")
 		}
-	}
-	pod.Status.ContainerStatuses = containerStatuses
 
-	if noErrors && allContainersDone {
-		//start actual containers
-		for _, container := range pod.Spec.Containers {
-			(*dri).DeployContainer(pod.ObjectMeta.Namespace, pod, &container)
+		//a failed init container only shows up as terminated when the restart policy doesn't run it again
+		terminated := status.State.Terminated
+		completed := terminated != nil && terminated.ExitCode == 0
+		if terminated != nil && terminated.ExitCode != 0 {
+			failed = true
+		}
+		if !completed {
+			incomplete = append(incomplete, cont.Name)
 		}
+		previousCompleted = previousCompleted && completed
+		statuses = append(statuses, status)
 	}
-	UpdateInitPodStatus(pod, noErrors, allContainersDone)
+	return statuses, next, incomplete, failed
 }
 
-func (dri *ContainerdRuntimeInterface) UpdateContainerStatuses(namespace string, pod *v1.Pod, podStatus v1.PodCondition) {
+func (dri *ContainerdRuntimeInterface) UpdateContainerStatuses(namespace string, pod *v1.Pod) {
 	//ctx := namespaces.WithNamespace(context.Background(), namespace)
 	allContainersRunning := true
 	allContainersDone := true
 	noErrors := true
 
+	//completed init containers keep being reported
+	if len(pod.Spec.InitContainers) > 0 {
+		pod.Status.InitContainerStatuses, _, _, _ = dri.getInitContainerStatuses(namespace, pod)
+	}
+
 	containerStatuses := []v1.ContainerStatus{}
-	for _, cont := range pod.Spec.Containers {
+	for i, cont := range pod.Spec.Containers {
 		fullName := dri.GetContainerNameAlt(namespace, pod.ObjectMeta.Name, cont.Name)
-		tuple, found := dri.containerNameTaskMapping[fullName]
-		if found {
-			state := v1.ContainerState{}
-
-			taskStatus, _ := tuple.task.Status(dri.ctx)
-			switch taskStatus.Status { //contJSON.State.Status {
-			case "created":
+		tuple := dri.getPodContainer(fullName)
+		if tuple == nil {
+			//containers that couldn't be created yet are tried again on every status update
+			if _, err := dri.DeployContainer(namespace, pod, &pod.Spec.Containers[i]); err != nil {
+				fmt.Println(err.Error())
+				dri.recordEvent(pod, v1.EventTypeWarning, "Failed", err.Error())
 				allContainersRunning = false
 				allContainersDone = false
-				state.Waiting = &v1.ContainerStateWaiting{
-					Reason:  "Starting",
-					Message: "Starting container",
-				}
-			case "running":
-				fallthrough
-			case "paused":
-				fallthrough
-			case "restarting":
-				allContainersDone = false
-				state.Running = &v1.ContainerStateRunning{ //add real time later
-					StartedAt: metav1.Now(),
-				}
-			case "removing":
-				fallthrough
-			case "exited":
-				fallthrough
-			case "dead":
-				if taskStatus.ExitStatus > 0 { //contJSON.State.ExitCode > 0 {
-					noErrors = false
-				}
-				state.Terminated = &v1.ContainerStateTerminated{
-					Reason:      "Stopped",
-					Message:     "Container stopped",
-					FinishedAt:  metav1.Now(), //add real time later
-					ContainerID: tuple.container.ID(),
-				}
+				containerStatuses = append(containerStatuses, dri.missingContainerStatus(namespace, pod.ObjectMeta.Name, cont, "ContainerCreating"))
+				continue
 			}
-
-			status := v1.ContainerStatus{
-				Name:         cont.Name,
-				State:        state,
-				Ready:        false,
-				RestartCount: 0,
-				Image:        cont.Image,
-				ImageID:      "",
-				ContainerID:  tuple.container.ID(),
-			}
-
-			containerStatuses = []v1.ContainerStatus{status}
+			tuple = dri.getPodContainer(fullName)
+		}
+		status := dri.GetContainerStatus(cont, tuple)
+		if status.State.Running == nil {
+			allContainersRunning = false
+		}
+		if status.State.Terminated == nil {
+			allContainersDone = false
+		} else if status.State.Terminated.ExitCode != 0 {
+			noErrors = false
 		}
+		containerStatuses = append(containerStatuses, status)
 	}
-	changed := UpdatePodStatus(podStatus, containerStatuses, pod, noErrors, allContainersRunning, allContainersDone)
+	changed := UpdatePodStatus(containerStatuses, pod, noErrors, allContainersRunning, allContainersDone)
 	if changed {
 		fmt.Println("Setting podsChanged true")
-		dri.podsChanged = true
+		dri.setPodsChanged()
 	}
 }
 
-func (dri *ContainerdRuntimeInterface) FetchContainerLogs(namespace string, podName string, containerName string, tail string, timestamps bool) *io.ReadCloser {
-	//TODO
-	return nil
+// FetchContainerLogs streams the log file of the container's current or previous run
+func (dri *ContainerdRuntimeInterface) FetchContainerLogs(ctx context.Context, namespace string, podName string, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	pc := dri.getPodContainer(dri.GetContainerNameAlt(namespace, podName, containerName))
+	if pc == nil {
+		return nil, strongerrors.NotFound(fmt.Errorf("container %s not found in pod %s/%s", containerName, namespace, podName))
+	}
+
+	pc.lock.Lock()
+	run := pc.restartCount
+	pc.lock.Unlock()
+	if opts.Previous {
+		if run == 0 {
+			return nil, strongerrors.InvalidArgument(fmt.Errorf("previous terminated container %s in pod %s/%s not found", containerName, namespace, podName))
+		}
+		run--
+		opts.Follow = false
+	}
+
+	//following stops when the container exits or restarts
+	running := func() bool {
+		pc.lock.Lock()
+		defer pc.lock.Unlock()
+		return pc.restartCount == run && pc.task != nil && !pc.exited && !pc.stopping
+	}
+	return ReadCRILog(ctx, ContainerLogFile(pc.podLogDir, containerName, run), opts, running)
 }
 
 func (dri *ContainerdRuntimeInterface) ShutdownPods() {
-	for _, pod := range dri.podSpecs {
-		dri.DeletePod(pod)
+	for _, pod := range dri.GetPods() {
+		if err := dri.DeletePod(pod); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+}
+
+// setPodSpec stores a copy of the pod, the pod worker keeps changing its own pod
+func (dri *ContainerdRuntimeInterface) setPodSpec(pod *v1.Pod) {
+	stored := pod.DeepCopy()
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	dri.podSpecs[pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name] = stored
+}
+
+// setDeployError keeps why the container couldn't be deployed, a nil err clears it
+func (dri *ContainerdRuntimeInterface) setDeployError(fullName string, err error) {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	if err == nil {
+		delete(dri.deployErrors, fullName)
+	} else {
+		dri.deployErrors[fullName] = err
 	}
 }
+
+// missingContainerStatus is the status of a container that wasn't deployed, reason is used unless its deployment failed
+func (dri *ContainerdRuntimeInterface) missingContainerStatus(namespace string, podName string, cont v1.Container, reason string) v1.ContainerStatus {
+	dri.lock.Lock()
+	err := dri.deployErrors[dri.GetContainerNameAlt(namespace, podName, cont.Name)]
+	dri.lock.Unlock()
+	if err != nil {
+		if _, waitingReason, ok := providers.ContainerWaitingReason(err); ok {
+			return WaitingContainerStatus(cont, waitingReason, err.Error())
+		}
+	}
+	return WaitingContainerStatus(cont, reason, "")
+}
+
+func (dri *ContainerdRuntimeInterface) deletePodSpec(pod *v1.Pod) {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	delete(dri.podSpecs, pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name)
+}
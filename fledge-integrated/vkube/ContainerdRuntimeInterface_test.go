@@ -0,0 +1,154 @@
+package vkube
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/providers"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+func newInitTestPod(policy v1.RestartPolicy) *v1.Pod {
+	pod := newContainerdTestPod("web", policy, "app")
+	pod.Spec.InitContainers = []v1.Container{
+		{Name: "migrate", Image: "docker.io/library/busybox:latest"},
+		{Name: "seed", Image: "docker.io/library/busybox:latest"},
+	}
+	return pod
+}
+
+// addExitedContainer adds a container whose task exited with exitCode, the exit is handled like the event loop does
+func addExitedContainer(t *testing.T, dri *ContainerdRuntimeInterface, pod *v1.Pod, name string, exitCode int32) *PodContainer {
+	task := newFakeTask(name, 42)
+	fullName, pc := addTestContainer(dri, pod, name, task)
+	task.exit(uint32(exitCode))
+	if !dri.handleTaskExit(fullName, pc, 42, exitCode, time.Now()) {
+		t.Fatalf("exit of %s wasn't handled", name)
+	}
+	return pc
+}
+
+func TestInitContainersRunInOrder(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newInitTestPod(v1.RestartPolicyAlways)
+
+	statuses, next, incomplete, failed := dri.getInitContainerStatuses("default", pod)
+	if next == nil || next.Name != "migrate" || failed || strings.Join(incomplete, ",") != "migrate,seed" {
+		t.Fatalf("next %v, incomplete %v, failed %t before any init container ran", next, incomplete, failed)
+	}
+	if len(statuses) != 2 || statuses[0].State.Waiting.Reason != "PodInitializing" || statuses[1].State.Waiting.Reason != "PodInitializing" {
+		t.Fatalf("statuses %+v, want both initializing", statuses)
+	}
+
+	//the second one waits while the first one runs
+	_, pc := addTestContainer(dri, pod, "migrate", newFakeTask("migrate", 41))
+	statuses, next, incomplete, _ = dri.getInitContainerStatuses("default", pod)
+	if next != nil || statuses[0].State.Running == nil || strings.Join(incomplete, ",") != "migrate,seed" {
+		t.Fatalf("next %v, incomplete %v, state %+v while the first init container runs", next, incomplete, statuses[0].State)
+	}
+
+	pc.task.(*fakeTask).exit(0)
+	dri.handleTaskExit(pc.container.ID(), pc, 41, 0, time.Now())
+	statuses, next, incomplete, _ = dri.getInitContainerStatuses("default", pod)
+	if next == nil || next.Name != "seed" || strings.Join(incomplete, ",") != "seed" {
+		t.Fatalf("next %v, incomplete %v after the first init container completed", next, incomplete)
+	}
+	if terminated := statuses[0].State.Terminated; terminated == nil || terminated.Reason != "Completed" {
+		t.Fatalf("state %+v, want completed", statuses[0].State)
+	}
+
+	addExitedContainer(t, dri, pod, "seed", 0)
+	statuses, next, incomplete, failed = dri.getInitContainerStatuses("default", pod)
+	if next != nil || len(incomplete) != 0 || failed || statuses[1].State.Terminated == nil {
+		t.Fatalf("next %v, incomplete %v, failed %t after all init containers completed", next, incomplete, failed)
+	}
+}
+
+func TestInitContainerFailure(t *testing.T) {
+	cases := []struct {
+		policy v1.RestartPolicy
+		failed bool
+	}{
+		//init containers are run again on failure, even in pods that restart their containers always
+		{v1.RestartPolicyAlways, false},
+		{v1.RestartPolicyOnFailure, false},
+		{v1.RestartPolicyNever, true},
+	}
+	for _, c := range cases {
+		dri := newTestContainerd(t)
+		pod := newInitTestPod(c.policy)
+		pc := addExitedContainer(t, dri, pod, "migrate", 1)
+		if pc.restartPolicy == v1.RestartPolicyAlways {
+			t.Fatalf("%s: init container restarts always", c.policy)
+		}
+
+		statuses, next, incomplete, failed := dri.getInitContainerStatuses("default", pod)
+		if failed != c.failed || next != nil || strings.Join(incomplete, ",") != "migrate,seed" {
+			t.Errorf("%s: next %v, incomplete %v, failed %t", c.policy, next, incomplete, failed)
+			continue
+		}
+		if c.failed {
+			if statuses[0].State.Terminated == nil || statuses[0].State.Terminated.Reason != "Error" {
+				t.Errorf("%s: state %+v, want an error", c.policy, statuses[0].State)
+			}
+			dri.CheckInitContainers("default", pod)
+			if pod.Status.Phase != v1.PodFailed {
+				t.Errorf("%s: phase %s, want failed", c.policy, pod.Status.Phase)
+			}
+		} else if statuses[0].State.Waiting == nil || statuses[0].State.Waiting.Reason != "CrashLoopBackOff" {
+			t.Errorf("%s: state %+v, want the init container restarting", c.policy, statuses[0].State)
+		}
+	}
+}
+
+func TestInitContainerCompletedIsNotRestarted(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newInitTestPod(v1.RestartPolicyAlways)
+	pc := addExitedContainer(t, dri, pod, "migrate", 0)
+	if !pc.waitingUntil.IsZero() || pc.terminated == nil {
+		t.Fatal("completed init container is restarted")
+	}
+}
+
+func TestCheckInitContainersWhileRunning(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newInitTestPod(v1.RestartPolicyAlways)
+	addExitedContainer(t, dri, pod, "migrate", 0)
+	addTestContainer(dri, pod, "seed", newFakeTask("seed", 43))
+
+	dri.CheckInitContainers("default", pod)
+	if pod.Status.Phase != v1.PodPending || len(pod.Status.InitContainerStatuses) != 2 {
+		t.Fatalf("phase %s with %d init statuses, want pending with 2", pod.Status.Phase, len(pod.Status.InitContainerStatuses))
+	}
+	if pod.Status.InitContainerStatuses[1].State.Running == nil {
+		t.Fatalf("state %+v, want the second init container running", pod.Status.InitContainerStatuses[1].State)
+	}
+	//the app containers wait for the init containers
+	if len(pod.Status.ContainerStatuses) != 1 || pod.Status.ContainerStatuses[0].State.Waiting.Reason != "PodInitializing" {
+		t.Fatalf("container statuses %+v, want the app container initializing", pod.Status.ContainerStatuses)
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodInitialized && (condition.Status != v1.ConditionFalse || !strings.Contains(condition.Message, "[seed]")) {
+			t.Fatalf("initialized condition %+v, want seed incomplete", condition)
+		}
+	}
+}
+
+func TestMissingContainerStatusReportsDeployError(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newInitTestPod(v1.RestartPolicyAlways)
+	addExitedContainer(t, dri, pod, "migrate", 0)
+	pullErr := &providers.ImagePullError{Container: "seed", Image: "busybox", Reason: providers.ReasonImagePullBackOff, Err: errors.New("not found")}
+	dri.setDeployError(dri.GetContainerNameAlt("default", "web", "seed"), errors.Wrap(pullErr, "failed to deploy"))
+
+	statuses, next, _, _ := dri.getInitContainerStatuses("default", pod)
+	if next == nil || next.Name != "seed" {
+		t.Fatalf("next %v, want the init container to be tried again", next)
+	}
+	if waiting := statuses[1].State.Waiting; waiting == nil || waiting.Reason != providers.ReasonImagePullBackOff || !strings.Contains(waiting.Message, "not found") {
+		t.Fatalf("state %+v, want the pull error", statuses[1].State)
+	}
+}
@@ -0,0 +1,165 @@
+package vkube
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"fledge/fledge-integrated/config"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	DefaultSandboxImage = "k8s.gcr.io/pause:3.6"
+	//the sandbox takes the place of a container in the name of its containerd container, users can't name a container like this
+	sandboxContainerName = "POD"
+	//the sandbox doesn't handle any signal but SIGKILL, there's nothing to wait for
+	sandboxStopTimeout = 10 * time.Second
+)
+
+// podSandbox is the pause container of a pod, its task holds the network, ipc and uts namespaces every container of the pod joins
+type podSandbox struct {
+	container containerd.Container
+	task      containerd.Task
+}
+
+// createSandbox starts the pause container of the pod and connects its network namespace to the pod network
+func (dri *ContainerdRuntimeInterface) createSandbox(pod *v1.Pod) (*podSandbox, error) {
+	namespace := pod.ObjectMeta.Namespace
+	fullName := dri.GetContainerNameAlt(namespace, pod.ObjectMeta.Name, sandboxContainerName)
+
+	imageName := config.Cfg.Containerd.SandboxImage
+	if imageName == "" {
+		imageName = DefaultSandboxImage
+	}
+	imageName, err := dri.CheckFullTag(imageName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dri.images.EnsureImage(dri.ctx, nil, sandboxContainerName, imageName, v1.PullIfNotPresent, dri.imagePullKeyring(pod)); err != nil {
+		return nil, errors.Wrapf(err, "failed to pull sandbox image %s", imageName)
+	}
+	image, err := dri.client.GetImage(dri.ctx, imageName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get sandbox image %s", imageName)
+	}
+
+	hostname := pod.Spec.Hostname
+	if hostname == "" {
+		hostname = pod.ObjectMeta.Name
+	}
+	specOpts := []oci.SpecOpts{
+		oci.WithImageConfig(image),
+	}
+	if pod.Spec.HostNetwork {
+		specOpts = append(specOpts, oci.WithHostNamespace(specs.NetworkNamespace), oci.WithHostNamespace(specs.UTSNamespace))
+	} else {
+		specOpts = append(specOpts, oci.WithHostname(hostname))
+	}
+	if pod.Spec.HostIPC {
+		specOpts = append(specOpts, oci.WithHostNamespace(specs.IPCNamespace))
+	}
+
+	container, err := dri.client.NewContainer(
+		dri.ctx,
+		fullName,
+		containerd.WithImage(image),
+		containerd.WithNewSnapshot(fmt.Sprintf("%s-snapshot", fullName), image),
+		containerd.WithNewSpec(specOpts...),
+		containerd.WithContainerLabels(containerLabels(pod, sandboxContainerName)),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create sandbox of pod %s", pod.ObjectMeta.Name)
+	}
+	task, err := container.NewTask(dri.ctx, cio.NullIO)
+	if err != nil {
+		container.Delete(dri.ctx, containerd.WithSnapshotCleanup)
+		return nil, errors.Wrapf(err, "failed to create sandbox task of pod %s", pod.ObjectMeta.Name)
+	}
+	if err := task.Start(dri.ctx); err != nil {
+		task.Delete(dri.ctx)
+		container.Delete(dri.ctx, containerd.WithSnapshotCleanup)
+		return nil, errors.Wrapf(err, "failed to start sandbox of pod %s", pod.ObjectMeta.Name)
+	}
+	fmt.Printf("Started sandbox of pod %s with pid %d\n", pod.ObjectMeta.Name, task.Pid())
+
+	pod.Status.HostIP = config.Cfg.DeviceIP
+	if pod.Spec.HostNetwork {
+		pod.Status.PodIP = config.Cfg.DeviceIP
+	} else {
+		pod.Status.PodIP = BindNetNamespace(namespace, pod.ObjectMeta.Name, int(task.Pid()))
+	}
+
+	sandbox := &podSandbox{container: container, task: task}
+	dri.lock.Lock()
+	dri.sandboxes[namespace+"_"+pod.ObjectMeta.Name] = sandbox
+	dri.lock.Unlock()
+	return sandbox, nil
+}
+
+// getSandbox returns the sandbox of the pod, if it was created
+func (dri *ContainerdRuntimeInterface) getSandbox(pod *v1.Pod) *podSandbox {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	return dri.sandboxes[pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name]
+}
+
+// removeSandbox stops the pause container of the pod once its containers are gone, and releases the pod network
+func (dri *ContainerdRuntimeInterface) removeSandbox(pod *v1.Pod) {
+	key := pod.ObjectMeta.Namespace + "_" + pod.ObjectMeta.Name
+	dri.lock.Lock()
+	sandbox := dri.sandboxes[key]
+	delete(dri.sandboxes, key)
+	dri.lock.Unlock()
+	if sandbox == nil {
+		return
+	}
+
+	if !pod.Spec.HostNetwork {
+		RemoveNetNamespace(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		FreeIP(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	}
+
+	if statusC, err := sandbox.task.Wait(dri.ctx); err == nil {
+		if err := sandbox.task.Kill(dri.ctx, syscall.SIGKILL); err == nil || errdefs.IsNotFound(err) {
+			select {
+			case <-statusC:
+			case <-time.After(sandboxStopTimeout):
+				fmt.Printf("Sandbox of pod %s didn't stop\n", pod.ObjectMeta.Name)
+			}
+		}
+	}
+	if _, err := sandbox.task.Delete(dri.ctx); err != nil && !errdefs.IsNotFound(err) {
+		fmt.Printf("Failed to delete sandbox task of pod %s: %s\n", pod.ObjectMeta.Name, err.Error())
+	}
+	if err := sandbox.container.Delete(dri.ctx, containerd.WithSnapshotCleanup); err != nil && !errdefs.IsNotFound(err) {
+		fmt.Printf("Failed to delete sandbox of pod %s: %s\n", pod.ObjectMeta.Name, err.Error())
+	}
+}
+
+// withSandboxNamespaces makes a container join the namespaces of the sandbox, namespaces shared with the host are left alone
+func withSandboxNamespaces(pod *v1.Pod, sandbox *podSandbox) oci.SpecOpts {
+	pid := sandbox.task.Pid()
+	opts := []oci.SpecOpts{}
+	if pod.Spec.HostNetwork {
+		opts = append(opts, oci.WithHostNamespace(specs.NetworkNamespace), oci.WithHostNamespace(specs.UTSNamespace))
+	} else {
+		opts = append(opts,
+			oci.WithLinuxNamespace(specs.LinuxNamespace{Type: specs.NetworkNamespace, Path: fmt.Sprintf("/proc/%d/ns/net", pid)}),
+			oci.WithLinuxNamespace(specs.LinuxNamespace{Type: specs.UTSNamespace, Path: fmt.Sprintf("/proc/%d/ns/uts", pid)}),
+		)
+	}
+	if pod.Spec.HostIPC {
+		opts = append(opts, oci.WithHostNamespace(specs.IPCNamespace))
+	} else {
+		opts = append(opts, oci.WithLinuxNamespace(specs.LinuxNamespace{Type: specs.IPCNamespace, Path: fmt.Sprintf("/proc/%d/ns/ipc", pid)}))
+	}
+	return oci.Compose(opts...)
+}
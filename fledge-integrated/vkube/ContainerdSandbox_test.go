@@ -0,0 +1,89 @@
+package vkube
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestWithSandboxNamespaces(t *testing.T) {
+	cases := []struct {
+		name        string
+		hostNetwork bool
+		hostIPC     bool
+		want        map[specs.LinuxNamespaceType]string
+	}{
+		{"pod network", false, false, map[specs.LinuxNamespaceType]string{
+			specs.PIDNamespace:     "",
+			specs.MountNamespace:   "",
+			specs.NetworkNamespace: "/proc/7/ns/net",
+			specs.UTSNamespace:     "/proc/7/ns/uts",
+			specs.IPCNamespace:     "/proc/7/ns/ipc",
+		}},
+		{"host network", true, false, map[specs.LinuxNamespaceType]string{
+			specs.PIDNamespace:   "",
+			specs.MountNamespace: "",
+			specs.IPCNamespace:   "/proc/7/ns/ipc",
+		}},
+		{"host ipc", false, true, map[specs.LinuxNamespaceType]string{
+			specs.PIDNamespace:     "",
+			specs.MountNamespace:   "",
+			specs.NetworkNamespace: "/proc/7/ns/net",
+			specs.UTSNamespace:     "/proc/7/ns/uts",
+		}},
+	}
+	for _, c := range cases {
+		pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+		pod.Spec.HostNetwork = c.hostNetwork
+		pod.Spec.HostIPC = c.hostIPC
+		sandbox := &podSandbox{task: newFakeTask("POD", 7)}
+		spec := &oci.Spec{Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{
+			{Type: specs.PIDNamespace}, {Type: specs.IPCNamespace}, {Type: specs.UTSNamespace}, {Type: specs.MountNamespace}, {Type: specs.NetworkNamespace},
+		}}}
+		if err := withSandboxNamespaces(pod, sandbox)(context.Background(), nil, nil, spec); err != nil {
+			t.Fatal(err)
+		}
+
+		namespaces := make(map[specs.LinuxNamespaceType]string)
+		for _, ns := range spec.Linux.Namespaces {
+			namespaces[ns.Type] = ns.Path
+		}
+		if len(namespaces) != len(c.want) {
+			t.Errorf("%s: namespaces %v, want %v", c.name, namespaces, c.want)
+			continue
+		}
+		for nsType, path := range c.want {
+			if got, found := namespaces[nsType]; !found || got != path {
+				t.Errorf("%s: %s namespace %q, want %q", c.name, nsType, got, path)
+			}
+		}
+	}
+}
+
+func TestRemoveSandbox(t *testing.T) {
+	dri := newTestContainerd(t)
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	task := newFakeTask("POD", 7)
+	container := newFakeContainer(dri.GetContainerNameAlt("default", "web", sandboxContainerName), containerLabels(pod, sandboxContainerName), task)
+	dri.sandboxes["default_web"] = &podSandbox{container: container, task: task}
+
+	dri.removeSandbox(pod)
+	if signals := task.receivedSignals(); len(signals) != 1 || signals[0].signal != syscall.SIGKILL {
+		t.Fatalf("signals %v, want the pause process killed", signals)
+	}
+	if !task.deleted || !container.isDeleted() {
+		t.Fatal("sandbox task or container wasn't deleted")
+	}
+	if dri.getSandbox(pod) != nil {
+		t.Fatal("sandbox is still known")
+	}
+	//the sandbox is removed once
+	dri.removeSandbox(pod)
+	if signals := task.receivedSignals(); len(signals) != 1 {
+		t.Fatalf("signals %v after removing the sandbox again", signals)
+	}
+}
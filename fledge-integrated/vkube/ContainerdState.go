@@ -0,0 +1,117 @@
+package vkube
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	DefaultContainerdStateDir = "/var/lib/fledge/containerd"
+	containerdStateFile       = "state.db"
+	//another fledge holding the database shouldn't block startup forever
+	containerdStateOpenTimeout = 5 * time.Second
+
+	//labels of the containerd containers of pods, the reconciler finds them by these after a restart
+	labelPodNamespace  = "io.fledge.pod.namespace"
+	labelPodName       = "io.fledge.pod.name"
+	labelPodUID        = "io.fledge.pod.uid"
+	labelContainerName = "io.fledge.container.name"
+	//updated whenever a new task of the container is started
+	labelRestartCount = "io.fledge.container.restart-count"
+	labelStartedAt    = "io.fledge.container.started-at"
+)
+
+var podsBucket = []byte("pods")
+
+// ContainerdStateStore keeps the pods deployed on containerd on disk, including their IP, so they survive a restart of fledge.
+// A nil store keeps nothing.
+type ContainerdStateStore struct {
+	db *bolt.DB
+}
+
+// OpenContainerdStateStore opens the state database in dir, it is created when it doesn't exist yet
+func OpenContainerdStateStore(dir string) (*ContainerdStateStore, error) {
+	if dir == "" {
+		dir = DefaultContainerdStateDir
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create state directory")
+	}
+	db, err := bolt.Open(filepath.Join(dir, containerdStateFile), 0600, &bolt.Options{Timeout: containerdStateOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open state database")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(podsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize state database")
+	}
+	return &ContainerdStateStore{db: db}, nil
+}
+
+// SavePod stores the pod, replacing what was stored for it before
+func (s *ContainerdStateStore) SavePod(pod *v1.Pod) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(pod)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode pod %s", pod.ObjectMeta.Name)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(podsBucket).Put([]byte(pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name), data)
+	})
+}
+
+func (s *ContainerdStateStore) DeletePod(namespace string, name string) error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(podsBucket).Delete([]byte(namespace + "_" + name))
+	})
+}
+
+// LoadPods returns every stored pod, pods that can't be decoded are skipped
+func (s *ContainerdStateStore) LoadPods() ([]*v1.Pod, error) {
+	pods := []*v1.Pod{}
+	if s == nil {
+		return pods, nil
+	}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(podsBucket).ForEach(func(key, data []byte) error {
+			pod := &v1.Pod{}
+			if err := json.Unmarshal(data, pod); err == nil {
+				pods = append(pods, pod)
+			}
+			return nil
+		})
+	})
+	return pods, err
+}
+
+func (s *ContainerdStateStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// containerLabels are the labels of a containerd container of the pod, the sandbox included
+func containerLabels(pod *v1.Pod, containerName string) map[string]string {
+	return map[string]string{
+		labelPodNamespace:  pod.ObjectMeta.Namespace,
+		labelPodName:       pod.ObjectMeta.Name,
+		labelPodUID:        string(pod.ObjectMeta.UID),
+		labelContainerName: containerName,
+	}
+}
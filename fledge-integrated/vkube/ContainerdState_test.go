@@ -0,0 +1,105 @@
+package vkube
+
+import (
+	"sort"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	v1 "k8s.io/api/core/v1"
+)
+
+func loadPodNames(t *testing.T, store *ContainerdStateStore) []string {
+	t.Helper()
+	pods, err := store.LoadPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, pod := range pods {
+		names = append(names, pod.ObjectMeta.Namespace+"_"+pod.ObjectMeta.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestContainerdStateStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenContainerdStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	web.Status.PodIP = "10.1.0.5"
+	db := newContainerdTestPod("db", v1.RestartPolicyAlways, "postgres")
+	for _, pod := range []*v1.Pod{web, db} {
+		if err := store.SavePod(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//saving replaces the stored pod
+	web.Status.PodIP = "10.1.0.6"
+	if err := store.SavePod(web); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePod("default", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the pods survive a restart
+	store, err = OpenContainerdStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pods, err := store.LoadPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 {
+		t.Fatalf("%d pods stored, want 1", len(pods))
+	}
+	pod := pods[0]
+	if pod.ObjectMeta.Name != "web" || pod.ObjectMeta.UID != web.ObjectMeta.UID || pod.Status.PodIP != "10.1.0.6" || pod.Spec.Containers[0].Name != "app" {
+		t.Fatalf("stored pod %+v doesn't match", pod)
+	}
+}
+
+func TestContainerdStateStoreSkipsBrokenPods(t *testing.T) {
+	store, err := OpenContainerdStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.SavePod(newContainerdTestPod("web", v1.RestartPolicyAlways, "app")); err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(podsBucket).Put([]byte("default_broken"), []byte("{"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := loadPodNames(t, store); len(names) != 1 || names[0] != "default_web" {
+		t.Fatalf("loaded %v, want the pod that can be decoded", names)
+	}
+}
+
+func TestNilContainerdStateStore(t *testing.T) {
+	var store *ContainerdStateStore
+	if err := store.SavePod(newContainerdTestPod("web", v1.RestartPolicyAlways, "app")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePod("default", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if pods, err := store.LoadPods(); err != nil || len(pods) != 0 {
+		t.Fatalf("nil store loaded %v, %v", pods, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
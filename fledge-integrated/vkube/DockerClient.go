@@ -0,0 +1,501 @@
+package vkube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+)
+
+const DefaultDockerEndpoint = "unix:///var/run/docker.sock"
+
+// DockerClient talks to the Docker Engine HTTP API, only the calls needed to run pods are implemented
+type DockerClient struct {
+	http    *http.Client
+	baseURL string
+	version string
+	//dial connects to the daemon for requests whose connection is taken over by a stream, like exec and attach
+	dial func(ctx context.Context) (net.Conn, error)
+}
+
+type DockerPortBinding struct {
+	HostIP   string `json:"HostIp,omitempty"`
+	HostPort string `json:"HostPort,omitempty"`
+}
+
+type DockerRestartPolicy struct {
+	Name string `json:"Name"`
+}
+
+type DockerHostConfig struct {
+	Binds         []string                       `json:"Binds,omitempty"`
+	NetworkMode   string                         `json:"NetworkMode,omitempty"`
+	IpcMode       string                         `json:"IpcMode,omitempty"`
+	PidMode       string                         `json:"PidMode,omitempty"`
+	Privileged    bool                           `json:"Privileged,omitempty"`
+	Memory        int64                          `json:"Memory,omitempty"`
+	NanoCPUs      int64                          `json:"NanoCpus,omitempty"`
+	CPUShares     int64                          `json:"CpuShares,omitempty"`
+	PortBindings  map[string][]DockerPortBinding `json:"PortBindings,omitempty"`
+	RestartPolicy DockerRestartPolicy            `json:"RestartPolicy"`
+}
+
+type DockerContainerConfig struct {
+	Image        string              `json:"Image"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Hostname     string              `json:"Hostname,omitempty"`
+	Tty          bool                `json:"Tty,omitempty"`
+	OpenStdin    bool                `json:"OpenStdin,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   DockerHostConfig    `json:"HostConfig"`
+}
+
+type DockerContainerState struct {
+	Status     string `json:"Status"`
+	Running    bool   `json:"Running"`
+	Paused     bool   `json:"Paused"`
+	Restarting bool   `json:"Restarting"`
+	OOMKilled  bool   `json:"OOMKilled"`
+	Dead       bool   `json:"Dead"`
+	Pid        int    `json:"Pid"`
+	ExitCode   int    `json:"ExitCode"`
+	Error      string `json:"Error"`
+	StartedAt  string `json:"StartedAt"`
+	FinishedAt string `json:"FinishedAt"`
+}
+
+type DockerContainerJSON struct {
+	ID           string                `json:"Id"`
+	Name         string                `json:"Name"`
+	Image        string                `json:"Image"`
+	RestartCount int                   `json:"RestartCount"`
+	State        *DockerContainerState `json:"State"`
+	Config       *struct {
+		Tty bool `json:"Tty"`
+	} `json:"Config"`
+}
+
+// DockerLogsOptions are the query parameters of a logs request, Tail is a number of lines or "all"
+type DockerLogsOptions struct {
+	Tail       string
+	Since      time.Time
+	Timestamps bool
+	Follow     bool
+}
+
+// NewDockerClient creates a client for a unix:// socket or a tcp:// or http:// address, version may be empty to use the daemon's API version
+func NewDockerClient(endpoint string, version string) (*DockerClient, error) {
+	if endpoint == "" {
+		endpoint = DefaultDockerEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid docker endpoint %s", endpoint)
+	}
+
+	client := &DockerClient{
+		version: strings.TrimPrefix(version, "v"),
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		client.dial = func(ctx context.Context) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return client.dial(ctx)
+			},
+		}
+		client.http = &http.Client{Transport: transport}
+		//the host is ignored by the dialer, but has to be a valid one
+		client.baseURL = "http://docker"
+	case "tcp", "http":
+		host := u.Host
+		client.dial = func(ctx context.Context) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "tcp", host)
+		}
+		client.http = &http.Client{}
+		client.baseURL = "http://" + host
+	default:
+		return nil, errors.Errorf("unsupported docker endpoint scheme %s", u.Scheme)
+	}
+	return client, nil
+}
+
+func (c *DockerClient) buildURL(path string, query url.Values) string {
+	u := c.baseURL
+	if c.version != "" {
+		u += "/v" + c.version
+	}
+	u += path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// do sends a request and turns error responses into errors, the caller has to close the body of successful responses
+func (c *DockerClient) do(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.buildURL(path, query), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "docker request %s %s failed", method, path)
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	return nil, responseError(resp)
+}
+
+// responseError turns an error response of the daemon into an error of the matching kind and closes its body
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	message := strings.TrimSpace(string(data))
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+		message = apiErr.Message
+	}
+	err := errors.Errorf("docker: %s", message)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return strongerrors.NotFound(err)
+	case http.StatusConflict:
+		return strongerrors.Conflict(err)
+	case http.StatusBadRequest:
+		return strongerrors.InvalidArgument(err)
+	}
+	return err
+}
+
+// doJSON sends a request and decodes the response into out if it isn't nil
+func (c *DockerClient) doJSON(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *DockerClient) Ping(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// ImageExists inspects the image, a missing image isn't an error
+func (c *DockerClient) ImageExists(ctx context.Context, image string) (bool, error) {
+	err := c.doJSON(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil, nil)
+	if strongerrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PullImage pulls the image and waits for the pull to finish, errors are reported in the progress stream
+func (c *DockerClient) PullImage(ctx context.Context, image string) error {
+	name, tag := SplitImageTag(image)
+	query := url.Values{}
+	query.Set("fromImage", name)
+	query.Set("tag", tag)
+
+	resp, err := c.do(ctx, http.MethodPost, "/images/create", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := decoder.Decode(&progress); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to read pull progress of %s", image)
+		}
+		if progress.Error != "" {
+			return errors.Errorf("failed to pull %s: %s", image, progress.Error)
+		}
+	}
+}
+
+// SplitImageTag splits a reference in the repository and tag or digest, the tag defaults to latest
+func SplitImageTag(image string) (string, string) {
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		return image[:idx], image[idx+1:]
+	}
+	//a colon before the last slash belongs to the registry port
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		return image[:idx], image[idx+1:]
+	}
+	return image, "latest"
+}
+
+func (c *DockerClient) CreateContainer(ctx context.Context, name string, cfg *DockerContainerConfig) (string, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	var created struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/create", query, cfg, &created); err != nil {
+		return "", err
+	}
+	for _, warning := range created.Warnings {
+		fmt.Printf("Docker warning for container %s: %s\n", name, warning)
+	}
+	return created.ID, nil
+}
+
+func (c *DockerClient) StartContainer(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+// StopContainer sends SIGTERM and kills the container after the timeout, the daemon waits for that so ctx has to allow it
+func (c *DockerClient) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{}
+	query.Set("t", fmt.Sprintf("%d", int(timeout.Seconds())))
+	return c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/stop", query, nil, nil)
+}
+
+func (c *DockerClient) RemoveContainer(ctx context.Context, id string) error {
+	query := url.Values{}
+	query.Set("force", "1")
+	query.Set("v", "1")
+	return c.doJSON(ctx, http.MethodDelete, "/containers/"+id, query, nil, nil)
+}
+
+func (c *DockerClient) InspectContainer(ctx context.Context, id string) (*DockerContainerJSON, error) {
+	container := &DockerContainerJSON{}
+	if err := c.doJSON(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, container); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// ContainerLogs returns the container output, stdout and stderr are multiplexed in one stream unless tty is set
+func (c *DockerClient) ContainerLogs(ctx context.Context, id string, opts DockerLogsOptions, tty bool) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	query.Set("tail", opts.Tail)
+	if opts.Timestamps {
+		query.Set("timestamps", "1")
+	}
+	if opts.Follow {
+		query.Set("follow", "1")
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(opts.Since.Unix(), 10))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if tty {
+		return resp.Body, nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		writer.CloseWithError(DemuxDockerStream(writer, resp.Body))
+	}()
+	return reader, nil
+}
+
+// DemuxDockerStream copies the payload of a multiplexed stream to out, every frame has an 8 byte header with the stream type and size
+func DemuxDockerStream(out io.Writer, in io.Reader) error {
+	return DemuxDockerStreams(out, out, in)
+}
+
+// DemuxDockerStreams copies the stdout frames of a multiplexed stream to stdout and the stderr frames to stderr,
+// the output of a nil writer is dropped
+func DemuxDockerStreams(stdout io.Writer, stderr io.Writer, in io.Reader) error {
+	reader := bufio.NewReader(in)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		out := stdout
+		if header[0] == 2 {
+			out = stderr
+		}
+		if out == nil {
+			out = ioutil.Discard
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(out, reader, size); err != nil {
+			return err
+		}
+	}
+}
+
+// DockerExecConfig is the body of an exec create request
+type DockerExecConfig struct {
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+	Tty          bool     `json:"Tty"`
+	Cmd          []string `json:"Cmd"`
+}
+
+// DockerStream is a connection the daemon streams the stdio of an exec or an attached container over.
+// The output is multiplexed unless a tty is used.
+type DockerStream struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (s *DockerStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// CloseWrite tells the daemon that the input ended, the output can still be read
+func (s *DockerStream) CloseWrite() error {
+	if conn, ok := s.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return nil
+}
+
+// hijack sends a request whose connection the daemon takes over for a stream
+func (c *DockerClient) hijack(ctx context.Context, path string, query url.Values, body interface{}) (*DockerStream, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.buildURL(path, query), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "docker request POST %s failed", path)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "docker request POST %s failed", path)
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "docker request POST %s failed", path)
+	}
+	if resp.StatusCode >= 400 {
+		conn.Close()
+		return nil, responseError(resp)
+	}
+	//the deadline only covers setting up the stream
+	conn.SetDeadline(time.Time{})
+	return &DockerStream{Conn: conn, reader: reader}, nil
+}
+
+// CreateExec prepares a command in a running container and returns the id of the exec
+func (c *DockerClient) CreateExec(ctx context.Context, id string, cfg DockerExecConfig) (string, error) {
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/exec", nil, cfg, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// StartExec runs an exec, its stdio is streamed over the returned connection
+func (c *DockerClient) StartExec(ctx context.Context, execID string, tty bool) (*DockerStream, error) {
+	return c.hijack(ctx, "/exec/"+execID+"/start", nil, map[string]bool{"Detach": false, "Tty": tty})
+}
+
+// InspectExec returns whether the exec still runs and its exit code once it is done
+func (c *DockerClient) InspectExec(ctx context.Context, execID string) (bool, int, error) {
+	inspected := struct {
+		Running  bool `json:"Running"`
+		ExitCode int  `json:"ExitCode"`
+	}{}
+	if err := c.doJSON(ctx, http.MethodGet, "/exec/"+execID+"/json", nil, nil, &inspected); err != nil {
+		return false, 0, err
+	}
+	return inspected.Running, inspected.ExitCode, nil
+}
+
+func (c *DockerClient) ResizeExec(ctx context.Context, execID string, width uint16, height uint16) error {
+	return c.doJSON(ctx, http.MethodPost, "/exec/"+execID+"/resize", resizeQuery(width, height), nil, nil)
+}
+
+// AttachContainer streams the output of a container from now on and, if stdin is set, its input
+func (c *DockerClient) AttachContainer(ctx context.Context, id string, stdin bool) (*DockerStream, error) {
+	query := url.Values{}
+	query.Set("stream", "1")
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	if stdin {
+		query.Set("stdin", "1")
+	}
+	return c.hijack(ctx, "/containers/"+id+"/attach", query, nil)
+}
+
+func (c *DockerClient) ResizeContainer(ctx context.Context, id string, width uint16, height uint16) error {
+	return c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/resize", resizeQuery(width, height), nil, nil)
+}
+
+func resizeQuery(width uint16, height uint16) url.Values {
+	query := url.Values{}
+	query.Set("w", strconv.Itoa(int(width)))
+	query.Set("h", strconv.Itoa(int(height)))
+	return query
+}
@@ -0,0 +1,213 @@
+package vkube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+const dockerExecPollInterval = 50 * time.Millisecond
+
+var _ ContainerRuntimeInterface = &DockerRuntimeInterface{}
+
+// ExecInContainer runs a command in a running container, the pod is looked up by uid or by its pod key.
+// A command that exits with a non-zero code returns an exec.CodeExitError.
+func (dri *DockerRuntimeInterface) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	id, _ := dri.findContainer(name, uid, container)
+	if id == "" {
+		return strongerrors.NotFound(errors.Errorf("container %s not found in pod %s", container, name))
+	}
+
+	//docker refuses to exec in a container that isn't running with a conflict
+	createCtx, cancel := context.WithTimeout(context.Background(), dri.timeout)
+	execID, err := dri.client.CreateExec(createCtx, id, DockerExecConfig{
+		AttachStdin:  in != nil,
+		AttachStdout: out != nil,
+		AttachStderr: errOut != nil,
+		Tty:          tty,
+		Cmd:          cmd,
+	})
+	cancel()
+	if err != nil {
+		return errors.Wrap(err, "failed to create exec process")
+	}
+
+	startCtx, cancel := context.WithTimeout(context.Background(), dri.timeout)
+	stream, err := dri.client.StartExec(startCtx, execID, tty)
+	cancel()
+	if err != nil {
+		return errors.Wrap(err, "failed to start exec process")
+	}
+	defer stream.Close()
+
+	if in != nil {
+		go func() {
+			io.Copy(stream, in)
+			stream.CloseWrite()
+		}()
+	}
+	if tty && resize != nil {
+		go func() {
+			for size := range resize {
+				resizeCtx, cancel := context.WithTimeout(context.Background(), dri.timeout)
+				if err := dri.client.ResizeExec(resizeCtx, execID, size.Width, size.Height); err != nil {
+					fmt.Printf("Failed to resize terminal of exec in %s: %s\n", container, err.Error())
+				}
+				cancel()
+			}
+		}()
+	}
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		copyDockerOutput(stream, out, errOut, tty)
+	}()
+
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	select {
+	case <-outputDone:
+	case <-timeoutC:
+		//docker can't kill an exec, the command loses its stdio when the stream is closed
+		return errors.Errorf("command timed out after %s", timeout)
+	}
+
+	code, err := dri.waitForExec(execID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get exit status of exec process")
+	}
+	if code != 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with exit code %d", code),
+			Code: code,
+		}
+	}
+	return nil
+}
+
+// waitForExec returns the exit code of an exec, docker can still report it as running right after its output ended
+func (dri *DockerRuntimeInterface) waitForExec(execID string) (int, error) {
+	deadline := time.Now().Add(dri.timeout)
+	for {
+		inspectCtx, cancel := context.WithTimeout(context.Background(), dri.timeout)
+		running, code, err := dri.client.InspectExec(inspectCtx, execID)
+		cancel()
+		if err != nil || !running {
+			return code, err
+		}
+		if time.Now().After(deadline) {
+			return 0, errors.Errorf("exec %s is still running after its output ended", execID)
+		}
+		time.Sleep(dockerExecPollInterval)
+	}
+}
+
+// AttachToContainer connects to the stdio of a running container until it stops or the client's input ends.
+// Input is only passed on if the container has stdin.
+func (dri *DockerRuntimeInterface) AttachToContainer(name string, uid types.UID, container string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	id, containerTTY := dri.findContainer(name, uid, container)
+	if id == "" {
+		return strongerrors.NotFound(errors.Errorf("container %s not found in pod %s", container, name))
+	}
+
+	attachCtx, cancel := context.WithTimeout(context.Background(), dri.timeout)
+	stream, err := dri.client.AttachContainer(attachCtx, id, in != nil)
+	cancel()
+	if err != nil {
+		return errors.Wrapf(err, "failed to attach to container %s", container)
+	}
+	defer stream.Close()
+
+	stdinDone := make(chan struct{})
+	if in != nil {
+		go func() {
+			defer close(stdinDone)
+			io.Copy(stream, in)
+		}()
+	}
+	if tty && containerTTY && resize != nil {
+		go func() {
+			for size := range resize {
+				resizeCtx, cancel := context.WithTimeout(context.Background(), dri.timeout)
+				if err := dri.client.ResizeContainer(resizeCtx, id, size.Width, size.Height); err != nil {
+					fmt.Printf("Failed to resize terminal of container %s: %s\n", container, err.Error())
+				}
+				cancel()
+			}
+		}()
+	}
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		copyDockerOutput(stream, out, errOut, containerTTY)
+	}()
+
+	select {
+	case <-outputDone:
+	case <-stdinDone:
+	}
+	return nil
+}
+
+// copyDockerOutput copies the output of a stream to out and errOut, the output of a tty isn't multiplexed
+func copyDockerOutput(stream io.Reader, out, errOut io.Writer, tty bool) {
+	if !tty {
+		var stdout, stderr io.Writer
+		if out != nil {
+			stdout = out
+		}
+		if errOut != nil {
+			stderr = errOut
+		}
+		DemuxDockerStreams(stdout, stderr, stream)
+		return
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, stream)
+		return
+	}
+	io.Copy(out, stream)
+}
+
+// findContainer returns the id of a container and whether it has a tty, the pod is looked up by uid first
+// and only then by the pod key in name
+func (dri *DockerRuntimeInterface) findContainer(name string, uid types.UID, container string) (string, bool) {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+
+	var dpod *dockerPod
+	if uid != "" {
+		for _, candidate := range dri.pods {
+			if candidate.pod.ObjectMeta.UID == uid {
+				dpod = candidate
+				break
+			}
+		}
+	}
+	if dpod == nil {
+		dpod = dri.pods[name]
+	}
+	if dpod == nil {
+		return "", false
+	}
+	for _, cont := range dpod.pod.Spec.Containers {
+		if cont.Name == container {
+			return dpod.containerIDs[container], cont.TTY
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,729 @@
+package vkube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultDockerTimeout = 10 * time.Second
+	dockerPollInterval   = 3 * time.Second
+)
+
+// dockerPod keeps the pod spec together with the containers docker created for it.
+// The pause container of the sandbox owns the network and ipc namespaces, the containers of the pod join them.
+type dockerPod struct {
+	pod          *v1.Pod
+	containerIDs map[string]string
+	statuses     map[string]v1.ContainerStatus
+	sandboxID    string
+	netBound     bool
+}
+
+// DockerRuntimeInterface runs pods through the Docker Engine API, for devices that only have dockerd
+type DockerRuntimeInterface struct {
+	client       *DockerClient
+	timeout      time.Duration
+	sandboxImage string
+
+	lock        sync.Mutex
+	pods        map[string]*dockerPod
+	podsChanged bool
+}
+
+// NewDockerRuntimeInterface connects to the docker daemon and starts polling container states
+func NewDockerRuntimeInterface(cfg config.DockerConfig) (*DockerRuntimeInterface, error) {
+	client, err := NewDockerClient(cfg.Endpoint, cfg.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	dri := &DockerRuntimeInterface{
+		client:       client,
+		timeout:      time.Duration(cfg.Timeout) * time.Second,
+		sandboxImage: cfg.SandboxImage,
+		pods:         make(map[string]*dockerPod),
+	}
+	if dri.timeout <= 0 {
+		dri.timeout = defaultDockerTimeout
+	}
+	if dri.sandboxImage == "" {
+		dri.sandboxImage = DefaultSandboxImage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dri.timeout)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		return nil, errors.Wrap(err, "docker daemon not reachable")
+	}
+
+	go dri.PollLoop()
+	return dri, nil
+}
+
+func (dri *DockerRuntimeInterface) PodsChanged() bool {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	return dri.podsChanged
+}
+
+func (dri *DockerRuntimeInterface) ResetFlags() {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	dri.podsChanged = false
+}
+
+func (dri *DockerRuntimeInterface) GetContainerName(namespace string, pod v1.Pod, dc v1.Container) string {
+	return namespace + "_" + pod.ObjectMeta.Name + "_" + dc.Name
+}
+
+func (dri *DockerRuntimeInterface) GetContainerNameAlt(namespace string, podName string, dcName string) string {
+	return namespace + "_" + podName + "_" + dcName
+}
+
+func (dri *DockerRuntimeInterface) DeployPod(pod *v1.Pod) error {
+	if err := dri.StartPod(context.Background(), pod); err != nil {
+		fmt.Printf("Failed to deploy pod %s: %s\n", pod.ObjectMeta.Name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// StartPod starts the sandbox and the containers of the pod, anything already created is removed again on failure.
+// The runtime keeps its own copy of the pod.
+func (dri *DockerRuntimeInterface) StartPod(ctx context.Context, pod *v1.Pod) error {
+	if len(pod.Spec.InitContainers) > 0 {
+		return &providers.RuntimeUnavailableError{Runtime: "docker", Reason: "init containers are not supported"}
+	}
+	pod = pod.DeepCopy()
+
+	ignored := config.Cfg.IgnoreKubeProxy == "true" && strings.HasPrefix(pod.ObjectMeta.Name, "kube-proxy")
+	if ignored {
+		IgnoreKubeProxy(pod)
+	} else {
+		CreateVolumes(ctx, pod)
+		UpdatePostCreationPodStatus(pod, false)
+	}
+
+	key := pod.ObjectMeta.Namespace + "_" + pod.ObjectMeta.Name
+	dri.lock.Lock()
+	if _, found := dri.pods[key]; found {
+		dri.lock.Unlock()
+		return errors.Errorf("pod %s already exists", key)
+	}
+	dpod := &dockerPod{
+		pod:          pod,
+		containerIDs: make(map[string]string),
+		statuses:     make(map[string]v1.ContainerStatus),
+	}
+	dri.pods[key] = dpod
+	dri.podsChanged = true
+	dri.lock.Unlock()
+
+	if ignored {
+		return nil
+	}
+
+	if err := dri.startSandbox(ctx, dpod); err != nil {
+		dri.StopPod(ctx, pod)
+		return err
+	}
+	for i := range pod.Spec.Containers {
+		if _, err := dri.deployContainer(ctx, dpod, &pod.Spec.Containers[i]); err != nil {
+			dri.StopPod(ctx, pod)
+			return err
+		}
+	}
+
+	dri.refreshPod(ctx, key)
+	return nil
+}
+
+func (dri *DockerRuntimeInterface) DeployContainer(namespace string, pod *v1.Pod, dc *v1.Container) (string, error) {
+	dri.lock.Lock()
+	dpod, found := dri.pods[namespace+"_"+pod.ObjectMeta.Name]
+	dri.lock.Unlock()
+	if !found {
+		return "", strongerrors.NotFound(errors.Errorf("pod %s/%s not found", namespace, pod.ObjectMeta.Name))
+	}
+	return dri.deployContainer(context.Background(), dpod, dc)
+}
+
+func (dri *DockerRuntimeInterface) deployContainer(ctx context.Context, dpod *dockerPod, dc *v1.Container) (string, error) {
+	pod := dpod.pod
+	fullName := dri.GetContainerName(pod.ObjectMeta.Namespace, *pod, *dc)
+
+	if err := dri.ensureImage(ctx, dc); err != nil {
+		return "", &providers.ImagePullError{Container: dc.Name, Image: dc.Image, Err: err}
+	}
+
+	dri.lock.Lock()
+	sandboxID := dpod.sandboxID
+	dri.lock.Unlock()
+
+	cfg := BuildDockerContainerConfig(pod, dc, sandboxID)
+	id, err := dri.createContainer(ctx, fullName, cfg)
+	if err != nil {
+		return "", &providers.CreateContainerError{Container: dc.Name, Err: err}
+	}
+	dri.lock.Lock()
+	dpod.containerIDs[dc.Name] = id
+	dri.lock.Unlock()
+
+	startCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+	err = dri.client.StartContainer(startCtx, id)
+	cancel()
+	if err != nil {
+		return "", &providers.RunContainerError{Container: dc.Name, Err: err}
+	}
+	fmt.Printf("Started docker container %s with id %s\n", fullName, id)
+	return id, nil
+}
+
+// startSandbox starts the pause container of the pod and connects its network namespace to the pod network,
+// the namespaces outlive restarts of the pod's containers
+func (dri *DockerRuntimeInterface) startSandbox(ctx context.Context, dpod *dockerPod) error {
+	pod := dpod.pod
+	fullName := dri.GetContainerNameAlt(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, sandboxContainerName)
+
+	pause := &v1.Container{Name: sandboxContainerName, Image: dri.sandboxImage, ImagePullPolicy: v1.PullIfNotPresent}
+	if err := dri.ensureImage(ctx, pause); err != nil {
+		return errors.Wrapf(err, "failed to pull sandbox image %s", dri.sandboxImage)
+	}
+	id, err := dri.createContainer(ctx, fullName, BuildDockerSandboxConfig(pod, dri.sandboxImage))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create sandbox of pod %s", pod.ObjectMeta.Name)
+	}
+	dri.lock.Lock()
+	dpod.sandboxID = id
+	dri.lock.Unlock()
+
+	startCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+	err = dri.client.StartContainer(startCtx, id)
+	cancel()
+	if err != nil {
+		return errors.Wrapf(err, "failed to start sandbox of pod %s", pod.ObjectMeta.Name)
+	}
+	dri.setupPodIP(ctx, dpod, id)
+	return nil
+}
+
+// ensureImage pulls the container's image according to its pull policy
+func (dri *DockerRuntimeInterface) ensureImage(ctx context.Context, dc *v1.Container) error {
+	if dc.ImagePullPolicy != v1.PullAlways {
+		inspectCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+		exists, err := dri.client.ImageExists(inspectCtx, dc.Image)
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, "failed to inspect image %s", dc.Image)
+		}
+		if exists {
+			return nil
+		}
+		if dc.ImagePullPolicy == v1.PullNever {
+			return errors.Errorf("image %s not present and pull policy is Never", dc.Image)
+		}
+	}
+
+	fmt.Printf("Pulling image %s\n", dc.Image)
+	return dri.client.PullImage(ctx, dc.Image)
+}
+
+// createContainer creates the container, a leftover container with the same name from an earlier run is removed first
+func (dri *DockerRuntimeInterface) createContainer(ctx context.Context, name string, cfg *DockerContainerConfig) (string, error) {
+	createCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+	id, err := dri.client.CreateContainer(createCtx, name, cfg)
+	cancel()
+	if !strongerrors.IsConflict(err) {
+		return id, err
+	}
+
+	fmt.Printf("Removing stale container %s\n", name)
+	removeCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+	err = dri.client.RemoveContainer(removeCtx, name)
+	cancel()
+	if err != nil && !strongerrors.IsNotFound(err) {
+		return "", err
+	}
+	createCtx, cancel = context.WithTimeout(ctx, dri.timeout)
+	defer cancel()
+	return dri.client.CreateContainer(createCtx, name, cfg)
+}
+
+// setupPodIP binds the network namespace of the pod's sandbox to the pod network
+func (dri *DockerRuntimeInterface) setupPodIP(ctx context.Context, dpod *dockerPod, id string) {
+	pod := dpod.pod
+	if pod.Spec.HostNetwork {
+		dri.lock.Lock()
+		pod.Status.PodIP = config.Cfg.DeviceIP
+		dri.lock.Unlock()
+		return
+	}
+
+	inspectCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+	container, err := dri.client.InspectContainer(inspectCtx, id)
+	cancel()
+	if err != nil || container.State == nil || container.State.Pid == 0 {
+		fmt.Printf("Can't find the pid of container %s, pod %s has no network\n", id, pod.ObjectMeta.Name)
+		return
+	}
+	ip := BindNetNamespace(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, container.State.Pid)
+
+	dri.lock.Lock()
+	pod.Status.PodIP = ip
+	dpod.netBound = true
+	dri.lock.Unlock()
+}
+
+// BuildDockerSandboxConfig returns the docker create request of the pause container that owns the pod's namespaces
+func BuildDockerSandboxConfig(pod *v1.Pod, image string) *DockerContainerConfig {
+	cfg := &DockerContainerConfig{
+		Image: image,
+		Labels: map[string]string{
+			"io.kubernetes.pod.namespace":  pod.ObjectMeta.Namespace,
+			"io.kubernetes.pod.name":       pod.ObjectMeta.Name,
+			"io.kubernetes.pod.uid":        string(pod.ObjectMeta.UID),
+			"io.kubernetes.container.name": sandboxContainerName,
+		},
+	}
+	host := &cfg.HostConfig
+	if pod.Spec.HostNetwork {
+		host.NetworkMode = "host"
+	} else {
+		//the cni script attaches the namespace to the pod network once the sandbox runs
+		host.NetworkMode = "none"
+		cfg.Hostname = pod.Spec.Hostname
+		if cfg.Hostname == "" {
+			cfg.Hostname = pod.ObjectMeta.Name
+		}
+	}
+	if pod.Spec.HostIPC {
+		host.IpcMode = "host"
+	} else {
+		host.IpcMode = "shareable"
+	}
+	//a restarted sandbox would have new namespaces the containers aren't in
+	host.RestartPolicy.Name = "no"
+	return cfg
+}
+
+// BuildDockerContainerConfig translates a container spec to a docker create request,
+// the container joins the network and ipc namespaces of the pod's sandbox.
+func BuildDockerContainerConfig(pod *v1.Pod, dc *v1.Container, sandboxID string) *DockerContainerConfig {
+	env := map[string]string{}
+	cfg := &DockerContainerConfig{
+		Image:      dc.Image,
+		Env:        GetDockerEnv(dc, env),
+		Entrypoint: expandEnv(dc.Command, env),
+		Cmd:        expandEnv(dc.Args, env),
+		WorkingDir: dc.WorkingDir,
+		Tty:        dc.TTY,
+		OpenStdin:  dc.Stdin,
+		Labels: map[string]string{
+			"io.kubernetes.pod.namespace":  pod.ObjectMeta.Namespace,
+			"io.kubernetes.pod.name":       pod.ObjectMeta.Name,
+			"io.kubernetes.pod.uid":        string(pod.ObjectMeta.UID),
+			"io.kubernetes.container.name": dc.Name,
+		},
+	}
+
+	host := &cfg.HostConfig
+	host.Binds = buildDockerBinds(pod, dc)
+	if pod.Spec.HostNetwork {
+		host.NetworkMode = "host"
+	} else {
+		host.NetworkMode = "container:" + sandboxID
+	}
+	if pod.Spec.HostIPC {
+		host.IpcMode = "host"
+	} else {
+		host.IpcMode = "container:" + sandboxID
+	}
+	if pod.Spec.HostPID {
+		host.PidMode = "host"
+	}
+	if dc.SecurityContext != nil && dc.SecurityContext.Privileged != nil {
+		host.Privileged = *dc.SecurityContext.Privileged
+	}
+
+	if memory, found := dc.Resources.Limits[v1.ResourceMemory]; found {
+		host.Memory = memory.Value()
+	}
+	if cpu, found := dc.Resources.Limits[v1.ResourceCPU]; found {
+		host.NanoCPUs = cpu.MilliValue() * 1000000
+	}
+	if cpu, found := dc.Resources.Requests[v1.ResourceCPU]; found {
+		host.CPUShares = cpu.MilliValue() * 1024 / 1000
+		if host.CPUShares < 2 {
+			host.CPUShares = 2
+		}
+	}
+
+	//docker restarts containers itself, the restart count shows up when inspecting them
+	switch pod.Spec.RestartPolicy {
+	case v1.RestartPolicyNever:
+		host.RestartPolicy.Name = "no"
+	case v1.RestartPolicyOnFailure:
+		host.RestartPolicy.Name = "on-failure"
+	default:
+		host.RestartPolicy.Name = "always"
+	}
+	return cfg
+}
+
+// GetDockerEnv returns the container's env vars as NAME=value, vars are also stored in env to expand $(NAME) references
+func GetDockerEnv(dc *v1.Container, env map[string]string) []string {
+	vars := []string{}
+	for _, evar := range dc.Env {
+		if evar.ValueFrom != nil {
+			fmt.Printf("Env var %s uses valueFrom, which isn't supported\n", evar.Name)
+			continue
+		}
+		value := strings.Join(expandEnv([]string{evar.Value}, env), "")
+		env[evar.Name] = value
+		vars = append(vars, evar.Name+"="+value)
+	}
+	return vars
+}
+
+func expandEnv(args []string, env map[string]string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	expanded := []string{}
+	for _, arg := range args {
+		for name, value := range env {
+			arg = strings.Replace(arg, "$("+name+")", value, -1)
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+func buildDockerBinds(pod *v1.Pod, dc *v1.Container) []string {
+	binds := []string{}
+	for _, volMount := range dc.VolumeMounts {
+		var hostPath *string
+		for _, vol := range pod.Spec.Volumes {
+			if vol.Name == volMount.Name {
+				hostPath = GetHostMountPath(pod, vol)
+			}
+		}
+		if hostPath == nil {
+			fmt.Printf("No hostpath found for volume %s, mount not supported?\n", volMount.Name)
+			continue
+		}
+		bind := *hostPath + volMount.SubPath + ":" + volMount.MountPath
+		if volMount.ReadOnly {
+			bind += ":ro"
+		}
+		binds = append(binds, bind)
+	}
+	return binds
+}
+
+func (dri *DockerRuntimeInterface) UpdatePod(pod *v1.Pod) error {
+	fmt.Printf("Updating pod namespace %s name %s\n", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	if err := dri.DeletePod(pod); err != nil && !strongerrors.IsNotFound(err) {
+		return err
+	}
+	return dri.DeployPod(pod)
+}
+
+func (dri *DockerRuntimeInterface) DeletePod(pod *v1.Pod) error {
+	if err := dri.StopPod(context.Background(), pod); err != nil {
+		fmt.Printf("Failed to delete pod %s: %s\n", pod.ObjectMeta.Name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// StopPod stops the pod's containers within the grace period, the one of the pod's deletion if it is being deleted,
+// and removes them together with the sandbox. The pod stays known until everything is removed, so stopping it again
+// removes what a failed call left.
+func (dri *DockerRuntimeInterface) StopPod(ctx context.Context, pod *v1.Pod) error {
+	key := pod.ObjectMeta.Namespace + "_" + pod.ObjectMeta.Name
+
+	dri.lock.Lock()
+	dpod, found := dri.pods[key]
+	if !found {
+		dri.lock.Unlock()
+		return strongerrors.NotFound(errors.Errorf("pod %s not found", key))
+	}
+	//containers stop in the reverse order they were started in
+	containerIDs := []string{}
+	containers := dpod.pod.Spec.Containers
+	for i := len(containers) - 1; i >= 0; i-- {
+		if id, found := dpod.containerIDs[containers[i].Name]; found {
+			containerIDs = append(containerIDs, id)
+		}
+	}
+	sandboxID := dpod.sandboxID
+	netBound := dpod.netBound
+	dri.lock.Unlock()
+
+	gracePeriod := TerminationGracePeriod(pod, nil)
+	var lastErr error
+	for _, id := range containerIDs {
+		if err := dri.removeContainer(ctx, id, gracePeriod); err != nil {
+			lastErr = err
+		}
+	}
+	//the sandbox goes last, the containers are still using its namespaces until then
+	if sandboxID != "" {
+		if err := dri.removeContainer(ctx, sandboxID, 0); err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+
+	if netBound {
+		RemoveNetNamespace(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		FreeIP(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	}
+	dri.lock.Lock()
+	if dri.pods[key] == dpod {
+		delete(dri.pods, key)
+		dri.podsChanged = true
+	}
+	dri.lock.Unlock()
+	return nil
+}
+
+// removeContainer stops a container within the grace period and removes it
+func (dri *DockerRuntimeInterface) removeContainer(ctx context.Context, id string, gracePeriod time.Duration) error {
+	stopCtx, cancel := context.WithTimeout(ctx, dri.timeout+gracePeriod)
+	err := dri.client.StopContainer(stopCtx, id, gracePeriod)
+	cancel()
+	if err != nil && !strongerrors.IsNotFound(err) {
+		fmt.Printf("Failed to stop container %s: %s\n", id, err.Error())
+	}
+
+	removeCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+	err = dri.client.RemoveContainer(removeCtx, id)
+	cancel()
+	if err != nil && !strongerrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to remove container %s", id)
+	}
+	return nil
+}
+
+// GetPod returns a copy of the pod with the status of the last poll
+func (dri *DockerRuntimeInterface) GetPod(namespace string, name string) (*v1.Pod, bool) {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+
+	dpod, found := dri.pods[namespace+"_"+name]
+	if !found {
+		return nil, false
+	}
+	dri.updatePodStatus(dpod)
+	return dpod.pod.DeepCopy(), true
+}
+
+func (dri *DockerRuntimeInterface) GetPods() []*v1.Pod {
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+
+	pods := []*v1.Pod{}
+	for _, dpod := range dri.pods {
+		dri.updatePodStatus(dpod)
+		pods = append(pods, dpod.pod.DeepCopy())
+	}
+	return pods
+}
+
+func (dri *DockerRuntimeInterface) FetchContainerLogs(ctx context.Context, namespace string, podName string, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	return dri.ContainerLogs(ctx, namespace, podName, containerName, opts)
+}
+
+// ContainerLogs streams the output of a container, dockerd only keeps the logs of the current container
+func (dri *DockerRuntimeInterface) ContainerLogs(ctx context.Context, namespace string, podName string, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error) {
+	if opts.Previous {
+		return nil, strongerrors.InvalidArgument(errors.New("docker doesn't keep the logs of previous containers"))
+	}
+
+	dri.lock.Lock()
+	id := ""
+	tty := false
+	if dpod, found := dri.pods[namespace+"_"+podName]; found {
+		id = dpod.containerIDs[containerName]
+		for _, cont := range dpod.pod.Spec.Containers {
+			if cont.Name == containerName {
+				tty = cont.TTY
+			}
+		}
+	}
+	dri.lock.Unlock()
+
+	if id == "" {
+		return nil, strongerrors.NotFound(errors.Errorf("container %s not found in pod %s/%s", containerName, namespace, podName))
+	}
+	logOpts := DockerLogsOptions{
+		Tail:       "all",
+		Since:      opts.SinceTime,
+		Timestamps: opts.Timestamps,
+		Follow:     opts.Follow,
+	}
+	if opts.Tail > 0 {
+		logOpts.Tail = strconv.Itoa(opts.Tail)
+	}
+	logs, err := dri.client.ContainerLogs(ctx, id, logOpts, tty)
+	if err != nil || opts.LimitBytes <= 0 {
+		return logs, err
+	}
+	return LimitReadCloser(logs, opts.LimitBytes), nil
+}
+
+func (dri *DockerRuntimeInterface) ShutdownPods() {
+	for _, pod := range dri.GetPods() {
+		dri.DeletePod(pod)
+	}
+}
+
+// PollLoop inspects the containers of every pod, container exits are only noticed through it
+func (dri *DockerRuntimeInterface) PollLoop() {
+	for {
+		time.Sleep(dockerPollInterval)
+		dri.lock.Lock()
+		keys := []string{}
+		for key := range dri.pods {
+			keys = append(keys, key)
+		}
+		dri.lock.Unlock()
+
+		for _, key := range keys {
+			dri.refreshPod(context.Background(), key)
+		}
+	}
+}
+
+// refreshPod inspects the containers of a pod, the lock isn't held during the calls
+func (dri *DockerRuntimeInterface) refreshPod(ctx context.Context, key string) {
+	dri.lock.Lock()
+	dpod, found := dri.pods[key]
+	if !found {
+		dri.lock.Unlock()
+		return
+	}
+	containerIDs := map[string]string{}
+	for name, id := range dpod.containerIDs {
+		containerIDs[name] = id
+	}
+	images := map[string]string{}
+	for _, cont := range dpod.pod.Spec.Containers {
+		images[cont.Name] = cont.Image
+	}
+	dri.lock.Unlock()
+
+	statuses := map[string]v1.ContainerStatus{}
+	for name, id := range containerIDs {
+		inspectCtx, cancel := context.WithTimeout(ctx, dri.timeout)
+		container, err := dri.client.InspectContainer(inspectCtx, id)
+		cancel()
+		if err != nil {
+			fmt.Printf("Failed to inspect container %s: %s\n", id, err.Error())
+			continue
+		}
+		statuses[name] = ConvertDockerContainerStatus(name, images[name], container)
+	}
+
+	dri.lock.Lock()
+	defer dri.lock.Unlock()
+	if current, found := dri.pods[key]; !found || current != dpod {
+		return
+	}
+	for name, status := range statuses {
+		dpod.statuses[name] = status
+	}
+	dri.updatePodStatus(dpod)
+}
+
+// updatePodStatus derives the pod status from the last known container states, the lock must be held
+func (dri *DockerRuntimeInterface) updatePodStatus(dpod *dockerPod) {
+	if len(dpod.statuses) == 0 {
+		return
+	}
+	dpod.pod.Status.HostIP = config.Cfg.DeviceIP
+	containerStatuses := []v1.ContainerStatus{}
+	for _, cont := range dpod.pod.Spec.Containers {
+		if status, found := dpod.statuses[cont.Name]; found {
+			containerStatuses = append(containerStatuses, status)
+		}
+	}
+	if UpdatePodStatusFromContainers(dpod.pod, containerStatuses) {
+		dri.podsChanged = true
+	}
+}
+
+func parseDockerTime(value string) metav1.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.Year() <= 1 {
+		return metav1.Time{}
+	}
+	return metav1.NewTime(t)
+}
+
+// ConvertDockerContainerStatus translates the inspected state of a container into a kubernetes container status
+func ConvertDockerContainerStatus(name string, image string, container *DockerContainerJSON) v1.ContainerStatus {
+	status := v1.ContainerStatus{
+		Name:         name,
+		Image:        image,
+		ImageID:      "docker://" + container.Image,
+		ContainerID:  "docker://" + container.ID,
+		RestartCount: int32(container.RestartCount),
+	}
+	state := container.State
+	if state == nil {
+		status.State.Waiting = &v1.ContainerStateWaiting{Reason: "ContainerStatusUnknown"}
+		return status
+	}
+
+	terminated := &v1.ContainerStateTerminated{
+		ExitCode:    int32(state.ExitCode),
+		Reason:      "Completed",
+		Message:     state.Error,
+		StartedAt:   parseDockerTime(state.StartedAt),
+		FinishedAt:  parseDockerTime(state.FinishedAt),
+		ContainerID: status.ContainerID,
+	}
+	if state.OOMKilled {
+		terminated.Reason = "OOMKilled"
+	} else if state.ExitCode != 0 {
+		terminated.Reason = "Error"
+	}
+
+	switch state.Status {
+	case "running", "paused":
+		status.State.Running = &v1.ContainerStateRunning{StartedAt: parseDockerTime(state.StartedAt)}
+		status.Ready = state.Status == "running"
+	case "created":
+		status.State.Waiting = &v1.ContainerStateWaiting{Reason: "ContainerCreating"}
+	case "restarting":
+		//docker is backing off before the next restart
+		status.State.Waiting = &v1.ContainerStateWaiting{
+			Reason:  "CrashLoopBackOff",
+			Message: fmt.Sprintf("back-off restarting failed container, exit code %d", state.ExitCode),
+		}
+		status.LastTerminationState.Terminated = terminated
+	case "exited", "dead", "removing":
+		status.State.Terminated = terminated
+	default:
+		status.State.Waiting = &v1.ContainerStateWaiting{Reason: "ContainerStatusUnknown", Message: state.Status}
+	}
+	return status
+}
@@ -0,0 +1,570 @@
+package vkube
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fledge/fledge-integrated/config"
+	"fledge/fledge-integrated/providers"
+	"fledge/fledge-integrated/providers/testutil"
+
+	"github.com/cpuguy83/strongerrors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilexec "k8s.io/utils/exec"
+)
+
+// fakeDockerd serves the parts of the Docker Engine API the runtime uses, containers are kept in memory
+type fakeDockerd struct {
+	lock       sync.Mutex
+	calls      []string
+	images     map[string]bool
+	containers map[string]*DockerContainerJSON
+	configs    map[string]DockerContainerConfig
+	execs      map[string]*fakeExec
+	nextID     int
+	//the stop timeout every container got
+	stopTimeouts map[string]string
+	//images that fail to pull, the image whose containers fail to start and whether containers fail to be removed
+	brokenImages map[string]bool
+	failStart    string
+	failRemove   bool
+}
+
+// fakeExec runs the commands cat, which echoes its input, fail, which exits with 3, and sleep, which runs until the stream is closed
+type fakeExec struct {
+	cmd      []string
+	stdin    bool
+	exitCode int
+	running  bool
+}
+
+func newFakeDockerd() *fakeDockerd {
+	return &fakeDockerd{
+		images:       make(map[string]bool),
+		containers:   make(map[string]*DockerContainerJSON),
+		configs:      make(map[string]DockerContainerConfig),
+		execs:        make(map[string]*fakeExec),
+		stopTimeouts: make(map[string]string),
+		brokenImages: make(map[string]bool),
+	}
+}
+
+func (f *fakeDockerd) getCalls() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+func writeDockerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// writeFrame writes data as a frame of a multiplexed stream
+func writeFrame(w io.Writer, stream byte, data string) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	w.Write(append(header, data...))
+}
+
+// hijack takes over the connection of a request like dockerd does for exec and attach
+func hijack(w http.ResponseWriter) (io.Closer, *bufio.ReadWriter) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	rw.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	rw.Flush()
+	return conn, rw
+}
+
+// serveStream handles the requests that stream over their connection, the lock isn't held while streaming
+func (f *fakeDockerd) serveStream(w http.ResponseWriter, r *http.Request, path string) {
+	//the stream starts after the request body
+	io.Copy(ioutil.Discard, r.Body)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] == "exec" && parts[2] == "start" {
+		f.lock.Lock()
+		exec, found := f.execs[parts[1]]
+		if found {
+			exec.running = true
+		}
+		f.lock.Unlock()
+		if !found {
+			writeDockerError(w, http.StatusNotFound, "No such exec instance: "+parts[1])
+			return
+		}
+		conn, rw := hijack(w)
+		defer conn.Close()
+		input := []byte{}
+		if exec.stdin || exec.cmd[0] == "sleep" {
+			input, _ = ioutil.ReadAll(rw)
+		}
+		exitCode := 0
+		switch exec.cmd[0] {
+		case "cat":
+			writeFrame(rw, 1, string(input))
+		case "fail":
+			writeFrame(rw, 2, "oops\n")
+			exitCode = 3
+		}
+		rw.Flush()
+		f.lock.Lock()
+		exec.running = false
+		exec.exitCode = exitCode
+		f.lock.Unlock()
+		return
+	}
+
+	//an attached container prints a line and stops
+	f.lock.Lock()
+	_, found := f.containers[parts[1]]
+	f.lock.Unlock()
+	if !found {
+		writeDockerError(w, http.StatusNotFound, "No such container: "+parts[1])
+		return
+	}
+	conn, rw := hijack(w)
+	defer conn.Close()
+	writeFrame(rw, 1, "attached\n")
+	rw.Flush()
+}
+
+func (f *fakeDockerd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1.41")
+	f.lock.Lock()
+	f.calls = append(f.calls, r.Method+" "+path)
+	f.lock.Unlock()
+	if r.Method == http.MethodPost && ((strings.HasPrefix(path, "/exec/") && strings.HasSuffix(path, "/start")) ||
+		(strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/attach"))) {
+		f.serveStream(w, r, path)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && path == "/_ping":
+		w.Write([]byte("OK"))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		image := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+		if !f.images[image] {
+			writeDockerError(w, http.StatusNotFound, "No such image: "+image)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Id": "sha256:" + image})
+	case r.Method == http.MethodPost && path == "/images/create":
+		image := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+		//pull errors come in the progress stream after a successful response
+		encoder := json.NewEncoder(w)
+		encoder.Encode(map[string]string{"status": "Pulling from " + image})
+		if f.brokenImages[image] {
+			encoder.Encode(map[string]string{"error": "manifest unknown"})
+			return
+		}
+		f.images[image] = true
+		encoder.Encode(map[string]string{"status": "Downloaded newer image for " + image})
+	case r.Method == http.MethodPost && path == "/containers/create":
+		cfg := DockerContainerConfig{}
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			writeDockerError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.nextID++
+		id := fmt.Sprintf("c%d", f.nextID)
+		f.configs[id] = cfg
+		f.containers[id] = &DockerContainerJSON{
+			ID:    id,
+			Name:  "/" + r.URL.Query().Get("name"),
+			Image: "sha256:" + cfg.Image,
+			State: &DockerContainerState{Status: "created"},
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"Id": id, "Warnings": []string{}})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/exec/") && strings.HasSuffix(path, "/json"):
+		exec, found := f.execs[strings.TrimSuffix(strings.TrimPrefix(path, "/exec/"), "/json")]
+		if !found {
+			writeDockerError(w, http.StatusNotFound, "No such exec instance")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Running": exec.running, "ExitCode": exec.exitCode})
+	case strings.HasPrefix(path, "/containers/"):
+		parts := strings.Split(strings.TrimPrefix(path, "/containers/"), "/")
+		container, found := f.containers[parts[0]]
+		if !found {
+			writeDockerError(w, http.StatusNotFound, "No such container: "+parts[0])
+			return
+		}
+		action := ""
+		if len(parts) > 1 {
+			action = parts[1]
+		}
+		switch {
+		case r.Method == http.MethodPost && action == "start":
+			if f.failStart != "" && container.Image == "sha256:"+f.failStart {
+				writeDockerError(w, http.StatusInternalServerError, "failed to create shim task")
+				return
+			}
+			container.State = &DockerContainerState{Status: "running", Running: true, Pid: 4242, StartedAt: "2026-10-18T10:00:00Z"}
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost && action == "exec":
+			if !container.State.Running {
+				writeDockerError(w, http.StatusConflict, "Container "+parts[0]+" is not running")
+				return
+			}
+			cfg := DockerExecConfig{}
+			if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+				writeDockerError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			f.nextID++
+			id := fmt.Sprintf("e%d", f.nextID)
+			f.execs[id] = &fakeExec{cmd: cfg.Cmd, stdin: cfg.AttachStdin}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"Id": id})
+		case r.Method == http.MethodPost && action == "stop":
+			f.stopTimeouts[parts[0]] = r.URL.Query().Get("t")
+			container.State = &DockerContainerState{Status: "exited", ExitCode: 143, StartedAt: container.State.StartedAt, FinishedAt: "2026-10-18T10:05:00Z"}
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && action == "json":
+			json.NewEncoder(w).Encode(container)
+		case r.Method == http.MethodDelete && action == "":
+			if f.failRemove {
+				writeDockerError(w, http.StatusInternalServerError, "driver failed to remove root filesystem")
+				return
+			}
+			delete(f.containers, parts[0])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeDockerError(w, http.StatusNotImplemented, "not implemented")
+		}
+	default:
+		writeDockerError(w, http.StatusNotImplemented, "not implemented")
+	}
+}
+
+func newTestDockerRuntime(t *testing.T) (*DockerRuntimeInterface, *fakeDockerd) {
+	//the poll loops of earlier tests keep reading the config
+	if config.Cfg == nil {
+		config.Cfg = &config.Config{DeviceIP: "10.0.0.1"}
+	}
+	fake := newFakeDockerd()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	dri, err := NewDockerRuntimeInterface(config.DockerConfig{Endpoint: server.URL, APIVersion: "v1.41", Timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dri, fake
+}
+
+func newTestDockerPod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: v1.PodSpec{
+			//the pod network would be set up by the cni scripts otherwise
+			HostNetwork: true,
+			Containers: []v1.Container{
+				{Name: "app", Image: "nginx:1.21", Command: []string{"nginx"}, Args: []string{"-g", "daemon off;"}},
+				{Name: "sidecar", Image: "busybox:latest"},
+			},
+		},
+	}
+}
+
+func TestDockerStartPod(t *testing.T) {
+	dri, fake := newTestDockerRuntime(t)
+	fake.lock.Lock()
+	fake.images["busybox:latest"] = true
+	fake.lock.Unlock()
+
+	pod := newTestDockerPod("web")
+	if err := dri.StartPod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Status.Phase != "" {
+		t.Fatal("the runtime changed the pod it was given")
+	}
+
+	calls := fake.getCalls()
+	want := []string{
+		"GET /_ping",
+		"GET /images/" + DefaultSandboxImage + "/json",
+		"POST /images/create",
+		"POST /containers/create",
+		"POST /containers/c1/start",
+		"GET /images/nginx:1.21/json",
+		"POST /images/create",
+		"POST /containers/create",
+		"POST /containers/c2/start",
+		"GET /images/busybox:latest/json",
+		"POST /containers/create",
+		"POST /containers/c3/start",
+	}
+	if fmt.Sprint(calls[:len(want)]) != fmt.Sprint(want) {
+		t.Fatalf("docker got calls %v, want them to start with %v", calls, want)
+	}
+
+	fake.lock.Lock()
+	sandbox, app, sidecar := fake.configs["c1"], fake.configs["c2"], fake.configs["c3"]
+	fake.lock.Unlock()
+	if sandbox.Image != DefaultSandboxImage || sandbox.HostConfig.IpcMode != "shareable" || sandbox.HostConfig.RestartPolicy.Name != "no" {
+		t.Fatalf("sandbox is %s with ipc %s and restart policy %s", sandbox.Image, sandbox.HostConfig.IpcMode, sandbox.HostConfig.RestartPolicy.Name)
+	}
+	if fmt.Sprint(app.Entrypoint, app.Cmd) != "[nginx] [-g daemon off;]" {
+		t.Fatalf("app container runs %v %v", app.Entrypoint, app.Cmd)
+	}
+	for _, cfg := range []DockerContainerConfig{sandbox, app, sidecar} {
+		if cfg.HostConfig.NetworkMode != "host" {
+			t.Fatalf("container of a host network pod is in network %s", cfg.HostConfig.NetworkMode)
+		}
+	}
+	if app.HostConfig.IpcMode != "container:c1" || sidecar.HostConfig.IpcMode != "container:c1" {
+		t.Fatalf("containers use ipc %s and %s instead of the sandbox's", app.HostConfig.IpcMode, sidecar.HostConfig.IpcMode)
+	}
+
+	started, found := dri.GetPod("default", "web")
+	if !found {
+		t.Fatal("started pod not found")
+	}
+	if started.Status.Phase != v1.PodRunning || started.Status.PodIP != "10.0.0.1" {
+		t.Fatalf("pod is %s with ip %s", started.Status.Phase, started.Status.PodIP)
+	}
+	if len(started.Status.ContainerStatuses) != 2 {
+		t.Fatalf("pod has %d container statuses, the sandbox isn't a container of the pod", len(started.Status.ContainerStatuses))
+	}
+	for _, status := range started.Status.ContainerStatuses {
+		if status.State.Running == nil || !strings.HasPrefix(status.ContainerID, "docker://c") {
+			t.Fatalf("container %s isn't running: %+v", status.Name, status.State)
+		}
+	}
+}
+
+func TestDockerContainersJoinSandbox(t *testing.T) {
+	pod := newTestDockerPod("web")
+	pod.Spec.HostNetwork = false
+	sandbox := BuildDockerSandboxConfig(pod, DefaultSandboxImage)
+	if sandbox.HostConfig.NetworkMode != "none" || sandbox.Hostname != "web" {
+		t.Fatalf("sandbox is in network %s with hostname %s", sandbox.HostConfig.NetworkMode, sandbox.Hostname)
+	}
+	app := BuildDockerContainerConfig(pod, &pod.Spec.Containers[0], "c1")
+	if app.HostConfig.NetworkMode != "container:c1" || app.HostConfig.IpcMode != "container:c1" || app.Hostname != "" {
+		t.Fatalf("container is in network %s and ipc %s", app.HostConfig.NetworkMode, app.HostConfig.IpcMode)
+	}
+}
+
+func TestDockerStopPodRemovesContainers(t *testing.T) {
+	dri, fake := newTestDockerRuntime(t)
+	pod := newTestDockerPod("web")
+	var gracePeriod, deletionGracePeriod int64 = 30, 2
+	pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+	if err := dri.StartPod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	pod.ObjectMeta.DeletionGracePeriodSeconds = &deletionGracePeriod
+	if err := dri.StopPod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fake.getCalls()
+	//the sandbox goes last
+	want := []string{
+		"POST /containers/c3/stop",
+		"DELETE /containers/c3",
+		"POST /containers/c2/stop",
+		"DELETE /containers/c2",
+		"POST /containers/c1/stop",
+		"DELETE /containers/c1",
+	}
+	if fmt.Sprint(calls[len(calls)-len(want):]) != fmt.Sprint(want) {
+		t.Fatalf("docker got calls %v, want them to end with %v", calls, want)
+	}
+	fake.lock.Lock()
+	left := len(fake.containers)
+	timeouts := fake.stopTimeouts["c2"] + " " + fake.stopTimeouts["c3"]
+	fake.lock.Unlock()
+	if left != 0 {
+		t.Fatalf("%d containers left", left)
+	}
+	if timeouts != "2 2" {
+		t.Fatalf("containers got stop timeouts %s instead of the deletion grace period", timeouts)
+	}
+	if _, found := dri.GetPod("default", "web"); found {
+		t.Fatal("stopped pod is still known")
+	}
+}
+
+func TestDockerStopPodFailureIsRetried(t *testing.T) {
+	dri, fake := newTestDockerRuntime(t)
+	pod := newTestDockerPod("web")
+	if err := dri.StartPod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	fake.lock.Lock()
+	fake.failRemove = true
+	fake.lock.Unlock()
+	if err := dri.StopPod(context.Background(), pod); err == nil || !strings.Contains(err.Error(), "root filesystem") {
+		t.Fatalf("stopping the pod returned %v while its containers couldn't be removed", err)
+	}
+	//the pod is kept so stopping it again removes the containers
+	if _, found := dri.GetPod("default", "web"); !found {
+		t.Fatal("pod is forgotten while its containers are left")
+	}
+
+	fake.lock.Lock()
+	fake.failRemove = false
+	fake.lock.Unlock()
+	if err := dri.StopPod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	fake.lock.Lock()
+	left := len(fake.containers)
+	fake.lock.Unlock()
+	if _, found := dri.GetPod("default", "web"); found || left != 0 {
+		t.Fatalf("%d containers left after stopping the pod again", left)
+	}
+}
+
+func TestDockerStartPodRejectsInitContainers(t *testing.T) {
+	dri, fake := newTestDockerRuntime(t)
+	pod := newTestDockerPod("web")
+	pod.Spec.InitContainers = []v1.Container{{Name: "migrate", Image: "busybox:latest"}}
+	if err := dri.StartPod(context.Background(), pod); !providers.IsRuntimeUnavailable(err) {
+		t.Fatalf("starting a pod with init containers returned %v", err)
+	}
+	if calls := fake.getCalls(); len(calls) != 1 {
+		t.Fatalf("docker got calls %v besides the ping", calls)
+	}
+}
+
+func TestDockerStartPodMissingImage(t *testing.T) {
+	dri, fake := newTestDockerRuntime(t)
+
+	pod := newTestDockerPod("never")
+	pod.Spec.Containers[0].ImagePullPolicy = v1.PullNever
+	err := dri.StartPod(context.Background(), pod)
+	if _, ok := err.(*providers.ImagePullError); !ok {
+		t.Fatalf("pod with an image that is never pulled failed with %v", err)
+	}
+
+	fake.lock.Lock()
+	fake.brokenImages["busybox:latest"] = true
+	fake.lock.Unlock()
+	pod = newTestDockerPod("broken")
+	pod.Spec.Containers = pod.Spec.Containers[1:]
+	err = dri.StartPod(context.Background(), pod)
+	if _, ok := err.(*providers.ImagePullError); !ok || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Fatalf("pod with an image that fails to pull failed with %v", err)
+	}
+
+	fake.lock.Lock()
+	for id, cfg := range fake.configs {
+		if cfg.Image != DefaultSandboxImage {
+			t.Errorf("container %s created without its image %s", id, cfg.Image)
+		}
+	}
+	left := len(fake.containers)
+	fake.lock.Unlock()
+	if left != 0 {
+		t.Fatalf("%d containers left", left)
+	}
+	if len(dri.GetPods()) != 0 {
+		t.Fatal("failed pods are still known")
+	}
+}
+
+func TestDockerStartPodStartFails(t *testing.T) {
+	dri, fake := newTestDockerRuntime(t)
+	fake.lock.Lock()
+	fake.images["nginx:1.21"] = true
+	fake.failStart = "nginx:1.21"
+	fake.lock.Unlock()
+
+	err := dri.StartPod(context.Background(), newTestDockerPod("web"))
+	runErr, ok := err.(*providers.RunContainerError)
+	if !ok || runErr.Container != "app" || !strings.Contains(err.Error(), "failed to create shim task") {
+		t.Fatalf("pod whose container fails to start failed with %v", err)
+	}
+
+	//the container that was created is removed again, and the sandbox after it
+	calls := fake.getCalls()
+	want := []string{"DELETE /containers/c2", "POST /containers/c1/stop", "DELETE /containers/c1"}
+	if fmt.Sprint(calls[len(calls)-len(want):]) != fmt.Sprint(want) {
+		t.Fatalf("docker got calls %v, want them to end with %v", calls, want)
+	}
+	if len(dri.GetPods()) != 0 {
+		t.Fatal("failed pod is still known")
+	}
+}
+
+func TestDockerExec(t *testing.T) {
+	dri, _ := newTestDockerRuntime(t)
+	pod := newTestDockerPod("web")
+	pod.ObjectMeta.UID = "uid-web"
+	if err := dri.StartPod(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &testutil.BufferCloser{}
+	if err := dri.ExecInContainer("default_web", "", "app", []string{"cat"}, strings.NewReader("hello\n"), out, nil, false, nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\n" {
+		t.Fatalf("exec printed %q", out.String())
+	}
+
+	//the uid goes first, the pod key doesn't have to match
+	errOut := &testutil.BufferCloser{}
+	err := dri.ExecInContainer("default_other", "uid-web", "app", []string{"fail"}, nil, nil, errOut, false, nil, time.Second)
+	exitErr, ok := err.(utilexec.CodeExitError)
+	if !ok || exitErr.Code != 3 {
+		t.Fatalf("failing command returned %v", err)
+	}
+	if errOut.String() != "oops\n" {
+		t.Fatalf("exec printed %q to stderr", errOut.String())
+	}
+
+	err = dri.ExecInContainer("default_web", "", "app", []string{"sleep"}, nil, nil, nil, false, nil, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("command that runs too long returned %v", err)
+	}
+
+	if err := dri.ExecInContainer("default_web", "", "db", []string{"ls"}, nil, nil, nil, false, nil, time.Second); !strongerrors.IsNotFound(err) {
+		t.Fatalf("exec in an unknown container returned %v", err)
+	}
+}
+
+func TestDockerAttach(t *testing.T) {
+	dri, fake := newTestDockerRuntime(t)
+	if err := dri.StartPod(context.Background(), newTestDockerPod("web")); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &testutil.BufferCloser{}
+	if err := dri.AttachToContainer("default_web", "", "sidecar", nil, out, nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "attached\n" {
+		t.Fatalf("attach printed %q", out.String())
+	}
+	calls := fake.getCalls()
+	if last := calls[len(calls)-1]; last != "POST /containers/c3/attach" {
+		t.Fatalf("docker got calls %v, want them to end with attaching to c3", calls)
+	}
+}
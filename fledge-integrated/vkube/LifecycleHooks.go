@@ -0,0 +1,47 @@
+package vkube
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	//the default of terminationGracePeriodSeconds
+	defaultGracePeriod = 30 * time.Second
+	//like the kubelet, a container always gets a moment to handle its stop signal
+	minimumGracePeriod = 2 * time.Second
+	//how long a killed task gets to exit before stopping it counts as failed
+	killTimeout = 10 * time.Second
+)
+
+// RunLifecycleHandler runs a postStart or preStop hook against the container of the target
+func RunLifecycleHandler(ctx context.Context, handler *v1.LifecycleHandler, target ProbeTarget) error {
+	switch {
+	case handler.Exec != nil:
+		return runExecProbe(ctx, handler.Exec, target)
+	case handler.HTTPGet != nil:
+		return runHTTPProbe(ctx, handler.HTTPGet, target)
+	case handler.TCPSocket != nil:
+		return errors.New("tcp socket lifecycle hooks are not supported")
+	}
+	return errors.New("lifecycle hook has no handler")
+}
+
+// TerminationGracePeriod returns how long the containers of a pod get to stop, override is used when it is set,
+// otherwise a pod that is being deleted gets the grace period of its deletion
+func TerminationGracePeriod(pod *v1.Pod, override *int64) time.Duration {
+	seconds := override
+	if seconds == nil {
+		seconds = pod.ObjectMeta.DeletionGracePeriodSeconds
+	}
+	if seconds == nil {
+		seconds = pod.Spec.TerminationGracePeriodSeconds
+	}
+	if seconds == nil {
+		return defaultGracePeriod
+	}
+	return time.Duration(*seconds) * time.Second
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package vkube
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// inNetNamespace runs f on a thread that is moved into the network namespace at nsPath.
+// Sockets keep the namespace they were created in, so f can create them for use elsewhere.
+func inNetNamespace(nsPath string, f func() error) error {
+	netNs, err := os.Open(nsPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open pod network namespace")
+	}
+	defer netNs.Close()
+
+	runtime.LockOSThread()
+	origNs, err := os.Open(fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origNs.Close()
+
+	if err := unix.Setns(int(netNs.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return errors.Wrap(err, "failed to enter pod network namespace")
+	}
+	fErr := f()
+
+	if err := unix.Setns(int(origNs.Fd()), unix.CLONE_NEWNET); err != nil {
+		//leave the thread locked, it exits with the goroutine instead of being reused in the wrong namespace
+		return errors.Wrap(err, "failed to return to the host network namespace")
+	}
+	runtime.UnlockOSThread()
+	return fErr
+}
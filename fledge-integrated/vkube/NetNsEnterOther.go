@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package vkube
+
+import (
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+)
+
+func inNetNamespace(nsPath string, f func() error) error {
+	return strongerrors.NotImplemented(errors.New("network namespaces are only supported on linux"))
+}
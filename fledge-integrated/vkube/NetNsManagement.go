@@ -3,6 +3,8 @@ package vkube
 import (
 	"fledge/fledge-integrated/manager"
 	"fmt"
+	"strconv"
+
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -11,10 +13,21 @@ func GetNetNs(namespace string, pod string) string {
 }
 
 func BindNetNamespace(namespace string, pod string, pid int) string {
+	return bindNetNamespace(namespace, pod, strconv.Itoa(pid))
+}
+
+// BindNetNamespacePath sets up the network of a pod like BindNetNamespace, for a namespace that is held open at nsPath
+// instead of by a process of the pod, so the namespace stays reachable when the processes of the pod are restarted
+func BindNetNamespacePath(namespace string, pod string, nsPath string) string {
+	return bindNetNamespace(namespace, pod, nsPath)
+}
+
+// bindNetNamespace links the namespace of a pid or at a path and moves a veth with a new ip into it
+func bindNetNamespace(namespace string, pod string, target string) string {
 	netNs := GetNetNs(namespace, pod)
 
 	ip, _ := RequestIP(namespace, pod)
-	cmd := fmt.Sprintf("sh -x ./setupcontainercni.sh %s %d eth1 %s %d %s", netNs, pid, ip, subnetMask, gatewayIP)
+	cmd := fmt.Sprintf("sh -x ./setupcontainercni.sh %s %s eth1 %s %d %s", netNs, target, ip, subnetMask, gatewayIP)
 	manager.ExecCmdBash(cmd)
 	return ip
 }
@@ -0,0 +1,66 @@
+package vkube
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ForwardPodPort copies data between stream and a port inside the pod, until either side is done sending.
+// Clients like kubectl only close their side after the forwarding stopped.
+func ForwardPodPort(ctx context.Context, pod *v1.Pod, port int32, stream io.ReadWriteCloser) error {
+	conn, err := DialPodPort(ctx, pod, port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	errC := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(conn, stream)
+		errC <- err
+	}()
+	go func() {
+		_, err := io.Copy(stream, conn)
+		errC <- err
+	}()
+
+	select {
+	case err = <-errC:
+	case <-ctx.Done():
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error copying data of port %d of pod %s/%s", port, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	}
+	return nil
+}
+
+// DialPodPort connects to a port on localhost inside the network namespace of the pod
+func DialPodPort(ctx context.Context, pod *v1.Pod, port int32) (net.Conn, error) {
+	conn, err := DialPod(ctx, pod, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to port %d of pod %s/%s", port, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	}
+	return conn, nil
+}
+
+// DialPod makes a tcp connection from inside the network namespace of the pod,
+// pods on the host network dial from the node
+func DialPod(ctx context.Context, pod *v1.Pod, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	if pod.Spec.HostNetwork {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	var conn net.Conn
+	err := inNetNamespace(GetNetworkNamespace(pod.ObjectMeta.Namespace, pod), func() error {
+		var err error
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+		return err
+	})
+	return conn, err
+}
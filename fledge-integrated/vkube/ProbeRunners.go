@@ -0,0 +1,177 @@
+package vkube
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// only the start of a response body is read, like the kubelet does
+const maxProbeBody = 10 * 1024
+
+// RunProbe runs a probe once against the target, it returns an error when the probe failed
+func RunProbe(ctx context.Context, probe *v1.Probe, target ProbeTarget) error {
+	switch {
+	case probe.Exec != nil:
+		return runExecProbe(ctx, probe.Exec, target)
+	case probe.HTTPGet != nil:
+		return runHTTPProbe(ctx, probe.HTTPGet, target)
+	case probe.TCPSocket != nil:
+		return runTCPProbe(ctx, probe.TCPSocket, target)
+	case probe.GRPC != nil:
+		return runGRPCProbe(ctx, probe.GRPC, target)
+	}
+	return errors.New("probe has no handler")
+}
+
+func runExecProbe(ctx context.Context, action *v1.ExecAction, target ProbeTarget) error {
+	if target.Exec == nil {
+		return errors.New("exec probes are not supported by the runtime")
+	}
+	output, err := target.Exec(ctx, action.Command)
+	if err != nil {
+		if len(output) > 0 {
+			return errors.Wrap(err, strings.TrimSpace(string(output)))
+		}
+		return err
+	}
+	return nil
+}
+
+func runHTTPProbe(ctx context.Context, action *v1.HTTPGetAction, target ProbeTarget) error {
+	port, err := resolveProbePort(action.Port, target.Container)
+	if err != nil {
+		return err
+	}
+	scheme := strings.ToLower(string(action.Scheme))
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := action.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(probeHost(action.Host, target.Pod), strconv.Itoa(port)), path))
+	if err != nil {
+		return errors.Wrap(err, "invalid probe url")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "kube-probe/fledge")
+	req.Header.Set("Accept", "*/*")
+	for _, header := range action.HTTPHeaders {
+		if header.Name == "Host" {
+			req.Host = header.Value
+			continue
+		}
+		req.Header.Add(header.Name, header.Value)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return DialPod(ctx, target.Pod, addr)
+			},
+			//probes don't verify certificates
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("HTTP probe failed with statuscode: %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func runTCPProbe(ctx context.Context, action *v1.TCPSocketAction, target ProbeTarget) error {
+	port, err := resolveProbePort(action.Port, target.Container)
+	if err != nil {
+		return err
+	}
+	conn, err := DialPod(ctx, target.Pod, net.JoinHostPort(probeHost(action.Host, target.Pod), strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func runGRPCProbe(ctx context.Context, action *v1.GRPCAction, target ProbeTarget) error {
+	addr := net.JoinHostPort(probeHost("", target.Pod), strconv.Itoa(int(action.Port)))
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return DialPod(ctx, target.Pod, addr)
+		}),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to service at %s", addr)
+	}
+	defer conn.Close()
+
+	service := ""
+	if action.Service != nil {
+		service = *action.Service
+	}
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	if err != nil {
+		return errors.Wrap(err, "health check failed")
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return errors.Errorf("service unhealthy (responded with %q)", resp.Status.String())
+	}
+	return nil
+}
+
+// probeHost returns the host a probe connects to, which defaults to the pod ip
+func probeHost(host string, pod *v1.Pod) string {
+	if host != "" {
+		return host
+	}
+	if pod.Status.PodIP != "" {
+		return pod.Status.PodIP
+	}
+	return "127.0.0.1"
+}
+
+// resolveProbePort looks up a named port in the ports of the container
+func resolveProbePort(port intstr.IntOrString, dc *v1.Container) (int, error) {
+	if port.Type == intstr.Int {
+		if port.IntValue() <= 0 || port.IntValue() > 65535 {
+			return 0, errors.Errorf("invalid port number: %d", port.IntValue())
+		}
+		return port.IntValue(), nil
+	}
+	for _, p := range dc.Ports {
+		if p.Name == port.StrVal {
+			return int(p.ContainerPort), nil
+		}
+	}
+	//a named port can be a number as well
+	if n, err := strconv.Atoi(port.StrVal); err == nil && n > 0 && n <= 65535 {
+		return n, nil
+	}
+	return 0, errors.Errorf("couldn't find port %q in container %s", port.StrVal, dc.Name)
+}
@@ -0,0 +1,150 @@
+package vkube
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestResolveProbePort(t *testing.T) {
+	dc := &v1.Container{Name: "app", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "metrics", ContainerPort: 9090}}}
+	cases := []struct {
+		port intstr.IntOrString
+		want int
+		err  bool
+	}{
+		{intstr.FromInt(80), 80, false},
+		{intstr.FromString("http"), 8080, false},
+		{intstr.FromString("metrics"), 9090, false},
+		{intstr.FromString("8081"), 8081, false},
+		{intstr.FromString("grpc"), 0, true},
+		{intstr.FromInt(0), 0, true},
+		{intstr.FromInt(70000), 0, true},
+		{intstr.FromString("70000"), 0, true},
+	}
+	for _, c := range cases {
+		port, err := resolveProbePort(c.port, dc)
+		if (err != nil) != c.err || port != c.want {
+			t.Errorf("resolveProbePort(%s) = %d, %v, want %d", c.port.String(), port, err, c.want)
+		}
+	}
+}
+
+// hostNetworkTarget is a container of a pod on the host network, with its port named http
+func hostNetworkTarget(port int) ProbeTarget {
+	pod := newContainerdTestPod("web", v1.RestartPolicyAlways, "app")
+	pod.Spec.Containers[0].Ports = []v1.ContainerPort{{Name: "http", ContainerPort: int32(port)}}
+	return ProbeTarget{Pod: pod, Container: &pod.Spec.Containers[0]}
+}
+
+func listenerPort(t *testing.T, addr net.Addr) int {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func TestHTTPProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "kube-probe/fledge" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/host":
+			if r.Host != "web.example.com" || r.Header.Get("X-Probe") != "yes" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		case "/moved":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("broken\n"))
+		}
+	}))
+	defer server.Close()
+	port := listenerPort(t, server.Listener.Addr())
+	target := hostNetworkTarget(port)
+
+	cases := []struct {
+		name    string
+		action  v1.HTTPGetAction
+		err     string
+		success bool
+	}{
+		{name: "ok", action: v1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(port)}, success: true},
+		{name: "named port", action: v1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("http")}, success: true},
+		{name: "path without slash", action: v1.HTTPGetAction{Path: "healthz", Port: intstr.FromInt(port)}, success: true},
+		{name: "headers", action: v1.HTTPGetAction{Path: "/host", Port: intstr.FromInt(port), HTTPHeaders: []v1.HTTPHeader{{Name: "Host", Value: "web.example.com"}, {Name: "X-Probe", Value: "yes"}}}, success: true},
+		{name: "missing headers", action: v1.HTTPGetAction{Path: "/host", Port: intstr.FromInt(port)}, err: "statuscode: 403"},
+		{name: "redirection codes count as success", action: v1.HTTPGetAction{Path: "/moved", Port: intstr.FromInt(port)}, success: true},
+		{name: "server error", action: v1.HTTPGetAction{Path: "/broken", Port: intstr.FromInt(port)}, err: "statuscode: 500 broken"},
+		{name: "unknown port", action: v1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("grpc")}, err: "couldn't find port"},
+	}
+	for _, c := range cases {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := RunProbe(ctx, &v1.Probe{ProbeHandler: v1.ProbeHandler{HTTPGet: &c.action}}, target)
+		cancel()
+		if c.success && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.success && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: got %v, want an error with %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestTCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listenerPort(t, listener.Addr())
+	target := hostNetworkTarget(port)
+	probe := &v1.Probe{ProbeHandler: v1.ProbeHandler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromString("http")}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := RunProbe(ctx, probe, target); err != nil {
+		t.Fatalf("probe of an open port failed: %v", err)
+	}
+	listener.Close()
+	if err := RunProbe(ctx, probe, target); err == nil {
+		t.Fatal("probe of a closed port succeeded")
+	}
+}
+
+func TestExecProbe(t *testing.T) {
+	target := hostNetworkTarget(80)
+	probe := &v1.Probe{ProbeHandler: v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"cat", "/tmp/healthy"}}}}
+
+	if err := RunProbe(context.Background(), probe, target); err == nil {
+		t.Fatal("exec probe succeeded without exec support")
+	}
+	target.Exec = func(ctx context.Context, cmd []string) ([]byte, error) {
+		if strings.Join(cmd, " ") != "cat /tmp/healthy" {
+			t.Errorf("ran %v", cmd)
+		}
+		return []byte("no such file\n"), errors.New("exit status 1")
+	}
+	if err := RunProbe(context.Background(), probe, target); err == nil || err.Error() != "no such file: exit status 1" {
+		t.Fatalf("got %v, want the output in the error", err)
+	}
+	if err := RunProbe(context.Background(), &v1.Probe{}, target); err == nil {
+		t.Fatal("probe without a handler succeeded")
+	}
+}
@@ -0,0 +1,227 @@
+package vkube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+type probeType string
+
+const (
+	livenessProbe  probeType = "Liveness"
+	readinessProbe probeType = "Readiness"
+	startupProbe   probeType = "Startup"
+
+	//the defaults the api server fills in for probes
+	defaultProbePeriod           = 10 * time.Second
+	defaultProbeTimeout          = 1 * time.Second
+	defaultProbeFailureThreshold = 3
+	defaultProbeSuccessThreshold = 1
+)
+
+// ProbeTarget is the container a prober checks and what the runtime offers to do so
+type ProbeTarget struct {
+	Pod       *v1.Pod
+	Container *v1.Container
+	//Exec runs a command in the container, a non-zero exit code returns an exec.ExitError
+	Exec func(ctx context.Context, cmd []string) ([]byte, error)
+	//Kill stops the container after a failed liveness or startup probe, the restart policy decides what happens next.
+	//gracePeriod overrides the grace period of the pod when it is set.
+	Kill func(reason string, gracePeriod *int64)
+	//Changed is called when the container became ready, unready or started
+	Changed func()
+}
+
+// Prober runs the probes of containers and keeps whether they are started and ready
+type Prober struct {
+	lock       sync.Mutex
+	containers map[string]*probedContainer
+}
+
+type probedContainer struct {
+	target  ProbeTarget
+	started bool
+	ready   bool
+	stopC   chan struct{}
+}
+
+type probeWorker struct {
+	prober    *Prober
+	name      string
+	container *probedContainer
+	probeType probeType
+	probe     *v1.Probe
+	startedAt time.Time
+
+	lastResult bool
+	resultRun  int
+}
+
+func NewProber() *Prober {
+	return &Prober{containers: make(map[string]*probedContainer)}
+}
+
+// StartContainer starts probing a container whose task was just started, it replaces the workers of its previous task
+func (p *Prober) StartContainer(name string, target ProbeTarget) {
+	p.StopContainer(name)
+
+	dc := target.Container
+	pc := &probedContainer{
+		target: target,
+		//without a startup probe a container counts as started once it runs
+		started: dc.StartupProbe == nil,
+		ready:   dc.ReadinessProbe == nil,
+		stopC:   make(chan struct{}),
+	}
+	p.lock.Lock()
+	p.containers[name] = pc
+	p.lock.Unlock()
+
+	now := time.Now()
+	for ptype, probe := range map[probeType]*v1.Probe{livenessProbe: dc.LivenessProbe, readinessProbe: dc.ReadinessProbe, startupProbe: dc.StartupProbe} {
+		if probe == nil {
+			continue
+		}
+		w := &probeWorker{
+			prober:    p,
+			name:      name,
+			container: pc,
+			probeType: ptype,
+			probe:     probe,
+			startedAt: now,
+		}
+		go w.run()
+	}
+}
+
+// StopContainer stops probing a container, it is no longer started or ready
+func (p *Prober) StopContainer(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if pc, found := p.containers[name]; found {
+		close(pc.stopC)
+		delete(p.containers, name)
+	}
+}
+
+// Started returns whether the startup probe of the container succeeded
+func (p *Prober) Started(name string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pc, found := p.containers[name]
+	return found && pc.started
+}
+
+// Ready returns whether the container started and passes its readiness probe
+func (p *Prober) Ready(name string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pc, found := p.containers[name]
+	return found && pc.started && pc.ready
+}
+
+// setResult stores the result of a probe, a container that isn't probed anymore is left alone
+func (p *Prober) setResult(name string, pc *probedContainer, ptype probeType, success bool) {
+	p.lock.Lock()
+	if p.containers[name] != pc {
+		p.lock.Unlock()
+		return
+	}
+	changed := false
+	switch ptype {
+	case readinessProbe:
+		changed = pc.ready != success
+		pc.ready = success
+	case startupProbe:
+		changed = pc.started != success
+		pc.started = success
+	}
+	p.lock.Unlock()
+
+	if changed && pc.target.Changed != nil {
+		pc.target.Changed()
+	}
+}
+
+func (w *probeWorker) run() {
+	period := probeDuration(w.probe.PeriodSeconds, defaultProbePeriod)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for w.doProbe() {
+		select {
+		case <-w.container.stopC:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// doProbe runs the probe once and applies the thresholds, it returns whether the worker should keep probing
+func (w *probeWorker) doProbe() bool {
+	select {
+	case <-w.container.stopC:
+		return false
+	default:
+	}
+	//liveness and readiness are only checked once the startup probe succeeded
+	if w.probeType != startupProbe && !w.prober.Started(w.name) {
+		return true
+	}
+	if time.Since(w.startedAt) < time.Duration(w.probe.InitialDelaySeconds)*time.Second {
+		return true
+	}
+
+	target := w.container.target
+	timeout := probeDuration(w.probe.TimeoutSeconds, defaultProbeTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err := RunProbe(ctx, w.probe, target)
+	cancel()
+	success := err == nil
+	if !success {
+		fmt.Printf("%s probe of container %s failed: %s\n", w.probeType, w.name, err.Error())
+	}
+
+	if success == w.lastResult {
+		w.resultRun++
+	} else {
+		w.lastResult = success
+		w.resultRun = 1
+	}
+	failureThreshold := int(w.probe.FailureThreshold)
+	if failureThreshold <= 0 {
+		failureThreshold = defaultProbeFailureThreshold
+	}
+	successThreshold := int(w.probe.SuccessThreshold)
+	if successThreshold <= 0 {
+		successThreshold = defaultProbeSuccessThreshold
+	}
+	if (!success && w.resultRun < failureThreshold) || (success && w.resultRun < successThreshold) {
+		return true
+	}
+
+	w.prober.setResult(w.name, w.container, w.probeType, success)
+	switch {
+	case w.probeType == startupProbe && success:
+		//startup probes stop once the container started
+		return false
+	case (w.probeType == livenessProbe || w.probeType == startupProbe) && !success:
+		//the next task of the container gets new workers
+		if target.Kill != nil {
+			target.Kill(fmt.Sprintf("Container %s failed %s probe, will be restarted", target.Container.Name, w.probeType), w.probe.TerminationGracePeriodSeconds)
+		}
+		return false
+	}
+	return true
+}
+
+func probeDuration(seconds int32, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
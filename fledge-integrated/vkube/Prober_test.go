@@ -0,0 +1,247 @@
+package vkube
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// fakeProbeTarget is a container whose exec probes succeed while healthy is set
+type fakeProbeTarget struct {
+	lock        sync.Mutex
+	healthy     bool
+	probes      int
+	changes     int
+	kills       []string
+	gracePeriod *int64
+}
+
+func (f *fakeProbeTarget) target(dc *v1.Container) ProbeTarget {
+	return ProbeTarget{
+		Pod:       newContainerdTestPod("web", v1.RestartPolicyAlways),
+		Container: dc,
+		Exec: func(ctx context.Context, cmd []string) ([]byte, error) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			f.probes++
+			if !f.healthy {
+				return []byte("unhealthy"), errors.New("exit status 1")
+			}
+			return nil, nil
+		},
+		Kill: func(reason string, gracePeriod *int64) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			f.kills = append(f.kills, reason)
+			f.gracePeriod = gracePeriod
+		},
+		Changed: func() {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			f.changes++
+		},
+	}
+}
+
+func (f *fakeProbeTarget) setHealthy(healthy bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.healthy = healthy
+}
+
+func (f *fakeProbeTarget) counts() (int, int, int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.probes, f.changes, len(f.kills)
+}
+
+func execProbe(failureThreshold int32, successThreshold int32) *v1.Probe {
+	probe := &v1.Probe{FailureThreshold: failureThreshold, SuccessThreshold: successThreshold}
+	probe.Exec = &v1.ExecAction{Command: []string{"check"}}
+	return probe
+}
+
+// newProbeWorker registers the container with the prober without starting workers, so the test drives the probes
+func newProbeWorker(p *Prober, f *fakeProbeTarget, dc *v1.Container, ptype probeType, probe *v1.Probe) *probeWorker {
+	pc := &probedContainer{
+		target:  f.target(dc),
+		started: dc.StartupProbe == nil,
+		ready:   dc.ReadinessProbe == nil,
+		stopC:   make(chan struct{}),
+	}
+	p.lock.Lock()
+	p.containers["app"] = pc
+	p.lock.Unlock()
+	return &probeWorker{prober: p, name: "app", container: pc, probeType: ptype, probe: probe, startedAt: time.Now()}
+}
+
+func TestReadinessProbeThresholds(t *testing.T) {
+	cases := []struct {
+		name             string
+		failureThreshold int32
+		successThreshold int32
+		results          []bool
+		ready            []bool
+	}{
+		{"defaults", 0, 0, []bool{true, false, false, false, true}, []bool{true, true, true, false, true}},
+		{"success threshold", 1, 2, []bool{true, true, false, true, true}, []bool{false, true, false, false, true}},
+		{"failures have to be in a row", 2, 1, []bool{true, false, true, false, false}, []bool{true, true, true, true, false}},
+	}
+	for _, c := range cases {
+		p := NewProber()
+		f := &fakeProbeTarget{}
+		dc := &v1.Container{Name: "app", ReadinessProbe: execProbe(c.failureThreshold, c.successThreshold)}
+		w := newProbeWorker(p, f, dc, readinessProbe, dc.ReadinessProbe)
+		for i, healthy := range c.results {
+			f.setHealthy(healthy)
+			if !w.doProbe() {
+				t.Fatalf("%s: readiness probe stopped", c.name)
+			}
+			if ready := p.Ready("app"); ready != c.ready[i] {
+				t.Errorf("%s: probe %d: ready %t, want %t", c.name, i, ready, c.ready[i])
+			}
+		}
+		probes, changes, kills := f.counts()
+		if probes != len(c.results) || kills != 0 {
+			t.Errorf("%s: %d probes and %d kills", c.name, probes, kills)
+		}
+		//the status is refreshed whenever the container became ready or unready
+		wantChanges := 0
+		previous := false
+		for _, ready := range c.ready {
+			if ready != previous {
+				wantChanges++
+			}
+			previous = ready
+		}
+		if changes != wantChanges {
+			t.Errorf("%s: %d changes, want %d", c.name, changes, wantChanges)
+		}
+	}
+}
+
+func TestProbeInitialDelay(t *testing.T) {
+	p := NewProber()
+	f := &fakeProbeTarget{healthy: true}
+	dc := &v1.Container{Name: "app", ReadinessProbe: execProbe(0, 0)}
+	dc.ReadinessProbe.InitialDelaySeconds = 10
+	w := newProbeWorker(p, f, dc, readinessProbe, dc.ReadinessProbe)
+
+	w.doProbe()
+	if probes, _, _ := f.counts(); probes != 0 || p.Ready("app") {
+		t.Fatalf("probed %d times during the initial delay", probes)
+	}
+	w.startedAt = time.Now().Add(-10 * time.Second)
+	w.doProbe()
+	if probes, _, _ := f.counts(); probes != 1 || !p.Ready("app") {
+		t.Fatalf("probed %d times after the initial delay", probes)
+	}
+}
+
+func TestLivenessProbeKillsContainer(t *testing.T) {
+	for _, ptype := range []probeType{livenessProbe, startupProbe} {
+		p := NewProber()
+		f := &fakeProbeTarget{}
+		gracePeriod := int64(5)
+		probe := execProbe(2, 0)
+		probe.TerminationGracePeriodSeconds = &gracePeriod
+		dc := &v1.Container{Name: "app"}
+		if ptype == livenessProbe {
+			dc.LivenessProbe = probe
+		} else {
+			dc.StartupProbe = probe
+		}
+		w := newProbeWorker(p, f, dc, ptype, probe)
+
+		if !w.doProbe() {
+			t.Fatalf("%s: stopped after the first failure", ptype)
+		}
+		if _, _, kills := f.counts(); kills != 0 {
+			t.Fatalf("%s: killed below the failure threshold", ptype)
+		}
+		if w.doProbe() {
+			t.Fatalf("%s: kept probing the killed container", ptype)
+		}
+		if _, _, kills := f.counts(); kills != 1 || f.gracePeriod == nil || *f.gracePeriod != 5 {
+			t.Fatalf("%s: %d kills with grace period %v", ptype, kills, f.gracePeriod)
+		}
+	}
+}
+
+func TestStartupProbeGatesOtherProbes(t *testing.T) {
+	p := NewProber()
+	f := &fakeProbeTarget{}
+	dc := &v1.Container{Name: "app", StartupProbe: execProbe(5, 0), LivenessProbe: execProbe(1, 0), ReadinessProbe: execProbe(0, 0)}
+	startup := newProbeWorker(p, f, dc, startupProbe, dc.StartupProbe)
+	liveness := &probeWorker{prober: p, name: "app", container: startup.container, probeType: livenessProbe, probe: dc.LivenessProbe, startedAt: time.Now()}
+	readiness := &probeWorker{prober: p, name: "app", container: startup.container, probeType: readinessProbe, probe: dc.ReadinessProbe, startedAt: time.Now()}
+
+	//the failing container isn't killed by its liveness probe while it starts
+	liveness.doProbe()
+	readiness.doProbe()
+	startup.doProbe()
+	if probes, _, kills := f.counts(); probes != 1 || kills != 0 {
+		t.Fatalf("%d probes and %d kills before the container started, want the startup probe only", probes, kills)
+	}
+	if p.Started("app") || p.Ready("app") {
+		t.Fatal("container started before its startup probe succeeded")
+	}
+
+	f.setHealthy(true)
+	if startup.doProbe() {
+		t.Fatal("startup probe kept probing the started container")
+	}
+	if !p.Started("app") || p.Ready("app") {
+		t.Fatal("container didn't start or was ready before its readiness probe")
+	}
+	readiness.doProbe()
+	liveness.doProbe()
+	if probes, _, kills := f.counts(); probes != 4 || kills != 0 || !p.Ready("app") {
+		t.Fatalf("%d probes and %d kills after the container started", probes, kills)
+	}
+}
+
+func TestProberPeriodAndStop(t *testing.T) {
+	p := NewProber()
+	f := &fakeProbeTarget{healthy: true}
+	probe := execProbe(0, 0)
+	probe.PeriodSeconds = 1
+	dc := &v1.Container{Name: "app", ReadinessProbe: probe}
+	p.StartContainer("app", f.target(dc))
+
+	time.Sleep(1500 * time.Millisecond)
+	probes, changes, _ := f.counts()
+	//right away and once a second after that
+	if probes != 2 || changes != 1 || !p.Ready("app") {
+		t.Fatalf("%d probes and %d changes after 1.5s, want 2 and 1", probes, changes)
+	}
+
+	p.StopContainer("app")
+	if p.Ready("app") || p.Started("app") {
+		t.Fatal("stopped container is still ready")
+	}
+	time.Sleep(1200 * time.Millisecond)
+	if after, _, _ := f.counts(); after != probes {
+		t.Fatalf("%d probes after the container stopped", after-probes)
+	}
+}
+
+func TestProberIgnoresPreviousTask(t *testing.T) {
+	p := NewProber()
+	f := &fakeProbeTarget{healthy: true}
+	dc := &v1.Container{Name: "app", ReadinessProbe: execProbe(0, 0)}
+	w := newProbeWorker(p, f, dc, readinessProbe, dc.ReadinessProbe)
+
+	//the container was restarted, the worker of its previous task is late
+	p.StartContainer("app", f.target(&v1.Container{Name: "app", ReadinessProbe: &v1.Probe{InitialDelaySeconds: 3600, ProbeHandler: dc.ReadinessProbe.ProbeHandler}}))
+	defer p.StopContainer("app")
+	w.container.stopC = make(chan struct{})
+	w.doProbe()
+	if p.Ready("app") {
+		t.Fatal("result of the previous task was applied")
+	}
+}
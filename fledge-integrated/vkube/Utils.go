@@ -114,7 +114,8 @@ func GetHighestPodStatus(pod *v1.Pod) *v1.PodCondition {
 		return &podUnschedulable
 	} else if podReady.Status == v1.ConditionTrue {
 		return &podReady
-	} else if initialized.Status == v1.ConditionTrue {
+	} else if initialized.Type == v1.PodInitialized {
+		//PodInitialized (false) ranks above PodScheduled too, its init containers still have to be checked
 		return &initialized
 	} else if scheduled.Status == v1.ConditionTrue {
 		return &scheduled
@@ -122,3 +123,27 @@ func GetHighestPodStatus(pod *v1.Pod) *v1.PodCondition {
 	//well this can't happen, but whatever, better catch it anyway
 	return nil
 }
+
+// UpdatePodStatusFromContainers derives the pod phase and conditions from container statuses reported by a pod provider
+// that doesn't run its containers through containerd.
+func UpdatePodStatusFromContainers(pod *v1.Pod, containerStatuses []v1.ContainerStatus) bool {
+	noErrors := true
+	allContainersRunning := len(containerStatuses) == len(pod.Spec.Containers)
+	allContainersDone := allContainersRunning
+
+	for _, status := range containerStatuses {
+		if status.State.Running != nil {
+			allContainersDone = false
+		} else if status.State.Terminated != nil {
+			allContainersRunning = false
+			if status.State.Terminated.ExitCode != 0 {
+				noErrors = false
+			}
+		} else {
+			allContainersRunning = false
+			allContainersDone = false
+		}
+	}
+
+	return UpdatePodStatus(containerStatuses, pod, noErrors, allContainersRunning, allContainersDone)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"fledge/fledge-integrated/providers"
+	"net/http"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/gorilla/mux"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PodAttachHandlerFunc makes an http handler func from a provider which attaches to the stdio of a pod's container
+func PodAttachHandlerFunc(p providers.ContainerAttacher) http.HandlerFunc {
+	return handleError(func(w http.ResponseWriter, req *http.Request) error {
+		vars := mux.Vars(req)
+
+		namespace := vars["namespace"]
+		pod := vars["pod"]
+		container := vars["container"]
+		//only set by the route that includes the pod uid
+		uid := types.UID(vars["uid"])
+
+		opts, err := parseRemoteCommandOptions(req)
+		if err != nil {
+			return strongerrors.InvalidArgument(err)
+		}
+
+		name := namespace + "_" + pod
+		serveRemoteCommand(w, req, opts, func(streams *remoteCommandStreams) error {
+			return p.AttachToContainer(name, uid, container, streams.stdin, streams.stdout, streams.stderr, opts.TTY, streams.resize)
+		})
+		return nil
+	})
+}
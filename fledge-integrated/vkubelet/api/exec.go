@@ -3,36 +3,40 @@ package api
 import (
 	"fledge/fledge-integrated/providers"
 	"net/http"
-	//"k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // PodExecHandlerFunc makes an http handler func from a Provider which execs a command in a pod's container
 // Note that this handler currently depends on gorrilla/mux to get url parts as variables.
 // TODO(@cpuguy83): don't force gorilla/mux on consumers of this function
-func PodExecHandlerFunc(p providers.PodProvider) http.HandlerFunc { //backend remotecommand.Executor) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
-		//vars := mux.Vars(req)
+func PodExecHandlerFunc(p providers.PodProvider) http.HandlerFunc {
+	return handleError(func(w http.ResponseWriter, req *http.Request) error {
+		vars := mux.Vars(req)
 
-		/*namespace := vars["namespace"]
+		namespace := vars["namespace"]
 		pod := vars["pod"]
 		container := vars["container"]
+		//only set by the route that includes the pod uid
+		uid := types.UID(vars["uid"])
 
-		supportedStreamProtocols := strings.Split(req.Header.Get("X-Stream-Protocol-Version"), ",")
-
-		q := req.URL.Query()
-		command := q["command"]
-
-		// TODO: tty flag causes remotecommand.createStreams to wait for the wrong number of streams
-		streamOpts := &remotecommand.Options{
-			Stdin:  true,
-			Stdout: true,
-			Stderr: true,
-			TTY:    false,
+		opts, err := parseRemoteCommandOptions(req)
+		if err != nil {
+			return strongerrors.InvalidArgument(err)
+		}
+		command := req.URL.Query()["command"]
+		if len(command) == 0 {
+			return strongerrors.InvalidArgument(errors.New("no command specified"))
 		}
 
-		idleTimeout := time.Second * 30
-		streamCreationTimeout := time.Second * 30*/
-
-		//remotecommand.ServeExec(w, req, backend, fmt.Sprintf("%s-%s", namespace, pod), "", container, command, streamOpts, idleTimeout, streamCreationTimeout, supportedStreamProtocols)
-	}
+		//providers look pods up by their pod key
+		name := namespace + "_" + pod
+		serveRemoteCommand(w, req, opts, func(streams *remoteCommandStreams) error {
+			return p.ExecInContainer(name, uid, container, command, streams.stdin, streams.stdout, streams.stderr, opts.TTY, streams.resize, 0)
+		})
+		return nil
+	})
 }
@@ -2,9 +2,12 @@ package api
 
 import (
 	"context"
+	"fledge/fledge-integrated/providers"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/cpuguy83/strongerrors"
 	"github.com/gorilla/mux"
@@ -13,7 +16,7 @@ import (
 
 // ContainerLogsBackend is used in place of backend implementations for getting container logs
 type ContainerLogsBackend interface {
-	GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error)
+	GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts providers.ContainerLogOpts) (io.ReadCloser, error)
 }
 
 // PodLogsHandlerFunc creates an http handler function from a provider to serve logs from a pod
@@ -29,25 +32,94 @@ func PodLogsHandlerFunc(p ContainerLogsBackend) http.HandlerFunc {
 		namespace := vars["namespace"]
 		pod := vars["pod"]
 		container := vars["container"]
-		tail := 10
-		q := req.URL.Query()
 
-		if queryTail := q.Get("tailLines"); queryTail != "" {
-			t, err := strconv.Atoi(queryTail)
-			if err != nil {
-				return strongerrors.InvalidArgument(errors.Wrap(err, "could not parse \"tailLines\""))
-			}
-			tail = t
+		opts, err := parseLogOpts(req.URL.Query())
+		if err != nil {
+			return strongerrors.InvalidArgument(err)
 		}
 
-		podsLogs, err := p.GetContainerLogs(ctx, namespace, pod, container, tail)
+		logs, err := p.GetContainerLogs(ctx, namespace, pod, container, opts)
 		if err != nil {
 			return errors.Wrap(err, "error getting container logs?)")
 		}
+		defer logs.Close()
 
-		if _, err := io.WriteString(w, podsLogs); err != nil {
+		w.Header().Set("Content-Type", "text/plain")
+		var out io.Writer = w
+		if flusher, ok := w.(http.Flusher); ok && opts.Follow {
+			out = &flushWriter{writer: w, flusher: flusher}
+		}
+		if _, err := io.Copy(out, logs); err != nil {
 			return strongerrors.Unknown(errors.Wrap(err, "error writing response to client"))
 		}
 		return nil
 	})
 }
+
+// parseLogOpts reads the query parameters of kubectl logs, sinceSeconds is turned into a SinceTime
+func parseLogOpts(q url.Values) (providers.ContainerLogOpts, error) {
+	opts := providers.ContainerLogOpts{}
+	var err error
+
+	if opts.Tail, err = parseIntParam(q, "tailLines"); err != nil {
+		return opts, err
+	}
+	if opts.LimitBytes, err = parseIntParam(q, "limitBytes"); err != nil {
+		return opts, err
+	}
+	for name, val := range map[string]*bool{"timestamps": &opts.Timestamps, "follow": &opts.Follow, "previous": &opts.Previous} {
+		if query := q.Get(name); query != "" {
+			if *val, err = strconv.ParseBool(query); err != nil {
+				return opts, errors.Wrapf(err, "could not parse %q", name)
+			}
+		}
+	}
+
+	sinceSeconds, err := parseIntParam(q, "sinceSeconds")
+	if err != nil {
+		return opts, err
+	}
+	sinceTime := q.Get("sinceTime")
+	if sinceSeconds != 0 && sinceTime != "" {
+		return opts, errors.New("at most one of \"sinceTime\" or \"sinceSeconds\" may be specified")
+	}
+	if sinceSeconds > 0 {
+		opts.SinceTime = time.Now().Add(-time.Duration(sinceSeconds) * time.Second)
+	}
+	if sinceTime != "" {
+		if opts.SinceTime, err = time.Parse(time.RFC3339, sinceTime); err != nil {
+			return opts, errors.Wrap(err, "could not parse \"sinceTime\"")
+		}
+	}
+	return opts, nil
+}
+
+// parseIntParam returns the value of an integer query parameter, 0 if it isn't set
+func parseIntParam(q url.Values, name string) (int, error) {
+	query := q.Get(name)
+	if query == "" {
+		return 0, nil
+	}
+	val, err := strconv.Atoi(query)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse %q", name)
+	}
+	if val < 0 {
+		return 0, errors.Errorf("%q must not be negative", name)
+	}
+	return val, nil
+}
+
+// flushWriter sends every write to the client right away, for streaming followed logs
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.flusher.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"encoding/binary"
+	"fledge/fledge-integrated/providers"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+)
+
+const (
+	portForwardProtocolV1 = "portforward.k8s.io"
+
+	//every forwarded connection has a data and an error stream, they have the same request id
+	portForwardPortHeader      = "port"
+	portForwardRequestIDHeader = "requestID"
+	streamTypeData             = "data"
+)
+
+// portForwardFunc copies data between stream and a port of the pod
+type portForwardFunc func(port int32, stream io.ReadWriteCloser) error
+
+// PodPortForwardHandlerFunc makes an http handler func from a provider which forwards connections to the ports of a pod
+func PodPortForwardHandlerFunc(p providers.PortForwarder) http.HandlerFunc {
+	return handleError(func(w http.ResponseWriter, req *http.Request) error {
+		vars := mux.Vars(req)
+
+		namespace := vars["namespace"]
+		pod := vars["pod"]
+
+		ctx := req.Context()
+		forward := func(port int32, stream io.ReadWriteCloser) error {
+			return p.PortForward(ctx, namespace, pod, port, stream)
+		}
+
+		if isWebSocketRequest(req) {
+			//websocket clients pass the ports up front, spdy clients create streams for them
+			ports, err := parsePortForwardPorts(req.URL.Query()["port"])
+			if err != nil {
+				return strongerrors.InvalidArgument(err)
+			}
+			acceptWebSocket(w, req, func(conn *wsConn, v4 bool) {
+				serveWebSocketPortForward(conn, ports, forward)
+			})
+			return nil
+		}
+		servePortForwardSPDY(w, req, forward)
+		return nil
+	})
+}
+
+func parsePort(port string) (int32, error) {
+	val, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || val == 0 {
+		return 0, errors.Errorf("invalid port %q", port)
+	}
+	return int32(val), nil
+}
+
+func parsePortForwardPorts(query []string) ([]int32, error) {
+	if len(query) == 0 {
+		return nil, errors.New("at least 1 port must be specified")
+	}
+	ports := []int32{}
+	for _, port := range query {
+		val, err := parsePort(port)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, val)
+	}
+	return ports, nil
+}
+
+// portForwardPair are the streams of a forwarded connection
+type portForwardPair struct {
+	created     time.Time
+	dataStream  httpstream.Stream
+	errorStream httpstream.Stream
+}
+
+// forward copies the data of the connection and reports a failure on the error stream
+func (pair *portForwardPair) forward(forward portForwardFunc) {
+	defer pair.dataStream.Close()
+	defer pair.errorStream.Close()
+
+	//the header was checked when the stream was created
+	port, _ := parsePort(pair.dataStream.Headers().Get(portForwardPortHeader))
+	if err := forward(port, pair.dataStream); err != nil {
+		fmt.Fprintf(pair.errorStream, "error forwarding port %d to pod: %v", port, err)
+	}
+}
+
+func (pair *portForwardPair) reset() {
+	for _, stream := range []httpstream.Stream{pair.dataStream, pair.errorStream} {
+		if stream != nil {
+			stream.Reset()
+		}
+	}
+}
+
+// portForwardRequestID returns the id of the connection a stream belongs to
+func portForwardRequestID(stream httpstream.Stream) string {
+	if id := stream.Headers().Get(portForwardRequestIDHeader); id != "" {
+		return id
+	}
+	//older clients don't send the header, the error and data stream of a connection have consecutive odd identifiers
+	id := stream.Identifier()
+	if stream.Headers().Get(streamType) == streamTypeData {
+		id -= 2
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func servePortForwardSPDY(w http.ResponseWriter, req *http.Request, forward portForwardFunc) {
+	if _, err := httpstream.Handshake(req, w, []string{portForwardProtocolV1}); err != nil {
+		//the handshake already responded
+		return
+	}
+
+	streamC := make(chan httpstream.Stream, 1)
+	upgrader := spdy.NewResponseUpgrader()
+	conn := upgrader.UpgradeResponse(w, req, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+		port := stream.Headers().Get(portForwardPortHeader)
+		if port == "" {
+			return errors.Errorf("%q header is required", portForwardPortHeader)
+		}
+		if _, err := parsePort(port); err != nil {
+			return err
+		}
+		streamC <- stream
+		return nil
+	})
+	if conn == nil {
+		//the upgrader already responded
+		return
+	}
+	defer conn.Close()
+	conn.SetIdleTimeout(streamIdleTimeout)
+
+	pairs := map[string]*portForwardPair{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.CloseChan():
+			for _, pair := range pairs {
+				pair.reset()
+			}
+			return
+		case stream := <-streamC:
+			id := portForwardRequestID(stream)
+			pair, found := pairs[id]
+			if !found {
+				pair = &portForwardPair{created: time.Now()}
+				pairs[id] = pair
+			}
+			switch stream.Headers().Get(streamType) {
+			case streamTypeData:
+				if pair.dataStream != nil {
+					stream.Reset()
+					continue
+				}
+				pair.dataStream = stream
+			case streamTypeError:
+				if pair.errorStream != nil {
+					stream.Reset()
+					continue
+				}
+				pair.errorStream = stream
+			default:
+				stream.Reset()
+				continue
+			}
+			if pair.dataStream != nil && pair.errorStream != nil {
+				delete(pairs, id)
+				go pair.forward(forward)
+			}
+		case <-ticker.C:
+			//the client didn't create the other stream of a connection in time
+			for id, pair := range pairs {
+				if time.Since(pair.created) > streamCreationTimeout {
+					pair.reset()
+					delete(pairs, id)
+				}
+			}
+		}
+	}
+}
+
+// wsPortStream is the data channel of a port, its input is fed by the read loop of the websocket
+type wsPortStream struct {
+	*io.PipeReader
+	wsWriter
+	input *io.PipeWriter
+}
+
+func (s *wsPortStream) Close() error {
+	return s.PipeReader.Close()
+}
+
+// serveWebSocketPortForward forwards a connection to every port, port i uses channel 2i for data and 2i+1 for errors
+func serveWebSocketPortForward(conn *wsConn, ports []int32, forward portForwardFunc) {
+	streams := make([]*wsPortStream, len(ports))
+	for i, port := range ports {
+		reader, writer := io.Pipe()
+		streams[i] = &wsPortStream{
+			PipeReader: reader,
+			wsWriter:   wsWriter{conn: conn, channel: byte(2 * i)},
+			input:      writer,
+		}
+		//both channels of a port start with the port number
+		portBytes := make([]byte, 2)
+		binary.LittleEndian.PutUint16(portBytes, uint16(port))
+		conn.write(byte(2*i), portBytes)
+		conn.write(byte(2*i+1), portBytes)
+	}
+
+	go func() {
+		defer func() {
+			for _, stream := range streams {
+				stream.input.Close()
+			}
+		}()
+		for {
+			channel, data, err := conn.read()
+			if err != nil {
+				return
+			}
+			if channel%2 == 0 && int(channel/2) < len(streams) {
+				//a port that stopped forwarding drops its input
+				streams[channel/2].input.Write(data)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i int, port int32) {
+			defer wg.Done()
+			if err := forward(port, streams[i]); err != nil {
+				conn.write(byte(2*i+1), []byte(fmt.Sprintf("error forwarding port %d to pod: %v", port, err)))
+			}
+		}(i, port)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	//same defaults as the kubelet
+	streamIdleTimeout     = 4 * time.Hour
+	streamCreationTimeout = 30 * time.Second
+
+	streamProtocolV2 = "v2.channel.k8s.io"
+	streamProtocolV3 = "v3.channel.k8s.io"
+	streamProtocolV4 = "v4.channel.k8s.io"
+
+	//spdy clients create a stream per type
+	streamType       = "streamType"
+	streamTypeError  = "error"
+	streamTypeStdin  = "stdin"
+	streamTypeStdout = "stdout"
+	streamTypeStderr = "stderr"
+	streamTypeResize = "resize"
+
+	//websocket channels of a remote command
+	wsStdin  = 0
+	wsStdout = 1
+	wsStderr = 2
+	wsError  = 3
+	wsResize = 4
+)
+
+var spdyProtocols = []string{streamProtocolV4, streamProtocolV3, streamProtocolV2}
+
+// remoteCommandOptions are the streams the client asked for, stderr is merged into stdout by a tty
+type remoteCommandOptions struct {
+	Stdin  bool
+	Stdout bool
+	Stderr bool
+	TTY    bool
+}
+
+// remoteCommandStreams are handed to the command, the streams that weren't requested are nil
+type remoteCommandStreams struct {
+	stdin  io.Reader
+	stdout io.WriteCloser
+	stderr io.WriteCloser
+	resize <-chan remotecommand.TerminalSize
+}
+
+// remoteCommandFunc runs the command, an error implementing exec.ExitError is reported as the exit code
+type remoteCommandFunc func(streams *remoteCommandStreams) error
+
+// parseRemoteCommandOptions reads the stream query parameters of kubectl exec and attach
+func parseRemoteCommandOptions(req *http.Request) (*remoteCommandOptions, error) {
+	q := req.URL.Query()
+	opts := &remoteCommandOptions{}
+	for name, val := range map[string]*bool{"stdin": &opts.Stdin, "stdout": &opts.Stdout, "stderr": &opts.Stderr, "tty": &opts.TTY} {
+		query := q.Get(name)
+		if query == "" {
+			continue
+		}
+		var err error
+		if *val, err = strconv.ParseBool(query); err != nil {
+			return nil, errors.Wrapf(err, "could not parse %q", name)
+		}
+	}
+	if opts.TTY && opts.Stderr {
+		//a terminal has a single output stream
+		opts.Stderr = false
+	}
+	if !opts.Stdin && !opts.Stdout && !opts.Stderr {
+		return nil, errors.New("you must specify at least 1 of stdin, stdout, stderr")
+	}
+	return opts, nil
+}
+
+// serveRemoteCommand upgrades the request to a SPDY or websocket connection and runs the command on its streams,
+// the result of the command is sent on the error stream
+func serveRemoteCommand(w http.ResponseWriter, req *http.Request, opts *remoteCommandOptions, run remoteCommandFunc) {
+	if isWebSocketRequest(req) {
+		serveWebSocket(w, req, opts, run)
+		return
+	}
+	serveSPDY(w, req, opts, run)
+}
+
+// exitStatus turns the result of a command in the status that is sent to clients of the v4 protocols
+func exitStatus(err error) *metav1.Status {
+	if err == nil {
+		return &metav1.Status{Status: metav1.StatusSuccess}
+	}
+	if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.Exited() {
+		code := exitErr.ExitStatus()
+		return &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  "NonZeroExitCode",
+			Message: fmt.Sprintf("command terminated with non-zero exit code: %v", err),
+			Details: &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{
+					Type:    "ExitCode",
+					Message: strconv.Itoa(code),
+				}},
+			},
+		}
+	}
+	return &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: err.Error(),
+	}
+}
+
+// writeExitStatus reports the result on the error stream, older protocols only get the message of an error
+func writeExitStatus(out io.Writer, v4 bool, err error) error {
+	if v4 {
+		status := exitStatus(err)
+		status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+		data, merr := json.Marshal(status)
+		if merr != nil {
+			return merr
+		}
+		_, werr := out.Write(data)
+		return werr
+	}
+	if err == nil {
+		return nil
+	}
+	_, werr := io.WriteString(out, err.Error())
+	return werr
+}
+
+// decodeResizes sends the terminal sizes written as json by the client on a channel, until the stream ends or done is closed
+func decodeResizes(in io.Reader, resize chan<- remotecommand.TerminalSize, done <-chan struct{}) {
+	defer close(resize)
+	decoder := json.NewDecoder(in)
+	for {
+		size := remotecommand.TerminalSize{}
+		if err := decoder.Decode(&size); err != nil {
+			return
+		}
+		select {
+		case resize <- size:
+		case <-done:
+			return
+		}
+	}
+}
+
+func serveSPDY(w http.ResponseWriter, req *http.Request, opts *remoteCommandOptions, run remoteCommandFunc) {
+	protocol, err := httpstream.Handshake(req, w, spdyProtocols)
+	if err != nil {
+		//the handshake already responded
+		return
+	}
+
+	type newStream struct {
+		stream    httpstream.Stream
+		replySent <-chan struct{}
+	}
+	streamC := make(chan newStream)
+	upgrader := spdy.NewResponseUpgrader()
+	conn := upgrader.UpgradeResponse(w, req, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+		streamC <- newStream{stream: stream, replySent: replySent}
+		return nil
+	})
+	if conn == nil {
+		//the upgrader already responded
+		return
+	}
+	defer conn.Close()
+	conn.SetIdleTimeout(streamIdleTimeout)
+
+	expected := 1
+	for _, requested := range []bool{opts.Stdin, opts.Stdout, opts.Stderr, opts.TTY && protocol != streamProtocolV2} {
+		if requested {
+			expected++
+		}
+	}
+
+	streams := map[string]httpstream.Stream{}
+	replies := []<-chan struct{}{}
+	timeout := time.After(streamCreationTimeout)
+	for len(streams) < expected {
+		select {
+		case s := <-streamC:
+			kind := s.stream.Headers().Get(streamType)
+			switch kind {
+			case streamTypeError, streamTypeStdin, streamTypeStdout, streamTypeStderr, streamTypeResize:
+				streams[kind] = s.stream
+				replies = append(replies, s.replySent)
+			default:
+				s.stream.Reset()
+			}
+		case <-timeout:
+			//the connection was upgraded, there is no way to respond anymore
+			fmt.Println("Timed out waiting for client to create streams")
+			return
+		}
+	}
+	for _, replySent := range replies {
+		<-replySent
+	}
+
+	errStream := streams[streamTypeError]
+	if errStream == nil {
+		fmt.Println("Client didn't create an error stream")
+		return
+	}
+	defer errStream.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	cmdStreams := &remoteCommandStreams{}
+	if s := streams[streamTypeStdin]; s != nil {
+		cmdStreams.stdin = s
+	}
+	if s := streams[streamTypeStdout]; s != nil {
+		cmdStreams.stdout = s
+	}
+	if s := streams[streamTypeStderr]; s != nil {
+		cmdStreams.stderr = s
+	}
+	if s := streams[streamTypeResize]; s != nil {
+		resize := make(chan remotecommand.TerminalSize, 1)
+		go decodeResizes(s, resize, done)
+		cmdStreams.resize = resize
+	}
+
+	runErr := run(cmdStreams)
+	if err := writeExitStatus(errStream, protocol == streamProtocolV4, runErr); err != nil {
+		fmt.Printf("Failed to send the result of a remote command: %s\n", err.Error())
+	}
+}
+
+func serveWebSocket(w http.ResponseWriter, req *http.Request, opts *remoteCommandOptions, run remoteCommandFunc) {
+	acceptWebSocket(w, req, func(conn *wsConn, v4 bool) {
+		serveWebSocketStreams(conn, v4, opts, run)
+	})
+}
+
+func serveWebSocketStreams(conn *wsConn, v4 bool, opts *remoteCommandOptions, run remoteCommandFunc) {
+	streams := &remoteCommandStreams{}
+	//an empty message on every channel tells the client which ones are in use
+	for channel, requested := range map[byte]bool{wsStdout: opts.Stdout, wsStderr: opts.Stderr, wsError: true} {
+		if requested {
+			conn.write(channel, []byte{})
+		}
+	}
+	if opts.Stdout {
+		streams.stdout = &wsWriter{conn: conn, channel: wsStdout}
+	}
+	if opts.Stderr {
+		streams.stderr = &wsWriter{conn: conn, channel: wsStderr}
+	}
+
+	var stdin *io.PipeWriter
+	if opts.Stdin {
+		var reader *io.PipeReader
+		reader, stdin = io.Pipe()
+		streams.stdin = reader
+	}
+	var resize chan remotecommand.TerminalSize
+	if opts.TTY {
+		resize = make(chan remotecommand.TerminalSize, 1)
+		streams.resize = resize
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer func() {
+			if stdin != nil {
+				stdin.Close()
+			}
+			if resize != nil {
+				close(resize)
+			}
+		}()
+		for {
+			channel, data, err := conn.read()
+			if err != nil {
+				return
+			}
+			switch {
+			case channel == wsStdin && stdin != nil:
+				if _, err := stdin.Write(data); err != nil {
+					return
+				}
+			case channel == wsResize && resize != nil:
+				size := remotecommand.TerminalSize{}
+				if json.Unmarshal(data, &size) != nil {
+					continue
+				}
+				select {
+				case resize <- size:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	runErr := run(streams)
+	if err := writeExitStatus(&wsWriter{conn: conn, channel: wsError}, v4, runErr); err != nil {
+		fmt.Printf("Failed to send the result of a remote command: %s\n", err.Error())
+	}
+}
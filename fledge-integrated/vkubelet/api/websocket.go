@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	//the protocols of the kubelet's websocket streams, the base64 ones send text messages
+	wsProtocolV1       = "channel.k8s.io"
+	wsProtocolV4       = "v4.channel.k8s.io"
+	wsProtocolBase64V1 = "base64.channel.k8s.io"
+	wsProtocolBase64V4 = "v4.base64.channel.k8s.io"
+)
+
+var wsProtocols = []string{wsProtocolV4, wsProtocolBase64V4, wsProtocolV1, wsProtocolBase64V1}
+
+func isWebSocketRequest(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// wsConn multiplexes streams over a websocket, every message starts with the number of its channel
+type wsConn struct {
+	ws     *websocket.Conn
+	base64 bool
+	lock   sync.Mutex
+}
+
+func (c *wsConn) write(channel byte, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.base64 {
+		return websocket.Message.Send(c.ws, string('0'+channel)+base64.StdEncoding.EncodeToString(data))
+	}
+	return websocket.Message.Send(c.ws, append([]byte{channel}, data...))
+}
+
+// read returns the next message and its channel
+func (c *wsConn) read() (byte, []byte, error) {
+	for {
+		var data []byte
+		if c.base64 {
+			var msg string
+			if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+				return 0, nil, err
+			}
+			if len(msg) == 0 {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(msg[1:])
+			if err != nil {
+				return 0, nil, err
+			}
+			return msg[0] - '0', decoded, nil
+		}
+		if err := websocket.Message.Receive(c.ws, &data); err != nil {
+			return 0, nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		return data[0], data[1:], nil
+	}
+}
+
+// wsWriter writes to one channel of a websocket
+type wsWriter struct {
+	conn    *wsConn
+	channel byte
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	if err := w.conn.write(w.channel, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *wsWriter) Close() error {
+	return nil
+}
+
+// acceptWebSocket upgrades the request to a websocket with one of the channel protocols and calls handle with it,
+// v4 tells whether the client expects a status on the error channel
+func acceptWebSocket(w http.ResponseWriter, req *http.Request, handle func(conn *wsConn, v4 bool)) {
+	var protocol string
+	server := websocket.Server{
+		Handshake: func(cfg *websocket.Config, req *http.Request) error {
+			for _, supported := range wsProtocols {
+				for _, requested := range cfg.Protocol {
+					if strings.TrimSpace(requested) == supported {
+						protocol = supported
+						cfg.Protocol = []string{supported}
+						return nil
+					}
+				}
+			}
+			if len(cfg.Protocol) > 0 {
+				return errors.Errorf("none of the requested protocols %v are supported", cfg.Protocol)
+			}
+			//clients that don't ask for a protocol get the oldest one
+			protocol = wsProtocolV1
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			conn := &wsConn{
+				ws:     ws,
+				base64: protocol == wsProtocolBase64V1 || protocol == wsProtocolBase64V4,
+			}
+			if !conn.base64 {
+				ws.PayloadType = websocket.BinaryFrame
+			}
+			handle(conn, protocol == wsProtocolV4 || protocol == wsProtocolBase64V4)
+		},
+	}
+	server.ServeHTTP(w, req)
+}
@@ -25,7 +25,12 @@ func PodHandler(p providers.NodeProvider) http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/containerLogs/{namespace}/{pod}/{container}", api.PodLogsHandlerFunc(p)).Methods("GET")
-	r.HandleFunc("/exec/{namespace}/{pod}/{container}", api.PodExecHandlerFunc(p)).Methods("POST")
+	r.HandleFunc("/exec/{namespace}/{pod}/{container}", api.PodExecHandlerFunc(p)).Methods("GET", "POST")
+	r.HandleFunc("/exec/{namespace}/{pod}/{uid}/{container}", api.PodExecHandlerFunc(p)).Methods("GET", "POST")
+	r.HandleFunc("/attach/{namespace}/{pod}/{container}", attachHandler(p)).Methods("GET", "POST")
+	r.HandleFunc("/attach/{namespace}/{pod}/{uid}/{container}", attachHandler(p)).Methods("GET", "POST")
+	r.HandleFunc("/portForward/{namespace}/{pod}", portForwardHandler(p)).Methods("GET", "POST")
+	r.HandleFunc("/portForward/{namespace}/{pod}/{uid}", portForwardHandler(p)).Methods("GET", "POST")
 	r.NotFoundHandler = http.HandlerFunc(NotFound)
 	return r
 }
@@ -35,7 +40,12 @@ func AllHandler(p providers.NodeProvider) http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/containerLogs/{namespace}/{pod}/{container}", api.PodLogsHandlerFunc(p)).Methods("GET")
-	r.HandleFunc("/exec/{namespace}/{pod}/{container}", api.PodExecHandlerFunc(p)).Methods("POST")
+	r.HandleFunc("/exec/{namespace}/{pod}/{container}", api.PodExecHandlerFunc(p)).Methods("GET", "POST")
+	r.HandleFunc("/exec/{namespace}/{pod}/{uid}/{container}", api.PodExecHandlerFunc(p)).Methods("GET", "POST")
+	r.HandleFunc("/attach/{namespace}/{pod}/{container}", attachHandler(p)).Methods("GET", "POST")
+	r.HandleFunc("/attach/{namespace}/{pod}/{uid}/{container}", attachHandler(p)).Methods("GET", "POST")
+	r.HandleFunc("/portForward/{namespace}/{pod}", portForwardHandler(p)).Methods("GET", "POST")
+	r.HandleFunc("/portForward/{namespace}/{pod}/{uid}", portForwardHandler(p)).Methods("GET", "POST")
 
 	const summaryRoute = "/stats/summary"
 	var h http.HandlerFunc
@@ -54,6 +64,22 @@ func AllHandler(p providers.NodeProvider) http.Handler {
 	return r
 }
 
+// attachHandler serves http.StatusNotImplemented if the provider does not implement providers.ContainerAttacher
+func attachHandler(p providers.NodeProvider) http.HandlerFunc {
+	if attacher, ok := p.(providers.ContainerAttacher); ok {
+		return api.PodAttachHandlerFunc(attacher)
+	}
+	return NotImplemented
+}
+
+// portForwardHandler serves http.StatusNotImplemented if the provider does not implement providers.PortForwarder
+func portForwardHandler(p providers.NodeProvider) http.HandlerFunc {
+	if forwarder, ok := p.(providers.PortForwarder); ok {
+		return api.PodPortForwardHandlerFunc(forwarder)
+	}
+	return NotImplemented
+}
+
 // MetricsSummaryHandler creates an http handler for serving pod metrics.
 //
 // If the passed in provider does not implement providers.PodMetricsProvider,
@@ -62,7 +62,7 @@ func (s *Server) registerNode(ctx context.Context) error {
 				KubeletVersion:  vkVersion,
 			},
 			Capacity:        s.nodeProvider.Capacity(ctx),
-			Allocatable:     s.nodeProvider.Capacity(ctx),
+			Allocatable:     s.nodeProvider.Allocatable(ctx),
 			Conditions:      s.nodeProvider.NodeConditions(ctx),
 			Addresses:       s.nodeProvider.NodeAddresses(ctx),
 			DaemonEndpoints: *s.nodeProvider.NodeDaemonEndpoints(ctx),
@@ -99,9 +99,8 @@ func (s *Server) updateNode(ctx context.Context) {
 	n.ResourceVersion = "" // Blank out resource version to prevent object has been modified error
 	n.Status.Conditions = s.nodeProvider.NodeConditions(ctx)
 
-	capacity := s.nodeProvider.Capacity(ctx)
-	n.Status.Capacity = capacity
-	n.Status.Allocatable = capacity
+	n.Status.Capacity = s.nodeProvider.Capacity(ctx)
+	n.Status.Allocatable = s.nodeProvider.Allocatable(ctx)
 
 	n.Status.Addresses = s.nodeProvider.NodeAddresses(ctx)
 
@@ -15,6 +15,7 @@ import (
 	"k8s.io/client-go/tools/record"
 
 	"fledge/fledge-integrated/log"
+	"fledge/fledge-integrated/providers"
 )
 
 func (s *Server) createOrUpdatePod(ctx context.Context, pod *corev1.Pod, recorder record.EventRecorder) error {
@@ -37,13 +38,26 @@ func (s *Server) createOrUpdatePod(ctx context.Context, pod *corev1.Pod, recorde
 
 	if origErr := s.nodeProvider.CreatePod(ctx, pod); origErr != nil {
 		podPhase := corev1.PodPending
+		reason := podStatusReasonProviderFailed
 		if pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
 			podPhase = corev1.PodFailed
 		}
+		// A pod asking for a runtime this device doesn't have will never start here, so reject it instead of retrying.
+		rejected := providers.IsRuntimeUnavailable(origErr)
+		if rejected {
+			podPhase = corev1.PodFailed
+			reason = podStatusReasonRuntimeUnavailable
+			recorder.Event(pod, corev1.EventTypeWarning, reason, origErr.Error())
+		}
+		// A container that couldn't be started shows why, like it does on a kubelet.
+		if container, waitingReason, ok := providers.ContainerWaitingReason(origErr); ok {
+			setContainerWaiting(pod, container, waitingReason, origErr.Error())
+			recorder.Event(pod, corev1.EventTypeWarning, "Failed", origErr.Error())
+		}
 
 		pod.ResourceVersion = "" // Blank out resource version to prevent object has been modified error
 		pod.Status.Phase = podPhase
-		pod.Status.Reason = podStatusReasonProviderFailed
+		pod.Status.Reason = reason
 		pod.Status.Message = origErr.Error()
 
 		_, err := s.k8sClient.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
@@ -51,6 +65,10 @@ func (s *Server) createOrUpdatePod(ctx context.Context, pod *corev1.Pod, recorde
 			logger.WithError(err).Warn("Failed to update pod status")
 		}
 
+		if rejected {
+			logger.WithError(origErr).Warn("Pod rejected")
+			return nil
+		}
 		return origErr
 	}
 
@@ -59,7 +77,9 @@ func (s *Server) createOrUpdatePod(ctx context.Context, pod *corev1.Pod, recorde
 	return nil
 }
 
-func (s *Server) deletePod(ctx context.Context, namespace, name string) error {
+// deletePod terminates the pod in the provider, giving its containers gracePeriod seconds to stop when it is set,
+// and deletes the Kubernetes API resource once they are gone.
+func (s *Server) deletePod(ctx context.Context, namespace, name string, gracePeriod *int64) error {
 	// Grab the pod as known by the provider.
 	// NOTE: Some providers return a non-nil error in their GetPod implementation when the pod is not found while some other don't.
 	// Hence, we ignore the error and just act upon the pod if it is non-nil (meaning that the provider still knows about the pod).
@@ -69,24 +89,29 @@ func (s *Server) deletePod(ctx context.Context, namespace, name string) error {
 		return s.forceDeletePodResource(ctx, namespace, name)
 	}
 
-	var delErr error
-	if delErr = s.nodeProvider.DeletePod(ctx, pod); delErr != nil && errors.IsNotFound(delErr) {
-		return delErr
+	// The pod as known by the provider doesn't carry the grace period of the deletion.
+	pod = pod.DeepCopy()
+	if gracePeriod != nil {
+		pod.DeletionGracePeriodSeconds = gracePeriod
+	}
+
+	// The Kubernetes API resource is kept while the pod couldn't be terminated, so the deletion is retried.
+	if err := s.nodeProvider.DeletePod(ctx, pod); err != nil {
+		return err
 	}
 
 	logger := log.G(ctx).WithField("pod", pod.GetName()).WithField("namespace", pod.GetNamespace())
-	if !errors.IsNotFound(delErr) {
-		if err := s.forceDeletePodResource(ctx, namespace, name); err != nil {
-			return err
-		}
-		logger.Info("Pod deleted")
+	if err := s.forceDeletePodResource(ctx, namespace, name); err != nil {
+		return err
 	}
+	logger.Info("Pod deleted")
 
 	return nil
 }
 
+// forceDeletePodResource removes the Kubernetes API resource of a pod right away.
+// It is only called once the provider stopped the containers of the pod within their grace period, so there is nothing left to wait for.
 func (s *Server) forceDeletePodResource(ctx context.Context, namespace, name string) error {
-
 	var grace int64
 	if err := s.k8sClient.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{GracePeriodSeconds: &grace}); err != nil {
 		if errors.IsNotFound(err) {
@@ -131,8 +156,7 @@ func (s *Server) updatePodStatuses(ctx context.Context) {
 func (s *Server) updatePodStatus(ctx context.Context, pod *corev1.Pod) error {
 
 	if pod.Status.Phase == corev1.PodSucceeded ||
-		pod.Status.Phase == corev1.PodFailed ||
-		pod.Status.Reason == podStatusReasonProviderFailed {
+		pod.Status.Phase == corev1.PodFailed {
 		return nil
 	}
 
@@ -144,6 +168,9 @@ func (s *Server) updatePodStatus(ctx context.Context, pod *corev1.Pod) error {
 	// Update the pod's status
 	if status != nil {
 		pod.Status = *status
+	} else if pod.Status.Reason == podStatusReasonProviderFailed {
+		// The pod controller retries creating the pod, until then its status tells why it failed.
+		return nil
 	} else {
 		// Only change the status when the pod was already up
 		// Only doing so when the pod was successfully running makes sure we don't run into race conditions during pod creation.
@@ -172,3 +199,31 @@ func (s *Server) updatePodStatus(ctx context.Context, pod *corev1.Pod) error {
 
 	return nil
 }
+
+// setContainerWaiting reports the container of the pod as waiting for reason, the other container statuses are kept.
+func setContainerWaiting(pod *corev1.Pod, container, reason, message string) {
+	waiting := corev1.ContainerStatus{
+		Name: container,
+		State: corev1.ContainerState{
+			Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message},
+		},
+	}
+	for _, spec := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		if spec.Name == container {
+			waiting.Image = spec.Image
+		}
+	}
+	statuses := &pod.Status.ContainerStatuses
+	for _, spec := range pod.Spec.InitContainers {
+		if spec.Name == container {
+			statuses = &pod.Status.InitContainerStatuses
+		}
+	}
+	for i := range *statuses {
+		if (*statuses)[i].Name == container {
+			(*statuses)[i] = waiting
+			return
+		}
+	}
+	*statuses = append(*statuses, waiting)
+}
@@ -0,0 +1,78 @@
+package vkubelet
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newWaitingTestPod() *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate", Image: "busybox"}},
+			Containers: []corev1.Container{
+				{Name: "app", Image: "nginx"},
+				{Name: "sidecar", Image: "envoy"},
+			},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "migrate", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", RestartCount: 3, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			},
+		},
+	}
+}
+
+func TestSetContainerWaiting(t *testing.T) {
+	cases := []struct {
+		name      string
+		container string
+		//names of the container statuses and init container statuses afterwards
+		containers []string
+		init       []string
+		image      string
+	}{
+		{"replaces the status", "app", []string{"app"}, []string{"migrate"}, "nginx"},
+		{"adds a status", "sidecar", []string{"app", "sidecar"}, []string{"migrate"}, "envoy"},
+		{"init container", "migrate", []string{"app"}, []string{"migrate"}, "busybox"},
+		{"unknown container", "other", []string{"app", "other"}, []string{"migrate"}, ""},
+	}
+	for _, c := range cases {
+		pod := newWaitingTestPod()
+		setContainerWaiting(pod, c.container, "ImagePullBackOff", "back-off pulling image")
+
+		names := func(statuses []corev1.ContainerStatus) []string {
+			result := []string{}
+			for _, status := range statuses {
+				result = append(result, status.Name)
+			}
+			return result
+		}
+		if got := names(pod.Status.ContainerStatuses); fmt.Sprint(got) != fmt.Sprint(c.containers) {
+			t.Errorf("%s: container statuses %v, want %v", c.name, got, c.containers)
+			continue
+		}
+		if got := names(pod.Status.InitContainerStatuses); fmt.Sprint(got) != fmt.Sprint(c.init) {
+			t.Errorf("%s: init container statuses %v, want %v", c.name, got, c.init)
+			continue
+		}
+
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.Name != c.container {
+				continue
+			}
+			waiting := status.State.Waiting
+			if waiting == nil || waiting.Reason != "ImagePullBackOff" || waiting.Message != "back-off pulling image" || status.State.Running != nil {
+				t.Errorf("%s: state %+v, want waiting for the image", c.name, status.State)
+			}
+			if status.Image != c.image {
+				t.Errorf("%s: image %q, want %q", c.name, status.Image, c.image)
+			}
+		}
+	}
+}
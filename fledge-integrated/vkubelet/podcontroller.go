@@ -35,6 +35,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 
 	"fledge/fledge-integrated/log"
+	"fledge/fledge-integrated/providers"
 )
 
 const (
@@ -57,6 +58,15 @@ type PodController struct {
 	workqueue workqueue.RateLimitingInterface
 	// recorder is an event recorder for recording Event resources to the Kubernetes API.
 	recorder record.EventRecorder
+	// terminations holds the pods being deleted in the provider by their key, see terminatePod.
+	terminations     map[string]*podTermination
+	terminationsLock sync.Mutex
+}
+
+// podTermination is the deletion of a pod in the provider, which runs outside the workers as it can take the whole grace period.
+type podTermination struct {
+	done bool
+	err  error
 }
 
 // NewPodController returns a new instance of PodController.
@@ -66,6 +76,10 @@ func NewPodController(server *Server) *PodController {
 	eventBroadcaster.StartLogging(log.L.Infof)
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: server.k8sClient.CoreV1().Events("")})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: fmt.Sprintf("%s/pod-controller", server.nodeName)})
+	// Providers that report events about their pods, like failed lifecycle hooks, send them through the same recorder.
+	if eventRecorder, ok := server.nodeProvider.(providers.PodEventRecorder); ok {
+		eventRecorder.RecordPodEvents(recorder)
+	}
 
 	// Create an instance of PodController having a work queue that uses the rate limiter created above.
 	pc := &PodController{
@@ -74,6 +88,7 @@ func NewPodController(server *Server) *PodController {
 		podsLister:   server.podInformer.Lister(),
 		workqueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pods"),
 		recorder:     recorder,
+		terminations: make(map[string]*podTermination),
 	}
 
 	// Set up event handlers for when Pod resources change.
@@ -244,7 +259,7 @@ func (pc *PodController) syncHandler(ctx context.Context, key string) error {
 		}
 		// At this point we know the Pod resource doesn't exist, which most probably means it was deleted.
 		// Hence, we must delete it from the provider if it still exists there.
-		if err := pc.server.deletePod(ctx, namespace, name); err != nil {
+		if err := pc.terminatePod(ctx, namespace, name, nil); err != nil {
 			err := pkgerrors.Wrapf(err, "failed to delete pod %q in the provider", loggablePodNameFromCoordinates(namespace, name))
 			//span.SetStatus(ocstatus.FromError(err))
 			return err
@@ -266,7 +281,7 @@ func (pc *PodController) syncPodInProvider(ctx context.Context, pod *corev1.Pod)
 	// Check whether the pod has been marked for deletion.
 	// If it does, guarantee it is deleted in the provider and Kubernetes.
 	if pod.DeletionTimestamp != nil {
-		if err := pc.server.deletePod(ctx, pod.Namespace, pod.Name); err != nil {
+		if err := pc.terminatePod(ctx, pod.Namespace, pod.Name, pod.DeletionGracePeriodSeconds); err != nil {
 			err := pkgerrors.Wrapf(err, "failed to delete pod %q in the provider", loggablePodName(pod))
 			//span.SetStatus(ocstatus.FromError(err))
 			return err
@@ -289,6 +304,35 @@ func (pc *PodController) syncPodInProvider(ctx context.Context, pod *corev1.Pod)
 	return nil
 }
 
+// terminatePod deletes the pod in the provider and Kubernetes without blocking the worker while the containers stop.
+// The pod is queued again once the deletion is done, that sync returns the error of a failed deletion so it is retried
+// with backoff. Syncs while the deletion is running don't start another one.
+func (pc *PodController) terminatePod(ctx context.Context, namespace, name string, gracePeriod *int64) error {
+	key := loggablePodNameFromCoordinates(namespace, name)
+
+	pc.terminationsLock.Lock()
+	defer pc.terminationsLock.Unlock()
+	if termination, found := pc.terminations[key]; found {
+		if !termination.done {
+			return nil
+		}
+		delete(pc.terminations, key)
+		return termination.err
+	}
+
+	termination := &podTermination{}
+	pc.terminations[key] = termination
+	go func() {
+		err := pc.server.deletePod(ctx, namespace, name, gracePeriod)
+		pc.terminationsLock.Lock()
+		termination.done = true
+		termination.err = err
+		pc.terminationsLock.Unlock()
+		pc.workqueue.Add(key)
+	}()
+	return nil
+}
+
 // deleteDanglingPods checks whether the provider knows about any pods which Kubernetes doesn't know about, and deletes them.
 func (pc *PodController) deleteDanglingPods(ctx context.Context, threadiness int) {
 	//ctx, span := trace.StartSpan(ctx, "deleteDanglingPods")
@@ -344,7 +388,7 @@ func (pc *PodController) deleteDanglingPods(ctx context.Context, threadiness int
 			// Add the pod's attributes to the current span.
 			//addPodAttributes(span, pod)
 			// Actually delete the pod.
-			if err := pc.server.deletePod(ctx, pod.Namespace, pod.Name); err != nil {
+			if err := pc.server.deletePod(ctx, pod.Namespace, pod.Name, nil); err != nil {
 				//span.SetStatus(ocstatus.FromError(err))
 				log.G(ctx).Errorf("failed to delete pod %q in provider", loggablePodName(pod))
 			} else {
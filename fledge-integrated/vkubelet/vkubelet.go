@@ -20,7 +20,8 @@ import (
 )
 
 const (
-	podStatusReasonProviderFailed = "ProviderFailed"
+	podStatusReasonProviderFailed     = "ProviderFailed"
+	podStatusReasonRuntimeUnavailable = "RuntimeUnavailable"
 )
 
 // Server masquarades itself as a kubelet and allows for the virtual node to be backed by non-vm/node providers.
@@ -79,6 +80,11 @@ func (s *Server) Run(ctx context.Context) error {
 		return err
 	}
 
+	//providers that pull images read the pull secrets of pods through the resource manager
+	if user, ok := s.nodeProvider.(providers.PodResourceUser); ok {
+		user.UseResourceManager(s.resourceManager)
+	}
+
 	go s.providerSyncLoop(ctx)
 	s.lease, _ = s.leaseController.BackoffEnsureLease(ctx)
 
@@ -97,6 +103,17 @@ func (s *Server) providerSyncLoop(ctx context.Context) {
 	firstHb := true
 	const sleepTime = updateTime * time.Second
 
+	//providers that notify about pod changes get their statuses sent right away instead of on the next tick
+	podsChangedC := make(chan struct{}, 1)
+	if notifier, ok := s.nodeProvider.(providers.PodNotifier); ok {
+		notifier.NotifyPods(ctx, func() {
+			select {
+			case podsChangedC <- struct{}{}:
+			default:
+			}
+		})
+	}
+
 	t := time.NewTimer(sleepTime)
 	defer t.Stop()
 
@@ -104,6 +121,13 @@ func (s *Server) providerSyncLoop(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
+		case <-podsChangedC:
+			if s.nodeProvider.PodsChanged() {
+				//reset first, so changes made while sending the statuses aren't lost
+				s.nodeProvider.ResetChanges()
+				fmt.Println("Pods changed, sending pod statuses to master node")
+				s.updatePodStatuses(ctx)
+			}
 		case <-t.C:
 			lastUpdate += updateTime
 			lastLease += updateTime